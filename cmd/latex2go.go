@@ -37,7 +37,7 @@ as input and generates equivalent Go code.`,
 		// --- Dependency Injection ---
 		// 1. Instantiate Domain Services
 		latexParser := parser.NewParser()
-		codeGenerator := generator.NewGenerator()
+		codeGenerator := generator.NewGenerator(generatorOptions(cmd)...)
 
 		// 2. Instantiate Adapters
 		// Input adapter uses the command itself to access flags
@@ -64,6 +64,32 @@ func init() {
 	rootCmd.Flags().StringP("output", "o", "", "Output Go file path (default: stdout)")
 	rootCmd.Flags().String("package", "main", "Go package name for the generated file")
 	rootCmd.Flags().String("func-name", "calculate", "Function name in the generated Go code")
+	rootCmd.Flags().Bool("closure", false, "Generate a factory binding the parameters and returning a func() closure")
+	rootCmd.Flags().String("go-version", "", "Go version targeted by the generated code (e.g. 1.21), enabling newer constructs such as the min/max builtins")
+	rootCmd.Flags().Int("max-params", 0, "Fail when the generated function would have more than N parameters (0 disables the check)")
+	rootCmd.Flags().Bool("clamp-chains", false, "Generate relational chains like 0 \\le x \\le 10 as a clamp instead of a bool test")
+	rootCmd.Flags().Bool("complex", false, "Treat variables as complex128 and generate math/cmplx code")
+	rootCmd.Flags().Bool("vectorize", false, "Generate a function taking a slice per parameter and returning the elementwise results")
+	rootCmd.Flags().String("param-order", "alpha", "Order of the generated function's parameters: alpha (sorted by name) or appearance (first use in the equation)")
+	rootCmd.Flags().Bool("check-overflow", false, "Generate a function returning (float64, error) that fails when the result overflows to ±Inf")
+	rootCmd.Flags().Int("indent", 0, "Indent the generated code with N spaces per level instead of tabs (0 keeps gofmt's tabs)")
+	rootCmd.Flags().Bool("no-math-import", false, "Generate pure-Go helpers instead of importing math (supports Sqrt and Abs)")
+	rootCmd.Flags().Bool("check-units", false, "Check that \\text{...} unit annotations are consistent, e.g. reject meters plus seconds")
+	rootCmd.Flags().Bool("trace", false, "Generate code printing intermediate values to stderr as it runs")
+	rootCmd.Flags().Int("max-terms", 0, "Stop every sum or product with bounds after N terms, e.g. for series up to \\infty (0 disables the cap)")
+	rootCmd.Flags().Bool("guard-numerics", false, "Stop sums, integrals, derivatives and limits at the first NaN or ±Inf value instead of computing on with it")
+	rootCmd.Flags().Bool("domain-notes", false, "Document the domain constraints of the parameters, e.g. // Note: x must be >= 0 for \\sqrt{x}")
+	rootCmd.Flags().Bool("simplify", false, "Simplify the equation before generating, e.g. flatten nested fractions and drop x/1, 1*x and x+0")
+	rootCmd.Flags().Bool("context", false, "Generate a function taking a context.Context and returning an error, stopping its numerical loops on cancellation")
+	rootCmd.Flags().Bool("einstein", false, "Sum a product over an index repeated once up and once down, e.g. a^i b_i as \\sum_i a_i b_i")
+	rootCmd.Flags().Bool("optimize", false, "Evaluate polynomials in Horner form, e.g. a x^3 + b x^2 + c x + d as ((a*x + b)*x + c)*x + d")
+	rootCmd.Flags().Bool("generic", false, "Generate a function generic over ~float32 | ~float64 that computes in float64; requires --go-version 1.18 or later")
+	rootCmd.Flags().Bool("intermediates", false, "Return a map[string]float64 of the value of every assignment and of the result, under \"result\"")
+	rootCmd.Flags().Bool("validator", false, "Also generate a <func>Valid function returning an error when the arguments violate the inferred domain constraints")
+	rootCmd.Flags().Bool("split-helpers", false, "Write helper functions (from --no-math-import) to a separate helpers.go next to the --output file")
+	rootCmd.Flags().Bool("schema", false, "Write a JSON Schema of the function's parameters, with their domains, and of its result instead of the Go code")
+	rootCmd.Flags().Bool("profile", false, "Print how long parsing, generation, formatting and writing took to stderr")
+	rootCmd.Flags().Bool("debug-ast", false, "Print the parsed AST to stderr before generating code")
 
 	// Mark input as required
 	if err := rootCmd.MarkFlagRequired("input"); err != nil {
@@ -73,6 +99,78 @@ func init() {
 	}
 }
 
+// generatorOptions translates the code generation flags into generator options.
+func generatorOptions(cmd *cobra.Command) []generator.Option {
+	var opts []generator.Option
+	if closure, _ := cmd.Flags().GetBool("closure"); closure {
+		opts = append(opts, generator.WithClosure())
+	}
+	if clamp, _ := cmd.Flags().GetBool("clamp-chains"); clamp {
+		opts = append(opts, generator.WithClampChains())
+	}
+	if goVersion, _ := cmd.Flags().GetString("go-version"); goVersion != "" {
+		opts = append(opts, generator.WithGoVersion(goVersion))
+	}
+	if maxParams, _ := cmd.Flags().GetInt("max-params"); maxParams > 0 {
+		opts = append(opts, generator.WithMaxParams(maxParams))
+	}
+	if complexMode, _ := cmd.Flags().GetBool("complex"); complexMode {
+		opts = append(opts, generator.WithComplex())
+	}
+	if vectorize, _ := cmd.Flags().GetBool("vectorize"); vectorize {
+		opts = append(opts, generator.WithVectorize())
+	}
+	if paramOrder, _ := cmd.Flags().GetString("param-order"); paramOrder != "" {
+		opts = append(opts, generator.WithParamOrder(paramOrder))
+	}
+	if checkOverflow, _ := cmd.Flags().GetBool("check-overflow"); checkOverflow {
+		opts = append(opts, generator.WithOverflowCheck())
+	}
+	if indent, _ := cmd.Flags().GetInt("indent"); indent != 0 {
+		opts = append(opts, generator.WithIndent(indent))
+	}
+	if noMath, _ := cmd.Flags().GetBool("no-math-import"); noMath {
+		opts = append(opts, generator.WithoutMathImport())
+	}
+	if checkUnits, _ := cmd.Flags().GetBool("check-units"); checkUnits {
+		opts = append(opts, generator.WithUnitCheck())
+	}
+	if trace, _ := cmd.Flags().GetBool("trace"); trace {
+		opts = append(opts, generator.WithTrace())
+	}
+	if maxTerms, _ := cmd.Flags().GetInt("max-terms"); maxTerms != 0 {
+		opts = append(opts, generator.WithMaxTerms(maxTerms))
+	}
+	if guard, _ := cmd.Flags().GetBool("guard-numerics"); guard {
+		opts = append(opts, generator.WithNumericGuards())
+	}
+	if domainNotes, _ := cmd.Flags().GetBool("domain-notes"); domainNotes {
+		opts = append(opts, generator.WithDomainNotes())
+	}
+	if simplify, _ := cmd.Flags().GetBool("simplify"); simplify {
+		opts = append(opts, generator.WithSimplify())
+	}
+	if cancellable, _ := cmd.Flags().GetBool("context"); cancellable {
+		opts = append(opts, generator.WithContext())
+	}
+	if einstein, _ := cmd.Flags().GetBool("einstein"); einstein {
+		opts = append(opts, generator.WithEinstein())
+	}
+	if optimize, _ := cmd.Flags().GetBool("optimize"); optimize {
+		opts = append(opts, generator.WithOptimize())
+	}
+	if generic, _ := cmd.Flags().GetBool("generic"); generic {
+		opts = append(opts, generator.WithGeneric())
+	}
+	if intermediates, _ := cmd.Flags().GetBool("intermediates"); intermediates {
+		opts = append(opts, generator.WithIntermediates())
+	}
+	if validator, _ := cmd.Flags().GetBool("validator"); validator {
+		opts = append(opts, generator.WithValidator())
+	}
+	return opts
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		// Cobra handles reporting the error to stderr here
@@ -38,11 +38,19 @@ func (a *Adapter) GetLatexInput() (latex string, config app.Config, err error) {
 	outputFile, _ := a.cmd.Flags().GetString("output") // Error checked during flag parsing by Cobra
 	packageName, _ := a.cmd.Flags().GetString("package")
 	funcName, _ := a.cmd.Flags().GetString("func-name")
+	debugAST, _ := a.cmd.Flags().GetBool("debug-ast") // false when the flag is not defined
+	splitHelpers, _ := a.cmd.Flags().GetBool("split-helpers")
+	profile, _ := a.cmd.Flags().GetBool("profile")
+	schema, _ := a.cmd.Flags().GetBool("schema")
 
 	config = app.Config{
-		OutputFile:  outputFile,
-		PackageName: packageName,
-		FuncName:    funcName,
+		OutputFile:   outputFile,
+		PackageName:  packageName,
+		FuncName:     funcName,
+		DebugAST:     debugAST,
+		SplitHelpers: splitHelpers,
+		Profile:      profile,
+		Schema:       schema,
 	}
 
 	return latex, config, nil
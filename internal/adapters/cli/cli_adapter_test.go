@@ -73,3 +73,71 @@ func TestCliAdapter_NewAdapter_PanicMissingFlags(t *testing.T) {
 		"Should panic if flags are missing",
 	)
 }
+
+func TestCliAdapter_GetLatexInput_DebugAST(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringP("input", "i", "", "LaTeX equation string")
+	cmd.Flags().StringP("output", "o", "", "Output Go file path")
+	cmd.Flags().String("package", "main", "Go package name")
+	cmd.Flags().String("func-name", "calculate", "Function name")
+	cmd.Flags().Bool("debug-ast", false, "Print the parsed AST")
+
+	cmd.Flags().Set("input", "x + 1")
+	cmd.Flags().Set("debug-ast", "true")
+
+	_, config, err := cli.NewAdapter(cmd).GetLatexInput()
+
+	require.NoError(t, err)
+	assert.True(t, config.DebugAST)
+}
+
+func TestCliAdapter_GetLatexInput_SplitHelpers(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringP("input", "i", "", "LaTeX equation string")
+	cmd.Flags().StringP("output", "o", "", "Output Go file path")
+	cmd.Flags().String("package", "main", "Go package name")
+	cmd.Flags().String("func-name", "calculate", "Function name")
+	cmd.Flags().Bool("split-helpers", false, "Write helpers to a separate file")
+
+	cmd.Flags().Set("input", `\sqrt{x}`)
+	cmd.Flags().Set("split-helpers", "true")
+
+	_, config, err := cli.NewAdapter(cmd).GetLatexInput()
+
+	require.NoError(t, err)
+	assert.True(t, config.SplitHelpers)
+}
+
+func TestCliAdapter_GetLatexInput_Profile(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringP("input", "i", "", "LaTeX equation string")
+	cmd.Flags().StringP("output", "o", "", "Output Go file path")
+	cmd.Flags().String("package", "main", "Go package name")
+	cmd.Flags().String("func-name", "calculate", "Function name")
+	cmd.Flags().Bool("profile", false, "Print phase timings")
+
+	cmd.Flags().Set("input", `x^2`)
+	cmd.Flags().Set("profile", "true")
+
+	_, config, err := cli.NewAdapter(cmd).GetLatexInput()
+
+	require.NoError(t, err)
+	assert.True(t, config.Profile)
+}
+
+func TestCliAdapter_GetLatexInput_Schema(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringP("input", "i", "", "LaTeX equation string")
+	cmd.Flags().StringP("output", "o", "", "Output Go file path")
+	cmd.Flags().String("package", "main", "Go package name")
+	cmd.Flags().String("func-name", "calculate", "Function name")
+	cmd.Flags().Bool("schema", false, "Write a JSON Schema")
+
+	cmd.Flags().Set("input", `\sqrt{x}`)
+	cmd.Flags().Set("schema", "true")
+
+	_, config, err := cli.NewAdapter(cmd).GetLatexInput()
+
+	require.NoError(t, err)
+	assert.True(t, config.Schema)
+}
@@ -2,9 +2,12 @@ package output
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/ZanzyTHEbar/latex2go/internal/app" // For app.GoCodeWriter
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/generator"
 )
 
 // --- Stdout Adapter ---
@@ -26,6 +29,14 @@ func (a *StdoutAdapter) WriteGoCode(code string) error {
 	return nil
 }
 
+// WriteGoFiles prints each file to standard output, preceded by a comment naming it.
+func (a *StdoutAdapter) WriteGoFiles(files []generator.File) error {
+	if err := writeNamedFiles(os.Stdout, files); err != nil {
+		return fmt.Errorf("failed to write code to stdout: %w", err)
+	}
+	return nil
+}
+
 // --- File Adapter ---
 
 // FileAdapter implements the app.GoCodeWriter interface for file output.
@@ -55,6 +66,71 @@ func (a *FileAdapter) WriteGoCode(code string) error {
 	return nil
 }
 
+// WriteGoFiles writes the first file, the generated function, to the adapter's path
+// and the others by name into the same directory, overwriting existing files.
+func (a *FileAdapter) WriteGoFiles(files []generator.File) error {
+	for i, file := range files {
+		path := a.filePath
+		if i > 0 {
+			path = filepath.Join(filepath.Dir(a.filePath), file.Name)
+		}
+		if err := os.WriteFile(path, []byte(file.Code), 0644); err != nil {
+			return fmt.Errorf("failed to write code to file '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// --- Writer Adapter ---
+
+// IOWriterAdapter implements the app.GoCodeWriter interface for any io.Writer
+// supplied by the caller (a buffer, a network connection, an HTTP response, ...).
+type IOWriterAdapter struct {
+	w io.Writer
+}
+
+// NewWriterAdapterForWriter creates a new adapter writing to w.
+func NewWriterAdapterForWriter(w io.Writer) *IOWriterAdapter {
+	if w == nil {
+		// Same safeguard as for an empty file path
+		panic("IOWriterAdapter requires a non-nil io.Writer")
+	}
+	return &IOWriterAdapter{w: w}
+}
+
+// WriteGoCode writes the generated Go code string to the underlying writer as is.
+func (a *IOWriterAdapter) WriteGoCode(code string) error {
+	_, err := io.WriteString(a.w, code)
+	if err != nil {
+		return fmt.Errorf("failed to write code to writer: %w", err)
+	}
+	return nil
+}
+
+// WriteGoFiles writes each file to the underlying writer, preceded by a comment naming it.
+func (a *IOWriterAdapter) WriteGoFiles(files []generator.File) error {
+	if err := writeNamedFiles(a.w, files); err != nil {
+		return fmt.Errorf("failed to write code to writer: %w", err)
+	}
+	return nil
+}
+
+// writeNamedFiles writes the files one after the other to w, each preceded by a
+// "// name" line and separated by a blank line.
+func writeNamedFiles(w io.Writer, files []generator.File) error {
+	for i, file := range files {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "// %s\n%s", file.Name, file.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // --- Factory Function ---
 
 // NewWriterAdapter creates the appropriate GoCodeWriter based on the output file path.
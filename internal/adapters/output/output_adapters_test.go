@@ -2,6 +2,7 @@ package output_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/ZanzyTHEbar/latex2go/internal/adapters/output"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/generator"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -108,6 +110,28 @@ func TestFileAdapter_WriteGoCode_InvalidPath(t *testing.T) {
 	fmt.Println("Expected error writing to directory:", err) // Log for debugging if needed
 }
 
+func TestFileAdapter_WriteGoFiles(t *testing.T) {
+	// Arrange
+	tempDir := t.TempDir()
+	testFilePath := filepath.Join(tempDir, "area.go")
+	files := []generator.File{
+		{Name: "area.go", Code: "package shapes\n\nfunc area(r float64) float64 {\n\treturn r * r\n}\n"},
+		{Name: "helpers.go", Code: "package shapes\n"},
+	}
+
+	// Act
+	err := output.NewFileAdapter(testFilePath).WriteGoFiles(files)
+
+	// Assert: the function goes to the path, the helpers next to it
+	require.NoError(t, err)
+	contentBytes, readErr := os.ReadFile(testFilePath)
+	require.NoError(t, readErr)
+	assert.Equal(t, files[0].Code, string(contentBytes))
+	contentBytes, readErr = os.ReadFile(filepath.Join(tempDir, "helpers.go"))
+	require.NoError(t, readErr)
+	assert.Equal(t, files[1].Code, string(contentBytes))
+}
+
 func TestNewFileAdapter_PanicEmptyPath(t *testing.T) {
 	// Arrange, Act & Assert
 	assert.PanicsWithValue(t,
@@ -128,3 +152,53 @@ func TestNewWriterAdapter_Factory(t *testing.T) {
 		assert.IsType(t, &output.FileAdapter{}, adapter)
 	})
 }
+
+func TestIOWriterAdapter_WriteGoCode(t *testing.T) {
+	// Arrange
+	var buf bytes.Buffer
+	adapter := output.NewWriterAdapterForWriter(&buf)
+	expectedCode := "package main\n\nfunc calculate(x float64) float64 {\n\treturn x\n}"
+
+	// Act
+	err := adapter.WriteGoCode(expectedCode)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, expectedCode, buf.String())
+}
+
+func TestIOWriterAdapter_WriteGoFiles(t *testing.T) {
+	var buf bytes.Buffer
+	files := []generator.File{
+		{Name: "calculate.go", Code: "package main\n\nfunc calculate() float64 {\n\treturn 1\n}\n"},
+		{Name: "helpers.go", Code: "package main\n"},
+	}
+
+	err := output.NewWriterAdapterForWriter(&buf).WriteGoFiles(files)
+
+	require.NoError(t, err)
+	assert.Equal(t, "// calculate.go\n"+files[0].Code+"\n// helpers.go\n"+files[1].Code, buf.String())
+}
+
+// failingWriter is an io.Writer whose writes always fail.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection closed")
+}
+
+func TestIOWriterAdapter_WriteGoCode_WriterError(t *testing.T) {
+	adapter := output.NewWriterAdapterForWriter(failingWriter{})
+
+	err := adapter.WriteGoCode("package fail")
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "failed to write code to writer: connection closed")
+}
+
+func TestNewWriterAdapterForWriter_PanicNilWriter(t *testing.T) {
+	assert.PanicsWithValue(t,
+		"IOWriterAdapter requires a non-nil io.Writer",
+		func() { output.NewWriterAdapterForWriter(nil) },
+	)
+}
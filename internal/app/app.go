@@ -50,3 +50,49 @@ func (s *Latex2GoService) ConvertLatexToGo(latexInput, packageName, funcName str
 
 	return goCode, nil
 }
+
+// ConvertLatexToSchema takes a LaTeX string and returns the JSON Schema of the
+// parameters and the result of the Go function that ConvertLatexToGo would
+// generate (see generator.GenerateSchema).
+func (s *Latex2GoService) ConvertLatexToSchema(latexInput, funcName string) (string, error) {
+	if latexInput == "" {
+		return "", fmt.Errorf("latex input cannot be empty")
+	}
+	if funcName == "" {
+		funcName = "generatedFunc" // Default function name
+	}
+
+	ast, err := s.parser.Parse(latexInput)
+	if err != nil {
+		return "", fmt.Errorf("parsing error: %w", err)
+	}
+	schema, err := s.generator.GenerateSchema(ast, funcName)
+	if err != nil {
+		return "", fmt.Errorf("schema generation error: %w", err)
+	}
+	return schema, nil
+}
+
+// ConvertLatexToGoFiles is like ConvertLatexToGo, but puts the helper functions in
+// a separate file (see generator.GenerateFiles). The function's file comes first.
+func (s *Latex2GoService) ConvertLatexToGoFiles(latexInput, packageName, funcName string) ([]generator.File, error) {
+	if latexInput == "" {
+		return nil, fmt.Errorf("latex input cannot be empty")
+	}
+	if packageName == "" {
+		packageName = "main" // Default package name
+	}
+	if funcName == "" {
+		funcName = "generatedFunc" // Default function name
+	}
+
+	ast, err := s.parser.Parse(latexInput)
+	if err != nil {
+		return nil, fmt.Errorf("parsing error: %w", err)
+	}
+	files, err := s.generator.GenerateFiles(ast, packageName, funcName)
+	if err != nil {
+		return nil, fmt.Errorf("code generation error: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,1097 @@
+package app_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/app"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/generator"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService() *app.Latex2GoService {
+	return app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator())
+}
+
+// runGeneratedCode compiles goCode (which must be in package main) together with
+// a main function printing the value of call, runs it and returns the trimmed output.
+func runGeneratedCode(t *testing.T, goCode, call string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping compile-and-run test in short mode")
+	}
+	dir := t.TempDir()
+	mainSrc := fmt.Sprintf("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(%s)\n}\n", call)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "generated.go"), []byte(goCode), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(mainSrc), 0644))
+
+	cmd := exec.Command("go", "run", "generated.go", "main.go")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "generated code failed to run:\n%s\nSource:\n%s", out, goCode)
+	return strings.TrimSpace(string(out))
+}
+
+// runGeneratedFloat is like runGeneratedCode but parses the printed result as a float64.
+func runGeneratedFloat(t *testing.T, goCode, call string) float64 {
+	t.Helper()
+	out := runGeneratedCode(t, goCode, call)
+	val, err := strconv.ParseFloat(out, 64)
+	require.NoError(t, err, "generated code printed a non-numeric result: %s", out)
+	return val
+}
+
+func TestLatex2GoService_TextConnectivesMatchSymbolicForms(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		text     string
+		symbolic string
+		expected string
+	}{
+		{`x > 0 \text{ and } x < 1`, `x > 0 \land x < 1`, "return x > 0 && x < 1"},
+		{`x < 0 \text{ or } x > 1`, `x < 0 \lor x > 1`, "return x < 0 || x > 1"},
+		{`\text{not} x > 0`, `\lnot x > 0`, "return !(x > 0)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			textCode, err := service.ConvertLatexToGo(tt.text, "main", "cond")
+			require.NoError(t, err)
+			symbolicCode, err := service.ConvertLatexToGo(tt.symbolic, "main", "cond")
+			require.NoError(t, err)
+
+			assert.Equal(t, symbolicCode, textCode)
+			assert.Contains(t, textCode, "func cond(x float64) bool")
+			assert.Contains(t, textCode, tt.expected)
+		})
+	}
+}
+
+func TestLatex2GoService_ArgMinArgMax(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\argmin_{x \in [0, 10]} (x-3)^2`, "main", "minimizer")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func minimizer() float64")
+	assert.InDelta(t, 3.0, runGeneratedFloat(t, goCode, "minimizer()"), 1e-6)
+
+	goCode, err = service.ConvertLatexToGo(`\arg\max_{x \in [lo, hi]} 1 - (x-c)^2`, "main", "maximizer")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func maximizer(c float64, hi float64, lo float64) float64")
+	assert.InDelta(t, -1.5, runGeneratedFloat(t, goCode, "maximizer(-1.5, 5, -5)"), 1e-6)
+
+	_, err = service.ConvertLatexToGo(`\argmin_x (x-3)^2`, "main", "minimizer")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a search domain")
+}
+
+func TestLatex2GoService_KroneckerDeltaAndIndicator(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\delta_{ij}`, "main", "delta")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func delta(i float64, j float64) float64")
+	assert.Contains(t, goCode, "if i == j {")
+	assert.Equal(t, 1.0, runGeneratedFloat(t, goCode, "delta(2, 2)"))
+	assert.Equal(t, 0.0, runGeneratedFloat(t, goCode, "delta(2, 3)"))
+
+	goCode, err = service.ConvertLatexToGo(`\mathbb{1}[x > 0] * y`, "main", "indicator")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func indicator(x float64, y float64) float64")
+	assert.Equal(t, 5.0, runGeneratedFloat(t, goCode, "indicator(1, 5)"))
+	assert.Equal(t, 0.0, runGeneratedFloat(t, goCode, "indicator(-1, 5)"))
+}
+
+func TestLatex2GoService_OneSidedLimits(t *testing.T) {
+	service := newTestService()
+
+	// 1/x has different one-sided limits at 0; evaluate their signs at runtime
+	rightCode, err := service.ConvertLatexToGo(`\lim_{x \to 0^+} \frac{1}{x}`, "main", "right")
+	require.NoError(t, err)
+	assert.Greater(t, runGeneratedFloat(t, rightCode, "right()"), 0.0)
+
+	leftCode, err := service.ConvertLatexToGo(`\lim_{x \to 0^-} \frac{1}{x}`, "main", "left")
+	require.NoError(t, err)
+	assert.Less(t, runGeneratedFloat(t, leftCode, "left()"), 0.0)
+
+	twoSidedCode, err := service.ConvertLatexToGo(`\lim_{x \to 0} \frac{1}{x}`, "main", "both")
+	require.NoError(t, err)
+	assert.Equal(t, "NaN", runGeneratedCode(t, twoSidedCode, "both()"))
+
+	continuousCode, err := service.ConvertLatexToGo(`\lim_{x \to 2} x^2`, "main", "square")
+	require.NoError(t, err)
+	assert.InDelta(t, 4.0, runGeneratedFloat(t, continuousCode, "square()"), 1e-6)
+}
+
+func TestLatex2GoService_LimitsAtInfinity(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		input    string
+		call     string
+		expected float64
+	}{
+		{`\lim_{x \to \infty} \frac{x}{x+1}`, "f()", 1},
+		{`\lim_{x \to -\infty} e^x`, "f(2.718281828459045)", 0},
+		{`(1 + \frac{1}{n})^n \xrightarrow{n \to \infty} e`, "f()", math.E},
+		// The example of the annotated arrow: x does not depend on n
+		{`x \xrightarrow{n \to \infty} L`, "f(3)", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.input, "main", "f")
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, runGeneratedFloat(t, goCode, tt.call), 1e-5)
+		})
+	}
+
+	// A slice has no element at infinity
+	_, err := service.ConvertLatexToGo(`x_n \xrightarrow{n \to \infty} L`, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the limit of a sequence as n \\to \\infty cannot be computed from the finitely many elements of a slice")
+}
+
+func TestLatex2GoService_ClosureOption(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithClosure()))
+
+	goCode, err := service.ConvertLatexToGo(`a + b`, "main", "makeF")
+	require.NoError(t, err)
+	assert.InDelta(t, 3.0, runGeneratedFloat(t, goCode, "makeF(1, 2)()"), 1e-12)
+
+	goCode, err = service.ConvertLatexToGo(`\sum_{i=1}^{n} i`, "main", "makeSum")
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0, runGeneratedFloat(t, goCode, "makeSum(4)()"), 1e-12)
+}
+
+func TestLatex2GoService_ExpectationAndVariance(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\mathbb{E}[X]`, "main", "mean")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, runGeneratedFloat(t, goCode, "mean([]float64{1, 2, 3, 4})"), 1e-12)
+
+	goCode, err = service.ConvertLatexToGo(`\text{Var}[X]`, "main", "variance")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.25, runGeneratedFloat(t, goCode, "variance([]float64{1, 2, 3, 4})"), 1e-12)
+
+	// The angle brackets of physics are the same expectation
+	goCode, err = service.ConvertLatexToGo(`\langle X \rangle`, "main", "mean")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, runGeneratedFloat(t, goCode, "mean([]float64{1, 2, 3, 4})"), 1e-12)
+}
+
+func TestLatex2GoService_ImplicationAndEquivalence(t *testing.T) {
+	service := newTestService()
+
+	// Truth tables, rows in the order (false, false), (false, true), (true, false), (true, true)
+	truthTable := "f(false, false), f(false, true), f(true, false), f(true, true)"
+	goCode, err := service.ConvertLatexToGo(`p \implies q`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "true true false true", runGeneratedCode(t, goCode, truthTable))
+
+	goCode, err = service.ConvertLatexToGo(`p \iff q`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "true false false true", runGeneratedCode(t, goCode, truthTable))
+
+	goCode, err = service.ConvertLatexToGo(`(x > 0) \implies (x^2 > 0)`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "true true true", runGeneratedCode(t, goCode, "f(-2), f(0), f(3)"))
+}
+
+func TestLatex2GoService_RelationalChainBoolAndClamp(t *testing.T) {
+	latex := `0 \le x \le 10`
+
+	goCode, err := newTestService().ConvertLatexToGo(latex, "main", "inRange")
+	require.NoError(t, err)
+	assert.Equal(t, "true false", runGeneratedCode(t, goCode, "inRange(5), inRange(12)"))
+
+	clampService := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithClampChains()))
+	goCode, err = clampService.ConvertLatexToGo(latex, "main", "clamp")
+	require.NoError(t, err)
+	assert.Equal(t, "0 5 10", runGeneratedCode(t, goCode, "clamp(-3), clamp(5), clamp(12)"))
+}
+
+func TestLatex2GoService_ClampAndLerp(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\operatorname{clamp}(x, lo, hi)`, "main", "clamp")
+	require.NoError(t, err)
+	assert.Equal(t, "0 0.5 1", runGeneratedCode(t, goCode, "clamp(-3, 0, 1), clamp(0.5, 0, 1), clamp(5, 0, 1)"))
+
+	goCode, err = service.ConvertLatexToGo(`\operatorname{lerp}(a, b, t)`, "main", "lerp")
+	require.NoError(t, err)
+	assert.Equal(t, "2 6 12", runGeneratedCode(t, goCode, "lerp(2, 12, 0), lerp(2, 12, 0.4), lerp(2, 12, 1)"))
+}
+
+func TestLatex2GoService_Atan2(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\operatorname{atan2}(1, 1)`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return math.Atan2(1, 1)")
+
+	// Unlike \arctan of the quotient, atan2 finds the quadrant of (x, y) = (-1, 1)
+	goCode, err = service.ConvertLatexToGo(`\arctan2(y, x)`, "main", "angle")
+	require.NoError(t, err)
+	assert.InDelta(t, 3*math.Pi/4, runGeneratedFloat(t, goCode, "angle(-1, 1)"), 1e-12)
+	goCode, err = service.ConvertLatexToGo(`\arctan{\frac{y}{x}}`, "main", "angle")
+	require.NoError(t, err)
+	assert.InDelta(t, -math.Pi/4, runGeneratedFloat(t, goCode, "angle(-1, 1)"), 1e-12)
+}
+
+func TestLatex2GoService_CompositeSpecialFunctions(t *testing.T) {
+	service := newTestService()
+
+	// sinc is continued by its limit at the removable singularity
+	goCode, err := service.ConvertLatexToGo(`\operatorname{sinc}(x)`, "main", "sinc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, runGeneratedFloat(t, goCode, "sinc(0)"))
+	assert.InDelta(t, math.Sin(0.5)/0.5, runGeneratedFloat(t, goCode, "sinc(0.5)"), 1e-15)
+
+	goCode, err = service.ConvertLatexToGo(`\operatorname{logistic}(x)`, "main", "sigma")
+	require.NoError(t, err)
+	assert.Equal(t, "0.5 1", runGeneratedCode(t, goCode, "sigma(0), sigma(800)"))
+
+	// softplus stays finite where e^x overflows
+	goCode, err = service.ConvertLatexToGo(`\operatorname{softplus}(x)`, "main", "softplus")
+	require.NoError(t, err)
+	assert.InDelta(t, math.Ln2, runGeneratedFloat(t, goCode, "softplus(0)"), 1e-15)
+	assert.Equal(t, 1000.0, runGeneratedFloat(t, goCode, "softplus(1000)"))
+
+	goCode, err = service.ConvertLatexToGo(`\operatorname{relu}(x)`, "main", "relu")
+	require.NoError(t, err)
+	assert.Equal(t, "0 0 3", runGeneratedCode(t, goCode, "relu(-2), relu(0), relu(3)"))
+}
+
+func TestLatex2GoService_Generic(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithGeneric(), generator.WithGoVersion("1.18")))
+	goCode, err := service.ConvertLatexToGo(`\sqrt{x^2 + y^2}`, "main", "hypot")
+	require.NoError(t, err)
+	assert.Equal(t, "5 5 float32 float64", runGeneratedCode(t, goCode,
+		"hypot(float32(3), float32(4)), hypot(3.0, 4.0), fmt.Sprintf(\"%T\", hypot(float32(3), float32(4))), fmt.Sprintf(\"%T\", hypot(3.0, 4.0))"))
+}
+
+func TestLatex2GoService_Intermediates(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithIntermediates()))
+	goCode, err := service.ConvertLatexToGo(`u = x^2; v = u + 1; u v`, "main", "f")
+	require.NoError(t, err)
+	// fmt prints maps sorted by key
+	assert.Equal(t, "map[result:90 u:9 v:10]", runGeneratedCode(t, goCode, "f(3)"))
+}
+
+func TestLatex2GoService_Validator(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithValidator()))
+	goCode, err := service.ConvertLatexToGo(`\sqrt{x - 1} + \frac{1}{y}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "f: x - 1 must be >= 0, got -3", runGeneratedCode(t, goCode, "fValid(-2, 1)"))
+	assert.Equal(t, "f: y must be != 0, got 0", runGeneratedCode(t, goCode, "fValid(5, 0)"))
+	assert.Equal(t, "<nil> 2.5", runGeneratedCode(t, goCode, "fValid(5, 2), f(5, 2)"))
+
+	// A case only constrains the arguments it applies to, so it is not checked
+	goCode, err = service.ConvertLatexToGo(`\begin{cases} \ln x & x > 0 \\ 0 & \text{otherwise} \end{cases}`, "main", "g")
+	require.NoError(t, err)
+	assert.Equal(t, "<nil> 0", runGeneratedCode(t, goCode, "gValid(-1), g(-1)"))
+
+	// Nor is the right operand of a short-circuiting connective
+	goCode, err = service.ConvertLatexToGo(`x > 0 \land \sqrt{x} < 2`, "main", "h")
+	require.NoError(t, err)
+	assert.Equal(t, "<nil> false", runGeneratedCode(t, goCode, "hValid(-1), h(-1)"))
+}
+
+func TestLatex2GoService_FracOfSumsAndIntegrals(t *testing.T) {
+	service := newTestService()
+
+	// The loop of a sum numerator or denominator runs in a parenthesized closure
+	goCode, err := service.ConvertLatexToGo(`\frac{\sum_{i=1}^n i}{n}`, "main", "mean")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return (func() float64 {")
+	assert.InDelta(t, 2.5, runGeneratedFloat(t, goCode, "mean(4)"), 1e-12)
+
+	goCode, err = service.ConvertLatexToGo(`\frac{n}{\sum_{i=1}^{n} i}`, "main", "inverse")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.4, runGeneratedFloat(t, goCode, "inverse(4)"), 1e-12)
+
+	goCode, err = service.ConvertLatexToGo(`\frac{\int_0^1 x\,dx}{2}`, "main", "half")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.25, runGeneratedFloat(t, goCode, "half()"), 1e-6)
+}
+
+func TestLatex2GoService_SumInsideLargerExpression(t *testing.T) {
+	service := newTestService()
+
+	// The sum binds to its immediate term, so c is added once
+	goCode, err := service.ConvertLatexToGo(`\sum_{i=1}^{n} \frac{1}{i} + c`, "main", "harmonicPlus")
+	require.NoError(t, err)
+	assert.InDelta(t, 1+0.5+1.0/3+10, runGeneratedFloat(t, goCode, "harmonicPlus(10, 3)"), 1e-12)
+
+	// Nested sums run the inner loop in its own closure
+	goCode, err = service.ConvertLatexToGo(`\sum_{i=1}^{n} \sum_{j=1}^{i} j`, "main", "nested")
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0, runGeneratedFloat(t, goCode, "nested(3)"), 1e-12)
+}
+
+func TestLatex2GoService_GoVersionMinMax(t *testing.T) {
+	for _, goVersion := range []string{"", "1.21"} {
+		service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithGoVersion(goVersion)))
+		goCode, err := service.ConvertLatexToGo(`\max(a, b, c) - \min(a, b)`, "main", "spread")
+		require.NoError(t, err)
+		assert.InDelta(t, 4.0, runGeneratedFloat(t, goCode, "spread(1, 3, 5)"), 1e-12, "go version %q", goVersion)
+	}
+}
+
+func TestLatex2GoService_PhysicsDerivative(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(`\dv{x^2}{x}`, "main", "slope")
+	require.NoError(t, err)
+	assert.InDelta(t, 6.0, runGeneratedFloat(t, goCode, "slope(3)"), 1e-6)
+
+	goCode, err = newTestService().ConvertLatexToGo(`\dv[2]{x^4}{x}`, "main", "curvature")
+	require.NoError(t, err)
+	assert.InDelta(t, 12.0, runGeneratedFloat(t, goCode, "curvature(1)"), 1e-4)
+
+	_, err = newTestService().ConvertLatexToGo(`\dv[3]{x^4}{x}`, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "derivatives of order 3 are not supported")
+
+	// The order of a Leibniz fraction is the power of its differentials
+	goCode, err = newTestService().ConvertLatexToGo(`\frac{d^2}{dx^2} x^4`, "main", "curvature")
+	require.NoError(t, err)
+	assert.InDelta(t, 12.0, runGeneratedFloat(t, goCode, "curvature(1)"), 1e-4)
+}
+
+func TestLatex2GoService_SmallIntegerPowers(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(`\frac{1}{x^2} + (x - 1)^3`, "main", "poly")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, "import \"math\"")
+	assert.InDelta(t, 0.25+1, runGeneratedFloat(t, goCode, "poly(2)"), 1e-12)
+}
+
+func TestLatex2GoService_EmptySumAndProduct(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		latex    string
+		call     string
+		expected float64
+	}{
+		{`\sum_{i=1}^{0} i`, "f()", 0},
+		{`\prod_{i=1}^{0} i`, "f()", 1},
+		{`\sum_{i=1}^{n} i`, "f(0)", 0},
+		{`\prod_{i=1}^{n} i`, "f(-3)", 1},
+		// Bounds round inwards to the integers in range: i = -1, 0
+		{`\sum_{i=-1.5}^{0.5} i`, "f()", -1},
+		{`\sum_{i=a}^{b} i`, "f(-1.5, 0.5)", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex+" "+tt.call, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, runGeneratedFloat(t, goCode, tt.call), 1e-12)
+		})
+	}
+}
+
+func TestLatex2GoService_CasesWithStyledText(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(
+		`\begin{cases} x & \text{\textbf{if} } x > 0 \\ 0 & \text{\textbf{otherwise}} \end{cases}`, "main", "relu")
+	require.NoError(t, err)
+	assert.Equal(t, "2 0", runGeneratedCode(t, goCode, "relu(2), relu(-2)"))
+}
+
+func TestLatex2GoService_ComplexOperators(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithComplex()))
+
+	tests := []struct {
+		latex    string
+		expected string
+	}{
+		{`\Re(z)`, "3"},
+		{`\Im(z)`, "4"},
+		{`|z|`, "5"},
+		{`\arg(z) - \arg(z)`, "0"},
+		{`\overline{z}`, "(3-4i)"},
+		{`z * \overline{z}`, "(25+0i)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, runGeneratedCode(t, goCode, "f(complex(3, 4))"))
+		})
+	}
+}
+
+func TestLatex2GoService_RealAndImaginaryPartSpellings(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithComplex()))
+
+	tests := []struct {
+		latex    string
+		code     string
+		expected string
+	}{
+		{`\Re(z)`, "return real(z)", "3"},
+		{`\operatorname{Re}(z)`, "return real(z)", "3"},
+		{`\text{Re}(z)`, "return real(z)", "3"},
+		{`\mathrm{Re}(z)`, "return real(z)", "3"},
+		{`\Im(z)`, "return imag(z)", "4"},
+		{`\operatorname{Im}(z)`, "return imag(z)", "4"},
+		{`\text{Im}(z)`, "return imag(z)", "4"},
+		{`\mathrm{Im}(z)`, "return imag(z)", "4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			assert.Contains(t, goCode, tt.code)
+			assert.Equal(t, tt.expected, runGeneratedCode(t, goCode, "f(complex(3, 4))"))
+		})
+	}
+}
+
+func TestLatex2GoService_SubstackFilteredSum(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(`\sum_{\substack{i=1 \\ i \ne j}}^{n} i`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "continue")
+	// 1 + 2 + 4 + 5 skips i == 3
+	assert.InDelta(t, 12, runGeneratedFloat(t, goCode, "f(3, 5)"), 1e-12)
+}
+
+func TestLatex2GoService_FourierSeries(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(`\sum_{n=1}^{N} \frac{\sin(n x)}{n}`, "main", "sawtooth")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "import \"math\"")
+	assert.Contains(t, goCode, "func sawtooth(N float64, x float64) float64")
+
+	// Partial sum of sin(n x)/n at x = 1 for n = 1..3
+	expected := math.Sin(1) + math.Sin(2)/2 + math.Sin(3)/3
+	assert.InDelta(t, expected, runGeneratedFloat(t, goCode, "sawtooth(3, 1)"), 1e-12)
+}
+
+func TestLatex2GoService_Vectorize(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithVectorize()))
+
+	goCode, err := service.ConvertLatexToGo(`x^2 + y`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "[11 24 39]", runGeneratedCode(t, goCode, "f([]float64{1, 2, 3}, []float64{10, 20, 30})"))
+
+	// A sum runs once per element
+	goCode, err = service.ConvertLatexToGo(`\sum_{k=1}^{n} k`, "main", "triangular")
+	require.NoError(t, err)
+	assert.Equal(t, "[1 3 6 10]", runGeneratedCode(t, goCode, "triangular([]float64{1, 2, 3, 4})"))
+}
+
+func TestLatex2GoService_RisingAndFallingFactorials(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		latex    string
+		call     string
+		expected float64
+	}{
+		{`(x)_3`, "f(2)", 2 * 3 * 4},
+		{`(x)_n`, "f(0, 5)", 1}, // Parameters sort as n, x
+		{`x^{\overline{3}}`, "f(2)", 2 * 3 * 4},
+		{`x^{\underline{3}}`, "f(5)", 5 * 4 * 3},
+		{`(3)_k`, "f(2)", 3 * 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, runGeneratedFloat(t, goCode, tt.call), 1e-12)
+		})
+	}
+}
+
+func TestLatex2GoService_ScientificNotationWithCdot(t *testing.T) {
+	goCode, err := newTestService().ConvertLatexToGo(`3 \cdot 10^8`, "main", "speedOfLight")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return 3 * math.Pow(10, 8)")
+	assert.InDelta(t, 3e8, runGeneratedFloat(t, goCode, "speedOfLight()"), 1e-6)
+}
+
+func TestLatex2GoService_Assignments(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`u = x^2; v = u + 1; \frac{v}{u}`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f(x float64) float64 {")
+	assert.InDelta(t, 5.0/4.0, runGeneratedFloat(t, goCode, "f(2)"), 1e-12)
+
+	// A top-level sum keeps its loop after the declarations
+	goCode, err = service.ConvertLatexToGo(`c = 2; \sum_{i=1}^{n} c i`, "main", "g")
+	require.NoError(t, err)
+	assert.InDelta(t, 20.0, runGeneratedFloat(t, goCode, "g(4)"), 1e-12)
+}
+
+func TestLatex2GoService_NestedFunctionArguments(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		latex    string
+		expected string
+		call     string
+		value    float64
+	}{
+		{`\sin(\cos(x))`, "return math.Sin(math.Cos(x))", "f(0.5)", math.Sin(math.Cos(0.5))},
+		{`\sqrt{\sin(x)}`, "return math.Sqrt(math.Sin(x))", "f(0.5)", math.Sqrt(math.Sin(0.5))},
+		{`\sin{\cos{\tan{x}}}`, "return math.Sin(math.Cos(math.Tan(x)))", "f(0.5)", math.Sin(math.Cos(math.Tan(0.5)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			assert.Contains(t, goCode, tt.expected)
+			assert.Equal(t, 1, strings.Count(goCode, `"math"`), "math must be imported exactly once:\n%s", goCode)
+			assert.InDelta(t, tt.value, runGeneratedFloat(t, goCode, tt.call), 1e-12)
+		})
+	}
+}
+
+func TestLatex2GoService_ProductOfFractions(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		latex    string
+		call     string
+		expected float64
+	}{
+		{`\prod_{k=1}^{n} \frac{k}{k+1}`, "f(3)", 1.0 / 4.0},
+		{`\prod_{k=1}^{3} \frac{k}{k+1}`, "f()", 1.0 / 4.0},
+		{`1 - \prod_{k=1}^{n} \frac{k}{k+1}`, "f(3)", 3.0 / 4.0},
+		{`\prod_{k=2}^{n} \frac{k^2 - 1}{k^2}`, "f(3)", 4.0 / 6.0}, // Telescopes to (n+1)/(2n)
+	}
+	for _, tt := range tests {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			// The float64 counter keeps k / (k + 1) a floating-point division
+			assert.Contains(t, goCode, "for k := ")
+			assert.InDelta(t, tt.expected, runGeneratedFloat(t, goCode, tt.call), 1e-12)
+		})
+	}
+}
+
+func TestLatex2GoService_BracedArrayPiecewise(t *testing.T) {
+	service := newTestService()
+
+	braced, err := service.ConvertLatexToGo(`\left\{ \begin{array}{ll} x^2 & \text{if } x > 0 \\ -x & \text{otherwise} \end{array} \right.`, "main", "f")
+	require.NoError(t, err)
+	cases, err := service.ConvertLatexToGo(`\begin{cases} x^2 & \text{if } x > 0 \\ -x & \text{otherwise} \end{cases}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, cases, braced)
+	assert.InDelta(t, 3.0, runGeneratedFloat(t, braced, "f(-3)"), 1e-12)
+}
+
+func TestLatex2GoService_TwoBranchCases(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\begin{cases} x^2 & x > 0 \\ -x & \text{otherwise} \end{cases}`, "main", "f")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, "func() float64")
+	assert.Contains(t, goCode, "if x > 0 {")
+	assert.Equal(t, "4 3", runGeneratedCode(t, goCode, "f(2), f(-3)"))
+}
+
+func TestLatex2GoService_SwitchCases(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\begin{cases} 1 & n = 0 \\ 2 & n = 1 \\ 5 & n = 2 \\ 0 & \text{otherwise} \end{cases}`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "switch n {")
+	assert.Equal(t, "1 2 5 0 0", runGeneratedCode(t, goCode, "f(0), f(1), f(2), f(3), f(0.5)"))
+
+	// More than two cases that are not all keyed on n form a chain of ifs
+	goCode, err = service.ConvertLatexToGo(`\begin{cases} 1 & n = 0 \\ 2 & n > 4 \\ 3 & n < 0 \end{cases}`, "main", "f")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, "switch")
+	assert.Equal(t, "1 2 3 NaN", runGeneratedCode(t, goCode, "f(0), f(5), f(-1), f(1)"))
+}
+
+func TestLatex2GoService_Gradient(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\nabla (x^2 + y^2)`, "main", "grad")
+	require.NoError(t, err)
+	// One partial derivative per parameter, in parameter order: (2x, 2y)
+	assert.Contains(t, goCode, "func grad(x float64, y float64) []float64 {")
+	out := runGeneratedCode(t, goCode, `fmt.Sprintf("%.6f", grad(1, 2))`)
+	assert.Equal(t, "[2.000000 4.000000]", out)
+}
+
+func TestLatex2GoService_SignFunction(t *testing.T) {
+	service := newTestService()
+
+	for _, input := range []string{`\sgn(x)`, `\operatorname{sgn}(x)`} {
+		goCode, err := service.ConvertLatexToGo(input, "main", "sign")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "func sign(x float64) float64 {")
+		assert.Equal(t, "-1 0 1", runGeneratedCode(t, goCode, "sign(-2), sign(0), sign(3)"))
+	}
+}
+
+func TestLatex2GoService_RoundingFunctions(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Halves round away from zero
+		{`\operatorname{round}(x)`, "3 -3 2"},
+		{`\operatorname{trunc}(x)`, "2 -2 2"},
+		// The fractional part keeps the sign of x
+		{`\operatorname{frac}(x)`, "0.5 -0.5 0.25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.input, "main", "f")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, runGeneratedCode(t, goCode, "f(2.5), f(-2.5), f(2.25)"))
+		})
+	}
+}
+
+func TestLatex2GoService_InverseHyperbolicFunctions(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		input    string
+		call     string
+		expected float64
+	}{
+		{`\sinh^{-1} x`, "f(1)", 0.881373587019543},
+		{`\operatorname{arccosh}(x)`, "f(2)", 1.3169578969248166},
+		{`\tanh^{-1}(x)`, "f(-0.5)", -0.5493061443340549},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.input, "main", "f")
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected, runGeneratedFloat(t, goCode, tt.call), 1e-12)
+		})
+	}
+}
+
+func TestLatex2GoService_Norms(t *testing.T) {
+	service := newTestService()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`\lVert v \rVert_1`, "7"},
+		{`\lVert v \rVert_2`, "5"},
+		{`\lVert v \rVert_\infty`, "4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.input, "main", "norm")
+			require.NoError(t, err)
+			assert.Contains(t, goCode, "func norm(v []float64) float64 {")
+			assert.Equal(t, tt.expected, runGeneratedCode(t, goCode, "norm([]float64{3, -4})"))
+		})
+	}
+}
+
+func TestLatex2GoService_BraKet(t *testing.T) {
+	service := newTestService()
+
+	for _, latex := range []string{`\braket{a|b}`, `\bra{a}\ket{b}`} {
+		goCode, err := service.ConvertLatexToGo(latex, "main", "f")
+		require.NoError(t, err)
+		assert.Equal(t, "11", runGeneratedCode(t, goCode, "f([]float64{1, 2}, []float64{3, 4})"))
+	}
+
+	// <a|b> conjugates a: (1-i)(1+i) = 2
+	complexService := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithComplex()))
+	goCode, err := complexService.ConvertLatexToGo(`\braket{a|b}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "(2+0i)", runGeneratedCode(t, goCode, "f([]complex128{1 + 1i}, []complex128{1 + 1i})"))
+}
+
+func TestLatex2GoService_EvaluationBar(t *testing.T) {
+	service := newTestService()
+
+	// \left. opens an evaluation bar: (1/3 b^3) - (1/3 a^3)
+	goCode, err := service.ConvertLatexToGo(`\left. \frac{x^3}{3} \right|_{x=a}^{b}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "9", runGeneratedCode(t, goCode, "f(0, 3)"))
+
+	// while \left| is an absolute value
+	goCode, err = service.ConvertLatexToGo(`\left| x - 5 \right|`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "2", runGeneratedCode(t, goCode, "f(3)"))
+
+	// The slope of x^3 at 2
+	goCode, err = service.ConvertLatexToGo(`\left. \frac{d}{dx} x^3 \right|_{x=2}`, "main", "f")
+	require.NoError(t, err)
+	assert.InDelta(t, 12, runGeneratedFloat(t, goCode, "f()"), 1e-6)
+}
+
+func TestLatex2GoService_DerivativeOfDefinition(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`f = x^2 y; \frac{\partial f}{\partial x}`, "main", "f")
+	require.NoError(t, err)
+	// d/dx x^2 y = 2xy
+	assert.InDelta(t, 12.0, runGeneratedFloat(t, goCode, "f(3, 2)"), 1e-6)
+}
+
+func TestLatex2GoService_PostfixAfterGcd(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\gcd(a,b)^2`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "36", runGeneratedCode(t, goCode, "f(12, -18)"))
+
+	goCode, err = service.ConvertLatexToGo(`\gcd(a,b)! + 1`, "main", "f")
+	require.NoError(t, err)
+	assert.InDelta(t, 3.0, runGeneratedFloat(t, goCode, "f(4, 6)"), 1e-9)
+}
+
+func TestLatex2GoService_IndexSetSum(t *testing.T) {
+	service := newTestService()
+
+	for _, latex := range []string{`\sum_{i=1,3,5} i^2`, `\sum_{i \in \{1,3,5\}} i^2`} {
+		goCode, err := service.ConvertLatexToGo(latex, "main", "f")
+		require.NoError(t, err)
+		assert.Equal(t, "35", runGeneratedCode(t, goCode, "f()"))
+	}
+}
+
+func TestLatex2GoService_IntervalSum(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\sum_{k \in [1,3]} k`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "6", runGeneratedCode(t, goCode, "f()"))
+
+	goCode, err = service.ConvertLatexToGo(`\prod_{k \in [1, n]} k`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "120", runGeneratedCode(t, goCode, "f(5)"))
+}
+
+func TestLatex2GoService_NegativeBounds(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\sum_{i=-3}^{3} i`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "0", runGeneratedCode(t, goCode, "f()"))
+
+	// Integer literal bounds must still give float64 endpoints
+	goCode, err = service.ConvertLatexToGo(`\int_{-1}^{1} x^2\,dx`, "main", "f")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.0/3.0, runGeneratedFloat(t, goCode, "f()"), 1e-4)
+
+	goCode, err = service.ConvertLatexToGo(`\int_{-0.5}^{0.5} x^2\,dx`, "main", "f")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0/12.0, runGeneratedFloat(t, goCode, "f()"), 1e-4)
+}
+
+func TestLatex2GoService_PlusMinus(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`1 + x \pm y`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f(x float64, y float64) (plus, minus float64) {")
+	assert.Equal(t, "6 0", runGeneratedCode(t, goCode, "f(2, 3)"))
+
+	// Both roots of x^2 - 3x + 2
+	goCode, err = service.ConvertLatexToGo(`\frac{-b \pm \sqrt{b^2 - 4 a c}}{2a}`, "main", "roots")
+	require.NoError(t, err)
+	assert.Equal(t, "2 1", runGeneratedCode(t, goCode, "roots(1, -3, 2)"))
+}
+
+func TestLatex2GoService_FloorDivision(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\lfloor 7/2 \rfloor`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "math.Floor(")
+	assert.Equal(t, "3", runGeneratedCode(t, goCode, "f()"))
+
+	goCode, err = service.ConvertLatexToGo(`\lfloor \frac{a}{b} \rfloor`, "main", "div")
+	require.NoError(t, err)
+	assert.Equal(t, "3 -4", runGeneratedCode(t, goCode, "div(7, 2), div(-7, 2)"))
+
+	goCode, err = service.ConvertLatexToGo(`\lceil a/b \rceil`, "main", "pages")
+	require.NoError(t, err)
+	assert.Equal(t, "4", runGeneratedCode(t, goCode, "pages(7, 2)"))
+}
+
+func TestLatex2GoService_SequenceSum(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\sum_n a_n`, "main", "total")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func total(a []float64) float64 {")
+	assert.Equal(t, "6 0", runGeneratedCode(t, goCode, "total([]float64{1, 2, 3}), total(nil)"))
+
+	goCode, err = service.ConvertLatexToGo(`\prod_n (1 + r_n)`, "main", "growth")
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", runGeneratedCode(t, goCode, "growth([]float64{0.25, 0.2})"))
+}
+
+func TestLatex2GoService_WithoutMathImport(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithoutMathImport()))
+
+	goCode, err := service.ConvertLatexToGo(`\sqrt{x^2 + y^2} + |z|`, "main", "f")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, `"math"`)
+	assert.Equal(t, "7 2 NaN", runGeneratedCode(t, goCode, `f(3, 4, -2), f(0, 0, 2), f(x_sqrt(-1), 0, 0)`))
+}
+
+func TestLatex2GoService_UnitCheck(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithUnitCheck()))
+
+	_, err := service.ConvertLatexToGo(`1\,\text{m} + 1\,\text{s}`, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot add meters to seconds")
+
+	// Units are tracked through assignments, products and quotients
+	_, err = service.ConvertLatexToGo(`v = \frac{d\,\text{m}}{t\,\text{s}}; v - 1\,\text{m}`, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot subtract meters from m/s")
+
+	goCode, err := service.ConvertLatexToGo(`m\,\text{kg} \cdot a\,\text{m/s^2} + 2\,\text{N}`, "main", "force")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func force(a float64, m float64) float64 {")
+	assert.Equal(t, "8", runGeneratedCode(t, goCode, "force(3, 2)"))
+
+	// The exponent after \text{m} is part of the unit, not of the quantity
+	goCode, err = service.ConvertLatexToGo(`3\,\text{m}^2 + 1\,\text{m^2}`, "main", "area")
+	require.NoError(t, err)
+	assert.Equal(t, "4", runGeneratedCode(t, goCode, "area()"))
+	_, err = service.ConvertLatexToGo(`3\,\text{m}^2 + 1\,\text{m}`, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot add m^2 to meters")
+}
+
+func TestLatex2GoService_Trace(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithTrace()))
+
+	goCode, err := service.ConvertLatexToGo(`u = x - 1; \frac{1}{u} + \sqrt{x}`, "main", "f")
+	require.NoError(t, err)
+	// The traced lines go to stderr, which runGeneratedCode captures ahead of the result
+	out := runGeneratedCode(t, goCode, "f(1)")
+	assert.Equal(t, strings.Join([]string{
+		"f: u = 0",
+		"f: (1) / (u) = +Inf",
+		"f: math.Sqrt(x) = 1",
+		"f: result = +Inf",
+		"+Inf",
+	}, "\n"), out)
+}
+
+func TestLatex2GoService_OverflowCheck(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithOverflowCheck()))
+
+	goCode, err := service.ConvertLatexToGo(`n!`, "main", "fact")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func fact(n float64) (float64, error) {")
+	// 170! is the largest factorial a float64 holds; 171! overflows
+	out := runGeneratedCode(t, goCode, `func() string { _, err170 := fact(170); _, err171 := fact(171); return fmt.Sprint(err170, ", ", err171) }()`)
+	assert.Equal(t, "<nil>, fact: result overflows float64", out)
+
+	// A logarithm of a non-positive value fails instead of returning NaN
+	goCode, err = service.ConvertLatexToGo(`\ln(x) + 1`, "main", "f")
+	require.NoError(t, err)
+	out = runGeneratedCode(t, goCode, `func() string { v1, err1 := f(1); _, err2 := f(-2); _, err3 := f(0); return fmt.Sprint(v1, " ", err1, ", ", err2, ", ", err3) }()`)
+	assert.Equal(t, "1 <nil>, f: log of non-positive value, f: log of non-positive value", out)
+
+	// A logarithm in a case is only checked where the case applies
+	goCode, err = service.ConvertLatexToGo(`\begin{cases} \ln x & x > 0 \\ 0 & \text{otherwise} \end{cases}`, "main", "g")
+	require.NoError(t, err)
+	assert.Equal(t, "0 <nil>", runGeneratedCode(t, goCode, "g(-1)"))
+
+	// Sums are checked too, not generated as a bare loop
+	goCode, err = service.ConvertLatexToGo(`\sum_{i=1}^{n} i`, "main", "total")
+	require.NoError(t, err)
+	assert.Equal(t, "55 <nil>", runGeneratedCode(t, goCode, "total(10)"))
+}
+
+func TestLatex2GoService_Context(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithContext()))
+
+	goCode, err := service.ConvertLatexToGo(`\sum_{i=1}^{n} i`, "main", "total")
+	require.NoError(t, err)
+	// The generated file imports context; the canceled one is declared alongside it
+	goCode += `
+var background = context.Background()
+
+var canceled = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+`
+	assert.Equal(t, "55 <nil>", runGeneratedCode(t, goCode, "total(background, 10)"))
+	// A canceled loop stops at the first check, 256 iterations in
+	out := runGeneratedCode(t, goCode, `func() string { v, err := total(canceled, 1e6); return fmt.Sprint(v < 1e6, " ", err) }()`)
+	assert.Equal(t, "true context canceled", out)
+}
+
+func TestLatex2GoService_Einstein(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithEinstein()))
+
+	goCode, err := service.ConvertLatexToGo(`a^i b_i`, "main", "dot")
+	require.NoError(t, err)
+	assert.Equal(t, "32", runGeneratedCode(t, goCode, "dot([]float64{1, 2, 3}, []float64{4, 5, 6})"))
+
+	goCode, err = service.ConvertLatexToGo(`2 a^{\mu} b_{\mu} + 1`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "65", runGeneratedCode(t, goCode, "f([]float64{1, 2, 3}, []float64{4, 5, 6})"))
+}
+
+func TestLatex2GoService_VectorArithmetic(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`\vec{a} + \vec{b}`, "main", "add")
+	require.NoError(t, err)
+	assert.Equal(t, "[5 7 9]", runGeneratedCode(t, goCode, "add([]float64{1, 2, 3}, []float64{4, 5, 6})"))
+
+	goCode, err = service.ConvertLatexToGo(`2 \cdot \overrightarrow{AB} - \hat{n}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "[1.4 3.2]", runGeneratedCode(t, goCode, "f([]float64{1, 2}, []float64{3, 4})"))
+}
+
+func TestLatex2GoService_IntegerConstantDivision(t *testing.T) {
+	service := newTestService()
+
+	for _, tt := range []struct {
+		latex    string
+		expected string
+	}{
+		{`\frac{1}{2} x`, "3"},
+		{`x + 1/4`, "6.25"},
+		{`\frac{(1+2)^2}{4}`, "2.25"},
+	} {
+		t.Run(tt.latex, func(t *testing.T) {
+			goCode, err := service.ConvertLatexToGo(tt.latex, "main", "f")
+			require.NoError(t, err)
+			call := "f()"
+			if strings.Contains(goCode, "x float64") {
+				call = "f(6)"
+			}
+			assert.Equal(t, tt.expected, runGeneratedCode(t, goCode, call))
+		})
+	}
+}
+
+func TestLatex2GoService_Schema(t *testing.T) {
+	service := newTestService()
+
+	schema, err := service.ConvertLatexToSchema(`\frac{m}{\sqrt{r}}`, "potential")
+	require.NoError(t, err)
+	var decoded struct {
+		Title      string                    `json:"title"`
+		Properties map[string]map[string]any `json:"properties"`
+		Required   []string                  `json:"required"`
+		Defs       map[string]map[string]any `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(schema), &decoded))
+	assert.Equal(t, "potential", decoded.Title)
+	assert.Equal(t, []string{"m", "r"}, decoded.Required)
+	assert.Equal(t, map[string]any{"type": "number"}, decoded.Properties["m"])
+	assert.Equal(t, map[string]any{"type": "number", "minimum": 0.0}, decoded.Properties["r"])
+	assert.Equal(t, map[string]any{"type": "number"}, decoded.Defs["result"])
+}
+
+func TestLatex2GoService_Optimize(t *testing.T) {
+	optimizing := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithOptimize()))
+
+	for _, input := range []string{`a x^3 + b x^2 + c x + d`, `2x^8 - 3x^4 + x + 5`, `1 - x^2 + \frac{x^3}{2}`} {
+		t.Run(input, func(t *testing.T) {
+			plainCode, err := newTestService().ConvertLatexToGo(input, "main", "f")
+			require.NoError(t, err)
+			goCode, err := optimizing.ConvertLatexToGo(input, "main", "f")
+			require.NoError(t, err)
+			assert.NotContains(t, goCode, "math.Pow")
+
+			call := "f(1.5)"
+			if strings.Contains(goCode, "a float64") {
+				call = "f(2, -3, 0.5, 7, 1.5)"
+			}
+			assert.InDelta(t, runGeneratedFloat(t, plainCode, call), runGeneratedFloat(t, goCode, call), 1e-9)
+		})
+	}
+}
+
+func TestLatex2GoService_ConvertLatexToGoFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping compile-and-run test in short mode")
+	}
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(generator.WithoutMathImport()))
+
+	// Two functions of the package share one helpers.go
+	dir := t.TempDir()
+	for _, eq := range []struct{ latex, funcName string }{{`\sqrt{x^2 + y^2}`, "hypot"}, {`|x - y|`, "dist"}} {
+		files, err := service.ConvertLatexToGoFiles(eq.latex, "main", eq.funcName)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		assert.Equal(t, eq.funcName+".go", files[0].Name)
+		for _, file := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, file.Name), []byte(file.Code), 0644))
+		}
+	}
+	mainSrc := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(hypot(3, 4), dist(1, 3))\n}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(mainSrc), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module generated\n\ngo 1.21\n"), 0644))
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "generated files failed to run:\n%s", out)
+	assert.Equal(t, "5 2", strings.TrimSpace(string(out)))
+}
+
+func TestLatex2GoService_NumericGuards(t *testing.T) {
+	service := app.NewLatex2GoService(parser.NewParser(), generator.NewGenerator(
+		generator.WithNumericGuards(), generator.WithMaxTerms(60), generator.WithOverflowCheck()))
+
+	// The series up to \infty stops after 60 terms
+	goCode, err := service.ConvertLatexToGo(`\sum_{n=1}^{\infty} \frac{1}{2^n}`, "main", "geometric")
+	require.NoError(t, err)
+	assert.Equal(t, "1 <nil>", runGeneratedCode(t, goCode, "geometric()"))
+
+	// A NaN term stops the accumulation and fails the call
+	goCode, err = service.ConvertLatexToGo(`\sum_{n=1}^{m} \sqrt{c - n}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "0 <nil>, 0 f: result is NaN",
+		runGeneratedCode(t, goCode, `func() string { v1, err1 := f(1, 1); v2, err2 := f(1, 5); return fmt.Sprint(v1, " ", err1, ", ", v2, " ", err2) }()`))
+}
+
+func TestLatex2GoService_PmodValueAndCongruence(t *testing.T) {
+	service := newTestService()
+
+	goCode, err := service.ConvertLatexToGo(`7 \pmod 3`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f() float64 {")
+	assert.Equal(t, "1", runGeneratedCode(t, goCode, "f()"))
+
+	// \bmod and the function forms \operatorname{mod}(a, n) and \mod(a, n) generate the same code
+	for _, input := range []string{`7 \bmod 3`, `\operatorname{mod}(7, 3)`, `\mod(7, 3)`} {
+		modCode, err := service.ConvertLatexToGo(input, "main", "f")
+		require.NoError(t, err)
+		assert.Equal(t, goCode, modCode, input)
+	}
+
+	goCode, err = service.ConvertLatexToGo(`7 \equiv 1 \pmod 3`, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f() bool {")
+	assert.Equal(t, "true", runGeneratedCode(t, goCode, "f()"))
+
+	goCode, err = service.ConvertLatexToGo(`a \equiv b \pmod{n}`, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, "false", runGeneratedCode(t, goCode, "f(7, 2, 3)"))
+}
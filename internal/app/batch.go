@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// BatchResult holds the outcome of converting one equation of a batch.
+type BatchResult struct {
+	Index    int    // Position of the equation in the input slice
+	Latex    string // The LaTeX input
+	FuncName string // Name of the generated function
+	Code     string // Generated Go code (empty on error)
+	Err      error  // Conversion error, if any
+}
+
+// ConvertBatch converts many LaTeX equations concurrently using a bounded pool of
+// workers (runtime.NumCPU() if workers <= 0). Results are returned in input order.
+// Each generated function is named funcName followed by its 1-based position.
+// The parser and generator are shared between workers: both are safe for
+// concurrent use because every Parse call builds its own lexer and parser state.
+func (s *Latex2GoService) ConvertBatch(latexInputs []string, packageName, funcName string, workers int) []BatchResult {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if funcName == "" {
+		funcName = "generatedFunc" // Default function name
+	}
+
+	results := make([]BatchResult, len(latexInputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				name := fmt.Sprintf("%s%d", funcName, i+1)
+				code, err := s.ConvertLatexToGo(latexInputs[i], packageName, name)
+				// Each worker writes only to its own index, so no locking is needed
+				results[i] = BatchResult{
+					Index:    i,
+					Latex:    latexInputs[i],
+					FuncName: name,
+					Code:     code,
+					Err:      err,
+				}
+			}
+		}()
+	}
+	for i := range latexInputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
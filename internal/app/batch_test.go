@@ -0,0 +1,63 @@
+package app_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatex2GoService_ConvertBatch_PreservesOrder(t *testing.T) {
+	service := newTestService()
+
+	inputs := make([]string, 200)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("x + %d", i)
+	}
+	inputs[7] = `\sqrt{x` // An invalid equation must not affect its neighbours
+
+	results := service.ConvertBatch(inputs, "main", "f", 8)
+	require.Len(t, results, len(inputs))
+
+	for i, res := range results {
+		assert.Equal(t, i, res.Index)
+		assert.Equal(t, inputs[i], res.Latex)
+		assert.Equal(t, fmt.Sprintf("f%d", i+1), res.FuncName)
+		if i == 7 {
+			assert.Error(t, res.Err)
+			assert.Empty(t, res.Code)
+			continue
+		}
+		require.NoError(t, res.Err)
+		assert.Contains(t, res.Code, fmt.Sprintf("func f%d(x float64) float64", i+1))
+		assert.Contains(t, res.Code, fmt.Sprintf("return x + %d", i))
+	}
+}
+
+func TestLatex2GoService_ConvertBatch_DefaultWorkers(t *testing.T) {
+	service := newTestService()
+
+	results := service.ConvertBatch([]string{"a", "b^2"}, "main", "", 0)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err)
+	require.NoError(t, results[1].Err)
+	assert.Contains(t, results[0].Code, "func generatedFunc1(a float64) float64")
+	assert.Contains(t, results[1].Code, "func generatedFunc2(b float64) float64")
+}
+
+func BenchmarkLatex2GoService_ConvertBatch(b *testing.B) {
+	service := newTestService()
+	inputs := make([]string, 1000)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf(`\frac{x^2 + %d}{\sqrt{y}} - \sum_{i=1}^{n} i`, i)
+	}
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				service.ConvertBatch(inputs, "main", "f", workers)
+			}
+		})
+	}
+}
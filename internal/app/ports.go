@@ -3,13 +3,18 @@ package app
 import (
 	// Import domain components used in interfaces
 	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/generator"
 )
 
 // Config holds configuration values passed from the input adapter.
 type Config struct {
-	OutputFile  string
-	PackageName string
-	FuncName    string
+	OutputFile   string
+	PackageName  string
+	FuncName     string
+	DebugAST     bool // Print the parsed AST to stderr before generating code
+	SplitHelpers bool // Write the helper functions to a separate file next to the generated function
+	Profile      bool // Print how long each phase of the conversion took to stderr
+	Schema       bool // Write a JSON Schema of the function's parameters and result instead of its code
 }
 
 // LatexProvider defines the input port for retrieving LaTeX input and config.
@@ -22,6 +27,13 @@ type GoCodeWriter interface {
 	WriteGoCode(code string) error
 }
 
+// GoFilesWriter is implemented by output ports that can also write several named
+// files, as needed by Config.SplitHelpers. The first file holds the generated
+// function; the others are written alongside it.
+type GoFilesWriter interface {
+	WriteGoFiles(files []generator.File) error
+}
+
 // --- Domain Service Interfaces ---
 // These interfaces define the contracts for domain services used by the application.
 
@@ -34,3 +46,23 @@ type Parser interface {
 type Generator interface {
 	Generate(root ast.Expr, pkgName, funcName string) (string, error)
 }
+
+// FilesGenerator is implemented by generators that can put the helper functions in
+// a separate file, as needed by Config.SplitHelpers.
+type FilesGenerator interface {
+	GenerateFiles(root ast.Expr, pkgName, funcName string) ([]generator.File, error)
+}
+
+// SchemaGenerator is implemented by generators that can describe the parameters
+// and the result of the generated function as a JSON Schema, as needed by
+// Config.Schema.
+type SchemaGenerator interface {
+	GenerateSchema(root ast.Expr, funcName string) (string, error)
+}
+
+// FormattingGenerator is implemented by generators that can generate and format
+// the code as separate steps, so that Config.Profile can time them apart.
+type FormattingGenerator interface {
+	GenerateUnformatted(root ast.Expr, pkgName, funcName string) (string, error)
+	Format(src string) (string, error)
+}
@@ -2,6 +2,10 @@ package app
 
 import (
 	"fmt"
+	"os"
+	"time"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
 	// Import domain components (adjust paths/names if they differ)
 	// "github.com/ZanzyTHEbar/latex2go/internal/domain/generator" // No longer needed directly
 	// "github.com/ZanzyTHEbar/latex2go/internal/domain/parser"    // No longer needed directly
@@ -40,23 +44,124 @@ func (s *ApplicationService) Run() error {
 	}
 
 	// 2. Parse the LaTeX string using the domain parser
+	timings := &phaseTimings{}
+	start := time.Now()
 	internalAST, err := s.parser.Parse(latexInput)
+	timings.since("parse", start)
 	if err != nil {
 		return fmt.Errorf("failed to parse latex: %w", err)
 	}
+	if config.DebugAST {
+		fmt.Fprint(os.Stderr, ast.Dump(internalAST))
+	}
+
+	if config.Schema {
+		// 3-4. Generate and write the JSON Schema in place of the code
+		if err := s.writeSchema(internalAST, config, timings); err != nil {
+			return err
+		}
+		if config.Profile {
+			fmt.Fprintln(os.Stderr, timings)
+		}
+		fmt.Println("Successfully generated the JSON Schema.")
+		return nil
+	}
+	if config.SplitHelpers {
+		// 3-4. Generate and write the function and its helpers as separate files
+		if err := s.writeSplitFiles(internalAST, config, timings); err != nil {
+			return err
+		}
+	} else {
+		// 3. Generate Go code using the domain generator
+		goCode, err := s.generateCode(internalAST, config, timings)
+		if err != nil {
+			return fmt.Errorf("failed to generate go code: %w", err)
+		}
+
+		// 4. Write the output using the code writer
+		start = time.Now()
+		err = s.codeWriter.WriteGoCode(goCode)
+		timings.since("write", start)
+		if err != nil {
+			return fmt.Errorf("failed to write go code: %w", err)
+		}
+	}
+	if config.Profile {
+		// On stderr, so that the timings never mix with code written to stdout
+		fmt.Fprintln(os.Stderr, timings)
+	}
 
-	// 3. Generate Go code using the domain generator
-	goCode, err := s.generator.Generate(internalAST, config.PackageName, config.FuncName)
+	fmt.Println("Successfully generated Go code.") // Add success message
+	return nil
+}
+
+// generateCode generates the Go code for root. When profiling, the generation and
+// the formatting are timed separately if the generator supports it.
+func (s *ApplicationService) generateCode(root ast.Expr, config Config, timings *phaseTimings) (string, error) {
+	formatting, ok := s.generator.(FormattingGenerator)
+	if !config.Profile || !ok {
+		start := time.Now()
+		goCode, err := s.generator.Generate(root, config.PackageName, config.FuncName)
+		timings.since("generate", start)
+		return goCode, err
+	}
+
+	start := time.Now()
+	src, err := formatting.GenerateUnformatted(root, config.PackageName, config.FuncName)
+	timings.since("generate", start)
 	if err != nil {
-		return fmt.Errorf("failed to generate go code: %w", err)
+		return "", err
 	}
+	start = time.Now()
+	goCode, err := formatting.Format(src)
+	timings.since("format", start)
+	return goCode, err
+}
 
-	// 4. Write the output using the code writer
-	err = s.codeWriter.WriteGoCode(goCode)
+// writeSplitFiles generates the function and its helpers as separate files and
+// writes them. Both the generator and the code writer must support several files.
+func (s *ApplicationService) writeSplitFiles(root ast.Expr, config Config, timings *phaseTimings) error {
+	filesGenerator, ok := s.generator.(FilesGenerator)
+	if !ok {
+		return fmt.Errorf("the generator cannot put helpers in a separate file")
+	}
+	filesWriter, ok := s.codeWriter.(GoFilesWriter)
+	if !ok {
+		return fmt.Errorf("the output cannot write several files")
+	}
+	start := time.Now()
+	files, err := filesGenerator.GenerateFiles(root, config.PackageName, config.FuncName)
+	timings.since("generate", start) // Includes the formatting
+	if err != nil {
+		return fmt.Errorf("failed to generate go code: %w", err)
+	}
+	start = time.Now()
+	err = filesWriter.WriteGoFiles(files)
+	timings.since("write", start)
 	if err != nil {
 		return fmt.Errorf("failed to write go code: %w", err)
 	}
+	return nil
+}
 
-	fmt.Println("Successfully generated Go code.") // Add success message
+// writeSchema generates the JSON Schema of the function and writes it. The
+// generator must support schemas.
+func (s *ApplicationService) writeSchema(root ast.Expr, config Config, timings *phaseTimings) error {
+	schemaGenerator, ok := s.generator.(SchemaGenerator)
+	if !ok {
+		return fmt.Errorf("the generator cannot describe the function as a JSON Schema")
+	}
+	start := time.Now()
+	schema, err := schemaGenerator.GenerateSchema(root, config.FuncName)
+	timings.since("generate", start)
+	if err != nil {
+		return fmt.Errorf("failed to generate the JSON Schema: %w", err)
+	}
+	start = time.Now()
+	err = s.codeWriter.WriteGoCode(schema)
+	timings.since("write", start)
+	if err != nil {
+		return fmt.Errorf("failed to write the JSON Schema: %w", err)
+	}
 	return nil
 }
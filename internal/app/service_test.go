@@ -1,13 +1,18 @@
 package app_test
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"os"
 	"testing"
 
 	"github.com/ZanzyTHEbar/latex2go/internal/app"
 	app_mocks "github.com/ZanzyTHEbar/latex2go/internal/app/mocks"
 	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/generator"
 	gen_mocks "github.com/ZanzyTHEbar/latex2go/internal/domain/generator/mocks"
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/parser"
 	parser_mocks "github.com/ZanzyTHEbar/latex2go/internal/domain/parser/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -152,3 +157,110 @@ func TestApplicationService_Run_WriteError(t *testing.T) {
 	assert.ErrorContains(t, err, "failed to write go code")
 	assert.ErrorIs(t, err, expectedError)
 }
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(f func() error) (string, error) {
+	oldStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+
+	err := f()
+
+	w.Close()
+	os.Stderr = oldStderr
+
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	return buf.String(), err
+}
+
+func TestApplicationService_Run_Profile(t *testing.T) {
+	// Arrange: the real parser and generator, which can time formatting apart
+	mockProvider := app_mocks.NewMockLatexProvider(t)
+	mockWriter := app_mocks.NewMockGoCodeWriter(t)
+	latexParser := parser.NewParser()
+	codeGenerator := generator.NewGenerator()
+
+	inputLatex := `\sqrt{x} + y`
+	inputConfig := app.Config{PackageName: "p", FuncName: "f", Profile: true}
+	root, err := latexParser.Parse(inputLatex)
+	require.NoError(t, err)
+	expectedGoCode, err := codeGenerator.Generate(root, "p", "f")
+	require.NoError(t, err)
+
+	mockProvider.On("GetLatexInput").Return(inputLatex, inputConfig, nil).Once()
+	// The code written is exactly the generated code, free of timings
+	mockWriter.On("WriteGoCode", expectedGoCode).Return(nil).Once()
+
+	service := app.NewApplicationService(mockProvider, mockWriter, latexParser, codeGenerator)
+
+	// Act
+	stderr, err := captureStderr(service.Run)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Regexp(t, `^profile: parse \S+, generate \S+, format \S+, write \S+, total \S+\n$`, stderr)
+}
+
+func TestApplicationService_Run_ProfileDisabled(t *testing.T) {
+	mockProvider := app_mocks.NewMockLatexProvider(t)
+	mockWriter := app_mocks.NewMockGoCodeWriter(t)
+	mockParser := parser_mocks.NewMockParser(t)
+	mockGenerator := gen_mocks.NewMockGenerator(t)
+
+	inputConfig := app.Config{PackageName: "p", FuncName: "f"}
+	mockAST := &ast.Variable{Name: "x"}
+	mockProvider.On("GetLatexInput").Return("x", inputConfig, nil).Once()
+	mockParser.On("Parse", "x").Return(mockAST, nil).Once()
+	mockGenerator.On("Generate", mockAST, "p", "f").Return("package p", nil).Once()
+	mockWriter.On("WriteGoCode", "package p").Return(nil).Once()
+
+	service := app.NewApplicationService(mockProvider, mockWriter, mockParser, mockGenerator)
+
+	stderr, err := captureStderr(service.Run)
+
+	require.NoError(t, err)
+	assert.Empty(t, stderr)
+}
+
+func TestApplicationService_Run_Schema(t *testing.T) {
+	// The real generator, which can describe the function as a JSON Schema
+	mockProvider := app_mocks.NewMockLatexProvider(t)
+	mockWriter := app_mocks.NewMockGoCodeWriter(t)
+	latexParser := parser.NewParser()
+	codeGenerator := generator.NewGenerator()
+
+	inputLatex := `\sqrt{x}`
+	inputConfig := app.Config{PackageName: "p", FuncName: "f", Schema: true}
+	root, err := latexParser.Parse(inputLatex)
+	require.NoError(t, err)
+	expectedSchema, err := codeGenerator.GenerateSchema(root, "f")
+	require.NoError(t, err)
+
+	mockProvider.On("GetLatexInput").Return(inputLatex, inputConfig, nil).Once()
+	// The schema is written in place of the code
+	mockWriter.On("WriteGoCode", expectedSchema).Return(nil).Once()
+
+	service := app.NewApplicationService(mockProvider, mockWriter, latexParser, codeGenerator)
+
+	require.NoError(t, service.Run())
+}
+
+func TestApplicationService_Run_SchemaUnsupported(t *testing.T) {
+	mockProvider := app_mocks.NewMockLatexProvider(t)
+	mockWriter := app_mocks.NewMockGoCodeWriter(t)
+	mockParser := parser_mocks.NewMockParser(t)
+	mockGenerator := gen_mocks.NewMockGenerator(t)
+
+	inputConfig := app.Config{PackageName: "p", FuncName: "f", Schema: true}
+	mockAST := &ast.Variable{Name: "x"}
+	mockProvider.On("GetLatexInput").Return("x", inputConfig, nil).Once()
+	mockParser.On("Parse", "x").Return(mockAST, nil).Once()
+
+	service := app.NewApplicationService(mockProvider, mockWriter, mockParser, mockGenerator)
+
+	err := service.Run()
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "the generator cannot describe the function as a JSON Schema")
+}
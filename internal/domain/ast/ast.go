@@ -13,6 +13,20 @@ type Expr interface {
 	expr() // Internal marker method
 }
 
+// Extension is implemented by custom node types defined outside this package
+// (e.g. for domain-specific notation). Custom nodes embed ExtensionNode to satisfy Expr.
+type Extension interface {
+	Expr
+	Kind() string     // Key used to look up the node's code generator
+	Children() []Expr // Sub-expressions, walked when collecting free variables
+}
+
+// ExtensionNode is embedded in custom node types so they satisfy the Expr interface.
+type ExtensionNode struct{}
+
+func (ExtensionNode) node() {}
+func (ExtensionNode) expr() {}
+
 // --- Concrete Node Types ---
 
 // NumberLiteral represents a numeric value (e.g., 3.14, 42).
@@ -31,9 +45,17 @@ type Variable struct {
 func (Variable) node() {}
 func (Variable) expr() {}
 
+// ConstantExpr represents a named mathematical constant (e.g., \pi, \infty).
+type ConstantExpr struct {
+	Name string // Command name of the constant (e.g., "pi", "infty")
+}
+
+func (ConstantExpr) node() {}
+func (ConstantExpr) expr() {}
+
 // BinaryExpr represents an operation with two operands (e.g., a + b, x ^ 2).
 type BinaryExpr struct {
-	Op    string // Operator token (e.g., "+", "-", "*", "/", "^")
+	Op    string // Operator token (e.g., "+", "-", "*", "/", "^", "mod", "<", "<=", "&&", "||")
 	Left  Expr   // Left-hand side expression
 	Right Expr   // Right-hand side expression
 }
@@ -41,6 +63,35 @@ type BinaryExpr struct {
 func (BinaryExpr) node() {}
 func (BinaryExpr) expr() {}
 
+// RelationalChain represents a chained comparison (e.g., 0 \le x \le 10),
+// which holds when every adjacent pair of operands satisfies its relation.
+type RelationalChain struct {
+	Operands []Expr   // The compared expressions, one more than Ops
+	Ops      []string // Ordering operators between adjacent operands ("<", "<=", ">", ">=")
+}
+
+func (RelationalChain) node() {}
+func (RelationalChain) expr() {}
+
+// CongruenceExpr represents a congruence (e.g., a \equiv b \pmod{n}),
+// which holds when a - b is a multiple of n.
+type CongruenceExpr struct {
+	Left, Right Expr // The congruent expressions
+	Modulus     Expr // The modulus (e.g., n)
+}
+
+func (CongruenceExpr) node() {}
+func (CongruenceExpr) expr() {}
+
+// UnaryExpr represents an operation with a single operand (e.g., \lnot p).
+type UnaryExpr struct {
+	Op      string // Operator token (e.g., "!")
+	Operand Expr   // The expression the operator applies to
+}
+
+func (UnaryExpr) node() {}
+func (UnaryExpr) expr() {}
+
 // FuncCall represents a function call (e.g., \sqrt{x}, \sin{y}, \frac{a}{b}).
 // Note: \frac{a}{b} is treated like a function call in this AST,
 // the generator will handle its specific translation to Go division.
@@ -53,10 +104,15 @@ func (FuncCall) node() {}
 func (FuncCall) expr() {}
 
 // SumExpr represents a summation or product (e.g., \sum_{i=1}^{n} f(i), \prod_{i=1}^{n} f(i)).
+// The index runs over the integers in [Lower, Upper]; when that range is empty the
+// sum is 0 and the product 1 (e.g., \sum_{i=1}^{0} i = 0). With Values, it takes
+// each listed value in turn instead.
 type SumExpr struct {
 	IsProduct   bool   // true for product (\prod), false for sum (\sum)
 	Var         string // Summation variable (e.g., "i")
-	Lower, Upper Expr  // Lower and upper bounds (e.g., 1, n)
+	Lower, Upper Expr  // Lower and upper bounds (e.g., 1, n); both nil for \sum_n over the indices of a sequence
+	Values      []Expr // Enumerated index values (e.g., 1, 3, 5 in \sum_{i=1,3,5}); nil for a range
+	Filter      Expr   // Condition on the index from \substack (e.g., i \ne j); nil if every index counts
 	Body        Expr   // The expression to sum/product over (e.g., f(i))
 }
 
@@ -85,16 +141,134 @@ type DerivativeExpr struct {
 func (DerivativeExpr) node() {}
 func (DerivativeExpr) expr() {}
 
+// GradientExpr represents the gradient of a scalar expression (e.g., \nabla (x^2 + y^2)):
+// the vector of its partial derivatives with respect to each of its free variables.
+type GradientExpr struct {
+	Body Expr // The differentiated scalar expression
+}
+
+func (GradientExpr) node() {}
+func (GradientExpr) expr() {}
+
+// NormExpr represents the p-norm of a vector (e.g., \lVert v \rVert_1): the L1 norm
+// sums the absolute values, the L2 norm is the Euclidean length and the maximum norm
+// (\lVert v \rVert_\infty) is the largest absolute value.
+type NormExpr struct {
+	Vector string  // Name of the vector, a []float64 parameter (e.g., "v")
+	Order  float64 // The p of the norm, at least 1; +Inf for the maximum norm
+}
+
+func (NormExpr) node() {}
+func (NormExpr) expr() {}
+
+// InnerProductExpr represents the inner product of two vectors in bra-ket notation
+// (e.g., \braket{a|b} or \bra{a}\ket{b}): the sum of the products of their
+// elements, with those of the bra conjugated in complex mode.
+type InnerProductExpr struct {
+	Bra string // Name of the left vector, a slice parameter (e.g., "a")
+	Ket string // Name of the right vector, a slice parameter (e.g., "b")
+}
+
+func (InnerProductExpr) node() {}
+func (InnerProductExpr) expr() {}
+
+// KetExpr represents a lone ket or bra, a vector (e.g., \ket{\psi} or \bra{\psi}).
+type KetExpr struct {
+	Vector string // Name of the vector (e.g., "psi")
+	IsBra  bool   // true for \bra, false for \ket
+}
+
+func (KetExpr) node() {}
+func (KetExpr) expr() {}
+
+// VectorExpr represents a decorated vector (e.g., \vec{v}, \overrightarrow{AB} or
+// \hat{n}). In vector arithmetic it is a []float64 parameter; anywhere else the
+// decoration is dropped and it is the scalar variable of the same name.
+type VectorExpr struct {
+	Name string // Name of the vector (e.g., "v")
+	Unit bool   // true for \hat, the vector divided by its Euclidean norm
+}
+
+func (VectorExpr) node() {}
+func (VectorExpr) expr() {}
+
+// ConditionalExpectationExpr represents the expectation of a random variable given
+// a condition (e.g., \langle X \mid Y \rangle or \mathbb{E}[X \mid Y > 0]).
+type ConditionalExpectationExpr struct {
+	Value Expr // The random variable (e.g., X)
+	Given Expr // What it is conditioned on, a random variable or an event (e.g., Y > 0)
+}
+
+func (ConditionalExpectationExpr) node() {}
+func (ConditionalExpectationExpr) expr() {}
+
+// IndexExpr represents an element of a sequence (e.g., a_n or a_{i+1}). Indices
+// start at 0, so a_0 is the first element.
+type IndexExpr struct {
+	Sequence string // Name of the sequence, a []float64 parameter (e.g., "a")
+	Index    Expr   // The position of the element (e.g., n)
+}
+
+func (IndexExpr) node() {}
+func (IndexExpr) expr() {}
+
+// UnitExpr represents a quantity annotated with a unit (e.g., 9.81\,\text{m/s^2}).
+// The unit does not change the value; it only takes part in dimensional checks.
+type UnitExpr struct {
+	Value Expr   // The annotated quantity (e.g., 9.81)
+	Unit  string // The unit as written (e.g., "m/s^2")
+}
+
+func (UnitExpr) node() {}
+func (UnitExpr) expr() {}
+
 // LimitExpr represents a limit (e.g., \lim_{x \to a} f(x)).
 type LimitExpr struct {
 	Var        string // Limit variable (e.g., "x")
 	Approaches Expr   // Value that the variable approaches (e.g., a)
+	Direction  string // "+" from the right (a^+), "-" from the left (a^-), "" for two-sided
 	Body       Expr   // The expression to compute the limit of (e.g., f(x))
 }
 
 func (LimitExpr) node() {}
 func (LimitExpr) expr() {}
 
+// EvaluationExpr represents an evaluation bar (e.g., \left. x^2 \right|_0^1 or
+// \left. f \right|_{x=a}): the body with its variable set to Upper, minus the body
+// with it set to Lower, or the body at Lower alone without Upper.
+type EvaluationExpr struct {
+	Body         Expr   // The evaluated expression (e.g., x^2)
+	Var          string // The variable set to the bounds; empty for the only variable of the body
+	Lower, Upper Expr   // The bounds (e.g., 0, 1); Upper is nil for an evaluation at a point
+}
+
+func (EvaluationExpr) node() {}
+func (EvaluationExpr) expr() {}
+
+// ArgOptExpr represents an argmin/argmax over a bounded domain
+// (e.g., \argmin_{x \in [0, 10]} (x-3)^2).
+type ArgOptExpr struct {
+	IsMax        bool   // true for argmax, false for argmin
+	Var          string // Optimization variable (e.g., "x")
+	Lower, Upper Expr   // Search domain bounds; nil if no domain was given
+	Body         Expr   // The objective expression (e.g., (x-3)^2)
+}
+
+func (ArgOptExpr) node() {}
+func (ArgOptExpr) expr() {}
+
+// BigOpExpr represents a named operator with limits (e.g., \operatorname*{mean}_{i=1}^{n} x_i).
+// The generator reduces it with the reducer registered under Name.
+type BigOpExpr struct {
+	Name         string // Operator name (e.g., "mean")
+	Var          string // Index variable (e.g., "i")
+	Lower, Upper Expr   // Index bounds; nil when not given
+	Body         Expr   // The expression reduced over the index
+}
+
+func (BigOpExpr) node() {}
+func (BigOpExpr) expr() {}
+
 // FactorialExpr represents a factorial (e.g., n!).
 type FactorialExpr struct {
 	Value Expr // The expression to compute factorial of
@@ -103,6 +277,18 @@ type FactorialExpr struct {
 func (FactorialExpr) node() {}
 func (FactorialExpr) expr() {}
 
+// PochhammerExpr represents a rising or falling factorial: the rising factorial (x)_n,
+// also written x^{\overline{n}}, is x(x+1)...(x+n-1), and the falling factorial
+// x^{\underline{n}} is x(x-1)...(x-n+1). Both are 1 when n is 0.
+type PochhammerExpr struct {
+	Base   Expr // The factorial base (e.g., x)
+	Count  Expr // The number of factors (e.g., n)
+	Rising bool // true for the rising factorial, false for the falling one
+}
+
+func (PochhammerExpr) node() {}
+func (PochhammerExpr) expr() {}
+
 // PiecewiseCase represents one case in a piecewise function definition.
 type PiecewiseCase struct {
 	Value      Expr // Expression value for this case
@@ -117,4 +303,49 @@ type PiecewiseExpr struct {
 func (PiecewiseExpr) node() {}
 func (PiecewiseExpr) expr() {}
 
+// KroneckerDeltaExpr represents the Kronecker delta (e.g., \delta_{ij}),
+// which is 1 when both indices are equal and 0 otherwise.
+type KroneckerDeltaExpr struct {
+	I, J Expr // The two indices being compared
+}
+
+func (KroneckerDeltaExpr) node() {}
+func (KroneckerDeltaExpr) expr() {}
+
+// IndicatorExpr represents an indicator function (e.g., \mathbb{1}[x > 0]),
+// which is 1 when the condition holds and 0 otherwise.
+type IndicatorExpr struct {
+	Condition Expr // The condition being tested
+}
+
+func (IndicatorExpr) node() {}
+func (IndicatorExpr) expr() {}
+
+// MatrixExpr represents a matrix-like environment (e.g., \begin{pmatrix} a & b \\ c & d \end{pmatrix}).
+type MatrixExpr struct {
+	Rows [][]Expr // Cells in row-major order; all rows have the same length
+}
+
+func (MatrixExpr) node() {}
+func (MatrixExpr) expr() {}
+
+// AssignmentExpr binds a name to an intermediate value (e.g., u = x^2).
+type AssignmentExpr struct {
+	Name  string // The assigned name (e.g., "u")
+	Value Expr   // The assigned value (e.g., x^2)
+}
+
+func (AssignmentExpr) node() {}
+func (AssignmentExpr) expr() {}
+
+// BlockExpr represents intermediate assignments followed by a final expression
+// (e.g., u = x^2; u + 1). Each assignment may use the names assigned before it.
+type BlockExpr struct {
+	Assignments []*AssignmentExpr // Assignments in source order
+	Result      Expr              // The final expression, whose value is the result
+}
+
+func (BlockExpr) node() {}
+func (BlockExpr) expr() {}
+
 // TODO: Add IntegralExpr, DerivativeExpr, LimitExpr, PiecewiseExpr, SetIterationExpr as needed.
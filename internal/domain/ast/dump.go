@@ -0,0 +1,63 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dump renders an expression tree as indented text for debugging, one node type
+// per line followed by its fields, e.g. for x + 2:
+//
+//	BinaryExpr
+//	  Op: "+"
+//	  Left: Variable
+//	    Name: "x"
+//	  Right: NumberLiteral
+//	    Value: 2
+func Dump(e Expr) string {
+	var b strings.Builder
+	dumpValue(&b, reflect.ValueOf(e), 0)
+	return strings.TrimPrefix(b.String(), " ")
+}
+
+// dumpValue writes v at the given depth, completing a line that ends in a field
+// name or list bullet: " value" for scalars, " TypeName" followed by indented
+// fields for structs, and the indented items for slices.
+func dumpValue(b *strings.Builder, v reflect.Value, depth int) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			b.WriteString(" nil\n")
+			return
+		}
+		v = v.Elem()
+	}
+
+	indent := strings.Repeat("  ", depth+1)
+	switch v.Kind() {
+	case reflect.Struct:
+		b.WriteString(" " + v.Type().Name() + "\n")
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || field.Anonymous {
+				continue // Skip marker embeds such as ExtensionNode
+			}
+			b.WriteString(indent + field.Name + ":")
+			dumpValue(b, v.Field(i), depth+1)
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		for i := 0; i < v.Len(); i++ {
+			b.WriteString(indent + "-")
+			dumpValue(b, v.Index(i), depth+1)
+		}
+	case reflect.String:
+		fmt.Fprintf(b, " %q\n", v.String())
+	default:
+		fmt.Fprintf(b, " %v\n", v.Interface())
+	}
+}
@@ -0,0 +1,43 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDump(t *testing.T) {
+	// \sqrt{x} + 2
+	expr := &BinaryExpr{
+		Op:    "+",
+		Left:  &FuncCall{FuncName: "sqrt", Args: []Expr{&Variable{Name: "x"}}},
+		Right: &NumberLiteral{Value: 2},
+	}
+
+	expected := `BinaryExpr
+  Op: "+"
+  Left: FuncCall
+    FuncName: "sqrt"
+    Args:
+      - Variable
+        Name: "x"
+  Right: NumberLiteral
+    Value: 2
+`
+	assert.Equal(t, expected, Dump(expr))
+}
+
+func TestDump_NilAndNestedSlices(t *testing.T) {
+	expr := &PiecewiseExpr{Cases: []PiecewiseCase{
+		{Value: &Variable{Name: "x"}, Condition: nil},
+	}}
+
+	expected := `PiecewiseExpr
+  Cases:
+    - PiecewiseCase
+      Value: Variable
+        Name: "x"
+      Condition: nil
+`
+	assert.Equal(t, expected, Dump(expr))
+}
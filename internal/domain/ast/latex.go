@@ -0,0 +1,201 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Multiplication is the way ToLatex renders a product a * b.
+type Multiplication int
+
+const (
+	MultiplyCdot          Multiplication = iota // a \cdot b, the default
+	MultiplyTimes                               // a \times b
+	MultiplyJuxtaposition                       // a b
+	MultiplyAsterisk                            // a * b
+)
+
+// LatexOption configures the rendering of ToLatex.
+type LatexOption func(*latexPrinter)
+
+// WithMultiplication renders products with the given operator. Juxtaposition
+// falls back to \cdot before a factor starting with a digit, as 2 3 would read
+// as a single number, and before a parenthesized one, as a (b + c) would read
+// as a call.
+func WithMultiplication(m Multiplication) LatexOption {
+	return func(p *latexPrinter) {
+		p.multiplication = m
+	}
+}
+
+// latexPrinter holds the options of ToLatex.
+type latexPrinter struct {
+	multiplication Multiplication
+}
+
+// latexRelations are the LaTeX spellings of the comparison operators.
+var latexRelations = map[string]string{
+	"==": "=",
+	"!=": `\ne`,
+	"<":  "<",
+	"<=": `\le`,
+	">":  ">",
+	">=": `\ge`,
+}
+
+// ToLatex renders an expression back to LaTeX, e.g. \frac{a}{b} \cdot x^2 for
+// the tree of (a / b) * x^2. Numbers, variables, arithmetic, comparisons and
+// commands with braced arguments like \frac, \sqrt and \sin are supported;
+// other nodes are an error. Parentheses are only added where precedence
+// requires them.
+func ToLatex(e Expr, opts ...LatexOption) (string, error) {
+	p := &latexPrinter{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p.render(e)
+}
+
+// latexPrecedence returns the binding strength of a binary operator in LaTeX:
+// comparisons bind loosest, then sums, products and powers.
+func latexPrecedence(op string) int {
+	switch op {
+	case "+", "-":
+		return 2
+	case "*", "/":
+		return 3
+	case "^":
+		return 4
+	}
+	return 1
+}
+
+// render returns the LaTeX of e.
+func (p *latexPrinter) render(e Expr) (string, error) {
+	switch n := e.(type) {
+	case *NumberLiteral:
+		return fmt.Sprintf("%g", n.Value), nil
+	case *Variable:
+		return n.Name, nil
+	case *FuncCall:
+		var b strings.Builder
+		b.WriteString(`\` + n.FuncName)
+		for _, arg := range n.Args {
+			code, err := p.render(arg)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("{" + code + "}")
+		}
+		return b.String(), nil
+	case *BinaryExpr:
+		return p.renderBinary(n)
+	}
+	return "", fmt.Errorf("cannot render %T as LaTeX", e)
+}
+
+// renderBinary returns the LaTeX of a binary operation, with -1 * x as -x.
+func (p *latexPrinter) renderBinary(n *BinaryExpr) (string, error) {
+	if n.Op == "*" && isMinusOne(n.Left) {
+		operand, err := p.operand(n.Right, latexPrecedence("*"), false)
+		if err != nil {
+			return "", err
+		}
+		return "-" + operand, nil
+	}
+
+	prec := latexPrecedence(n.Op)
+	if n.Op == "/" {
+		// A quotient is a fraction, whose operands need no parentheses
+		num, err := p.render(n.Left)
+		if err != nil {
+			return "", err
+		}
+		den, err := p.render(n.Right)
+		if err != nil {
+			return "", err
+		}
+		return `\frac{` + num + "}{" + den + "}", nil
+	}
+	left, err := p.operand(n.Left, prec, n.Op == "^")
+	if err != nil {
+		return "", err
+	}
+	if n.Op == "^" {
+		exponent, err := p.render(n.Right)
+		if err != nil {
+			return "", err
+		}
+		if len(exponent) > 1 {
+			exponent = "{" + exponent + "}"
+		}
+		return left + "^" + exponent, nil
+	}
+	right, err := p.operand(n.Right, prec, true)
+	if err != nil {
+		return "", err
+	}
+
+	switch n.Op {
+	case "+", "-":
+		return left + " " + n.Op + " " + right, nil
+	case "*":
+		return left + p.times(right) + right, nil
+	}
+	if relation, ok := latexRelations[n.Op]; ok {
+		return left + " " + relation + " " + right, nil
+	}
+	return "", fmt.Errorf("cannot render the operator %s as LaTeX", n.Op)
+}
+
+// operand returns the LaTeX of an operand of an operator of precedence prec, in
+// parentheses if it binds looser, or as loosely when strict is set, as for the
+// right operands and the base of a power. A fraction only needs them as a base,
+// and a negation as a strict operand or one of a product or power. A negative
+// number as a strict operand is wrapped in \left(-3\right), as a - -3, a -3 or
+// -3^2 would read as another expression.
+func (p *latexPrinter) operand(e Expr, prec int, strict bool) (string, error) {
+	code, err := p.render(e)
+	if err != nil {
+		return "", err
+	}
+	if lit, ok := e.(*NumberLiteral); ok && strict && lit.Value < 0 {
+		return `\left(` + code + `\right)`, nil
+	}
+	b, ok := e.(*BinaryExpr)
+	if !ok {
+		return code, nil
+	}
+	opPrec := latexPrecedence(b.Op)
+	switch {
+	case b.Op == "/":
+		opPrec = latexPrecedence("^")
+	case b.Op == "*" && isMinusOne(b.Left):
+		opPrec = latexPrecedence("-")
+	}
+	if opPrec < prec || (strict && opPrec == prec) {
+		return "(" + code + ")", nil
+	}
+	return code, nil
+}
+
+// times returns the operator between two factors, the right one rendered as right.
+func (p *latexPrinter) times(right string) string {
+	switch p.multiplication {
+	case MultiplyTimes:
+		return ` \times `
+	case MultiplyJuxtaposition:
+		if right != "" && (right[0] < '0' || right[0] > '9') && right[0] != '(' && !strings.HasPrefix(right, `\left`) {
+			return " "
+		}
+	case MultiplyAsterisk:
+		return " * "
+	}
+	return ` \cdot `
+}
+
+// isMinusOne reports whether e is the literal -1 of a negation.
+func isMinusOne(e Expr) bool {
+	lit, ok := e.(*NumberLiteral)
+	return ok && lit.Value == -1
+}
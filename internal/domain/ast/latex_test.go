@@ -0,0 +1,71 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToLatex_Multiplication(t *testing.T) {
+	a, b := &Variable{Name: "a"}, &Variable{Name: "b"}
+	product := &BinaryExpr{Op: "*", Left: a, Right: b}
+	tests := []struct {
+		name     string
+		opts     []LatexOption
+		expected string
+	}{
+		{"default", nil, `a \cdot b`},
+		{"cdot", []LatexOption{WithMultiplication(MultiplyCdot)}, `a \cdot b`},
+		{"times", []LatexOption{WithMultiplication(MultiplyTimes)}, `a \times b`},
+		{"juxtaposition", []LatexOption{WithMultiplication(MultiplyJuxtaposition)}, `a b`},
+		{"asterisk", []LatexOption{WithMultiplication(MultiplyAsterisk)}, `a * b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latex, err := ToLatex(product, tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, latex)
+		})
+	}
+
+	// Juxtaposed numbers would read as one, and a parenthesized factor as a call
+	latex, err := ToLatex(&BinaryExpr{Op: "*", Left: a, Right: &NumberLiteral{Value: 2}}, WithMultiplication(MultiplyJuxtaposition))
+	require.NoError(t, err)
+	assert.Equal(t, `a \cdot 2`, latex)
+	latex, err = ToLatex(&BinaryExpr{Op: "*", Left: a, Right: &NumberLiteral{Value: -3}}, WithMultiplication(MultiplyJuxtaposition))
+	require.NoError(t, err)
+	assert.Equal(t, `a \cdot \left(-3\right)`, latex)
+}
+
+func TestToLatex(t *testing.T) {
+	x, y := &Variable{Name: "x"}, &Variable{Name: "y"}
+	num := func(v float64) Expr { return &NumberLiteral{Value: v} }
+	tests := []struct {
+		expr     Expr
+		expected string
+	}{
+		{&BinaryExpr{Op: "*", Left: &BinaryExpr{Op: "/", Left: x, Right: y}, Right: &BinaryExpr{Op: "^", Left: x, Right: num(2)}}, `\frac{x}{y} \cdot x^2`},
+		{&BinaryExpr{Op: "*", Left: &BinaryExpr{Op: "+", Left: x, Right: num(1)}, Right: y}, `(x + 1) \cdot y`},
+		{&BinaryExpr{Op: "-", Left: x, Right: &BinaryExpr{Op: "-", Left: y, Right: num(1)}}, `x - (y - 1)`},
+		{&BinaryExpr{Op: "*", Left: num(-1), Right: x}, `-x`},
+		{&BinaryExpr{Op: "*", Left: x, Right: &BinaryExpr{Op: "*", Left: num(-1), Right: y}}, `x \cdot (-y)`},
+		{&BinaryExpr{Op: "^", Left: &BinaryExpr{Op: "/", Left: x, Right: y}, Right: &BinaryExpr{Op: "+", Left: y, Right: num(1)}}, `(\frac{x}{y})^{y + 1}`},
+		{&BinaryExpr{Op: "<=", Left: &FuncCall{FuncName: "sqrt", Args: []Expr{x}}, Right: num(0.5)}, `\sqrt{x} \le 0.5`},
+		// Negative numbers are wrapped as strict operands only
+		{&BinaryExpr{Op: "-", Left: x, Right: num(-3)}, `x - \left(-3\right)`},
+		{&BinaryExpr{Op: "^", Left: num(-3), Right: num(2)}, `\left(-3\right)^2`},
+		{&BinaryExpr{Op: "+", Left: num(-3), Right: x}, `-3 + x`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			latex, err := ToLatex(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, latex)
+		})
+	}
+
+	_, err := ToLatex(&SumExpr{Var: "i", Body: x})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot render *ast.SumExpr as LaTeX")
+}
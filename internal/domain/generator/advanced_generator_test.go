@@ -1,10 +1,13 @@
 package generator
 
 import (
+	"go/parser"
+	"go/token"
 	"testing"
 
 	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGenerator_AdvancedExpressions(t *testing.T) {
@@ -42,7 +45,7 @@ func TestGenerator_AdvancedExpressions(t *testing.T) {
 				Order:     1,
 				Body:      &ast.Variable{Name: "x"},
 			},
-			expectMath:    true,
+			expectMath:    false, // Finite differences only need math through the body
 			expectPattern: "central difference",
 		},
 		{
@@ -52,9 +55,31 @@ func TestGenerator_AdvancedExpressions(t *testing.T) {
 				Approaches: &ast.NumberLiteral{Value: 0.0},
 				Body:       &ast.Variable{Name: "x"},
 			},
-			expectMath:    false,
+			expectMath:    true, // Two-sided limits compare both sides with math.Abs
 			expectPattern: "epsilon",
 		},
+		{
+			name: "Right-sided Limit",
+			expr: &ast.LimitExpr{
+				Var:        "x",
+				Approaches: &ast.NumberLiteral{Value: 0.0},
+				Direction:  "+",
+				Body:       &ast.Variable{Name: "x"},
+			},
+			expectMath:    false,
+			expectPattern: "eval(target + epsilon)",
+		},
+		{
+			name: "Left-sided Limit",
+			expr: &ast.LimitExpr{
+				Var:        "x",
+				Approaches: &ast.NumberLiteral{Value: 0.0},
+				Direction:  "-",
+				Body:       &ast.Variable{Name: "x"},
+			},
+			expectMath:    false,
+			expectPattern: "eval(target - epsilon)",
+		},
 		{
 			name: "Piecewise",
 			expr: &ast.PiecewiseExpr{
@@ -71,9 +96,80 @@ func TestGenerator_AdvancedExpressions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			code, needsMath := gen.generateExpr(tt.expr)
+			code, needsMath, err := gen.generateExpr(tt.expr)
+			require.NoError(t, err)
 			assert.Equal(t, tt.expectMath, needsMath)
 			assert.Contains(t, code, tt.expectPattern)
 		})
 	}
 }
+
+func TestGenerator_DerivativeOfUnknownFunction(t *testing.T) {
+	gen := NewGenerator()
+
+	// \frac{dy}{dx}: y is only named, not defined in terms of x
+	_, _, err := gen.generateExpr(&ast.DerivativeExpr{Var: "x", Order: 1, Body: &ast.Variable{Name: "y"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot differentiate y with respect to x")
+}
+
+func TestGenerator_DerivativeOrder(t *testing.T) {
+	gen := NewGenerator()
+	x := &ast.Variable{Name: "x"}
+
+	// Central differences cover the first and second derivatives only
+	_, _, err := gen.generateExpr(&ast.DerivativeExpr{Var: "x", Order: 3, Body: x})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "derivatives of order 3 are not supported: only first and second derivatives are")
+
+	code, _, err := gen.generateExpr(&ast.DerivativeExpr{Var: "x", Order: 2, Body: x})
+	require.NoError(t, err)
+	assert.Contains(t, code, "return (fwd - 2.0*ctr + bwd) / (h * h)")
+}
+
+func TestGenerator_DerivativeOfDefinition(t *testing.T) {
+	gen := NewGenerator()
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	square := &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: 2}}
+
+	// u = x^2; f = u y; \frac{\partial f}{\partial x}: the definitions are inlined into
+	// the finite differences, and u and f are not declared as nothing else reads them
+	block := &ast.BlockExpr{
+		Assignments: []*ast.AssignmentExpr{
+			{Name: "u", Value: square},
+			{Name: "f", Value: &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "u"}, Right: y}},
+		},
+		Result: &ast.DerivativeExpr{IsPartial: true, Var: "x", Order: 1, Body: &ast.Variable{Name: "f"}},
+	}
+	goCode, err := gen.Generate(block, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "x := x + h; return (x * x) * y")
+	assert.NotContains(t, goCode, "u :=")
+	assert.NotContains(t, goCode, "f :=")
+
+	// A definition also read outside the derivative is still declared
+	block.Result = &ast.BinaryExpr{Op: "+", Left: block.Result, Right: &ast.Variable{Name: "u"}}
+	goCode, err = gen.Generate(block, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "u := float64(x * x)")
+	assert.NotContains(t, goCode, "f :=")
+
+	// Only the definitions before an assignment are visible to it
+	_, err = gen.Generate(&ast.BlockExpr{
+		Assignments: []*ast.AssignmentExpr{
+			{Name: "g", Value: &ast.DerivativeExpr{Var: "x", Order: 1, Body: &ast.Variable{Name: "f"}}},
+			{Name: "f", Value: square},
+		},
+		Result: &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "g"}, Right: &ast.Variable{Name: "f"}},
+	}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot differentiate f with respect to x")
+}
+
+func TestGenerator_StrayPartial(t *testing.T) {
+	_, _, err := NewGenerator().generateExpr(&ast.Variable{Name: "\\partial x"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\partial is only supported in derivatives")
+}
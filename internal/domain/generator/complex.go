@@ -0,0 +1,228 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// valueKind is the Go type of a snippet generated in complex mode.
+type valueKind int
+
+const (
+	untypedValue valueKind = iota // Untyped constant, usable as float64 or complex128
+	realValue                     // float64
+	complexValue                  // complex128
+)
+
+// complexCode is a snippet generated in complex mode, with its type and imports.
+type complexCode struct {
+	code       string
+	kind       valueKind
+	needsMath  bool
+	needsCmplx bool
+}
+
+// complexFuncs maps the elementary functions to their math/cmplx counterparts,
+// used when the argument is complex.
+var complexFuncs = map[string]string{
+	"sqrt": "cmplx.Sqrt",
+	"sin":  "cmplx.Sin",
+	"cos":  "cmplx.Cos",
+	"tan":  "cmplx.Tan",
+	"sinh": "cmplx.Sinh",
+	"cosh": "cmplx.Cosh",
+	"tanh": "cmplx.Tanh",
+	"ln":   "cmplx.Log",
+	"log":  "cmplx.Log",
+
+	"arctan":  "cmplx.Atan",
+	"arcsinh": "cmplx.Asinh",
+	"arccosh": "cmplx.Acosh",
+	"arctanh": "cmplx.Atanh",
+}
+
+// complexOnlyFuncs are the operators that only make sense on complex numbers.
+var complexOnlyFuncs = map[string]bool{
+	"Re":       true,
+	"Im":       true,
+	"arg":      true,
+	"overline": true,
+	"bar":      true,
+}
+
+// generateComplexExpr renders e in complex mode, where variables are complex128.
+// Real-valued results (\Re, \Im, \arg, |z|) stay float64 and are converted with
+// complex(x, 0) when combined with complex operands.
+func (g *Generator) generateComplexExpr(e ast.Expr) (complexCode, error) {
+	switch node := e.(type) {
+	case *ast.NumberLiteral:
+		return complexCode{code: formatLiteral(node.Value, g.floatLiterals), kind: untypedValue}, nil
+	case *ast.Variable:
+		return complexCode{code: sanitizeVariableName(node.Name), kind: complexValue}, nil
+	case *ast.ConstantExpr:
+		switch node.Name {
+		case "pi":
+			return complexCode{code: "math.Pi", kind: untypedValue, needsMath: true}, nil
+		case "infty":
+			return complexCode{code: "math.Inf(1)", kind: realValue, needsMath: true}, nil
+		}
+		return complexCode{}, fmt.Errorf("unsupported constant: \\%s", node.Name)
+	case *ast.UnaryExpr:
+		if node.Op != "-" {
+			return complexCode{}, fmt.Errorf("complex mode does not support the %q operator", node.Op)
+		}
+		operand, err := g.generateComplexExpr(node.Operand)
+		if err != nil {
+			return complexCode{}, err
+		}
+		if _, ok := node.Operand.(*ast.BinaryExpr); ok {
+			operand.code = "(" + operand.code + ")"
+		}
+		operand.code = "-" + operand.code
+		return operand, nil
+	case *ast.BinaryExpr:
+		return g.generateComplexBinary(node)
+	case *ast.FuncCall:
+		return g.generateComplexCall(node)
+	case *ast.UnitExpr:
+		return g.generateComplexExpr(node.Value)
+	case *ast.VectorExpr:
+		// Vector arithmetic is not supported in complex mode; elsewhere the decoration is transparent
+		return g.generateComplexExpr(&ast.Variable{Name: node.Name})
+	case *ast.InnerProductExpr:
+		return complexCode{code: generateInnerProduct(node, true), kind: complexValue, needsCmplx: true}, nil
+	case *ast.KetExpr:
+		return complexCode{}, errLoneKet(node)
+	case *ast.ConditionalExpectationExpr:
+		return complexCode{}, errConditionalExpectation
+	default:
+		return complexCode{}, fmt.Errorf("complex mode does not support %T", e)
+	}
+}
+
+// generateComplexBinary renders arithmetic on complex operands.
+func (g *Generator) generateComplexBinary(node *ast.BinaryExpr) (complexCode, error) {
+	left, err := g.dividendGenerator(node).generateComplexExpr(node.Left)
+	if err != nil {
+		return complexCode{}, err
+	}
+	right, err := g.generateComplexExpr(node.Right)
+	if err != nil {
+		return complexCode{}, err
+	}
+
+	if node.Op == "^" {
+		if n, ok := smallIntegerExponent(node); ok {
+			left.code = repeatedMultiplication(node.Left, left.code, n)
+			return left, nil
+		}
+		if left.kind != complexValue && right.kind != complexValue {
+			return combineComplex(fmt.Sprintf("math.Pow(%s, %s)", left.code, right.code), realValue, left, right, true, false), nil
+		}
+		return combineComplex(fmt.Sprintf("cmplx.Pow(%s, %s)", asComplex(left), asComplex(right)), complexValue, left, right, false, true), nil
+	}
+
+	switch node.Op {
+	case "+", "-", "*", "/":
+	default:
+		return complexCode{}, fmt.Errorf("complex mode does not support the %q operator", node.Op)
+	}
+	// Mixed operands promote the real side to complex128
+	if left.kind == complexValue && right.kind == realValue {
+		right.code = asComplex(right)
+	} else if left.kind == realValue && right.kind == complexValue {
+		left.code = asComplex(left)
+	}
+	prec := goPrecedence(node.Op)
+	if leftPrec, ok := g.operandPrecedence(node.Left); ok && leftPrec < prec {
+		left.code = "(" + left.code + ")"
+	}
+	if rightPrec, ok := g.operandPrecedence(node.Right); ok && rightPrec <= prec {
+		right.code = "(" + right.code + ")"
+	}
+	kind := left.kind
+	if right.kind > kind {
+		kind = right.kind
+	}
+	return combineComplex(fmt.Sprintf("%s %s %s", left.code, node.Op, right.code), kind, left, right, false, false), nil
+}
+
+// generateComplexCall renders function calls and complex operators.
+func (g *Generator) generateComplexCall(node *ast.FuncCall) (complexCode, error) {
+	if node.FuncName == "frac" {
+		if len(node.Args) != 2 {
+			return complexCode{}, fmt.Errorf("\\frac requires 2 arguments, got %d", len(node.Args))
+		}
+		return g.generateComplexBinary(&ast.BinaryExpr{Op: "/", Left: node.Args[0], Right: node.Args[1]})
+	}
+	if len(node.Args) != 1 {
+		return complexCode{}, fmt.Errorf("complex mode does not support \\%s with %d arguments", node.FuncName, len(node.Args))
+	}
+	arg, err := g.generateComplexExpr(node.Args[0])
+	if err != nil {
+		return complexCode{}, err
+	}
+
+	switch node.FuncName {
+	case "Re":
+		return withCode(arg, fmt.Sprintf("real(%s)", asComplex(arg)), realValue), nil
+	case "Im":
+		return withCode(arg, fmt.Sprintf("imag(%s)", asComplex(arg)), realValue), nil
+	case "arg":
+		arg = withCode(arg, fmt.Sprintf("cmplx.Phase(%s)", asComplex(arg)), realValue)
+		arg.needsCmplx = true
+		return arg, nil
+	case "overline", "bar":
+		arg = withCode(arg, fmt.Sprintf("cmplx.Conj(%s)", asComplex(arg)), complexValue)
+		arg.needsCmplx = true
+		return arg, nil
+	case "abs":
+		if arg.kind == complexValue {
+			arg = withCode(arg, fmt.Sprintf("cmplx.Abs(%s)", arg.code), realValue)
+			arg.needsCmplx = true
+			return arg, nil
+		}
+		arg = withCode(arg, fmt.Sprintf("math.Abs(%s)", arg.code), realValue)
+		arg.needsMath = true
+		return arg, nil
+	}
+
+	cmplxFunc, ok := complexFuncs[node.FuncName]
+	if !ok {
+		return complexCode{}, fmt.Errorf("unsupported LaTeX function in complex mode: %s", node.FuncName)
+	}
+	if arg.kind == complexValue {
+		arg = withCode(arg, fmt.Sprintf("%s(%s)", cmplxFunc, arg.code), complexValue)
+		arg.needsCmplx = true
+		return arg, nil
+	}
+	arg = withCode(arg, fmt.Sprintf("math.%s(%s)", cmplxFunc[len("cmplx."):], arg.code), realValue)
+	arg.needsMath = true
+	return arg, nil
+}
+
+// asComplex returns the code of c converted to complex128 if it is a float64.
+func asComplex(c complexCode) string {
+	if c.kind == realValue {
+		return fmt.Sprintf("complex(%s, 0)", c.code)
+	}
+	return c.code
+}
+
+// withCode returns c with new code and kind, keeping its imports.
+func withCode(c complexCode, code string, kind valueKind) complexCode {
+	c.code = code
+	c.kind = kind
+	return c
+}
+
+// combineComplex builds the snippet of an operation on left and right, merging their imports.
+func combineComplex(code string, kind valueKind, left, right complexCode, needsMath, needsCmplx bool) complexCode {
+	return complexCode{
+		code:       code,
+		kind:       kind,
+		needsMath:  needsMath || left.needsMath || right.needsMath,
+		needsCmplx: needsCmplx || left.needsCmplx || right.needsCmplx,
+	}
+}
@@ -0,0 +1,123 @@
+package generator
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The constraints noted by WithDomainNotes, each on the operand of an operation
+// outside whose domain the result is NaN, ±Inf or meaningless.
+const (
+	nonNegative        = "must be >= 0"                   // Argument of \sqrt
+	positive           = "must be > 0"                    // Argument of \ln and \log
+	nonZero            = "must be != 0"                   // Denominator of / and \frac
+	nonNegativeInteger = "must be a non-negative integer" // Operand of the factorial n!
+)
+
+// domainNote is a constraint on the Go code of an operand, e.g. x - 1 must be >= 0.
+type domainNote struct {
+	operand    string
+	constraint string
+}
+
+// domainNotes collects the constraints met while generating an equation. Like
+// signChoice, it is shared by the copies of the Generator made for one equation.
+type domainNotes struct {
+	notes []domainNote
+}
+
+// requireDomain notes that operandCode must satisfy constraint, when domain notes
+// are being collected and the operation is evaluated unconditionally.
+func (g *Generator) requireDomain(operandCode, constraint string) {
+	if g.domain == nil || g.guarded {
+		return
+	}
+	note := domainNote{operand: operandCode, constraint: constraint}
+	if !slices.Contains(g.domain.notes, note) {
+		g.domain.notes = append(g.domain.notes, note)
+	}
+}
+
+// docComment renders the notes whose operands only read the given parameters as
+// "// Note: x must be >= 0" lines, in the order they were met. A constraint on a
+// bound variable, such as the index of a sum, or on a local is not about the
+// caller's arguments and is left out, as is one on an operand spanning several
+// lines, such as an inline function.
+func (n *domainNotes) docComment(params []string) string {
+	var b strings.Builder
+	for _, note := range n.notes {
+		if readsOnly(note.operand, params) {
+			b.WriteString("// Note: " + note.operand + " " + note.constraint + "\n")
+		}
+	}
+	return b.String()
+}
+
+// validator renders the function funcName+"Valid" over params, the parameter list
+// of the computation, returning an error for the first note it finds violated, in
+// the order they were met, or nil. It checks the notes docComment would render.
+func (n *domainNotes) validator(funcName, params string, names []string) string {
+	var lines []string
+	for _, note := range n.notes {
+		if !readsOnly(note.operand, names) {
+			continue
+		}
+		operand := note.operand
+		var violated string
+		switch note.constraint {
+		case nonNegative:
+			violated = operand + " < 0"
+		case positive:
+			violated = operand + " <= 0"
+		case nonZero:
+			violated = operand + " == 0"
+		case nonNegativeInteger:
+			violated = fmt.Sprintf("%s < 0 || %s != math.Trunc(%s)", operand, operand, operand)
+		}
+		message := strconv.Quote(fmt.Sprintf("%s: %s %s, got %%v", funcName, operand, note.constraint))
+		lines = append(lines,
+			"\tif "+violated+" {",
+			fmt.Sprintf("\t\treturn fmt.Errorf(%s, %s)", message, operand),
+			"\t}",
+		)
+	}
+	lines = append(lines, "\treturn nil")
+	return fmt.Sprintf("// %sValid checks that the arguments are in the domain of %s.\nfunc %sValid(%s) error {\n%s\n}",
+		funcName, funcName, funcName, params, strings.Join(lines, "\n"))
+}
+
+// goBuiltins are the predeclared identifiers that generated expressions may use.
+var goBuiltins = map[string]bool{"float64": true, "int": true, "min": true, "max": true}
+
+// readsOnly reports whether the Go expression code is a single line reading no
+// variables other than params, and at least one of them.
+func readsOnly(code string, params []string) bool {
+	if strings.Contains(code, "\n") {
+		return false
+	}
+	expr, err := goparser.ParseExpr(code)
+	if err != nil {
+		return false
+	}
+	reads, only := false, true
+	goast.Inspect(expr, func(node goast.Node) bool {
+		switch node := node.(type) {
+		case *goast.SelectorExpr:
+			return false // math.Pi, math.Sqrt: package members
+		case *goast.FuncLit:
+			only = false
+		case *goast.Ident:
+			if slices.Contains(params, node.Name) {
+				reads = true
+			} else if !goBuiltins[node.Name] {
+				only = false
+			}
+		}
+		return only
+	})
+	return reads && only
+}
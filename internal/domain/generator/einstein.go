@@ -0,0 +1,207 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// With WithEinstein, an index repeated in a product, once up and once down, is
+// summed over: a^i b_i becomes \sum_i a_i b_i, a loop over the elements of the
+// vectors a and b. The factors carrying an index are
+//
+//	a^i, a superscript identifier, up       (otherwise the power a to the i)
+//	a_i, an element of a sequence, down
+//	a^{\mu} and a_{\mu}, tensor components with one index, up and down
+//
+// An index is only summed over when it appears exactly twice in the product, once
+// up and once down; a_i b_i is left as the product of two elements. A product may
+// repeat one index only, and only the indices of vectors can be summed over, so
+// the index of T^{\mu}_{\nu} x^{\nu} is an error.
+
+// einsteinIndex is an index carried by a factor of a product.
+type einsteinIndex struct {
+	name   string
+	upper  bool
+	vector string // The vector carrying it, or the Go name of a tensor with several indices
+	rank   int    // The number of indices of the tensor, 1 for a vector
+}
+
+// contractIndices rewrites every product of expr repeating an index, once up and
+// once down, as a sum over that index. Like simplify, it only visits arithmetic
+// and the operands of calls and assignments, and copies the nodes it rewrites.
+func contractIndices(expr ast.Expr) (ast.Expr, error) {
+	switch node := expr.(type) {
+	case *ast.BinaryExpr:
+		if node.Op == "*" {
+			return contractProduct(node)
+		}
+		b := *node
+		var err error
+		if b.Left, err = contractIndices(node.Left); err != nil {
+			return nil, err
+		}
+		if b.Right, err = contractIndices(node.Right); err != nil {
+			return nil, err
+		}
+		return &b, nil
+	case *ast.UnaryExpr:
+		u := *node
+		var err error
+		if u.Operand, err = contractIndices(node.Operand); err != nil {
+			return nil, err
+		}
+		return &u, nil
+	case *ast.FuncCall:
+		call := *node
+		call.Args = make([]ast.Expr, len(node.Args))
+		for i, arg := range node.Args {
+			var err error
+			if call.Args[i], err = contractIndices(arg); err != nil {
+				return nil, err
+			}
+		}
+		return &call, nil
+	case *ast.BlockExpr:
+		block := ast.BlockExpr{}
+		for _, assignment := range node.Assignments {
+			value, err := contractIndices(assignment.Value)
+			if err != nil {
+				return nil, err
+			}
+			block.Assignments = append(block.Assignments, &ast.AssignmentExpr{Name: assignment.Name, Value: value})
+		}
+		var err error
+		if block.Result, err = contractIndices(node.Result); err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+	return expr, nil
+}
+
+// contractProduct sums the product over its repeated index, if any.
+func contractProduct(node *ast.BinaryExpr) (ast.Expr, error) {
+	factors := productFactors(node)
+	occurrences := map[string][]einsteinIndex{}
+	var names []string              // Indices in order of appearance
+	positions := map[string][]int{} // Positions in factors of the vectors carrying each index
+	for i, factor := range factors {
+		indices := indicesOf(factor)
+		if indices == nil {
+			contracted, err := contractIndices(factor)
+			if err != nil {
+				return nil, err
+			}
+			factors[i] = contracted
+			continue
+		}
+		for _, index := range indices {
+			if _, seen := occurrences[index.name]; !seen {
+				names = append(names, index.name)
+			}
+			occurrences[index.name] = append(occurrences[index.name], index)
+			positions[index.name] = append(positions[index.name], i)
+		}
+	}
+
+	var repeated []string
+	for _, name := range names {
+		at := occurrences[name]
+		if len(at) != 2 || at[0].upper == at[1].upper {
+			continue
+		}
+		for _, index := range at {
+			if index.rank > 1 {
+				return nil, fmt.Errorf("cannot sum over the index %s of %s, which has %d indices: only the indices of vectors can be summed over",
+					name, index.vector, index.rank)
+			}
+		}
+		repeated = append(repeated, name)
+	}
+	switch len(repeated) {
+	case 0:
+		return rebuildProduct(factors), nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("the indices %s are each repeated in a product, but a product can only be summed over one index",
+			strings.Join(repeated, ", "))
+	}
+
+	name := repeated[0]
+	for j, i := range positions[name] {
+		factors[i] = &ast.IndexExpr{Sequence: occurrences[name][j].vector, Index: &ast.Variable{Name: name}}
+	}
+	return &ast.SumExpr{Var: name, Body: rebuildProduct(factors)}, nil
+}
+
+// indicesOf returns the indices carried by factor, nil if it carries none.
+func indicesOf(factor ast.Expr) []einsteinIndex {
+	switch node := factor.(type) {
+	case *ast.BinaryExpr:
+		base, baseOK := node.Left.(*ast.Variable)
+		index, indexOK := node.Right.(*ast.Variable)
+		if node.Op == "^" && baseOK && indexOK && !strings.Contains(base.Name, "_") {
+			return []einsteinIndex{{name: index.Name, upper: true, vector: base.Name, rank: 1}}
+		}
+	case *ast.IndexExpr:
+		if index, ok := node.Index.(*ast.Variable); ok {
+			return []einsteinIndex{{name: index.Name, vector: node.Sequence, rank: 1}}
+		}
+	case *ast.Variable:
+		return tensorIndices(node.Name)
+	}
+	return nil
+}
+
+// tensorIndices returns the indices of a tensor component, named by the parser
+// after them as in T_up_mu_lo_nu, or nil if name is not one.
+func tensorIndices(name string) []einsteinIndex {
+	tensor, rest, ok := strings.Cut(name, "_")
+	if !ok {
+		return nil
+	}
+	var upper, lower string
+	if indices, ok := strings.CutPrefix(rest, "up_"); ok {
+		upper, lower, _ = strings.Cut(indices, "_lo_")
+	} else if lower, ok = strings.CutPrefix(rest, "lo_"); !ok {
+		return nil
+	}
+	var indices []einsteinIndex
+	for _, index := range strings.Split(upper, "_") {
+		if index != "" {
+			indices = append(indices, einsteinIndex{name: index, upper: true})
+		}
+	}
+	for _, index := range strings.Split(lower, "_") {
+		if index != "" {
+			indices = append(indices, einsteinIndex{name: index})
+		}
+	}
+	vector := name
+	if len(indices) == 1 {
+		vector = tensor
+	}
+	for i := range indices {
+		indices[i].vector, indices[i].rank = vector, len(indices)
+	}
+	return indices
+}
+
+// productFactors returns the factors of a chain of '*', left to right.
+func productFactors(expr ast.Expr) []ast.Expr {
+	if b, ok := expr.(*ast.BinaryExpr); ok && b.Op == "*" {
+		return append(productFactors(b.Left), productFactors(b.Right)...)
+	}
+	return []ast.Expr{expr}
+}
+
+// rebuildProduct multiplies factors left to right.
+func rebuildProduct(factors []ast.Expr) ast.Expr {
+	result := factors[0]
+	for _, factor := range factors[1:] {
+		result = &ast.BinaryExpr{Op: "*", Left: result, Right: factor}
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// generateEvaluation generates an evaluation bar \left. F \right|_a^b as a closure
+// of the variable, called at b and at a: F(b) - F(a), or F(a) without an upper
+// bound.
+func (g *Generator) generateEvaluation(eval *ast.EvaluationExpr) (string, bool, error) {
+	variable, err := evaluationVar(eval)
+	if err != nil {
+		return "", false, err
+	}
+	bodyCode, needsMath, err := g.generateExpr(eval.Body)
+	if err != nil {
+		return "", false, err
+	}
+	lowerCode, lowerNeedsMath, err := g.generateExpr(eval.Lower)
+	if err != nil {
+		return "", false, err
+	}
+	needsMath = needsMath || lowerNeedsMath
+	param := "_" // A body without variables is constant
+	if variable != "" {
+		param = sanitizeVariableName(variable)
+	}
+	closure := fmt.Sprintf("func(%s float64) float64 { return %s }", param, bodyCode)
+	if eval.Upper == nil {
+		return fmt.Sprintf("%s(%s)", closure, lowerCode), needsMath, nil
+	}
+	upperCode, upperNeedsMath, err := g.generateExpr(eval.Upper)
+	if err != nil {
+		return "", false, err
+	}
+	// The closure is named after neither the body's variables nor the bounds'
+	taken := variableNames(eval)
+	for i, name := range taken {
+		taken[i] = sanitizeVariableName(name)
+	}
+	at := unusedName("at", taken)
+	return strings.Join([]string{
+		"func() float64 {",
+		fmt.Sprintf("    %s := %s", at, closure),
+		fmt.Sprintf("    return %s(%s) - %s(%s)", at, upperCode, at, lowerCode),
+		"}()",
+	}, "\n"), needsMath || upperNeedsMath, nil
+}
+
+// evaluationVar returns the variable set to the bounds of an evaluation bar: the
+// one named in the subscript, else the only variable of the body, or "" when the
+// body has none.
+func evaluationVar(eval *ast.EvaluationExpr) (string, error) {
+	if eval.Var != "" {
+		return eval.Var, nil
+	}
+	names := variableNames(eval.Body)
+	switch len(names) {
+	case 0:
+		return "", nil
+	case 1:
+		return names[0], nil
+	}
+	return "", fmt.Errorf("the evaluation bar of an expression in %s must name the variable, as in \\left. F \\right|_{%s=a}^{b}",
+		strings.Join(names, ", "), names[0])
+}
+
+// variableNames returns the names of the variables in e, sorted and without
+// duplicates.
+func variableNames(e ast.Expr) []string {
+	var names []string
+	inspectNodes(e, func(node ast.Expr) {
+		if variable, ok := node.(*ast.Variable); ok {
+			names = append(names, variable.Name)
+		}
+	})
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// inspectNodes calls visit for e and every node below it, found by walking every
+// field of the nodes.
+func inspectNodes(e ast.Expr, visit func(ast.Expr)) {
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return
+			}
+			if node, ok := v.Interface().(ast.Expr); ok && v.Kind() == reflect.Pointer {
+				visit(node)
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).IsExported() {
+					walk(v.Field(i))
+				}
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(e))
+}
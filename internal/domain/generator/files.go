@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"slices"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// HelpersFile is the name of the file GenerateFiles puts the helper functions in.
+const HelpersFile = "helpers.go"
+
+// File is one named Go source file of a generated package.
+type File struct {
+	Name string // File name, e.g. "helpers.go"
+	Code string // Formatted Go source
+}
+
+// GenerateFiles is like Generate, but puts the helper functions in HelpersFile
+// instead of appending them to the function. The function's file, named funcName.go,
+// comes first. HelpersFile holds every helper rather than only those the function
+// uses, so that it is the same for all functions generated into the package and
+// can be shared by them. Helpers only exist with WithoutMathImport; otherwise the
+// function's file is the only one.
+func (g *Generator) GenerateFiles(root ast.Expr, pkgName, funcName string) ([]File, error) {
+	code, err := g.generate(root, pkgName, funcName, false)
+	if err != nil {
+		return nil, err
+	}
+	files := []File{{Name: funcName + ".go", Code: code}}
+	if !g.noMathImport {
+		return files, nil
+	}
+
+	names := make([]string, 0, len(pureGoHelpers))
+	for name := range pureGoHelpers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	helpers, err := g.formatSource("package " + pkgName + helperSource(names))
+	if err != nil {
+		return nil, err
+	}
+	return append(files, File{Name: HelpersFile, Code: helpers}), nil
+}
@@ -1,9 +1,16 @@
 package generator
 
 import (
+	"errors"
 	"fmt"
+	goast "go/ast"
 	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"math"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/text/cases"
@@ -12,71 +19,634 @@ import (
 	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
 )
 
+// NodeGeneratorFunc renders a custom AST node into Go code.
+// It returns the code snippet, whether the snippet requires the "math" package, and any error.
+type NodeGeneratorFunc func(e ast.Expr, g *Generator) (string, bool, error)
+
+// ReducerFunc renders a named operator with limits (\operatorname*{name}_{i=1}^{n} body)
+// into Go code, with the same results as a NodeGeneratorFunc.
+type ReducerFunc func(op *ast.BigOpExpr, g *Generator) (string, bool, error)
+
 // Generator converts internal AST Expr into Go code.
-type Generator struct{}
+// It holds no per-call state, so Generate is safe for concurrent use once
+// custom node generators are registered.
+type Generator struct {
+	nodeGenerators map[string]NodeGeneratorFunc // Custom node generators keyed by ast.Extension Kind()
+	reducers       map[string]ReducerFunc       // Reduction operators keyed by \operatorname* name
+	closure        bool                         // Generate a factory returning a func() closure over the parameters
+	clampChains    bool                         // Generate relational chains as a clamp instead of a bool test
+	goVersion      string                       // Targeted Go version (e.g. "1.21"); empty targets any Go 1 release
+	maxParams      int                          // Maximum number of parameters of the generated function; 0 means no limit
+	complex        bool                         // Treat variables as complex128 and generate math/cmplx code
+	vectorize      bool                         // Take a slice per parameter and return the elementwise results
+	paramOrder     string                       // Parameter order: "alpha" (default) or "appearance"
+	checkOverflow  bool                         // Return (float64, error), failing when the result overflows to ±Inf
+	indent         int                          // Spaces per indentation level; 0 keeps gofmt's tabs
+	noMathImport   bool                         // Replace math calls with pure-Go helpers appended to the output
+	checkUnits     bool                         // Check the consistency of \text{...} unit annotations
+	trace          bool                         // Print intermediate values to stderr at runtime
+	maxTerms       int                          // Maximum number of terms of a bounded sum or product; 0 means no cap
+	guardNumerics  bool                         // Stop numerical methods at the first NaN or ±Inf value
+	domainNotes    bool                         // Document the domain constraints of the parameters
+	simplify       bool                         // Rewrite the AST with simplificationRules before generating
+	cancellable    bool                         // Take a context.Context and stop the numerical loops once it is canceled
+	einstein       bool                         // Sum products over an index repeated once up and once down
+	optimize       bool                         // Evaluate polynomials in Horner form
+	generic        bool                         // Generate a function generic over ~float32 | ~float64
+	intermediates  bool                         // Return the assignments and the result in a map[string]float64
+	validator      bool                         // Also generate a <funcName>Valid function checking the domain constraints
+
+	// State of the \sum_n loop being generated, if any. It is only set on a copy of
+	// the Generator made for the loop body, so Generate stays safe for concurrent use.
+	rangeIndex     string   // Its index, an int in the generated loop
+	rangeSequences []string // The sequences its body indexes by rangeIndex, in order of appearance
+
+	// Sign of \pm in the result being generated, also set on a copy only; nil
+	// outside the final expression
+	sign *signChoice
+
+	// Assignments visible to the expression being generated, in order, also set on
+	// a copy only. Inside the body of a derivative they are also inlined: each
+	// reference to one of them is replaced by its value, so that the finite
+	// differences follow how it depends on the differentiation variable.
+	definitions []*ast.AssignmentExpr
+	inline      bool
+
+	// Whether integer literals are written as floats, 2.0 rather than 2, also set on
+	// a copy only: for the dividend of a division of integer constants, which Go
+	// would truncate, so that 1/2 is 0.5 rather than 0
+	floatLiterals bool
+
+	// Whether the expression being generated is only evaluated under a condition,
+	// such as a case of a cases environment, also set on a copy only. Domain
+	// constraints are not noted there, since they need not hold for every argument.
+	guarded bool
+
+	// Domain constraints met in the equation being generated, with WithDomainNotes;
+	// shared by the copies made for it
+	domain *domainNotes
+}
+
+// signChoice selects the sign of every \pm, and the opposite one of every \mp, while
+// one of the two results of an equation using them is generated. Following the
+// usual convention, the upper signs give the first result and the lower ones the
+// second, however many \pm there are.
+type signChoice struct {
+	op   string // "+" for the first result, "-" for the second
+	used bool   // Whether the expression contains \pm or \mp
+}
+
+// Option configures optional Generator behavior.
+type Option func(*Generator)
+
+// WithClosure makes Generate emit a factory that binds the parameters and returns
+// a closure, e.g. func f(a, b float64) func() float64 { return func() float64 { return a + b } }.
+func WithClosure() Option {
+	return func(g *Generator) {
+		g.closure = true
+	}
+}
+
+// WithClampChains makes a relational chain lo \le x \le hi generate the clamp
+// math.Max(lo, math.Min(hi, x)) instead of the default bool test lo <= x && x <= hi.
+func WithClampChains() Option {
+	return func(g *Generator) {
+		g.clampChains = true
+	}
+}
+
+// WithGoVersion sets the Go version targeted by the generated code, e.g. "1.21"
+// or "go1.21". Version-gated constructs are only used when the target supports them:
+//   - Go 1.18+: WithGeneric is supported.
+//   - Go 1.21+: \min and \max use the min/max builtins instead of nested math.Min/math.Max.
+func WithGoVersion(version string) Option {
+	return func(g *Generator) {
+		g.goVersion = version
+	}
+}
+
+// WithMaxParams makes Generate fail when the generated function would take more than
+// n parameters, which usually signals a parse problem in a pasted expression.
+// A limit of 0 disables the check.
+func WithMaxParams(n int) Option {
+	return func(g *Generator) {
+		g.maxParams = n
+	}
+}
+
+// WithComplex makes Generate treat every variable as a complex128 and emit math/cmplx
+// calls where needed. \Re, \Im, \arg and |z| produce real-valued results, so a function
+// whose result is real returns float64 and any other returns complex128.
+func WithComplex() Option {
+	return func(g *Generator) {
+		g.complex = true
+	}
+}
+
+// WithVectorize makes Generate emit a function evaluating the expression elementwise:
+// every parameter becomes a slice, all of the same length, and the i-th result is the
+// expression evaluated at the i-th element of each, e.g.
+// func f(x []float64, y []float64) []float64. Slices of different lengths panic.
+func WithVectorize() Option {
+	return func(g *Generator) {
+		g.vectorize = true
+	}
+}
+
+// WithParamOrder sets the order of the generated function's parameters: "alpha"
+// (the default) sorts them by name, while "appearance" keeps the order in which they
+// first appear in the expression, so m a generates f(m, a float64) rather than f(a, m float64).
+func WithParamOrder(order string) Option {
+	return func(g *Generator) {
+		g.paramOrder = order
+	}
+}
+
+// WithOverflowCheck makes Generate emit a function returning (float64, error) that
+// fails when the result overflows float64, e.g. a factorial n! with n > 170. Every value
+// is a float64, so overflow shows as an infinite result; infinite inputs such as \infty
+// are reported the same way.
+func WithOverflowCheck() Option {
+	return func(g *Generator) {
+		g.checkOverflow = true
+	}
+}
+
+// WithIndent indents the generated code with the given number of spaces per level
+// instead of gofmt's tabs. Only leading indentation changes; 0 keeps the tabs.
+func WithIndent(spaces int) Option {
+	return func(g *Generator) {
+		g.indent = spaces
+	}
+}
+
+// WithoutMathImport makes Generate emit code that does not import the math package,
+// for targets where it is unavailable: calls like math.Sqrt become calls to
+// unexported pure-Go helpers (x_sqrt) appended to the output, only for the functions
+// used. Sqrt and Abs are supported; any other math identifier fails Generate.
+func WithoutMathImport() Option {
+	return func(g *Generator) {
+		g.noMathImport = true
+	}
+}
+
+// WithUnitCheck makes Generate check the units annotated with \text{...}, as in
+// 1\,\text{m} + 1\,\text{s}, failing on inconsistent ones such as a sum of meters and
+// seconds, or of kilometers and meters, which are not converted. Without it the
+// annotations are only stripped.
+func WithUnitCheck() Option {
+	return func(g *Generator) {
+		g.checkUnits = true
+	}
+}
+
+// WithTrace makes the generated function print intermediate values to stderr as it
+// runs, to help diagnose NaN or Inf results: each assignment, both operands of the
+// top-level arithmetic operation and the result, one "funcName: code = value" line each.
+func WithTrace() Option {
+	return func(g *Generator) {
+		g.trace = true
+	}
+}
+
+// WithMaxTerms caps every sum or product with bounds at n terms, so that a series
+// like \sum_{n=1}^{\infty} terminates after its first n terms. 0 means no cap.
+func WithMaxTerms(n int) Option {
+	return func(g *Generator) {
+		g.maxTerms = n
+	}
+}
+
+// WithDomainNotes makes Generate document the domain of the parameters above the
+// function, inferred from the operations applied to them: an argument of \sqrt must
+// be >= 0, a denominator != 0 and the operand of a factorial a non-negative
+// integer, as in "// Note: x - 1 must be >= 0". Only constraints on expressions of
+// the parameters are noted, and not those of a case of a cases environment or of
+// another operation evaluated only under a condition.
+func WithDomainNotes() Option {
+	return func(g *Generator) {
+		g.domainNotes = true
+	}
+}
+
+// WithValidator makes Generate also emit a function named after the computation
+// with a Valid suffix, func fValid(x float64) error, taking the same parameters and
+// checking the constraints WithDomainNotes would document: it returns an error
+// naming the first one the arguments violate, such as "f: x - 1 must be >= 0, got
+// -1", or nil. The computation itself is unchanged.
+func WithValidator() Option {
+	return func(g *Generator) {
+		g.validator = true
+	}
+}
+
+// WithNumericGuards makes the numerical methods stop at the first NaN or ±Inf value
+// rather than computing on with it: a sum or product stops accumulating at a
+// non-finite term, an integral at a non-finite sample of its integrand, and a
+// derivative or two-sided limit at a non-finite evaluation, each returning that
+// value. With WithOverflowCheck, a NaN result is then reported as an error too.
+func WithNumericGuards() Option {
+	return func(g *Generator) {
+		g.guardNumerics = true
+	}
+}
+
+// WithSimplify makes Generate rewrite the equation with algebraic identities before
+// generating it: nested fractions are flattened, so \frac{\frac{a}{b}}{\frac{c}{d}}
+// becomes (a * d) / (b * c), and x/1, 0*x, 1*x and x+0 are reduced to x, 0, x and x.
+func WithSimplify() Option {
+	return func(g *Generator) {
+		g.simplify = true
+	}
+}
+
+// WithContext makes Generate emit a function taking a ctx context.Context as its
+// first parameter and returning an error as its last result. Sums, products,
+// integrals and the grid search of argmin/argmax check ctx.Err() every
+// cancelCheckInterval iterations and stop early once it is canceled; the function
+// then returns the zero value and ctx.Err().
+func WithContext() Option {
+	return func(g *Generator) {
+		g.cancellable = true
+	}
+}
+
+// WithEinstein makes Generate apply the Einstein summation convention: a product
+// repeating an index once up and once down, as in a^i b_i, is summed over it. See
+// contractIndices for the factors carrying an index.
+func WithEinstein() Option {
+	return func(g *Generator) {
+		g.einstein = true
+	}
+}
+
+// WithOptimize makes Generate evaluate every polynomial of degree 2 or more in Horner
+// form: a x^3 + b x^2 + c x + d becomes ((a*x + b)*x + c)*x + d, with one
+// multiplication per degree and no math.Pow. Above a degree of maxExpandedExponent,
+// polynomials are left as they are.
+func WithOptimize() Option {
+	return func(g *Generator) {
+		g.optimize = true
+	}
+}
+
+// WithGeneric makes Generate produce a function generic over floats,
+// func f[T ~float32 | ~float64](x T) T, computing in float64: the parameters are
+// converted to float64 on entry and the result back to T. It requires a target of
+// Go 1.18 or later with WithGoVersion, float64 parameters and a float64 result.
+func WithGeneric() Option {
+	return func(g *Generator) {
+		g.generic = true
+	}
+}
+
+// WithIntermediates makes Generate return a map[string]float64 of the value of every
+// assignment of the equation, keyed by its Go name, and of the final result under
+// "result": a = x^2; b = a + 1; a b gives map[string]float64{"a": a, "b": b,
+// "result": a * b}. Every assignment is recorded, even one the result does not read.
+func WithIntermediates() Option {
+	return func(g *Generator) {
+		g.intermediates = true
+	}
+}
+
+// NewGenerator creates a fresh Generator configured with the given options.
+func NewGenerator(opts ...Option) *Generator {
+	g := &Generator{
+		nodeGenerators: make(map[string]NodeGeneratorFunc),
+		reducers:       make(map[string]ReducerFunc),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// RegisterNodeGenerator registers fn as the code generator for custom nodes
+// (implementations of ast.Extension) whose Kind() is kind.
+func (g *Generator) RegisterNodeGenerator(kind string, fn NodeGeneratorFunc) {
+	g.nodeGenerators[kind] = fn
+}
 
-// NewGenerator creates a fresh Generator.
-func NewGenerator() *Generator {
-	return &Generator{}
+// RegisterReducer registers fn as the code generator for the operator
+// \operatorname*{name}_{...}^{...}.
+func (g *Generator) RegisterReducer(name string, fn ReducerFunc) {
+	g.reducers[name] = fn
+}
+
+// GenerateExpr renders a single AST expression into a Go code snippet.
+// It is exported for custom node generators that need to render their child expressions.
+func (g *Generator) GenerateExpr(e ast.Expr) (string, bool, error) {
+	return g.generateExpr(e)
 }
 
 // generateExpr renders an AST expression or loop into Go code snippet.
 // It also returns a boolean indicating if the generated code requires the "math" package.
-func (g *Generator) generateExpr(e ast.Expr) (string, bool) {
+func (g *Generator) generateExpr(e ast.Expr) (string, bool, error) {
 	switch node := e.(type) {
 	case *ast.NumberLiteral:
-		return fmt.Sprintf("%g", node.Value), false
+		return formatLiteral(node.Value, g.floatLiterals), false, nil
 	case *ast.Variable:
-		return node.Name, false
+		if node.Name == g.rangeIndex {
+			// The int index of a \sum_n loop is only used directly to index sequences
+			return fmt.Sprintf("float64(%s)", node.Name), false, nil
+		}
+		if strings.HasPrefix(node.Name, "\\partial") {
+			return "", false, fmt.Errorf("\\partial is only supported in derivatives, as in \\frac{\\partial f}{\\partial x}")
+		}
+		if g.inline {
+			if i := definitionIndex(g.definitions, node.Name); i >= 0 {
+				return g.inlineDefinition(i)
+			}
+		}
+		return node.Name, false, nil
+	case *ast.IndexExpr:
+		sequence := sanitizeVariableName(node.Sequence)
+		if v, ok := node.Index.(*ast.Variable); ok && g.rangeIndex != "" && v.Name == g.rangeIndex {
+			if !slices.Contains(g.rangeSequences, sequence) {
+				g.rangeSequences = append(g.rangeSequences, sequence)
+			}
+			return fmt.Sprintf("%s[%s]", sequence, v.Name), false, nil
+		}
+		indexCode, needsMath, err := g.generateExpr(node.Index)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("%s[int(%s)]", sequence, indexCode), needsMath, nil
+	case *ast.ConstantExpr:
+		constCode, ok := mathConstants[node.Name]
+		if !ok {
+			return "", false, fmt.Errorf("unsupported constant: \\%s", node.Name)
+		}
+		return constCode, true, nil
 	case *ast.BinaryExpr:
-		leftCode, leftNeedsMath := g.generateExpr(node.Left)
-		rightCode, rightNeedsMath := g.generateExpr(node.Right)
+		if node.Op == "±" || node.Op == "∓" {
+			if g.sign == nil {
+				return "", false, fmt.Errorf("\\pm and \\mp are only supported in the final expression")
+			}
+			g.sign.used = true
+			op := g.sign.op
+			if node.Op == "∓" {
+				op = map[string]string{"+": "-", "-": "+"}[op]
+			}
+			return g.generateExpr(&ast.BinaryExpr{Op: op, Left: node.Left, Right: node.Right})
+		}
+		if isNegation(node) {
+			// The parser represents -b as -1 * b; generate it as a Go negation
+			operandCode, needsMath, err := g.generateExpr(node.Right)
+			if err != nil {
+				return "", false, err
+			}
+			if _, ok := g.operandPrecedence(node.Right); ok || strings.HasPrefix(operandCode, "-") {
+				operandCode = "(" + operandCode + ")"
+			}
+			return "-" + operandCode, needsMath, nil
+		}
+		leftCode, leftNeedsMath, err := g.dividendGenerator(node).generateExpr(node.Left)
+		if err != nil {
+			return "", false, err
+		}
+		rightGen := g
+		if node.Op == "&&" || node.Op == "||" || node.Op == "⟹" {
+			// Go short-circuits these, so the right operand is only evaluated sometimes
+			rightGen = g.guardedGenerator()
+		}
+		rightCode, rightNeedsMath, err := rightGen.generateExpr(node.Right)
+		if err != nil {
+			return "", false, err
+		}
 		needsMath := leftNeedsMath || rightNeedsMath
 		if node.Op == "^" {
-			return fmt.Sprintf("math.Pow(%s, %s)", leftCode, rightCode), true // math.Pow requires math
+			if n, ok := smallIntegerExponent(node); ok {
+				return repeatedMultiplication(node.Left, leftCode, n), leftNeedsMath, nil
+			}
+			return fmt.Sprintf("math.Pow(%s, %s)", leftCode, rightCode), true, nil // math.Pow requires math
+		}
+		if node.Op == "⟹" {
+			// p ⟹ q only fails when p holds and q does not
+			if _, ok := g.operandPrecedence(node.Left); ok {
+				leftCode = "(" + leftCode + ")"
+			}
+			return fmt.Sprintf("!%s || %s", leftCode, rightCode), needsMath, nil
+		}
+		if node.Op == "⟺" {
+			// Go comparisons do not chain, so compared conditions need parentheses
+			if prec, ok := g.operandPrecedence(node.Left); ok && prec <= goPrecedence("==") {
+				leftCode = "(" + leftCode + ")"
+			}
+			if prec, ok := g.operandPrecedence(node.Right); ok && prec <= goPrecedence("==") {
+				rightCode = "(" + rightCode + ")"
+			}
+			return fmt.Sprintf("%s == %s", leftCode, rightCode), needsMath, nil
+		}
+		if node.Op == "mod" {
+			// The result takes the sign of the dividend, like Go's % on integers
+			return fmt.Sprintf("math.Mod(%s, %s)", leftCode, rightCode), true, nil
+		}
+		if node.Op == "/" {
+			g.requireDomain(rightCode, nonZero)
+		}
+		// Parenthesize operands that bind more loosely than this operator in Go
+		prec := goPrecedence(node.Op)
+		if leftPrec, ok := g.operandPrecedence(node.Left); ok && leftPrec < prec {
+			leftCode = "(" + leftCode + ")"
 		}
-		return fmt.Sprintf("%s %s %s", leftCode, node.Op, rightCode), needsMath
+		if rightPrec, ok := g.operandPrecedence(node.Right); ok && rightPrec <= prec {
+			rightCode = "(" + rightCode + ")"
+		}
+		return fmt.Sprintf("%s %s %s", leftCode, node.Op, rightCode), needsMath, nil
+	case *ast.RelationalChain:
+		if g.clampChains {
+			return g.generateClamp(node)
+		}
+		// Bool test: each adjacent pair must satisfy its relation
+		needsMath := false
+		operands := make([]string, len(node.Operands))
+		for i, operand := range node.Operands {
+			operandCode, operandNeedsMath, err := g.generateExpr(operand)
+			if err != nil {
+				return "", false, err
+			}
+			if prec, ok := g.operandPrecedence(operand); ok && prec <= goPrecedence("<") {
+				operandCode = "(" + operandCode + ")"
+			}
+			operands[i] = operandCode
+			needsMath = needsMath || operandNeedsMath
+		}
+		comparisons := make([]string, len(node.Ops))
+		for i, op := range node.Ops {
+			comparisons[i] = fmt.Sprintf("%s %s %s", operands[i], op, operands[i+1])
+		}
+		return strings.Join(comparisons, " && "), needsMath, nil
+	case *ast.CongruenceExpr:
+		// a ≡ b (mod n) holds when a - b is a multiple of n
+		diffCode, _, err := g.generateExpr(&ast.BinaryExpr{Op: "-", Left: node.Left, Right: node.Right})
+		if err != nil {
+			return "", false, err
+		}
+		modulusCode, _, err := g.generateExpr(node.Modulus)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("math.Mod(%s, %s) == 0", diffCode, modulusCode), true, nil // math.Mod requires math
+	case *ast.UnaryExpr:
+		operandCode, needsMath, err := g.generateExpr(node.Operand)
+		if err != nil {
+			return "", false, err
+		}
+		if _, ok := g.operandPrecedence(node.Operand); ok {
+			operandCode = "(" + operandCode + ")"
+		}
+		return node.Op + operandCode, needsMath, nil
 	case *ast.FuncCall:
+		// min/max map to builtins or to nested math.Min/math.Max depending on the Go version
+		if node.FuncName == "min" || node.FuncName == "max" {
+			return g.generateMinMax(node)
+		}
+		if node.FuncName == "gcd" {
+			return g.generateGcd(node)
+		}
+		if node.FuncName == "clamp" {
+			if len(node.Args) != 3 {
+				return "", false, fmt.Errorf("clamp requires 3 arguments, got %d", len(node.Args))
+			}
+			// clamp(x, lo, hi) is the clamp of the chain lo \le x \le hi
+			x, lo, hi := node.Args[0], node.Args[1], node.Args[2]
+			return g.generateClamp(&ast.RelationalChain{Operands: []ast.Expr{lo, x, hi}, Ops: []string{"<=", "<="}})
+		}
+		if node.FuncName == "lerp" {
+			if len(node.Args) != 3 {
+				return "", false, fmt.Errorf("lerp requires 3 arguments, got %d", len(node.Args))
+			}
+			// lerp(a, b, t) is a + (b - a) t, parenthesized as any other sum
+			a, b, t := node.Args[0], node.Args[1], node.Args[2]
+			return g.generateExpr(&ast.BinaryExpr{Op: "+", Left: a, Right: &ast.BinaryExpr{
+				Op: "*", Left: &ast.BinaryExpr{Op: "-", Left: b, Right: a}, Right: t,
+			}})
+		}
+
+		// Expectation and variance are computed over a samples slice
+		if node.FuncName == "E" || node.FuncName == "Var" {
+			return g.generateSampleStatistic(node)
+		}
+
 		// Special handling for frac
 		if node.FuncName == "frac" {
 			if len(node.Args) != 2 {
 				// This should ideally be caught by the parser, but double-check here.
-				return "", false // Or return an error
+				return "", false, fmt.Errorf("\\frac requires 2 arguments, got %d", len(node.Args))
+			}
+			quotient := &ast.BinaryExpr{Op: "/", Left: node.Args[0], Right: node.Args[1]}
+			numeratorCode, numNeedsMath, err := g.dividendGenerator(quotient).generateExpr(node.Args[0])
+			if err != nil {
+				return "", false, err
+			}
+			denominatorCode, denNeedsMath, err := g.generateExpr(node.Args[1])
+			if err != nil {
+				return "", false, err
+			}
+			g.requireDomain(denominatorCode, nonZero)
+			return fmt.Sprintf("(%s) / (%s)", numeratorCode, denominatorCode), numNeedsMath || denNeedsMath, nil // Use parentheses for safety
+		}
+
+		// The sign function: -1, 0 or 1, with sgn(±0) = 0 and NaN propagating
+		if node.FuncName == "sgn" {
+			if len(node.Args) != 1 {
+				return "", false, fmt.Errorf("\\sgn requires 1 argument, got %d", len(node.Args))
+			}
+			argCode, needsMath, err := g.generateExpr(node.Args[0])
+			if err != nil {
+				return "", false, err
+			}
+			return fmt.Sprintf("func(v float64) float64 { switch { case v > 0: return 1; case v < 0: return -1; case v == 0: return 0 }; return v }(%s)", argCode), needsMath, nil
+		}
+
+		// The fractional part x - trunc(x), evaluating a compound x once
+		if node.FuncName == "fracpart" {
+			if len(node.Args) != 1 {
+				return "", false, fmt.Errorf("\\operatorname{frac} requires 1 argument, got %d", len(node.Args))
+			}
+			argCode, _, err := g.generateExpr(node.Args[0])
+			if err != nil {
+				return "", false, err
 			}
-			numeratorCode, numNeedsMath := g.generateExpr(node.Args[0])
-			denominatorCode, denNeedsMath := g.generateExpr(node.Args[1])
-			return fmt.Sprintf("(%s) / (%s)", numeratorCode, denominatorCode), numNeedsMath || denNeedsMath // Use parentheses for safety
+			switch node.Args[0].(type) {
+			case *ast.Variable, *ast.NumberLiteral:
+				return fmt.Sprintf("(%s - math.Trunc(%s))", argCode, argCode), true, nil
+			}
+			return fmt.Sprintf("func(v float64) float64 { return v - math.Trunc(v) }(%s)", argCode), true, nil
+		}
+
+		// Composite special functions such as sinc and the logistic sigmoid
+		if _, ok := compositeFuncs[node.FuncName]; ok {
+			return g.generateCompositeFunc(node)
 		}
 
 		// General function call handling (maps to math package)
 		args := make([]string, len(node.Args))
 		needsMath := false
 		for i, arg := range node.Args {
-			argCode, argNeedsMath := g.generateExpr(arg)
+			argCode, argNeedsMath, err := g.generateExpr(arg)
+			if err != nil {
+				return "", false, err
+			}
 			args[i] = argCode
 			needsMath = needsMath || argNeedsMath
 		}
 
+		if complexOnlyFuncs[node.FuncName] {
+			return "", false, fmt.Errorf("\\%s requires complex mode (--complex)", node.FuncName)
+		}
+
 		// Check if the function is supported in the math package
 		goFuncName := cases.Title(language.English, cases.Compact).String(node.FuncName)
-		supportedMathFuncs := map[string]bool{"Sqrt": true, "Sin": true, "Cos": true, "Tan": true, "Pow": true /* Add others as needed */} // Pow handled by BinaryExpr ^
+		if name, ok := mathFuncNames[node.FuncName]; ok {
+			goFuncName = name
+		}
+		supportedMathFuncs := map[string]bool{"Sqrt": true, "Sin": true, "Cos": true, "Tan": true, "Sinh": true, "Cosh": true, "Tanh": true, "Log": true, "Atan": true, "Atan2": true, "Asinh": true, "Acosh": true, "Atanh": true, "Abs": true, "Floor": true, "Ceil": true, "Round": true, "Trunc": true, "Pow": true /* Add others as needed */} // Pow handled by BinaryExpr ^
 		if _, supported := supportedMathFuncs[goFuncName]; !supported && node.FuncName != "pow" { // Allow pow implicitly via ^
 			// Return an error instead of generating invalid code
-			// Note: We don't return the error directly from here, let Generate handle it.
-			// For now, return empty string and signal no math needed, Generate will catch the error later.
-			// TODO: A better approach might be to return an error tuple: (string, bool, error)
-			return fmt.Sprintf("/* unsupported function: %s */", node.FuncName), false
+			return "", false, fmt.Errorf("unsupported LaTeX function: %s", node.FuncName)
 		}
 
+		switch goFuncName {
+		case "Sqrt":
+			g.requireDomain(args[0], nonNegative)
+		case "Log":
+			g.requireDomain(args[0], positive)
+		}
 		// Assume math needed for all other supported func calls
 		return fmt.Sprintf("math.%s(%s)",
 			goFuncName,
 			strings.Join(args, ", "),
-		), true
+		), true, nil
 	case *ast.DerivativeExpr:
+		// Central differences are only generated for the first and second derivatives
+		if node.Order < 1 || node.Order > 2 {
+			return "", false, fmt.Errorf("derivatives of order %d are not supported: only first and second derivatives are", node.Order)
+		}
+
+		// A bare dependent variable, as in the Leibniz fraction \frac{dy}{dx}, carries
+		// no definition in terms of the differentiation variable to evaluate, unless
+		// it was assigned earlier in the equation
+		bodyGen := *g
+		bodyGen.inline = true
+		body := node.Body
+		if v, ok := body.(*ast.Variable); ok && v.Name != node.Var {
+			i := definitionIndex(g.definitions, v.Name)
+			if i < 0 {
+				return "", false, fmt.Errorf("cannot differentiate %s with respect to %s: %s is not defined in terms of %s", v.Name, node.Var, v.Name, node.Var)
+			}
+			// Differentiate the definition itself, as seen from its assignment
+			body, bodyGen.definitions = g.definitions[i].Value, g.definitions[:i]
+		}
+
 		// For derivatives, we'll implement a simple finite difference approximation
 		// TODO: This is a placeholder for a more sophisticated numerical differentiation, ideally using an inteface for adapters.
-		bodyCode, _ := g.generateExpr(node.Body)
+		bodyCode, needsMath, err := bodyGen.generateExpr(body)
+		if err != nil {
+			return "", false, err
+		}
 		
 		// Implement numerical differentiation using central difference formula
 		derivCode := []string{
@@ -91,30 +661,35 @@ func (g *Generator) generateExpr(e ast.Expr) (string, bool) {
 				fmt.Sprintf("    %s := %s // Original point", node.Var, node.Var), // Assume variable is in scope
 				fmt.Sprintf("    fwd := func() float64 { %s := %s + h; return %s; }() // f(x+h)", node.Var, node.Var, bodyCode),
 				fmt.Sprintf("    bwd := func() float64 { %s := %s - h; return %s; }() // f(x-h)", node.Var, node.Var, bodyCode),
-				"    return (fwd - bwd) / (2.0 * h)",
 			)
-		} else if node.Order == 2 {
+			derivCode = append(derivCode, g.nonFiniteGuard("    ", "fwd", "bwd")...)
+			derivCode = append(derivCode, "    return (fwd - bwd) / (2.0 * h)")
+		} else {
 			// Second-order derivative using central difference: f''(x) ≈ (f(x+h) - 2f(x) + f(x-h)) / h²
 			derivCode = append(derivCode,
 				fmt.Sprintf("    %s := %s // Original point", node.Var, node.Var), // Assume variable is in scope
 				fmt.Sprintf("    fwd := func() float64 { %s := %s + h; return %s; }() // f(x+h)", node.Var, node.Var, bodyCode),
 				fmt.Sprintf("    ctr := %s // f(x)", bodyCode),
 				fmt.Sprintf("    bwd := func() float64 { %s := %s - h; return %s; }() // f(x-h)", node.Var, node.Var, bodyCode),
-				"    return (fwd - 2.0*ctr + bwd) / (h * h)",
-			)
-		} else {
-			// For higher-order derivatives, we'll just return a comment
-			derivCode = append(derivCode,
-				"    // Higher-order derivatives not supported",
-				"    return 0.0",
 			)
+			derivCode = append(derivCode, g.nonFiniteGuard("    ", "fwd", "ctr", "bwd")...)
+			derivCode = append(derivCode, "    return (fwd - 2.0*ctr + bwd) / (h * h)")
 		}
 		
 		derivCode = append(derivCode, "}()")
-		return strings.Join(derivCode, "\n"), true // Always needs math for numerical methods
+		return strings.Join(derivCode, "\n"), needsMath || g.guardNumerics, nil // Finite differences need math only through the body
 		
 	case *ast.PiecewiseExpr:
-		// Generate code for piecewise function using if-else statements
+		// Cases keyed on integer values of one variable become a switch
+		if key, ok := switchKey(node); ok {
+			switchCode, needsMath, err := g.switchStatement(node, key)
+			if err != nil {
+				return "", false, err
+			}
+			return "func() float64 {\n" + indent(switchCode, "    ") + "\n}()", needsMath, nil
+		}
+
+		// Generate code for piecewise function using if statements, each returning
 		needsMath := false
 		
 		// Start with a function wrapper for cleaner code
@@ -124,44 +699,46 @@ func (g *Generator) generateExpr(e ast.Expr) (string, bool) {
 		
 		// Generate if-else statements for each case
 		for i, caseItem := range node.Cases {
-			valueCode, valueNeedsMath := g.generateExpr(caseItem.Value)
+			// Only the first condition is always evaluated, and a value only when its
+			// case is reached
+			caseGen, valueGen := g, g
+			if i > 0 {
+				caseGen = g.guardedGenerator()
+			}
+			if i > 0 || caseItem.Condition != nil {
+				valueGen = g.guardedGenerator()
+			}
+			valueCode, valueNeedsMath, err := valueGen.generateExpr(caseItem.Value)
+			if err != nil {
+				return "", false, err
+			}
 			needsMath = needsMath || valueNeedsMath
 			
 			if caseItem.Condition == nil {
-				// This is the default case (otherwise/else)
-				if i == len(node.Cases)-1 {
-					// Last case without a condition is the default case
-					piecewiseCode = append(piecewiseCode, 
-						"    // Default case",
-						fmt.Sprintf("    return %s", valueCode),
-					)
-				} else {
-					// Error: cases without conditions should be last
-					piecewiseCode = append(piecewiseCode, 
-						"    // ERROR: Unconditional case not at end",
-						fmt.Sprintf("    return %s", valueCode),
-					)
+				// This is the default case (otherwise/else), which must come last:
+				// any case after it could never be reached
+				if i != len(node.Cases)-1 {
+					return "", false, fmt.Errorf("the default case of a cases environment must be the last one, but case %d of %d has no condition",
+						i+1, len(node.Cases))
 				}
+				piecewiseCode = append(piecewiseCode, 
+					"    // Default case",
+					fmt.Sprintf("    return %s", valueCode),
+				)
 			} else {
 				// This is a conditional case
-				conditionCode, condNeedsMath := g.generateExpr(caseItem.Condition)
+				conditionCode, condNeedsMath, err := caseGen.generateExpr(caseItem.Condition)
+				if err != nil {
+					return "", false, err
+				}
 				needsMath = needsMath || condNeedsMath
 				
-				if i == 0 {
-					// First condition uses "if"
-					piecewiseCode = append(piecewiseCode, 
-						fmt.Sprintf("    if %s {", conditionCode),
-						fmt.Sprintf("        return %s", valueCode),
-						"    }",
-					)
-				} else {
-					// Subsequent conditions use "else if"
-					piecewiseCode = append(piecewiseCode, 
-						fmt.Sprintf("    else if %s {", conditionCode),
-						fmt.Sprintf("        return %s", valueCode),
-						"    }",
-					)
-				}
+				// Every case returns, so the first holding condition wins without an else
+				piecewiseCode = append(piecewiseCode,
+					fmt.Sprintf("    if %s {", conditionCode),
+					fmt.Sprintf("        return %s", valueCode),
+					"    }",
+				)
 			}
 		}
 		
@@ -178,48 +755,92 @@ func (g *Generator) generateExpr(e ast.Expr) (string, bool) {
 		// Close the function and call it
 		piecewiseCode = append(piecewiseCode, "}()")
 		
-		return strings.Join(piecewiseCode, "\n"), needsMath
+		return strings.Join(piecewiseCode, "\n"), needsMath, nil
 
 	case *ast.LimitExpr:
 		// For limits, we'll implement a simple approximation by evaluating at a point very close to the limit
-		bodyCode, bodyNeedsMath := g.generateExpr(node.Body)
-		approachesCode, approachesNeedsMath := g.generateExpr(node.Approaches)
+		sign, atInfinity := infiniteTarget(node.Approaches)
+		if atInfinity && indexesBy(node.Body, node.Var) {
+			return "", false, fmt.Errorf("the limit of a sequence as %s \\to \\infty cannot be computed from the finitely many elements of a slice", node.Var)
+		}
+		bodyCode, bodyNeedsMath, err := g.generateExpr(node.Body)
+		if err != nil {
+			return "", false, err
+		}
+		if atInfinity {
+			return g.limitAtInfinity(sanitizeVariableName(node.Var), sign, bodyCode), true, nil
+		}
+		approachesCode, approachesNeedsMath, err := g.generateExpr(node.Approaches)
+		if err != nil {
+			return "", false, err
+		}
 		
-		// Implementation approach: evaluate at a point very close to the limit
+		// Implementation approach: evaluate at a point very close to the limit, from the
+		// right for a^+, from the left for a^-, and from both sides otherwise
 		limitCode := []string{
 			"func() float64 {",
 			"    // Approximating limit by evaluating at a point very close to the target",
 			"    epsilon := 1e-10 // Small value for approximation",
-			fmt.Sprintf("    target := %s // Value approached", approachesCode),
-			fmt.Sprintf("    %s := float64(target) + epsilon // Set variable slightly above target", node.Var),
-			fmt.Sprintf("    return %s // Evaluate expression", bodyCode),
-			"}()",
+			fmt.Sprintf("    target := float64(%s) // Value approached", approachesCode),
+			fmt.Sprintf("    eval := func(%s float64) float64 { return %s } // Expression under the limit", node.Var, bodyCode),
+		}
+		switch node.Direction {
+		case "+":
+			limitCode = append(limitCode, "    return eval(target + epsilon) // Approach from the right")
+		case "-":
+			limitCode = append(limitCode, "    return eval(target - epsilon) // Approach from the left")
+		default:
+			limitCode = append(limitCode, "    right, left := eval(target+epsilon), eval(target-epsilon)")
+			limitCode = append(limitCode, g.nonFiniteGuard("    ", "right", "left")...)
+			limitCode = append(limitCode,
+				"    if math.Abs(right-left) > 1e-6*math.Max(1, math.Abs(right)) {",
+				"        return math.NaN() // One-sided limits disagree",
+				"    }",
+				"    return (right + left) / 2",
+			)
+			approachesNeedsMath = true // Two-sided comparison uses math.Abs, math.Max and math.NaN
 		}
+		limitCode = append(limitCode, "}()")
 		
-		return strings.Join(limitCode, "\n"), bodyNeedsMath || approachesNeedsMath
+		return strings.Join(limitCode, "\n"), bodyNeedsMath || approachesNeedsMath, nil
 
 	case *ast.IntegralExpr:
 		// For integrals, we'll use numerical integration based on the trapezoidal rule
 		// For definite integrals, we can implement basic numerical integration
-		bodyCode, bodyNeedsMath := g.generateExpr(node.Body)
+		bodyCode, bodyNeedsMath, err := g.generateExpr(node.Body)
+		if err != nil {
+			return "", false, err
+		}
 		
 		if node.IsDefinite {
 			// Generate definite integral using numerical integration
-			lowerCode, lowerNeedsMath := g.generateExpr(node.Lower)
-			upperCode, upperNeedsMath := g.generateExpr(node.Upper)
+			lowerCode, lowerNeedsMath, err := g.generateExpr(node.Lower)
+			if err != nil {
+				return "", false, err
+			}
+			upperCode, upperNeedsMath, err := g.generateExpr(node.Upper)
+			if err != nil {
+				return "", false, err
+			}
 			
 			// We need to implement a basic numerical integration algorithm
 			// Using the trapezoidal rule for simplicity
 			integralCode := []string{
 				"func() float64 {",
-				fmt.Sprintf("    a := %s // Lower bound", lowerCode),
-				fmt.Sprintf("    b := %s // Upper bound", upperCode),
+				fmt.Sprintf("    a := float64(%s) // Lower bound", lowerCode),
+				fmt.Sprintf("    b := float64(%s) // Upper bound", upperCode),
 				"    n := 1000 // Number of intervals for numerical integration",
 				"    h := (b - a) / float64(n)",
 				"    sum := 0.0",
 				"    for i := 0; i <= n; i++ {",
+			}
+			integralCode = append(integralCode, g.cancelCheck("        ", "i", "sum * h")...)
+			integralCode = append(integralCode,
 				fmt.Sprintf("        %s := a + float64(i)*h // Integration variable", node.Var),
 				fmt.Sprintf("        fx := %s // Integrand", bodyCode),
+			)
+			integralCode = append(integralCode, g.nonFiniteGuard("        ", "fx")...)
+			integralCode = append(integralCode,
 				"        weight := 1.0",
 				"        if i == 0 || i == n {",
 				"            weight = 0.5",
@@ -228,95 +849,704 @@ func (g *Generator) generateExpr(e ast.Expr) (string, bool) {
 				"    }",
 				"    return sum * h",
 				"}()",
-			}
+			)
 			
-			return strings.Join(integralCode, "\n"), bodyNeedsMath || lowerNeedsMath || upperNeedsMath
+			return strings.Join(integralCode, "\n"), bodyNeedsMath || lowerNeedsMath || upperNeedsMath || g.guardNumerics, nil
 		} else {
 			// For indefinite integrals, we can only return a comment as symbolic integration
 			// is beyond the scope of a simple translator
 			// TODO: Implement a more sophisticated symbolic integration approach
 			return fmt.Sprintf("/* Symbolic integration of %s with respect to %s not supported */", 
-				bodyCode, node.Var), bodyNeedsMath
+				bodyCode, node.Var), bodyNeedsMath, nil
+		}
+
+	case *ast.ArgOptExpr:
+		// Numerical strategy: evaluate the objective on a uniform grid of 1000 steps
+		// over the domain, then refine the best grid point with golden-section search
+		// inside its neighbouring cells. argmax minimizes the negated objective.
+		opName := "argmin"
+		if node.IsMax {
+			opName = "argmax"
+		}
+		if node.Lower == nil || node.Upper == nil {
+			return "", false, fmt.Errorf("\\%s over %s requires a search domain, e.g. \\%s_{%s \\in [a, b]}", opName, node.Var, opName, node.Var)
+		}
+		bodyCode, bodyNeedsMath, err := g.generateExpr(node.Body)
+		if err != nil {
+			return "", false, err
+		}
+		lowerCode, lowerNeedsMath, err := g.generateExpr(node.Lower)
+		if err != nil {
+			return "", false, err
+		}
+		upperCode, upperNeedsMath, err := g.generateExpr(node.Upper)
+		if err != nil {
+			return "", false, err
+		}
+		sign := ""
+		if node.IsMax {
+			sign = "-"
+		}
+
+		argOptCode := []string{
+			"func() float64 {",
+			fmt.Sprintf("    objective := func(%s float64) float64 { return %s(%s) } // Minimized objective", node.Var, sign, bodyCode),
+			fmt.Sprintf("    lo, hi := float64(%s), float64(%s) // Search domain", lowerCode, upperCode),
+			"    n := 1000 // Number of grid steps",
+			"    step := (hi - lo) / float64(n)",
+			"    best, bestVal := lo, objective(lo)",
+			"    for i := 1; i <= n; i++ {",
+		}
+		argOptCode = append(argOptCode, g.cancelCheck("        ", "i", "best")...)
+		argOptCode = append(argOptCode,
+			"        if v := objective(lo + float64(i)*step); v < bestVal {",
+			"            best, bestVal = lo+float64(i)*step, v",
+			"        }",
+			"    }",
+			"    // Golden-section refinement around the best grid point",
+			"    a, b := best-step, best+step",
+			"    if a < lo {",
+			"        a = lo",
+			"    }",
+			"    if b > hi {",
+			"        b = hi",
+			"    }",
+			"    gr := 0.6180339887498949",
+			"    c, d := b-gr*(b-a), a+gr*(b-a)",
+			"    for i := 0; i < 100; i++ {",
+			"        if objective(c) < objective(d) {",
+			"            b = d",
+			"        } else {",
+			"            a = c",
+			"        }",
+			"        c, d = b-gr*(b-a), a+gr*(b-a)",
+			"    }",
+			"    return (a + b) / 2",
+			"}()",
+		)
+		return strings.Join(argOptCode, "\n"), bodyNeedsMath || lowerNeedsMath || upperNeedsMath, nil
+
+	case *ast.KroneckerDeltaExpr:
+		iCode, iNeedsMath, err := g.generateExpr(node.I)
+		if err != nil {
+			return "", false, err
+		}
+		jCode, jNeedsMath, err := g.generateExpr(node.J)
+		if err != nil {
+			return "", false, err
+		}
+		deltaCode := []string{
+			"func() float64 {",
+			fmt.Sprintf("    if %s == %s {", iCode, jCode),
+			"        return 1",
+			"    }",
+			"    return 0",
+			"}()",
+		}
+		return strings.Join(deltaCode, "\n"), iNeedsMath || jNeedsMath, nil
+
+	case *ast.IndicatorExpr:
+		conditionCode, needsMath, err := g.generateExpr(node.Condition)
+		if err != nil {
+			return "", false, err
+		}
+		indicatorCode := []string{
+			"func() float64 {",
+			fmt.Sprintf("    if %s {", conditionCode),
+			"        return 1.0",
+			"    }",
+			"    return 0.0",
+			"}()",
+		}
+		return strings.Join(indicatorCode, "\n"), needsMath, nil
+
+	case *ast.MatrixExpr:
+		// Matrices become a [][]float64 literal in row-major order
+		needsMath := false
+		rows := make([]string, len(node.Rows))
+		for i, row := range node.Rows {
+			cells := make([]string, len(row))
+			for j, cell := range row {
+				cellCode, cellNeedsMath, err := g.generateExpr(cell)
+				if err != nil {
+					return "", false, err
+				}
+				cells[j] = cellCode
+				needsMath = needsMath || cellNeedsMath
+			}
+			rows[i] = "{" + strings.Join(cells, ", ") + "}"
 		}
+		return "[][]float64{" + strings.Join(rows, ", ") + "}", needsMath, nil
 
 	case *ast.FactorialExpr:
 		// Generate factorial using math.Gamma(n+1)
-		valueCode, _ := g.generateExpr(node.Value)
+		valueCode, _, err := g.generateExpr(node.Value)
+		if err != nil {
+			return "", false, err
+		}
+		g.requireDomain(valueCode, nonNegativeInteger)
 		// Use math.Gamma(x+1) for factorial calculation
-		return fmt.Sprintf("math.Gamma(%s + 1.0)", valueCode), true
+		return fmt.Sprintf("math.Gamma(%s + 1.0)", valueCode), true, nil
 
 	case *ast.SumExpr:
-		// Summation or product loop
-		idx := node.Var
-		lowCode, lowNeedsMath := g.generateExpr(node.Lower)
-		upCode, upNeedsMath := g.generateExpr(node.Upper)
-		bodyCode, bodyNeedsMath := g.generateExpr(node.Body)
-		needsMath := lowNeedsMath || upNeedsMath || bodyNeedsMath
-
-		initVal, op := "0.0", "+" // Use float literal for init
-		if node.IsProduct {
-			initVal, op = "1.0", "*"
-		}
-		// Ensure loop bounds are treated as floats for comparison if they are variables
-		// Note: This assumes loop variables are integers, which might be fragile.
-		// TODO: A more robust solution might involve type analysis or clearer loop semantics.
-		loop := []string{
-			fmt.Sprintf("result := %s", initVal),
-			// Using float64 for loop counter and bounds for consistency with math ops
-			fmt.Sprintf("for %s := float64(int(%s)); %s <= float64(int(%s)); %s++ {", idx, lowCode, idx, upCode, idx),
-			fmt.Sprintf("    result = result %s (%s)", op, bodyCode), // Add parentheses around body for safety
-			"}",
-			"return result", // Return result directly from loop structure
+		// A sum or product inside a larger expression runs its loop in an immediately invoked closure
+		loopCode, needsMath, err := g.generateSumLoop(node)
+		if err != nil {
+			return "", false, err
 		}
-		return strings.Join(loop, "\n"), needsMath
+		return "func() float64 {\n" + indent(loopCode, "    ") + "\n}()", needsMath, nil
+	case *ast.PochhammerExpr:
+		return g.generatePochhammer(node)
+	case *ast.NormExpr:
+		return generateNorm(node), true, nil
+	case *ast.EvaluationExpr:
+		return g.generateEvaluation(node)
+	case *ast.VectorExpr:
+		// Outside vector arithmetic the decoration is transparent
+		return g.generateExpr(&ast.Variable{Name: node.Name})
+	case *ast.InnerProductExpr:
+		return generateInnerProduct(node, false), false, nil
+	case *ast.KetExpr:
+		return "", false, errLoneKet(node)
+	case *ast.ConditionalExpectationExpr:
+		return "", false, errConditionalExpectation
+	case *ast.UnitExpr:
+		// Units only take part in the optional dimensional check
+		return g.generateExpr(node.Value)
+	case *ast.GradientExpr:
+		// The components follow the parameters, known only once the whole equation is collected
+		return "", false, fmt.Errorf("\\nabla is only supported as the whole equation")
+	case *ast.BigOpExpr:
+		fn, ok := g.reducers[node.Name]
+		if !ok {
+			return "", false, fmt.Errorf("unknown operator \\operatorname*{%s}: no reducer registered for %q", node.Name, node.Name)
+		}
+		return fn(node, g)
 	default:
-		return "", false
+		// Give registered custom node generators a chance before giving up
+		if ext, ok := e.(ast.Extension); ok {
+			if fn, ok := g.nodeGenerators[ext.Kind()]; ok {
+				return fn(e, g)
+			}
+			return "", false, fmt.Errorf("no generator registered for custom node kind %q", ext.Kind())
+		}
+		return "", false, fmt.Errorf("unsupported AST node type %T", e)
 	}
 }
 
-// Generate produces full Go source code for the given AST root, package, and function.
-func (g *Generator) Generate(root ast.Expr, pkgName, funcName string) (string, error) {
-	// Generate the core expression/loop code and check if math is needed
-	codeBody, needsMath := g.generateExpr(root)
+// generateSumLoop renders a sum or product as the statements of a loop
+// accumulating into result, followed by returning result.
+func (g *Generator) generateSumLoop(node *ast.SumExpr) (string, bool, error) {
+	if node.Values != nil {
+		return g.generateIndexSetLoop(node)
+	}
+	if node.Lower == nil && node.Upper == nil {
+		return g.generateRangeLoop(node)
+	}
+	idx := node.Var
+	if idx == g.rangeIndex {
+		// The float64 index of this loop shadows the int one of an enclosing \sum_n
+		inner := *g
+		inner.rangeIndex = ""
+		return inner.generateSumLoop(node)
+	}
+	lowCode, lowNeedsMath, err := g.generateLoopBound(node.Lower, "Ceil")
+	if err != nil {
+		return "", false, err
+	}
+	upCode, upNeedsMath, err := g.generateLoopBound(node.Upper, "Floor")
+	if err != nil {
+		return "", false, err
+	}
+	bodyCode, bodyNeedsMath, err := g.generateExpr(node.Body)
+	if err != nil {
+		return "", false, err
+	}
+	needsMath := lowNeedsMath || upNeedsMath || bodyNeedsMath
 
-	// Check for unsupported function placeholder generated by generateExpr
-	if strings.HasPrefix(codeBody, "/* unsupported function:") {
-		var unsupportedFuncName string
-		fmt.Sscanf(codeBody, "/* unsupported function: %s */", &unsupportedFuncName)
-		return "", fmt.Errorf("unsupported LaTeX function: %s", unsupportedFuncName)
+	// A \substack filter skips the indices that fail its condition
+	var guard []string
+	if node.Filter != nil {
+		filterCode, filterNeedsMath, err := g.generateExpr(node.Filter)
+		if err != nil {
+			return "", false, err
+		}
+		needsMath = needsMath || filterNeedsMath
+		guard = []string{
+			fmt.Sprintf("    if !(%s) {", filterCode),
+			"        continue",
+			"    }",
+		}
 	}
 
-	mathImport := ""
-	if needsMath {
-		mathImport = "\"math\""
+	initVal, op := "0.0", "+" // Use float literal for init
+	if node.IsProduct {
+		initVal, op = "1.0", "*"
+	}
+	// The index runs over the integers in [lower, upper], or its first maxTerms ones.
+	// An empty range skips the loop, leaving the identity: 0 for an empty sum and 1
+	// for an empty product.
+	cond := fmt.Sprintf("%s <= %s", idx, upCode)
+	if g.maxTerms > 0 {
+		cond += fmt.Sprintf(" && %s < %s+%d", idx, lowCode, g.maxTerms)
 	}
+	steps, check := g.countedCancelCheck("    ", "result")
+	loop := []string{fmt.Sprintf("result := %s", initVal)}
+	loop = append(loop, steps...)
+	loop = append(loop,
+		// Using float64 for loop counter and bounds for consistency with math ops
+		fmt.Sprintf("for %s := %s; %s; %s++ {", idx, lowCode, cond, idx),
+	)
+	loop = append(loop, check...)
+	loop = append(loop, guard...)
+	loop = append(loop, g.accumulate(op, bodyCode)...)
+	loop = append(loop,
+		"}",
+		"return result", // Return result directly from loop structure
+	)
+	return strings.Join(loop, "\n"), needsMath || g.guardNumerics, nil
+}
 
-	var header string
-	if mathImport != "" {
-		header = fmt.Sprintf("package %s\n\nimport %s\n\n", pkgName, mathImport)
-	} else {
-		header = fmt.Sprintf("package %s\n\n", pkgName)
+// generateRangeLoop renders \sum_n or \prod_n, which has no bounds, as a loop over
+// the indices of the first sequence its body indexes by n: \sum_n a_n becomes
+// for n := range a { ... }. Any other sequence indexed by n must be at least as long.
+func (g *Generator) generateRangeLoop(node *ast.SumExpr) (string, bool, error) {
+	loopGen := *g
+	loopGen.rangeIndex, loopGen.rangeSequences = node.Var, nil
+	bodyCode, needsMath, err := loopGen.generateExpr(node.Body)
+	sequences := loopGen.rangeSequences
+	if err != nil {
+		return "", false, err
+	}
+	opName, initVal, op := "sum", "0.0", "+"
+	if node.IsProduct {
+		opName, initVal, op = "prod", "1.0", "*"
+	}
+	if len(sequences) == 0 {
+		return "", false, fmt.Errorf("\\%s_%s has no bounds, so its body must index a sequence by %s (e.g., a_%s)", opName, node.Var, node.Var, node.Var)
+	}
+	loop := []string{
+		fmt.Sprintf("result := %s", initVal),
+		fmt.Sprintf("for %s := range %s {", node.Var, sequences[0]),
 	}
+	loop = append(loop, g.cancelCheck("    ", node.Var, "result")...) // The index is an int
+	loop = append(loop, g.accumulate(op, bodyCode)...)
+	loop = append(loop,
+		"}",
+		"return result",
+	)
+	return strings.Join(loop, "\n"), needsMath || g.guardNumerics, nil
+}
 
-	// Collect variables from AST
-	vars := make(map[string]struct{})
-	var collect func(e ast.Expr, loopVar string) // Pass loopVar down
-	collect = func(e ast.Expr, loopVar string) {
-		if e == nil { // Add nil check for safety
-			return
+// generateIndexSetLoop renders a sum or product over enumerated index values, as in
+// \sum_{i=1,3,5} i^2, as a loop ranging over a slice of them.
+func (g *Generator) generateIndexSetLoop(node *ast.SumExpr) (string, bool, error) {
+	needsMath := false
+	values := make([]string, len(node.Values))
+	for i, value := range node.Values {
+		valueCode, valueNeedsMath, err := g.generateExpr(value)
+		if err != nil {
+			return "", false, err
 		}
-		switch n := e.(type) {
-		case *ast.Variable:
-			// Exclude loop variable from parameters
-			if n.Name != loopVar {
-				vars[sanitizeVariableName(n.Name)] = struct{}{}
-			}
-		case *ast.BinaryExpr:
-			collect(n.Left, loopVar)
-			collect(n.Right, loopVar)
-		case *ast.FuncCall:
-			// Don't collect from inside frac if it was handled specially
-			if n.FuncName != "frac" {
+		values[i] = valueCode
+		needsMath = needsMath || valueNeedsMath
+	}
+	bodyCode, bodyNeedsMath, err := g.generateExpr(node.Body)
+	if err != nil {
+		return "", false, err
+	}
+	needsMath = needsMath || bodyNeedsMath
+
+	initVal, op := "0.0", "+"
+	if node.IsProduct {
+		initVal, op = "1.0", "*"
+	}
+	steps, check := g.countedCancelCheck("    ", "result")
+	loop := []string{fmt.Sprintf("result := %s", initVal)}
+	loop = append(loop, steps...)
+	loop = append(loop, fmt.Sprintf("for _, %s := range []float64{%s} {", node.Var, strings.Join(values, ", ")))
+	loop = append(loop, check...)
+	loop = append(loop, g.accumulate(op, bodyCode)...)
+	loop = append(loop,
+		"}",
+		"return result",
+	)
+	return strings.Join(loop, "\n"), needsMath || g.guardNumerics, nil
+}
+
+// accumulate renders the loop statements combining a term into result with op. With
+// numeric guards, a non-finite term ends the loop's function, returning that term.
+func (g *Generator) accumulate(op, termCode string) []string {
+	if !g.guardNumerics {
+		return []string{fmt.Sprintf("    result = result %s (%s)", op, termCode)} // Add parentheses around body for safety
+	}
+	lines := []string{fmt.Sprintf("    term := %s", termCode)}
+	lines = append(lines, g.nonFiniteGuard("    ", "term")...)
+	return append(lines, fmt.Sprintf("    result = result %s term", op))
+}
+
+// nonFiniteGuard renders, with numeric guards, the statements returning the first
+// of the named values that is NaN or ±Inf; without them it renders nothing.
+func (g *Generator) nonFiniteGuard(prefix string, names ...string) []string {
+	if !g.guardNumerics {
+		return nil
+	}
+	var lines []string
+	for _, name := range names {
+		lines = append(lines,
+			prefix+fmt.Sprintf("if math.IsNaN(%s) || math.IsInf(%s, 0) {", name, name),
+			prefix+fmt.Sprintf("    return %s // Stop at a non-finite value instead of computing on with it", name),
+			prefix+"}",
+		)
+	}
+	return lines
+}
+
+// cancelCheckInterval is the number of iterations of a numerical loop between two
+// checks of ctx.Err() with WithContext, which takes a lock: often enough to stop
+// soon after a cancellation, rarely enough to cost little next to the loop body.
+const cancelCheckInterval = 256
+
+// cancelCheck renders, with WithContext, the statements ending the loop's function
+// with partial once ctx is canceled, checked when the int step is a multiple of
+// cancelCheckInterval. The value is discarded, as the function reports ctx.Err().
+func (g *Generator) cancelCheck(prefix, step, partial string) []string {
+	if !g.cancellable {
+		return nil
+	}
+	return []string{
+		prefix + fmt.Sprintf("if %s%%%d == 0 && ctx.Err() != nil {", step, cancelCheckInterval),
+		prefix + fmt.Sprintf("    return %s // Canceled", partial),
+		prefix + "}",
+	}
+}
+
+// countedCancelCheck is like cancelCheck for loops without an int index: it
+// returns the declaration of a step counter, to precede the loop, and the
+// statements counting a step and checking ctx, to start its body.
+func (g *Generator) countedCancelCheck(prefix, partial string) ([]string, []string) {
+	if !g.cancellable {
+		return nil, nil
+	}
+	return []string{"steps := 0"}, append([]string{prefix + "steps++"}, g.cancelCheck(prefix, "steps", partial)...)
+}
+
+// generatePochhammer renders a rising or falling factorial as the product of
+// floor(count) factors base+k (rising) or base-k (falling), k = 0, 1, ...
+// Base and count are bound before the loop, so their code cannot clash with k.
+func (g *Generator) generatePochhammer(node *ast.PochhammerExpr) (string, bool, error) {
+	baseCode, baseNeedsMath, err := g.generateExpr(node.Base)
+	if err != nil {
+		return "", false, err
+	}
+	countCode, countNeedsMath, err := g.generateExpr(node.Count)
+	if err != nil {
+		return "", false, err
+	}
+	op := "-"
+	if node.Rising {
+		op = "+"
+	}
+	code := []string{
+		"func() float64 {",
+		fmt.Sprintf("    base, count := float64(%s), float64(%s)", baseCode, countCode),
+		"    result := 1.0",
+		"    for k := 0.0; k+1 <= count; k++ {",
+		fmt.Sprintf("        result *= base %s k", op),
+		"    }",
+		"    return result",
+		"}()",
+	}
+	return strings.Join(code, "\n"), baseNeedsMath || countNeedsMath, nil
+}
+
+// generateLoopBound renders a sum or product bound rounded to an integer with
+// rounding ("Ceil" for lower bounds, "Floor" for upper bounds). Literal bounds are
+// rounded at generation time; others use math.Ceil/math.Floor, as truncating with
+// int() would round negative bounds the wrong way.
+func (g *Generator) generateLoopBound(bound ast.Expr, rounding string) (string, bool, error) {
+	if lit, ok := bound.(*ast.NumberLiteral); ok {
+		value := math.Floor(lit.Value)
+		if rounding == "Ceil" {
+			value = math.Ceil(lit.Value)
+		}
+		return fmt.Sprintf("%.1f", value), false, nil // Float literal keeps the index a float64
+	}
+	boundCode, _, err := g.generateExpr(bound)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("math.%s(%s)", rounding, boundCode), true, nil
+}
+
+// definitionIndex returns the position of the last assignment to name among
+// definitions, or -1 if there is none.
+func definitionIndex(definitions []*ast.AssignmentExpr, name string) int {
+	for i := len(definitions) - 1; i >= 0; i-- {
+		if definitions[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// inlineDefinition generates the value of the i-th definition in place of a
+// reference to it, parenthesized unless it is a single operand. The value is
+// generated as at its assignment, where only the definitions before it are visible.
+func (g *Generator) inlineDefinition(i int) (string, bool, error) {
+	value := g.definitions[i].Value
+	inner := *g
+	inner.definitions = g.definitions[:i]
+	code, needsMath, err := inner.generateExpr(value)
+	if err != nil {
+		return "", false, err
+	}
+	if _, ok := g.operandPrecedence(value); ok {
+		code = "(" + code + ")"
+	}
+	return code, needsMath, nil
+}
+
+// Generate produces full Go source code for the given AST root, package, and function.
+func (g *Generator) Generate(root ast.Expr, pkgName, funcName string) (string, error) {
+	return g.generate(root, pkgName, funcName, true)
+}
+
+// GenerateUnformatted is like Generate, but stops before formatting: passing its
+// result to Format gives the same code as Generate. The steps are separate so
+// that callers can measure them.
+func (g *Generator) GenerateUnformatted(root ast.Expr, pkgName, funcName string) (string, error) {
+	return g.source(root, pkgName, funcName, true)
+}
+
+// Format formats source returned by GenerateUnformatted with go/format and the
+// configured indentation.
+func (g *Generator) Format(src string) (string, error) {
+	return g.formatSource(src)
+}
+
+// generate produces the formatted Go source of the function, followed by the
+// helpers it uses if withHelpers is set.
+func (g *Generator) generate(root ast.Expr, pkgName, funcName string, withHelpers bool) (string, error) {
+	src, err := g.source(root, pkgName, funcName, withHelpers)
+	if err != nil {
+		return "", err
+	}
+	return g.formatSource(src)
+}
+
+// source produces the unformatted Go source of the function, followed by the
+// helpers it uses if withHelpers is set.
+func (g *Generator) source(root ast.Expr, pkgName, funcName string, withHelpers bool) (string, error) {
+	if g.maxTerms < 0 {
+		return "", fmt.Errorf("invalid maximum of %d terms: expected a positive number or 0 for no cap", g.maxTerms)
+	}
+	if g.checkUnits {
+		if err := checkUnits(root); err != nil {
+			return "", fmt.Errorf("inconsistent units: %w", err)
+		}
+	}
+	if g.simplify {
+		root = simplify(root)
+	}
+	if g.einstein {
+		var err error
+		if root, err = contractIndices(root); err != nil {
+			return "", err
+		}
+	}
+	if g.optimize {
+		root = optimize(root)
+	}
+	if g.domainNotes || g.checkOverflow || g.validator {
+		// Every copy made for this equation shares the notes, which overflow checks
+		// also read for the logarithms and the validator checks
+		noting := *g
+		noting.domain = &domainNotes{}
+		g = &noting
+	}
+
+	// Intermediate assignments become local declarations ahead of the final expression,
+	// which is generated as if it were the root
+	var assignments []*ast.AssignmentExpr
+	if block, ok := root.(*ast.BlockExpr); ok {
+		if g.complex || g.vectorize {
+			return "", fmt.Errorf("assignments are not supported in complex or vectorized mode")
+		}
+		assignments, root = block.Assignments, block.Result
+	}
+
+	// Generate the core expression/loop code and check if math is needed
+	var codeBody string
+	var needsMath, needsCmplx bool
+	var complexResult complexCode
+	// A top-level sum or product is the function's own loop, unless a vectorized
+	// function needs one per element
+	sum, rootIsLoop := root.(*ast.SumExpr)
+	rootIsLoop = rootIsLoop && !g.complex && !g.vectorize && !g.checkOverflow && !g.trace && !g.intermediates
+	gradient, rootIsGradient := root.(*ast.GradientExpr)
+	// Vector arithmetic returns its elementwise results, indexing the vectors by vectorIndex
+	rootIsVector, err := vectorArithmetic(root)
+	if err != nil {
+		return "", err
+	}
+	var vectorParams, unitVectors []string
+	var vectorIndex string
+	norms := make(map[string]string) // Names of the norms of the unit vectors
+	vectorNodes := make(map[*ast.VectorExpr]bool)
+	if rootIsVector {
+		if g.complex || g.vectorize || g.checkOverflow || g.trace {
+			return "", fmt.Errorf("vector arithmetic is not supported in complex, vectorized, overflow-checked or traced mode")
+		}
+		operands := vectorOperands(root)
+		for _, operand := range operands {
+			vectorNodes[operand] = true
+		}
+		vectorParams, unitVectors = vectorNames(operands)
+		taken := append(variableNames(root), vectorParams...)
+		vectorIndex = unusedName("i", taken)
+		for _, name := range unitVectors {
+			norms[name] = unusedName(name+"Norm", taken)
+		}
+	}
+	if g.trace && (g.complex || g.vectorize || g.checkOverflow || rootIsGradient) {
+		return "", fmt.Errorf("tracing is not supported for gradients or in complex, vectorized or overflow-checked mode")
+	}
+	if rootIsGradient && (g.complex || g.vectorize || len(assignments) > 0) {
+		return "", fmt.Errorf("\\nabla is not supported with assignments or in complex or vectorized mode")
+	}
+	// The final expression is generated with the upper sign of any \pm first
+	upperGen := *g
+	upperGen.sign = &signChoice{op: "+"}
+	upperGen.definitions = assignments
+	if g.complex {
+		// Complex mode has its own code path, typed by the kind of each sub-expression
+		complexResult, err = g.generateComplexExpr(root)
+		codeBody, needsMath, needsCmplx = complexResult.code, complexResult.needsMath, complexResult.needsCmplx
+	} else if rootIsVector {
+		codeBody, needsMath, err = upperGen.generateVectorElement(root, vectorIndex, norms)
+		needsMath = needsMath || len(unitVectors) > 0
+	} else if rootIsLoop {
+		codeBody, needsMath, err = upperGen.generateSumLoop(sum)
+	} else if rootIsGradient {
+		// Only the body for now: the partial derivatives are assembled once the parameters are known
+		codeBody, needsMath, err = upperGen.generateExpr(gradient.Body)
+	} else {
+		codeBody, needsMath, err = upperGen.generateExpr(root)
+	}
+	if err != nil {
+		return "", err
+	}
+	// With \pm, the function returns a second result using the lower signs
+	var lowerBody string
+	if upperGen.sign.used {
+		if g.vectorize || g.checkOverflow || g.trace || rootIsGradient {
+			return "", fmt.Errorf("\\pm is not supported for gradients or in vectorized, overflow-checked or traced mode")
+		}
+		if rootIsLoop {
+			// Each result runs its own loop, in a closure
+			rootIsLoop = false
+			codeBody, needsMath, err = upperGen.generateExpr(root)
+			if err != nil {
+				return "", err
+			}
+		}
+		lowerGen := *g
+		lowerGen.sign = &signChoice{op: "-"}
+		lowerGen.definitions = assignments
+		lowerBody, _, err = lowerGen.generateExpr(root) // Uses the same functions as the upper result
+		if err != nil {
+			return "", err
+		}
+	}
+	decls := make([]string, len(assignments))
+	for i, a := range assignments {
+		valueGen := *g
+		valueGen.definitions = assignments[:i] // Each assignment sees those before it
+		valueCode, valueNeedsMath, err := valueGen.generateExpr(a.Value)
+		if err != nil {
+			return "", err
+		}
+		// Convert to float64 so that a constant value such as 2 is not declared as an int
+		if !g.isBooleanExpr(a.Value) {
+			valueCode = fmt.Sprintf("float64(%s)", valueCode)
+		}
+		decls[i] = fmt.Sprintf("%s := %s", sanitizeVariableName(a.Name), valueCode)
+		needsMath = needsMath || valueNeedsMath
+	}
+
+	// Collect variables from AST
+	vars := make(map[string]struct{})
+	samples := make(map[string]struct{}) // Random variables of E/Var and normed vectors, passed as []float64
+	vectors := make(map[string]bool)      // Names among samples that are normed vectors
+	assigned := make(map[string]bool)     // Names of the assignments collected so far, bound locally
+	used := make(map[string]bool)         // Assigned names referenced by a later statement
+	reads := make(map[string][]string)    // Assigned names read by the code of each assignment ("" for the result)
+	reader := ""                          // The assignment whose value is being collected
+	inDerivative := 0                     // Depth of derivative bodies, where assigned names are inlined
+	enclosing := make(map[string]int)     // Indices of the sums and products enclosing the one being collected
+	indices := make(map[string]string)    // Indices of sums and products, to the \sum or \prod binding them
+	var appearance []string               // Parameter names in order of first appearance
+	addParam := func(set map[string]struct{}, name string) {
+		if _, ok := set[name]; !ok {
+			set[name] = struct{}{}
+			appearance = append(appearance, name)
+		}
+	}
+	// Variables standing alone as operands of a logical connective, as in p \land q,
+	// are propositions, passed as bool; uses counts every use of a parameter
+	propositions := make(map[string]int) // Uses as a proposition
+	uses := make(map[string]int)
+	markProposition := func(e ast.Expr, loopVar string) {
+		if v, ok := e.(*ast.Variable); ok && !assigned[v.Name] && v.Name != loopVar && enclosing[v.Name] == 0 {
+			propositions[sanitizeVariableName(v.Name)]++
+		}
+	}
+	var collect func(e ast.Expr, loopVar string) // Pass loopVar down
+	collect = func(e ast.Expr, loopVar string) {
+		if e == nil { // Add nil check for safety
+			return
+		}
+		switch n := e.(type) {
+		case *ast.Variable:
+			// Exclude loop variable and assigned names from parameters
+			if assigned[n.Name] {
+				used[n.Name] = true
+				if inDerivative == 0 {
+					reads[reader] = append(reads[reader], n.Name)
+				}
+			} else if n.Name != loopVar && enclosing[n.Name] == 0 {
+				addParam(vars, sanitizeVariableName(n.Name))
+				uses[sanitizeVariableName(n.Name)]++
+			}
+		case *ast.BinaryExpr:
+			if isConnective(n.Op) {
+				markProposition(n.Left, loopVar)
+				markProposition(n.Right, loopVar)
+			}
+			collect(n.Left, loopVar)
+			collect(n.Right, loopVar)
+		case *ast.UnaryExpr:
+			if n.Op == "!" {
+				markProposition(n.Operand, loopVar)
+			}
+			collect(n.Operand, loopVar)
+		case *ast.CongruenceExpr:
+			collect(n.Left, loopVar)
+			collect(n.Right, loopVar)
+			collect(n.Modulus, loopVar)
+		case *ast.RelationalChain:
+			for _, operand := range n.Operands {
+				collect(operand, loopVar)
+			}
+		case *ast.FuncCall:
+			// Random variables of \mathbb{E}[X] and \text{Var}[X] become slice parameters
+			if name, ok := sampleVariable(n); ok {
+				addParam(samples, name)
+				return
+			}
+			// Don't collect from inside frac if it was handled specially
+			if n.FuncName != "frac" {
 				for _, a := range n.Args {
 					collect(a, loopVar)
 				}
@@ -331,8 +1561,20 @@ func (g *Generator) Generate(root ast.Expr, pkgName, funcName string) (string, e
 			// Collect from bounds, passing the current loopVar (if any)
 			collect(n.Lower, loopVar)
 			collect(n.Upper, loopVar)
-			// Collect from body, passing the *new* loopVar for this SumExpr
+			for _, value := range n.Values {
+				collect(value, loopVar)
+			}
+			// Collect from body, passing the *new* loopVar for this SumExpr; the
+			// indices of enclosing sums stay bound in it
+			operator := "\\sum"
+			if n.IsProduct {
+				operator = "\\prod"
+			}
+			indices[sanitizeVariableName(n.Var)] = operator
+			enclosing[loopVar]++
 			collect(n.Body, n.Var)
+			collect(n.Filter, n.Var)
+			enclosing[loopVar]--
 		case *ast.IntegralExpr:
 			// Collect from bounds for definite integrals
 			if n.IsDefinite {
@@ -342,13 +1584,70 @@ func (g *Generator) Generate(root ast.Expr, pkgName, funcName string) (string, e
 			// Collect from body, passing the integration variable as loopVar to exclude it
 			collect(n.Body, n.Var)
 		case *ast.DerivativeExpr:
-			// Collect from body, passing the differentiation variable as loopVar
-			collect(n.Body, n.Var)
+			// The derivative is evaluated at the differentiation variable, so it stays a parameter
+			if n.Var != loopVar {
+				addParam(vars, sanitizeVariableName(n.Var))
+			}
+			inDerivative++
+			collect(n.Body, loopVar)
+			inDerivative--
 		case *ast.LimitExpr:
 			// Collect from approaches value
 			collect(n.Approaches, loopVar)
 			// Collect from body, passing the limit variable as loopVar
 			collect(n.Body, n.Var)
+		case *ast.EvaluationExpr:
+			// The variable set to the bounds is bound in the body; an ambiguous one is
+			// reported when generating
+			collect(n.Lower, loopVar)
+			collect(n.Upper, loopVar)
+			variable, _ := evaluationVar(n)
+			collect(n.Body, variable)
+		case *ast.ArgOptExpr:
+			// Collect from the domain bounds, excluding the optimization variable from the body
+			collect(n.Lower, loopVar)
+			collect(n.Upper, loopVar)
+			collect(n.Body, n.Var)
+		case *ast.GradientExpr:
+			collect(n.Body, loopVar)
+		case *ast.UnitExpr:
+			collect(n.Value, loopVar)
+		case *ast.NormExpr:
+			// Normed vectors are slice parameters, like random variables
+			name := sanitizeVariableName(n.Vector)
+			addParam(samples, name)
+			vectors[name] = true
+		case *ast.VectorExpr:
+			// Decorated vectors are slice parameters in vector arithmetic, and
+			// transparent elsewhere
+			if !vectorNodes[n] {
+				collect(&ast.Variable{Name: n.Name}, loopVar)
+				break
+			}
+			name := sanitizeVariableName(n.Name)
+			addParam(samples, name)
+			vectors[name] = true
+		case *ast.InnerProductExpr:
+			// Both vectors are slice parameters, like normed vectors
+			for _, vector := range []string{n.Bra, n.Ket} {
+				name := sanitizeVariableName(vector)
+				addParam(samples, name)
+				vectors[name] = true
+			}
+		case *ast.IndexExpr:
+			// Sequences are slice parameters, like normed vectors
+			name := sanitizeVariableName(n.Sequence)
+			addParam(samples, name)
+			vectors[name] = true
+			collect(n.Index, loopVar)
+		case *ast.PochhammerExpr:
+			collect(n.Base, loopVar)
+			collect(n.Count, loopVar)
+		case *ast.BigOpExpr:
+			// The index variable is bound by the operator
+			collect(n.Lower, loopVar)
+			collect(n.Upper, loopVar)
+			collect(n.Body, n.Var)
 		case *ast.FactorialExpr:
 			// Collect from the factorial's value
 			collect(n.Value, loopVar)
@@ -360,47 +1659,1085 @@ func (g *Generator) Generate(root ast.Expr, pkgName, funcName string) (string, e
 					collect(caseItem.Condition, loopVar)
 				}
 			}
+		case *ast.KroneckerDeltaExpr:
+			collect(n.I, loopVar)
+			collect(n.J, loopVar)
+		case *ast.IndicatorExpr:
+			collect(n.Condition, loopVar)
+		case *ast.MatrixExpr:
+			for _, row := range n.Rows {
+				for _, cell := range row {
+					collect(cell, loopVar)
+				}
+			}
+		case ast.Extension:
+			// Custom nodes expose their sub-expressions explicitly
+			for _, child := range n.Children() {
+				collect(child, loopVar)
+			}
 		}
 	}
+	for _, a := range assignments {
+		reader = a.Name
+		collect(a.Value, "")
+		// A name read before its assignment would be both a parameter and a local
+		if _, ok := vars[sanitizeVariableName(a.Name)]; ok {
+			return "", fmt.Errorf("%s is used before it is assigned", a.Name)
+		}
+		assigned[a.Name] = true
+	}
+	reader = ""
 	collect(root, "") // Start collection with no loop variable context
+	for _, a := range assignments {
+		if !used[a.Name] && !g.intermediates { // Recording the value is a use
+			return "", fmt.Errorf("%s is assigned but never used", a.Name)
+		}
+	}
+	// Only the assignments the generated code reads are declared, as Go rejects unused
+	// locals: one read only through derivatives, which inline it, is left out
+	declared := make(map[string]bool)
+	var declare func(name string)
+	declare = func(name string) {
+		if declared[name] {
+			return
+		}
+		declared[name] = true
+		for _, read := range reads[name] {
+			declare(read)
+		}
+	}
+	for _, read := range reads[""] {
+		declare(read)
+	}
+	for i, a := range assignments {
+		if !declared[a.Name] && !g.trace && !g.intermediates { // Traced and recorded values are read
+			decls[i] = ""
+		}
+	}
 
-	// Build sorted parameter list
-	names := make([]string, 0, len(vars))
+	// Build the parameter list, sorted by name unless first-appearance order was requested
+	for _, v := range appearance {
+		// \sum_{i=1}^{n} \frac{1}{i} + \frac{1}{i^2} only sums the first fraction, leaving
+		// the second i unbound
+		if operator, ok := indices[v]; ok {
+			if _, isParam := vars[v]; isParam {
+				return "", fmt.Errorf("%s is the index of %s_{%s} but is also used outside it: %s binds only the term that follows it, so write %s_{%s} (a + b) to cover several terms",
+					v, operator, v, operator, operator, v)
+			}
+		}
+	}
 	for v := range vars {
-		names = append(names, v)
+		if _, isSample := samples[v]; isSample {
+			if vectors[v] {
+				return "", fmt.Errorf("vector %s is also used as a scalar", v)
+			}
+			return "", fmt.Errorf("random variable %s is also used as a scalar", v)
+		}
+	}
+	for v, n := range propositions {
+		if uses[v] > n {
+			return "", fmt.Errorf("%s is used both as a number and as a proposition, an operand of a logical connective", v)
+		}
+	}
+	names := appearance
+	switch g.paramOrder {
+	case "", "alpha":
+		sort.Strings(names)
+	case "appearance":
+	default:
+		return "", fmt.Errorf("invalid parameter order %q: expected \"alpha\" or \"appearance\"", g.paramOrder)
+	}
+	if g.maxParams > 0 && len(names) > g.maxParams {
+		return "", fmt.Errorf("generated function would have %d parameters, exceeding the limit of %d: %s",
+			len(names), g.maxParams, strings.Join(names, ", "))
+	}
+	paramType := "float64"
+	if g.complex {
+		paramType = "complex128"
+	}
+	if g.vectorize {
+		if len(names) == 0 {
+			return "", fmt.Errorf("cannot vectorize an expression without parameters")
+		}
+		if len(samples) > 0 {
+			return "", fmt.Errorf("cannot vectorize an expression over random variables")
+		}
+		paramType = "[]" + paramType
 	}
-	sort.Strings(names)
 	params := ""
 	if len(names) > 0 {
 		parts := make([]string, len(names))
 		for i, v := range names { // Corrected loop syntax
-			parts[i] = fmt.Sprintf("%s float64", v) // Use sanitized name
+			if _, isSample := samples[v]; isSample {
+				if g.complex && vectors[v] {
+					parts[i] = fmt.Sprintf("%s []complex128", v)
+					continue
+				}
+				parts[i] = fmt.Sprintf("%s []float64", v)
+				continue
+			}
+			if propositions[v] > 0 {
+				parts[i] = fmt.Sprintf("%s %s", v, strings.Replace(paramType, "float64", "bool", 1))
+				continue
+			}
+			parts[i] = fmt.Sprintf("%s %s", v, paramType) // Use sanitized name
 		}
 		params = strings.Join(parts, ", ")
 	}
+	// The validator takes the arguments of the computation, without its context
+	validatorParams := params
+	if g.cancellable {
+		if slices.Contains(names, "ctx") {
+			return "", fmt.Errorf("a variable named ctx clashes with the context parameter")
+		}
+		params = strings.TrimSuffix("ctx context.Context, "+params, ", ")
+	}
+
+	// Conditions (relational/logical expressions) produce a bool-returning function
+	// and matrix environments a [][]float64-returning one
+	returnType := "float64"
+	if g.complex {
+		// Results of \Re, \Im, \arg and |z| stay real; anything else is complex
+		if complexResult.kind != realValue {
+			returnType = "complex128"
+		}
+	} else if g.isBooleanExpr(root) {
+		returnType = "bool"
+	} else if _, ok := root.(*ast.MatrixExpr); ok {
+		returnType = "[][]float64"
+	} else if rootIsGradient || rootIsVector {
+		returnType = "[]float64"
+	}
+
+	if g.intermediates && (returnType != "float64" || g.vectorize || g.checkOverflow || g.trace || lowerBody != "") {
+		return "", fmt.Errorf("intermediate results require a float64 result and are not supported with \\pm or in vectorized, overflow-checked or traced mode")
+	}
 
 	// Assemble the function body
-	var funcBody string
-	if _, ok := root.(*ast.SumExpr); ok {
+	var stmts string
+	switch {
+	case g.vectorize:
+		if _, ok := root.(*ast.MatrixExpr); ok {
+			return "", fmt.Errorf("cannot vectorize a matrix expression")
+		}
+		stmts = vectorizedBody(funcName, names, returnType, codeBody)
+		returnType = "[]" + returnType
+	case g.checkOverflow:
+		if returnType != "float64" || g.complex || rootIsGradient {
+			return "", fmt.Errorf("overflow checks require a float64 result")
+		}
+		stmts = g.overflowCheckedBody(funcName, append(slices.Clone(names), assignedNames(assignments)...), codeBody)
+		returnType = "(float64, error)"
+	case g.trace:
+		stmts, err = g.tracedBody(funcName, root, codeBody, append(slices.Clone(names), assignedNames(assignments)...))
+		if err != nil {
+			return "", err
+		}
+	case g.intermediates:
+		stmts, err = g.intermediatesBody(assignments, codeBody)
+		if err != nil {
+			return "", err
+		}
+		returnType = "map[string]float64"
+	case lowerBody != "":
+		if returnType != "float64" {
+			return "", fmt.Errorf("\\pm requires a numeric result")
+		}
+		upper, lower := unusedName("plus", names), unusedName("minus", names)
+		returnType = fmt.Sprintf("(%s, %s float64)", upper, lower)
+		stmts = fmt.Sprintf("return %s, %s", codeBody, lowerBody)
+	case rootIsLoop:
 		// For SumExpr, the generateExpr already returns the full loop and return statement
-		indented := indent(codeBody, "\t")
-		funcBody = fmt.Sprintf("func %s(%s) float64 {\n%s\n}", funcName, params, indented)
-	} else {
+		stmts = codeBody
+	case rootIsVector:
+		stmts = vectorBody(funcName, vectorParams, unitVectors, norms, vectorIndex, codeBody, names)
+	case rootIsGradient:
+		if len(names) == 0 || g.isBooleanExpr(gradient.Body) || len(samples) > 0 {
+			return "", fmt.Errorf("\\nabla requires a scalar expression of one or more variables")
+		}
+		stmts = gradientBody(names, codeBody)
+	case isSwitchCases(root):
+		// Cases keyed on integer values of one variable are a plain switch statement
+		pw := root.(*ast.PiecewiseExpr)
+		key, _ := switchKey(pw)
+		stmts, _, err = g.switchStatement(pw, key)
+		if err != nil {
+			return "", err
+		}
+	case isTwoBranchCases(root):
+		// "a if condition, otherwise b" as the whole equation is a plain if statement
+		stmts, err = g.twoBranchBody(root.(*ast.PiecewiseExpr))
+		if err != nil {
+			return "", err
+		}
+	default:
 		// For simple expressions, add the return statement
-		funcBody = fmt.Sprintf("func %s(%s) float64 {\n\treturn %s\n}", funcName, params, codeBody)
+		stmts = "return " + codeBody
+	}
+	if g.cancellable {
+		if g.checkOverflow || lowerBody != "" {
+			return "", fmt.Errorf("a context is not supported with \\pm or in overflow-checked mode")
+		}
+		stmts, returnType = contextBody(names, stmts, returnType)
+	}
+	if g.trace {
+		for i, a := range assignments {
+			name := sanitizeVariableName(a.Name)
+			decls[i] += "\n" + traceStatement(funcName, name, name)
+		}
+	}
+	decls = slices.DeleteFunc(decls, func(decl string) bool { return decl == "" })
+	if len(decls) > 0 {
+		stmts = strings.Join(decls, "\n") + "\n" + stmts
+	}
+
+	typeParams := ""
+	if g.generic {
+		hasGenerics, err := g.goVersionAtLeast(1, 18)
+		if err != nil {
+			return "", err
+		}
+		if !hasGenerics && g.goVersion == "" {
+			return "", fmt.Errorf("generic functions require a targeted Go version of 1.18 or later")
+		}
+		if !hasGenerics {
+			return "", fmt.Errorf("generic functions require a targeted Go version of 1.18 or later, got %s", g.goVersion)
+		}
+		if returnType != "float64" || g.complex || g.vectorize || g.cancellable || len(samples) > 0 || len(propositions) > 0 {
+			return "", fmt.Errorf("generic functions require float64 parameters and a float64 result")
+		}
+		typeParam := unusedName("T", names)
+		typeParams = fmt.Sprintf("[%s ~float32 | ~float64]", typeParam)
+		stmts = genericBody(names, typeParam, stmts)
+		params = strings.ReplaceAll(params, " float64", " "+typeParam)
+		returnType = typeParam
+	}
+
+	var funcBody string
+	if g.closure {
+		// Closure mode: funcName binds the parameters and returns a func() over them
+		funcBody = fmt.Sprintf("func %s%s(%s) func() %s {\n\treturn func() %s {\n%s\n\t}\n}",
+			funcName, typeParams, params, returnType, returnType, indent(stmts, "\t\t"))
+	} else {
+		funcBody = fmt.Sprintf("func %s%s(%s) %s {\n%s\n}", funcName, typeParams, params, returnType, indent(stmts, "\t"))
+	}
+	if g.domainNotes {
+		funcBody = g.domain.docComment(names) + funcBody
+	}
+	if g.validator {
+		if g.complex || g.vectorize || g.generic {
+			return "", fmt.Errorf("validation functions are not supported in complex, vectorized or generic mode")
+		}
+		funcBody += "\n\n" + g.domain.validator(funcName, validatorParams, names)
+	}
+
+	var helpers string
+	if g.noMathImport {
+		funcBody, helpers, err = replaceMathCalls(funcBody)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The packages imported are those the final code uses, whatever its parts
+	// reported: a custom node generator may claim math without calling it, and the
+	// statements around the expression may call it unannounced
+	needsMath = usesPackage(funcBody, "math", needsMath)
+	needsCmplx = usesPackage(funcBody, "cmplx", needsCmplx)
+	var imports []string
+	if g.cancellable {
+		imports = append(imports, "\"context\"")
+	}
+	if g.checkOverflow {
+		imports = append(imports, "\"errors\"")
+	}
+	if g.trace || usesPackage(funcBody, "fmt", g.validator) {
+		imports = append(imports, "\"fmt\"")
+	}
+	if needsMath {
+		imports = append(imports, "\"math\"")
+	}
+	if needsCmplx {
+		imports = append(imports, "\"math/cmplx\"")
+	}
+	if g.trace {
+		imports = append(imports, "\"os\"")
+	}
+
+	var header string
+	switch len(imports) {
+	case 0:
+		header = fmt.Sprintf("package %s\n\n", pkgName)
+	case 1:
+		header = fmt.Sprintf("package %s\n\nimport %s\n\n", pkgName, imports[0])
+	default:
+		header = fmt.Sprintf("package %s\n\nimport (\n\t%s\n)\n\n", pkgName, strings.Join(imports, "\n\t"))
 	}
 
 	src := header + funcBody
+	if withHelpers {
+		src += helpers
+	}
+	return src, nil
+}
+
+// usesPackage reports whether the Go code of a function refers to the package
+// imported as name, as in math.Sqrt(x). For code that does not parse, it returns
+// reported, what the generation of the code said.
+func usesPackage(funcCode, name string, reported bool) bool {
+	file, err := goparser.ParseFile(token.NewFileSet(), "", "package p\n\n"+funcCode, 0)
+	if err != nil {
+		return reported
+	}
+	uses := false
+	goast.Inspect(file, func(n goast.Node) bool {
+		if sel, ok := n.(*goast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*goast.Ident); ok && pkg.Name == name {
+				uses = true
+			}
+		}
+		return !uses
+	})
+	return uses
+}
 
-	// Format with go/format
+// formatSource formats src with go/format, then indents it as configured.
+func (g *Generator) formatSource(src string) (string, error) {
 	formatted, err := format.Source([]byte(src))
 	if err != nil {
 		// If formatting fails, return the unformatted source and the error for debugging
 		return src, fmt.Errorf("failed to format generated code: %w\nSource:\n%s", err, src)
 	}
+	if g.indent < 0 {
+		return "", fmt.Errorf("invalid indent %d: expected 0 for tabs or a number of spaces", g.indent)
+	}
+	if g.indent > 0 {
+		return indentWithSpaces(string(formatted), g.indent), nil
+	}
 	return string(formatted), nil
 }
 
+// indentWithSpaces replaces the leading tabs of each line of src with spaces
+// per tab. Tabs after the first non-tab character, as in aligned comments, are kept.
+func indentWithSpaces(src string, spaces int) string {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		body := strings.TrimLeft(line, "\t")
+		lines[i] = strings.Repeat(" ", spaces*(len(line)-len(body))) + body
+	}
+	return strings.Join(lines, "\n")
+}
+
+// vectorizedBody renders the statements of a vectorized function: the parameter slices
+// must have the same length, and inside the loop each parameter name is shadowed by its
+// i-th element so that exprCode evaluates unchanged.
+func vectorizedBody(funcName string, names []string, elemType, exprCode string) string {
+	index, out := unusedName("i", names), unusedName("out", names)
+	var lines []string
+	for _, name := range names[1:] {
+		lines = append(lines,
+			fmt.Sprintf("if len(%s) != len(%s) {", name, names[0]),
+			fmt.Sprintf("\tpanic(\"%s: %s and %s have different lengths\")", funcName, name, names[0]),
+			"}")
+	}
+	elems := make([]string, len(names))
+	for i, name := range names {
+		elems[i] = fmt.Sprintf("%s[%s]", name, index)
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s := make([]%s, len(%s))", out, elemType, names[0]),
+		fmt.Sprintf("for %s := range %s {", index, out),
+		fmt.Sprintf("\t%s := %s", strings.Join(names, ", "), strings.Join(elems, ", ")),
+		fmt.Sprintf("\t%s[%s] = %s", out, index, exprCode),
+		"}",
+		"return "+out)
+	return strings.Join(lines, "\n")
+}
+
+// intermediatesBody renders the statements of a function returning the values of
+// the assignments and of exprCode in a map, keyed by the Go names of the assignments
+// and "result".
+func (g *Generator) intermediatesBody(assignments []*ast.AssignmentExpr, exprCode string) (string, error) {
+	entries := make([]string, 0, len(assignments)+1)
+	for _, a := range assignments {
+		name := sanitizeVariableName(a.Name)
+		if name == "result" {
+			return "", fmt.Errorf("an assignment named result clashes with the key of the final value")
+		}
+		if g.isBooleanExpr(a.Value) {
+			return "", fmt.Errorf("intermediate results must be numbers, but %s is a condition", a.Name)
+		}
+		entries = append(entries, fmt.Sprintf("%q: %s,", name, name))
+	}
+	entries = append(entries, fmt.Sprintf("\"result\": %s,", exprCode))
+	return "return map[string]float64{\n" + indent(strings.Join(entries, "\n"), "\t") + "\n}", nil
+}
+
+// genericBody turns the statements of a function of float64 parameters returning
+// a float64 into those of one generic over typeParam: they run in a closure taking
+// the parameters converted to float64, whose result is converted back.
+func genericBody(names []string, typeParam, stmts string) string {
+	conversions := make([]string, len(names))
+	for i, name := range names {
+		conversions[i] = fmt.Sprintf("float64(%s)", name)
+	}
+	params := ""
+	if len(names) > 0 {
+		params = strings.Join(names, ", ") + " float64"
+	}
+	return fmt.Sprintf("return %s(func(%s) float64 {\n%s\n}(%s))",
+		typeParam, params, indent(stmts, "\t"), strings.Join(conversions, ", "))
+}
+
+// contextBody turns the statements of a function returning returnType into those
+// of one also returning ctx.Err(), with the zero value once ctx is canceled, and
+// returns them with the new result types.
+func contextBody(names []string, stmts, returnType string) (string, string) {
+	result := unusedName("result", names)
+	value, ok := strings.CutPrefix(stmts, "return ")
+	if !ok {
+		// Statements other than a single return run in a closure
+		value = fmt.Sprintf("func() %s {\n%s\n}()", returnType, indent(stmts, "\t"))
+	}
+	zero := "nil" // Slices
+	switch returnType {
+	case "float64", "complex128":
+		zero = "0"
+	case "bool":
+		zero = "false"
+	}
+	return strings.Join([]string{
+		result + " := " + value,
+		"if err := ctx.Err(); err != nil {",
+		"\treturn " + zero + ", err",
+		"}",
+		"return " + result + ", nil",
+	}, "\n"), fmt.Sprintf("(%s, error)", returnType)
+}
+
+// overflowCheckedBody renders the statements of a function returning exprCode and
+// an error when that value is infinite, or with numeric guards also when it is NaN.
+// The argument of a logarithm reading only names, the parameters and assignments, is
+// checked first, failing when it is not positive; one reading a bound variable, such
+// as the index of a sum, cannot be checked ahead and gives NaN.
+func (g *Generator) overflowCheckedBody(funcName string, names []string, exprCode string) string {
+	result := unusedName("result", names)
+	var lines []string
+	for _, note := range g.domain.notes {
+		if note.constraint == positive && readsOnly(note.operand, names) {
+			lines = append(lines,
+				fmt.Sprintf("if %s <= 0 {", note.operand),
+				fmt.Sprintf("\treturn 0, errors.New(\"%s: log of non-positive value\")", funcName),
+				"}",
+			)
+		}
+	}
+	lines = append(lines,
+		result+" := "+exprCode,
+		fmt.Sprintf("if math.IsInf(%s, 0) {", result),
+		fmt.Sprintf("\treturn 0, errors.New(\"%s: result overflows float64\")", funcName),
+		"}",
+	)
+	if g.guardNumerics {
+		lines = append(lines,
+			fmt.Sprintf("if math.IsNaN(%s) {", result),
+			fmt.Sprintf("\treturn 0, errors.New(\"%s: result is NaN\")", funcName),
+			"}",
+		)
+	}
+	return strings.Join(append(lines, "return "+result+", nil"), "\n")
+}
+
+// isTwoBranchCases reports whether e is a piecewise definition with one conditional
+// case followed by a default one.
+func isTwoBranchCases(e ast.Expr) bool {
+	pw, ok := e.(*ast.PiecewiseExpr)
+	return ok && len(pw.Cases) == 2 && pw.Cases[0].Condition != nil && pw.Cases[1].Condition == nil
+}
+
+// twoBranchBody renders the statements of a function returning a two-branch
+// piecewise definition: if the condition holds, the first value, otherwise the second.
+func (g *Generator) twoBranchBody(pw *ast.PiecewiseExpr) (string, error) {
+	conditionCode, _, err := g.generateExpr(pw.Cases[0].Condition)
+	if err != nil {
+		return "", err
+	}
+	thenCode, _, err := g.guardedGenerator().generateExpr(pw.Cases[0].Value)
+	if err != nil {
+		return "", err
+	}
+	elseCode, _, err := g.guardedGenerator().generateExpr(pw.Cases[1].Value)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("if %s {", conditionCode),
+		"\treturn " + thenCode,
+		"}",
+		"return " + elseCode,
+	}, "\n"), nil
+}
+
+// isSwitchCases reports whether e is a piecewise definition generated as a switch.
+func isSwitchCases(e ast.Expr) bool {
+	pw, ok := e.(*ast.PiecewiseExpr)
+	if !ok {
+		return false
+	}
+	_, ok = switchKey(pw)
+	return ok
+}
+
+// switchKey returns the variable of a piecewise definition whose conditions all
+// compare it to distinct integers, as in n = 0, n = 1, ..., optionally followed by
+// a default case. At least two conditions are needed, since one is a plain if.
+func switchKey(pw *ast.PiecewiseExpr) (string, bool) {
+	key := ""
+	seen := make(map[float64]bool)
+	for i, c := range pw.Cases {
+		if c.Condition == nil {
+			if i != len(pw.Cases)-1 {
+				return "", false
+			}
+			continue
+		}
+		name, value, ok := integerEquality(c.Condition)
+		if !ok || (key != "" && name != key) || seen[value] {
+			return "", false
+		}
+		key = name
+		seen[value] = true
+	}
+	return key, len(seen) >= 2
+}
+
+// integerEquality matches the condition v = k, or k = v, of a variable v and an
+// integer k.
+func integerEquality(cond ast.Expr) (string, float64, bool) {
+	eq, ok := cond.(*ast.BinaryExpr)
+	if !ok || eq.Op != "==" {
+		return "", 0, false
+	}
+	v, isVar := eq.Left.(*ast.Variable)
+	k, isNum := eq.Right.(*ast.NumberLiteral)
+	if !isVar || !isNum {
+		v, isVar = eq.Right.(*ast.Variable)
+		k, isNum = eq.Left.(*ast.NumberLiteral)
+	}
+	if !isVar || !isNum || k.Value != math.Trunc(k.Value) {
+		return "", 0, false
+	}
+	return v.Name, k.Value, true
+}
+
+// switchStatement renders a piecewise definition keyed on the variable key as a
+// switch statement returning the value of the matching case. Without a default
+// case, the default returns NaN, as for an if chain.
+func (g *Generator) switchStatement(pw *ast.PiecewiseExpr, key string) (string, bool, error) {
+	needsMath := false
+	lines := []string{fmt.Sprintf("switch %s {", sanitizeVariableName(key))}
+	for _, c := range pw.Cases {
+		valueCode, valueNeedsMath, err := g.guardedGenerator().generateExpr(c.Value)
+		if err != nil {
+			return "", false, err
+		}
+		needsMath = needsMath || valueNeedsMath
+		if c.Condition == nil {
+			lines = append(lines, "default:")
+		} else {
+			_, value, _ := integerEquality(c.Condition)
+			lines = append(lines, fmt.Sprintf("case %s:", strconv.FormatFloat(value, 'f', -1, 64)))
+		}
+		lines = append(lines, "\treturn "+valueCode)
+	}
+	if pw.Cases[len(pw.Cases)-1].Condition != nil {
+		lines = append(lines, "default:", "\treturn math.NaN()")
+		needsMath = true
+	}
+	return strings.Join(append(lines, "}"), "\n"), needsMath, nil
+}
+
+// tracedBody renders the statements of a traced function returning exprCode, the
+// code of root. The operands of a top-level arithmetic operation are computed and
+// printed first, then the result; taken lists the names in scope.
+func (g *Generator) tracedBody(funcName string, root ast.Expr, exprCode string, taken []string) (string, error) {
+	result := unusedName("result", taken)
+	var lines []string
+	if bin, ok := root.(*ast.BinaryExpr); ok && strings.Contains("+-*/", bin.Op) && !isNegation(bin) {
+		left, right := unusedName("left", taken), unusedName("right", taken)
+		for _, operand := range []struct {
+			name string
+			expr ast.Expr
+		}{{left, bin.Left}, {right, bin.Right}} {
+			code, _, err := g.generateExpr(operand.expr)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, operand.name+" := "+code, traceStatement(funcName, code, operand.name))
+		}
+		exprCode = fmt.Sprintf("%s %s %s", left, bin.Op, right)
+	}
+	lines = append(lines,
+		result+" := "+exprCode,
+		traceStatement(funcName, "result", result),
+		"return "+result)
+	return strings.Join(lines, "\n"), nil
+}
+
+// traceStatement renders a statement printing "funcName: label = value" to stderr.
+// Multi-line code, like the closure of a nested sum, is labeled with name instead.
+func traceStatement(funcName, label, name string) string {
+	if strings.Contains(label, "\n") {
+		label = name
+	}
+	format := fmt.Sprintf("%s: %s = %%v\n", funcName, strings.ReplaceAll(label, "%", "%%"))
+	return fmt.Sprintf("fmt.Fprintf(os.Stderr, %s, %s)", strconv.Quote(format), name)
+}
+
+// assignedNames returns the Go names bound by assignments.
+func assignedNames(assignments []*ast.AssignmentExpr) []string {
+	names := make([]string, len(assignments))
+	for i, a := range assignments {
+		names[i] = sanitizeVariableName(a.Name)
+	}
+	return names
+}
+
+// gradientBody renders the statements of a function returning the gradient of the
+// expression bodyCode: one partial derivative per parameter, in parameter order, each
+// approximated by the central difference (f(v+h) - f(v-h)) / 2h, where the closures
+// shadow the parameter v with the shifted point. The step h is renamed if it is a parameter.
+func gradientBody(names []string, bodyCode string) string {
+	h := unusedName("h", names)
+	lines := []string{
+		"// Numerical gradient using central differences",
+		fmt.Sprintf("%s := 0.0001 // Small step size", h),
+		"return []float64{",
+	}
+	for _, v := range names {
+		lines = append(lines,
+			fmt.Sprintf("\t// ∂/∂%s", v),
+			fmt.Sprintf("\t(func() float64 { %s := %s + %s; return %s }() - func() float64 { %s := %s - %s; return %s }()) / (2 * %s),",
+				v, v, h, bodyCode, v, v, h, bodyCode, h))
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n")
+}
+
+// unusedName returns name, suffixed with underscores until it is not one of taken.
+func unusedName(name string, taken []string) string {
+	for slices.Contains(taken, name) {
+		name += "_"
+	}
+	return name
+}
+
+// generateMinMax renders \min or \max over its arguments, using the builtins
+// from Go 1.21 on and nested math.Min/math.Max calls otherwise.
+func (g *Generator) generateMinMax(call *ast.FuncCall) (string, bool, error) {
+	if len(call.Args) < 2 {
+		return "", false, fmt.Errorf("\\%s requires at least 2 arguments, got %d", call.FuncName, len(call.Args))
+	}
+	needsMath := false
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		argCode, argNeedsMath, err := g.generateExpr(arg)
+		if err != nil {
+			return "", false, err
+		}
+		args[i] = argCode
+		needsMath = needsMath || argNeedsMath
+	}
+
+	hasBuiltins, err := g.goVersionAtLeast(1, 21)
+	if err != nil {
+		return "", false, err
+	}
+	if hasBuiltins {
+		return fmt.Sprintf("%s(%s)", call.FuncName, strings.Join(args, ", ")), needsMath, nil
+	}
+
+	// math.Min/math.Max take two arguments, so fold the remaining ones from the right
+	mathFunc := "math." + cases.Title(language.English).String(call.FuncName)
+	code := args[len(args)-1]
+	for i := len(args) - 2; i >= 0; i-- {
+		code = fmt.Sprintf("%s(%s, %s)", mathFunc, args[i], code)
+	}
+	return code, true, nil
+}
+
+// generateGcd renders \gcd(a, b, ...) as an inline function applying Euclid's
+// algorithm to each argument in turn. The arguments are expected to be integers;
+// their signs are ignored, and the gcd of zeros is 0.
+func (g *Generator) generateGcd(call *ast.FuncCall) (string, bool, error) {
+	if len(call.Args) < 2 {
+		return "", false, fmt.Errorf("\\gcd requires at least 2 arguments, got %d", len(call.Args))
+	}
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		argCode, _, err := g.generateExpr(arg)
+		if err != nil {
+			return "", false, err
+		}
+		args[i] = argCode
+	}
+	code := []string{
+		"func(values ...float64) float64 {",
+		"    gcd := 0.0",
+		"    for _, v := range values {",
+		"        a, b := gcd, math.Abs(v)",
+		"        for b != 0 {",
+		"            a, b = b, math.Mod(a, b)",
+		"        }",
+		"        gcd = a",
+		"    }",
+		"    return gcd",
+		fmt.Sprintf("}(%s)", strings.Join(args, ", ")),
+	}
+	return strings.Join(code, "\n"), true, nil
+}
+
+// goVersionAtLeast reports whether the targeted Go version is at least major.minor.
+// An unset version targets any Go 1 release and so only satisfies 1.0.
+func (g *Generator) goVersionAtLeast(major, minor int) (bool, error) {
+	if g.goVersion == "" {
+		return major < 1 || (major == 1 && minor == 0), nil
+	}
+	var targetMajor, targetMinor int
+	version := strings.TrimPrefix(g.goVersion, "go")
+	if _, err := fmt.Sscanf(version, "%d.%d", &targetMajor, &targetMinor); err != nil {
+		return false, fmt.Errorf("invalid Go version %q: expected a version like 1.21", g.goVersion)
+	}
+	if targetMajor != major {
+		return targetMajor > major, nil
+	}
+	return targetMinor >= minor, nil
+}
+
+// sampleVariable returns the sanitized name of the random variable of an
+// expectation or variance call, e.g. X for \mathbb{E}[X].
+func sampleVariable(call *ast.FuncCall) (string, bool) {
+	if (call.FuncName != "E" && call.FuncName != "Var") || len(call.Args) != 1 {
+		return "", false
+	}
+	v, ok := call.Args[0].(*ast.Variable)
+	if !ok {
+		return "", false
+	}
+	return sanitizeVariableName(v.Name), true
+}
+
+// generateSampleStatistic generates the expectation (sample mean) or the
+// population variance of a random variable, represented by a []float64 of samples.
+func (g *Generator) generateSampleStatistic(call *ast.FuncCall) (string, bool, error) {
+	samples, ok := sampleVariable(call)
+	if !ok {
+		return "", false, fmt.Errorf("%s requires a single random variable name as argument", call.FuncName)
+	}
+
+	statCode := []string{
+		"func() float64 {",
+		"    mean := 0.0",
+		fmt.Sprintf("    for _, xi := range %s {", samples),
+		"        mean += xi",
+		"    }",
+		fmt.Sprintf("    mean /= float64(len(%s))", samples),
+	}
+	if call.FuncName == "E" {
+		statCode = append(statCode, "    return mean")
+	} else {
+		statCode = append(statCode,
+			"    sumSq := 0.0",
+			fmt.Sprintf("    for _, xi := range %s {", samples),
+			"        sumSq += (xi - mean) * (xi - mean)",
+			"    }",
+			fmt.Sprintf("    return sumSq / float64(len(%s))", samples),
+		)
+	}
+	statCode = append(statCode, "}()")
+	return strings.Join(statCode, "\n"), false, nil
+}
+
+// generateNorm generates the p-norm of a vector, represented by a []float64.
+func generateNorm(norm *ast.NormExpr) string {
+	vector := sanitizeVariableName(norm.Vector)
+	total := unusedName("norm", []string{vector})
+	var accumulate, result string
+	switch {
+	case math.IsInf(norm.Order, 1):
+		accumulate = fmt.Sprintf("%s = math.Max(%s, math.Abs(vi))", total, total)
+		result = total
+	case norm.Order == 1:
+		accumulate, result = total+" += math.Abs(vi)", total
+	case norm.Order == 2:
+		accumulate, result = total+" += vi * vi", "math.Sqrt("+total+")"
+	default:
+		accumulate = fmt.Sprintf("%s += math.Pow(math.Abs(vi), %g)", total, norm.Order)
+		result = fmt.Sprintf("math.Pow(%s, 1.0/%g)", total, norm.Order)
+	}
+	return strings.Join([]string{
+		"func() float64 {",
+		"    " + total + " := 0.0",
+		fmt.Sprintf("    for _, vi := range %s {", vector),
+		"        " + accumulate,
+		"    }",
+		"    return " + result,
+		"}()",
+	}, "\n")
+}
+
+// generateInnerProduct generates the inner product of two vectors, represented by
+// slices of the same length: []float64, or []complex128 with conjugate set, in which
+// case the elements of the bra are conjugated.
+func generateInnerProduct(product *ast.InnerProductExpr, conjugate bool) string {
+	bra, ket := sanitizeVariableName(product.Bra), sanitizeVariableName(product.Ket)
+	total := unusedName("dot", []string{bra, ket})
+	index := unusedName("i", []string{bra, ket})
+	resultType, zero, braElem := "float64", "0.0", bra+"["+index+"]"
+	if conjugate {
+		resultType, zero, braElem = "complex128", "complex128(0)", "cmplx.Conj("+braElem+")"
+	}
+	return strings.Join([]string{
+		"func() " + resultType + " {",
+		"    " + total + " := " + zero,
+		fmt.Sprintf("    for %s := range %s {", index, bra),
+		fmt.Sprintf("        %s += %s * %s[%s]", total, braElem, ket, index),
+		"    }",
+		"    return " + total,
+		"}()",
+	}, "\n")
+}
+
+// errLoneKet reports a ket or bra outside of an inner product, as results are scalars.
+func errLoneKet(ket *ast.KetExpr) error {
+	if ket.IsBra {
+		return fmt.Errorf("\\bra{%s} is only supported before a ket, as in \\bra{%s}\\ket{b}", ket.Vector, ket.Vector)
+	}
+	return fmt.Errorf("\\ket{%s} is only supported in an inner product, as in \\braket{a|%s}", ket.Vector, ket.Vector)
+}
+
+// errConditionalExpectation reports a conditional expectation, which is parsed but
+// not generated: given a random variable, it is a function of that variable rather
+// than a number.
+var errConditionalExpectation = errors.New("the conditional expectation \\langle X \\mid Y \\rangle is not supported: only the expectation \\langle X \\rangle of a slice of samples is")
+
+// goPrecedence returns the Go operator precedence of a binary operator.
+// Higher values bind more tightly; unknown operators are treated as atomic.
+func goPrecedence(op string) int {
+	switch op {
+	case "||", "⟹": // p ⟹ q is generated as !p || q
+		return 1
+	case "&&":
+		return 2
+	case "==", "!=", "<", "<=", ">", ">=", "⟺": // p ⟺ q is generated as p == q
+		return 3
+	case "+", "-", "±", "∓":
+		return 4
+	case "*", "/":
+		return 5
+	default:
+		return 6
+	}
+}
+
+// operandPrecedence returns the Go precedence of the operator at the root of
+// the code generated for e, and false when that code is a single operand.
+func (g *Generator) operandPrecedence(e ast.Expr) (int, bool) {
+	switch n := e.(type) {
+	case *ast.BinaryExpr:
+		if isNegation(n) {
+			return 0, false
+		}
+		if exp, ok := smallIntegerExponent(n); ok {
+			// Powers expanded into x * x * ... bind like a product
+			return goPrecedence("*"), exp >= 2
+		}
+		return goPrecedence(n.Op), true
+	case *ast.RelationalChain:
+		if !g.clampChains {
+			return goPrecedence("&&"), true
+		}
+	case *ast.CongruenceExpr:
+		return goPrecedence("=="), true
+	case *ast.UnitExpr:
+		return g.operandPrecedence(n.Value)
+	}
+	return 0, false
+}
+
+// isNegation reports whether a product is the parser's -1 * x form of a unary minus.
+func isNegation(product *ast.BinaryExpr) bool {
+	lit, ok := product.Left.(*ast.NumberLiteral)
+	return ok && product.Op == "*" && lit.Value == -1
+}
+
+// formatLiteral renders the number v, with a .0 suffix if it is an integer and
+// float is set, so that Go reads it as a floating-point constant.
+func formatLiteral(v float64, float bool) string {
+	code := fmt.Sprintf("%g", v)
+	if float && !strings.ContainsAny(code, ".eIN") { // %g writes large numbers with an exponent, already floats
+		code += ".0"
+	}
+	return code
+}
+
+// integerConstant reports whether the code of e is a Go constant expression of
+// untyped integers, such as 2 * 3, or (1 + 2)^2 expanded into (1 + 2) * (1 + 2).
+func integerConstant(e ast.Expr) bool {
+	switch n := e.(type) {
+	case *ast.NumberLiteral:
+		return !strings.ContainsAny(formatLiteral(n.Value, false), ".eIN")
+	case *ast.BinaryExpr:
+		if _, ok := smallIntegerExponent(n); ok {
+			return integerConstant(n.Left)
+		}
+		return (n.Op == "+" || n.Op == "-" || n.Op == "*") && integerConstant(n.Left) && integerConstant(n.Right)
+	}
+	return false
+}
+
+// dividendGenerator returns the generator of the left operand of op: one writing
+// its integer literals as floats if op divides two integer constants, which Go
+// would otherwise truncate to an integer, and g itself otherwise.
+func (g *Generator) dividendGenerator(op *ast.BinaryExpr) *Generator {
+	if op.Op != "/" || !integerConstant(op.Left) || !integerConstant(op.Right) {
+		return g
+	}
+	floating := *g
+	floating.floatLiterals = true
+	return &floating
+}
+
+// guardedGenerator returns a copy of g for code only evaluated under a condition.
+func (g *Generator) guardedGenerator() *Generator {
+	guarded := *g
+	guarded.guarded = true
+	return &guarded
+}
+
+// maxExpandedExponent is the largest integer exponent expanded into repeated multiplication.
+const maxExpandedExponent = 8
+
+// smallIntegerExponent reports whether a power has a literal integer exponent in
+// [0, maxExpandedExponent], which is generated as repeated multiplication rather than math.Pow.
+// The base's code is repeated, so it must be cheap to evaluate again.
+func smallIntegerExponent(power *ast.BinaryExpr) (int, bool) {
+	if power.Op != "^" || !isCheapBase(power.Left) {
+		return 0, false
+	}
+	// A literal base such as 10^8 is a constant either way and reads best as written
+	if _, ok := power.Left.(*ast.NumberLiteral); ok {
+		return 0, false
+	}
+	lit, ok := power.Right.(*ast.NumberLiteral)
+	if !ok || lit.Value != math.Trunc(lit.Value) || lit.Value < 0 || lit.Value > maxExpandedExponent {
+		return 0, false
+	}
+	return int(lit.Value), true
+}
+
+// isCheapBase reports whether e is plain arithmetic on variables, constants and
+// sequence elements, whose code may be repeated in x * x * ... A call, or a sum or
+// integral running a loop, would be evaluated once per factor.
+func isCheapBase(e ast.Expr) bool {
+	switch n := e.(type) {
+	case *ast.Variable, *ast.ConstantExpr, *ast.NumberLiteral:
+		return true
+	case *ast.IndexExpr:
+		return isCheapBase(n.Index)
+	case *ast.UnitExpr:
+		return isCheapBase(n.Value)
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case "+", "-", "*", "/", "^":
+			return isCheapBase(n.Left) && isCheapBase(n.Right)
+		}
+	case *ast.FuncCall:
+		return n.FuncName == "frac" && len(n.Args) == 2 && isCheapBase(n.Args[0]) && isCheapBase(n.Args[1])
+	}
+	return false
+}
+
+// repeatedMultiplication renders base^n as base * base * ... (n factors); base^0 is 1.
+func repeatedMultiplication(base ast.Expr, baseCode string, n int) string {
+	if n == 0 {
+		return "1"
+	}
+	// Only plain operands may be repeated without parentheses
+	atomic := false
+	switch b := base.(type) {
+	case *ast.Variable, *ast.ConstantExpr:
+		atomic = true
+	case *ast.NumberLiteral:
+		atomic = b.Value >= 0
+	case *ast.FuncCall:
+		atomic = b.FuncName != "frac"
+	}
+	if !atomic {
+		baseCode = "(" + baseCode + ")"
+	}
+	factors := make([]string, n)
+	for i := range factors {
+		factors[i] = baseCode
+	}
+	return strings.Join(factors, " * ")
+}
+
+// generateClamp renders a chain lo \le x \le hi (or hi \ge x \ge lo) as
+// math.Max(lo, math.Min(hi, x)).
+func (g *Generator) generateClamp(chain *ast.RelationalChain) (string, bool, error) {
+	ascending := len(chain.Ops) == 2 &&
+		(chain.Ops[0] == "<" || chain.Ops[0] == "<=") && (chain.Ops[1] == "<" || chain.Ops[1] == "<=")
+	descending := len(chain.Ops) == 2 &&
+		(chain.Ops[0] == ">" || chain.Ops[0] == ">=") && (chain.Ops[1] == ">" || chain.Ops[1] == ">=")
+	if !ascending && !descending {
+		return "", false, fmt.Errorf("clamping requires a chain of the form lo \\le x \\le hi, got %s", strings.Join(chain.Ops, " "))
+	}
+
+	lo, x, hi := chain.Operands[0], chain.Operands[1], chain.Operands[2]
+	if descending {
+		lo, hi = hi, lo
+	}
+	loCode, _, err := g.generateExpr(lo)
+	if err != nil {
+		return "", false, err
+	}
+	xCode, _, err := g.generateExpr(x)
+	if err != nil {
+		return "", false, err
+	}
+	hiCode, _, err := g.generateExpr(hi)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("math.Max(%s, math.Min(%s, %s))", loCode, hiCode, xCode), true, nil
+}
+
+// isConnective reports whether op is a logical connective, whose operands are bools.
+func isConnective(op string) bool {
+	switch op {
+	case "&&", "||", "⟹", "⟺":
+		return true
+	}
+	return false
+}
+
+// isBooleanExpr reports whether e evaluates to a bool (a comparison or logical connective).
+func (g *Generator) isBooleanExpr(e ast.Expr) bool {
+	switch n := e.(type) {
+	case *ast.RelationalChain:
+		return !g.clampChains
+	case *ast.BinaryExpr:
+		prec := goPrecedence(n.Op)
+		return prec >= 1 && prec <= 3
+	case *ast.UnaryExpr:
+		return n.Op == "!"
+	case *ast.CongruenceExpr:
+		return true
+	}
+	return false
+}
+
 // indent prefixes each line of s with prefix.
 func indent(s, prefix string) string {
 	lines := strings.Split(s, "\n")
@@ -410,6 +2747,23 @@ func indent(s, prefix string) string {
 	return strings.Join(lines, "\n")
 }
 
+// mathConstants maps constant command names to their Go expressions.
+var mathConstants = map[string]string{
+	"pi":    "math.Pi",
+	"infty": "math.Inf(1)",
+}
+
+// mathFuncNames maps the logarithms and the inverse tangent and hyperbolic functions
+// to their math package names, which are not their titled LaTeX names.
+var mathFuncNames = map[string]string{
+	"ln":      "Log",
+	"log":     "Log",
+	"arctan":  "Atan",
+	"arcsinh": "Asinh",
+	"arccosh": "Acosh",
+	"arctanh": "Atanh",
+}
+
 // goKeywords is a set of Go reserved keywords.
 var goKeywords = map[string]struct{}{
 	"break": {}, "default": {}, "func": {}, "interface": {}, "select": {},
@@ -2,6 +2,7 @@ package generator
 
 import (
 	"fmt" // Added import for fmt.Sprintf
+	"math"
 	"strings"
 	"testing"
 
@@ -109,7 +110,7 @@ func TestGenerator(t *testing.T) {
 		assert.Contains(t, goCode, "a * b / c")
 	})
 
-	t.Run("Exponentiation - Requires Math", func(t *testing.T) {
+	t.Run("Exponentiation - Small Integer Expands", func(t *testing.T) {
 		// AST for a ^ 2
 		inputAST := &ast.BinaryExpr{
 			Op:    "^",
@@ -117,8 +118,20 @@ func TestGenerator(t *testing.T) {
 			Right: &ast.NumberLiteral{Value: 2},
 		}
 		goCode, err := gen.Generate(inputAST, "main", "powFunc")
+		checkGeneratedCode(t, goCode, err, "main", "powFunc", []string{"a"}, false) // Repeated multiplication needs no math
+		assert.Contains(t, goCode, "return a * a")
+	})
+
+	t.Run("Exponentiation - Fractional Requires Math", func(t *testing.T) {
+		// AST for a ^ 0.5
+		inputAST := &ast.BinaryExpr{
+			Op:    "^",
+			Left:  &ast.Variable{Name: "a"},
+			Right: &ast.NumberLiteral{Value: 0.5},
+		}
+		goCode, err := gen.Generate(inputAST, "main", "powFunc")
 		checkGeneratedCode(t, goCode, err, "main", "powFunc", []string{"a"}, true) // Expect math needed
-		assert.Contains(t, goCode, "return math.Pow(a, 2") // Check start of Pow call, ignore exact float format
+		assert.Contains(t, goCode, "return math.Pow(a, 0.5)")
 	})
 
 	t.Run("Function Call - sqrt - Requires Math", func(t *testing.T) {
@@ -146,6 +159,24 @@ func TestGenerator(t *testing.T) {
 		assert.Contains(t, goCode, "return (a) / (b)") // frac translates to division with parentheses
 	})
 
+	t.Run("Power of Fraction - Parenthesized Factors", func(t *testing.T) {
+		// AST for \frac{a}{b}^2
+		inputAST := &ast.BinaryExpr{
+			Op: "^",
+			Left: &ast.FuncCall{
+				FuncName: "frac",
+				Args: []ast.Expr{
+					&ast.Variable{Name: "a"},
+					&ast.Variable{Name: "b"},
+				},
+			},
+			Right: &ast.NumberLiteral{Value: 2},
+		}
+		goCode, err := gen.Generate(inputAST, "main", "fracPowFunc")
+		checkGeneratedCode(t, goCode, err, "main", "fracPowFunc", []string{"a", "b"}, false)
+		assert.Contains(t, goCode, "return ((a) / (b)) * ((a) / (b))")
+	})
+
 	t.Run("Function Call - sin - Requires Math", func(t *testing.T) {
 		// AST for \sin{x}
 		inputAST := &ast.FuncCall{
@@ -205,13 +236,45 @@ func TestGenerator(t *testing.T) {
 
 		// Check for key parts, acknowledging formatting might vary
 		assert.Contains(t, goCode, "math.Sqrt")
-		assert.Contains(t, goCode, "math.Pow")
+		assert.Contains(t, goCode, "b*b") // b^2 expands to a product, which gofmt tightens inside the subtraction
 		assert.Contains(t, goCode, "/") // From frac and potentially internal division
 		assert.Contains(t, goCode, "*")
 		assert.Contains(t, goCode, "+")
 		assert.Contains(t, goCode, "-")
 	})
 
+	t.Run("Logical Grouping - Returns Bool", func(t *testing.T) {
+		// AST for (p \lor q) \land r, which must keep its grouping in Go
+		inputAST := &ast.BinaryExpr{
+			Op: "&&",
+			Left: &ast.BinaryExpr{
+				Op:    "||",
+				Left:  &ast.BinaryExpr{Op: ">", Left: &ast.Variable{Name: "p"}, Right: &ast.NumberLiteral{Value: 0}},
+				Right: &ast.BinaryExpr{Op: ">", Left: &ast.Variable{Name: "q"}, Right: &ast.NumberLiteral{Value: 0}},
+			},
+			Right: &ast.BinaryExpr{Op: "<", Left: &ast.Variable{Name: "r"}, Right: &ast.NumberLiteral{Value: 1}},
+		}
+		goCode, err := gen.Generate(inputAST, "main", "condFunc")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "func condFunc(p float64, q float64, r float64) bool")
+		assert.Contains(t, goCode, "return (p > 0 || q > 0) && r < 1")
+	})
+
+	t.Run("Matrix - Returns Slice Literal", func(t *testing.T) {
+		// AST for \begin{pmatrix} a & 1 \\ 0 & \sqrt{b} \end{pmatrix}
+		inputAST := &ast.MatrixExpr{
+			Rows: [][]ast.Expr{
+				{&ast.Variable{Name: "a"}, &ast.NumberLiteral{Value: 1}},
+				{&ast.NumberLiteral{Value: 0}, &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.Variable{Name: "b"}}}},
+			},
+		}
+		goCode, err := gen.Generate(inputAST, "main", "matrixFunc")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "func matrixFunc(a float64, b float64) [][]float64")
+		assert.Contains(t, goCode, "return [][]float64{{a, 1}, {0, math.Sqrt(b)}}")
+		assert.Contains(t, goCode, `import "math"`)
+	})
+
 	t.Run("Unsupported Function Error", func(t *testing.T) {
 		// AST for \unknown{x}
 		inputAST := &ast.FuncCall{
@@ -226,3 +289,1829 @@ func TestGenerator(t *testing.T) {
 	// TODO: Add test for unsupported AST node type if a relevant scenario exists
 
 }
+
+// tripleExpr is a toy custom node used to exercise the generator extension point.
+type tripleExpr struct {
+	ast.ExtensionNode
+	Value ast.Expr
+}
+
+func (tripleExpr) Kind() string            { return "triple" }
+func (n *tripleExpr) Children() []ast.Expr { return []ast.Expr{n.Value} }
+
+func TestGenerator_CustomNodeGenerator(t *testing.T) {
+	gen := NewGenerator()
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &tripleExpr{Value: &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.Variable{Name: "x"}}}},
+		Right: &ast.Variable{Name: "y"},
+	}
+
+	_, err := gen.Generate(inputAST, "main", "customFunc")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no generator registered for custom node kind "triple"`)
+
+	gen.RegisterNodeGenerator("triple", func(e ast.Expr, g *Generator) (string, bool, error) {
+		node := e.(*tripleExpr)
+		valueCode, needsMath, err := g.GenerateExpr(node.Value)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("3 * (%s)", valueCode), needsMath, nil
+	})
+
+	goCode, err := gen.Generate(inputAST, "main", "customFunc")
+	checkGeneratedCode(t, goCode, err, "main", "customFunc", []string{"x", "y"}, true) // sqrt inside the custom node needs math
+	assert.Contains(t, goCode, "return 3*(math.Sqrt(x)) + y")
+}
+
+func TestGenerator_MathImportFromFinalCode(t *testing.T) {
+	// The custom node folds 3 * sqrt(4) to 6 but reports the math its operand needed
+	gen := NewGenerator()
+	gen.RegisterNodeGenerator("triple", func(e ast.Expr, g *Generator) (string, bool, error) {
+		_, needsMath, err := g.GenerateExpr(e.(*tripleExpr).Value)
+		if err != nil {
+			return "", false, err
+		}
+		return "6", needsMath, nil
+	})
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &tripleExpr{Value: &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.NumberLiteral{Value: 4}}}},
+		Right: &ast.Variable{Name: "y"},
+	}
+	goCode, err := gen.Generate(inputAST, "main", "folded")
+	checkGeneratedCode(t, goCode, err, "main", "folded", []string{"y"}, false)
+	assert.Contains(t, goCode, "return 6 + y")
+
+	// And the other way round, math called without being reported is imported
+	gen.RegisterNodeGenerator("triple", func(e ast.Expr, g *Generator) (string, bool, error) {
+		valueCode, _, err := g.GenerateExpr(e.(*tripleExpr).Value)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("math.Cbrt(%s)", valueCode), false, nil
+	})
+	inputAST.Left = &tripleExpr{Value: &ast.Variable{Name: "x"}}
+	goCode, err = gen.Generate(inputAST, "main", "cubeRoot")
+	checkGeneratedCode(t, goCode, err, "main", "cubeRoot", []string{"x", "y"}, true)
+	assert.Contains(t, goCode, "return math.Cbrt(x) + y")
+}
+
+func TestGenerator_ClosureOption(t *testing.T) {
+	gen := NewGenerator(WithClosure())
+	inputAST := &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "a"}, Right: &ast.Variable{Name: "b"}}
+
+	goCode, err := gen.Generate(inputAST, "main", "makeF")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func makeF(a float64, b float64) func() float64 {")
+	assert.Contains(t, goCode, "return func() float64 {\n\t\treturn a + b\n\t}")
+}
+
+func TestGenerator_SampleStatistics(t *testing.T) {
+	gen := NewGenerator()
+	mean := &ast.FuncCall{FuncName: "E", Args: []ast.Expr{&ast.Variable{Name: "X"}}}
+
+	goCode, err := gen.Generate(mean, "main", "expectation")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func expectation(X []float64) float64 {")
+	assert.Contains(t, goCode, "for _, xi := range X {")
+	assert.NotContains(t, goCode, "import \"math\"")
+
+	// Scalar parameters and sample slices are sorted together
+	variance := &ast.BinaryExpr{
+		Op:    "*",
+		Left:  &ast.Variable{Name: "a"},
+		Right: &ast.FuncCall{FuncName: "Var", Args: []ast.Expr{&ast.Variable{Name: "X"}}},
+	}
+	goCode, err = gen.Generate(variance, "main", "scaledVariance")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func scaledVariance(X []float64, a float64) float64 {")
+
+	// A random variable cannot double as a scalar parameter
+	mixed := &ast.BinaryExpr{Op: "-", Left: &ast.Variable{Name: "X"}, Right: mean}
+	_, err = gen.Generate(mixed, "main", "mixed")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "random variable X is also used as a scalar")
+}
+
+func TestGenerator_Norm(t *testing.T) {
+	gen := NewGenerator()
+
+	tests := []struct {
+		order      float64
+		accumulate string
+		result     string
+	}{
+		{1, "norm += math.Abs(vi)", "return norm"},
+		{2, "norm += vi * vi", "return math.Sqrt(norm)"},
+		{3, "norm += math.Pow(math.Abs(vi), 3)", "return math.Pow(norm, 1.0/3)"},
+		{math.Inf(1), "norm = math.Max(norm, math.Abs(vi))", "return norm"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.order), func(t *testing.T) {
+			goCode, err := gen.Generate(&ast.NormExpr{Vector: "v", Order: tt.order}, "main", "norm")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, "func norm(v []float64) float64 {")
+			assert.Contains(t, goCode, tt.accumulate)
+			assert.Contains(t, goCode, tt.result)
+		})
+	}
+
+	// A vector named norm moves the accumulator out of its way
+	goCode, err := gen.Generate(&ast.NormExpr{Vector: "norm", Order: 1}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "norm_ := 0.0")
+	assert.Contains(t, goCode, "for _, vi := range norm {")
+
+	// A vector cannot double as a scalar parameter
+	mixed := &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "v"}, Right: &ast.NormExpr{Vector: "v", Order: 2}}
+	_, err = gen.Generate(mixed, "main", "mixed")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "vector v is also used as a scalar")
+}
+
+func TestGenerator_InnerProduct(t *testing.T) {
+	product := &ast.InnerProductExpr{Bra: "a", Ket: "b"}
+
+	goCode, err := NewGenerator().Generate(product, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(a []float64, b []float64) float64 {")
+	assert.Contains(t, goCode, "dot += a[i] * b[i]")
+
+	// In complex mode the bra is conjugated
+	goCode, err = NewGenerator(WithComplex()).Generate(product, "main", "f")
+	require.NoError(t, err)
+	_, parseErr = parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(a []complex128, b []complex128) complex128 {")
+	assert.Contains(t, goCode, "dot += cmplx.Conj(a[i]) * b[i]")
+
+	// Results are scalars, so a lone ket or bra cannot be generated
+	_, err = NewGenerator().Generate(&ast.KetExpr{Vector: "psi"}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\ket{psi} is only supported in an inner product")
+	_, err = NewGenerator(WithComplex()).Generate(&ast.KetExpr{Vector: "psi", IsBra: true}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\bra{psi} is only supported before a ket")
+}
+
+func TestGenerator_EvaluationBar(t *testing.T) {
+	gen := NewGenerator()
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	square := &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: 2}}
+
+	tests := []struct {
+		name     string
+		input    *ast.EvaluationExpr
+		params   []string
+		expected []string
+	}{
+		{"bounds", &ast.EvaluationExpr{Body: square, Lower: &ast.NumberLiteral{Value: 0}, Upper: &ast.NumberLiteral{Value: 1}},
+			nil, []string{"at := func(x float64) float64 { return x * x }", "return at(1) - at(0)"}},
+		{"named variable", &ast.EvaluationExpr{Body: &ast.BinaryExpr{Op: "*", Left: x, Right: y}, Var: "x", Lower: y, Upper: &ast.Variable{Name: "b"}},
+			[]string{"b", "y"}, []string{"at := func(x float64) float64 { return x * y }", "return at(b) - at(y)"}},
+		{"point", &ast.EvaluationExpr{Body: square, Lower: &ast.NumberLiteral{Value: 2}},
+			nil, []string{"return func(x float64) float64 { return x * x }(2)"}},
+		{"constant", &ast.EvaluationExpr{Body: &ast.NumberLiteral{Value: 3}, Lower: &ast.NumberLiteral{Value: 0}, Upper: &ast.NumberLiteral{Value: 1}},
+			nil, []string{"at := func(_ float64) float64 { return 3 }"}},
+		{"closure named apart", &ast.EvaluationExpr{Body: square, Lower: &ast.Variable{Name: "at"}, Upper: &ast.NumberLiteral{Value: 1}},
+			[]string{"at"}, []string{"at_ := func(x float64) float64 { return x * x }", "return at_(1) - at_(at)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := gen.Generate(tt.input, "main", "f")
+			checkGeneratedCode(t, goCode, err, "main", "f", tt.params, false)
+			for _, expected := range tt.expected {
+				assert.Contains(t, goCode, expected)
+			}
+		})
+	}
+
+	// With several variables the one set to the bounds must be named
+	_, err := gen.Generate(&ast.EvaluationExpr{Body: &ast.BinaryExpr{Op: "*", Left: x, Right: y}, Lower: &ast.NumberLiteral{Value: 0}}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the evaluation bar of an expression in x, y must name the variable")
+}
+
+func TestGenerator_IndexSetSum(t *testing.T) {
+	gen := NewGenerator()
+	i := &ast.Variable{Name: "i"}
+
+	// \sum_{i=1,3,5} i^2
+	sum := &ast.SumExpr{
+		Var:    "i",
+		Values: []ast.Expr{&ast.NumberLiteral{Value: 1}, &ast.NumberLiteral{Value: 3}, &ast.NumberLiteral{Value: 5}},
+		Body:   &ast.BinaryExpr{Op: "^", Left: i, Right: &ast.NumberLiteral{Value: 2}},
+	}
+	goCode, err := gen.Generate(sum, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f() float64 {")
+	assert.Contains(t, goCode, "for _, i := range []float64{1, 3, 5} {")
+	assert.Contains(t, goCode, "result = result + (i * i)")
+
+	// \prod_{i \in \{a, b\}} (x - i): listed values may be parameters
+	product := &ast.SumExpr{
+		IsProduct: true,
+		Var:       "i",
+		Values:    []ast.Expr{&ast.Variable{Name: "a"}, &ast.Variable{Name: "b"}},
+		Body:      &ast.BinaryExpr{Op: "-", Left: &ast.Variable{Name: "x"}, Right: i},
+	}
+	goCode, err = gen.Generate(product, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f(a float64, b float64, x float64) float64 {")
+	assert.Contains(t, goCode, "result := 1.0")
+	assert.Contains(t, goCode, "for _, i := range []float64{a, b} {")
+}
+
+func TestGenerator_SequenceSum(t *testing.T) {
+	gen := NewGenerator()
+	n := &ast.Variable{Name: "n"}
+
+	// \sum_n a_n runs over the indices of a
+	sum := &ast.SumExpr{Var: "n", Body: &ast.IndexExpr{Sequence: "a", Index: n}}
+	goCode, err := gen.Generate(sum, "main", "total")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func total(a []float64) float64 {")
+	assert.Contains(t, goCode, "for n := range a {")
+	assert.Contains(t, goCode, "result = result + (a[n])")
+
+	// Outside an index, the int index is converted to float64
+	weighted := &ast.SumExpr{Var: "n", Body: &ast.BinaryExpr{Op: "*", Left: n, Right: &ast.IndexExpr{Sequence: "a", Index: n}}}
+	goCode, err = gen.Generate(weighted, "main", "moment")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "result = result + (float64(n) * a[n])")
+
+	// Other indices are float64 expressions, truncated to an int
+	shifted := &ast.IndexExpr{Sequence: "a", Index: &ast.BinaryExpr{Op: "+", Left: n, Right: &ast.NumberLiteral{Value: 1}}}
+	goCode, err = gen.Generate(shifted, "main", "next")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func next(a []float64, n float64) float64 {")
+	assert.Contains(t, goCode, "return a[int(n+1)]")
+
+	// Without bounds, the body must index a sequence by the index
+	_, err = gen.Generate(&ast.SumExpr{Var: "n", Body: &ast.Variable{Name: "x"}}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\sum_n has no bounds")
+}
+
+func TestGenerator_RelationalChain(t *testing.T) {
+	chain := &ast.RelationalChain{
+		Operands: []ast.Expr{&ast.NumberLiteral{Value: 0}, &ast.Variable{Name: "x"}, &ast.NumberLiteral{Value: 10}},
+		Ops:      []string{"<=", "<="},
+	}
+
+	t.Run("Bool Test By Default", func(t *testing.T) {
+		goCode, err := NewGenerator().Generate(chain, "main", "inRange")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "func inRange(x float64) bool {")
+		assert.Contains(t, goCode, "return 0 <= x && x <= 10")
+
+		negated := &ast.UnaryExpr{Op: "!", Operand: chain}
+		goCode, err = NewGenerator().Generate(negated, "main", "outOfRange")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "return !(0 <= x && x <= 10)")
+	})
+
+	t.Run("Clamp With Option", func(t *testing.T) {
+		gen := NewGenerator(WithClampChains())
+		goCode, err := gen.Generate(chain, "main", "clamp")
+		checkGeneratedCode(t, goCode, err, "main", "clamp", []string{"x"}, true)
+		assert.Contains(t, goCode, "return math.Max(0, math.Min(10, x))")
+
+		descending := &ast.RelationalChain{
+			Operands: []ast.Expr{&ast.Variable{Name: "hi"}, &ast.Variable{Name: "x"}, &ast.Variable{Name: "lo"}},
+			Ops:      []string{">", ">="},
+		}
+		goCode, err = gen.Generate(descending, "main", "clamp")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "return math.Max(lo, math.Min(hi, x))")
+
+		mixed := &ast.RelationalChain{
+			Operands: []ast.Expr{&ast.Variable{Name: "a"}, &ast.Variable{Name: "x"}, &ast.Variable{Name: "b"}},
+			Ops:      []string{"<", ">"},
+		}
+		_, err = gen.Generate(mixed, "main", "clamp")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "clamping requires a chain of the form")
+	})
+}
+
+func TestGenerator_Constants(t *testing.T) {
+	gen := NewGenerator()
+	inputAST := &ast.BinaryExpr{
+		Op:    "*",
+		Left:  &ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: 2}, Right: &ast.ConstantExpr{Name: "pi"}},
+		Right: &ast.Variable{Name: "r"},
+	}
+
+	goCode, err := gen.Generate(inputAST, "main", "circumference")
+	checkGeneratedCode(t, goCode, err, "main", "circumference", []string{"r"}, true)
+	assert.Contains(t, goCode, "return 2 * math.Pi * r")
+
+	code, needsMath, err := gen.GenerateExpr(&ast.ConstantExpr{Name: "infty"})
+	require.NoError(t, err)
+	assert.True(t, needsMath)
+	assert.Equal(t, "math.Inf(1)", code)
+
+	_, _, err = gen.GenerateExpr(&ast.ConstantExpr{Name: "hbar"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported constant: \hbar`)
+}
+
+func TestGenerator_GoVersionGatedMinMax(t *testing.T) {
+	minExpr := &ast.FuncCall{FuncName: "min", Args: []ast.Expr{&ast.Variable{Name: "a"}, &ast.Variable{Name: "b"}, &ast.Variable{Name: "c"}}}
+	maxExpr := &ast.FuncCall{FuncName: "max", Args: []ast.Expr{&ast.Variable{Name: "a"}, &ast.NumberLiteral{Value: 0}}}
+
+	tests := []struct {
+		name       string
+		goVersion  string
+		expr       ast.Expr
+		expected   string
+		expectMath bool
+	}{
+		{"Default Uses math.Min", "", minExpr, "math.Min(a, math.Min(b, c))", true},
+		{"Before 1.21 Uses math.Max", "1.20", maxExpr, "math.Max(a, 0)", true},
+		{"1.21 Uses Builtin", "1.21", minExpr, "min(a, b, c)", false},
+		{"go-prefixed Version", "go1.22", maxExpr, "max(a, 0)", false},
+		{"Next Major Uses Builtin", "2.0", maxExpr, "max(a, 0)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := NewGenerator(WithGoVersion(tt.goVersion))
+			code, needsMath, err := gen.GenerateExpr(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, code)
+			assert.Equal(t, tt.expectMath, needsMath)
+		})
+	}
+
+	_, _, err := NewGenerator(WithGoVersion("latest")).GenerateExpr(minExpr)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid Go version "latest"`)
+}
+
+func TestGenerator_Generic(t *testing.T) {
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	hypot := &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.BinaryExpr{Op: "+", Left: x, Right: y}}}
+
+	goCode, err := NewGenerator(WithGeneric(), WithGoVersion("1.18")).Generate(hypot, "main", "f")
+	require.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "", goCode, 0)
+	require.NoError(t, err, "generated code does not parse:\n%s", goCode)
+	assert.Contains(t, goCode, "func f[T ~float32 | ~float64](x T, y T) T {")
+	assert.Contains(t, goCode, "return T(func(x, y float64) float64 {\n\t\treturn math.Sqrt(x + y)\n\t}(float64(x), float64(y)))")
+
+	// The type parameter is renamed away from a variable T
+	goCode, err = NewGenerator(WithGeneric(), WithGoVersion("1.21")).Generate(&ast.Variable{Name: "T"}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f[T_ ~float32 | ~float64](T T_) T_ {")
+
+	errorTests := []struct {
+		name     string
+		opts     []Option
+		expr     ast.Expr
+		expected string
+	}{
+		{"No Go Version", nil, x, "generic functions require a targeted Go version of 1.18 or later"},
+		{"Go 1.17", []Option{WithGoVersion("1.17")}, x, "of 1.18 or later, got 1.17"},
+		{"Bool Result", []Option{WithGoVersion("1.18")}, &ast.BinaryExpr{Op: ">", Left: x, Right: y}, "generic functions require float64 parameters and a float64 result"},
+		{"Complex", []Option{WithGoVersion("1.18"), WithComplex()}, x, "generic functions require float64 parameters and a float64 result"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGenerator(append(tt.opts, WithGeneric())...).Generate(tt.expr, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestGenerator_InverseFunctions(t *testing.T) {
+	x := &ast.Variable{Name: "x"}
+	for name, goName := range map[string]string{"arctan": "Atan", "arcsinh": "Asinh", "arccosh": "Acosh", "arctanh": "Atanh"} {
+		call := &ast.FuncCall{FuncName: name, Args: []ast.Expr{x}}
+		code, needsMath, err := NewGenerator().GenerateExpr(call)
+		require.NoError(t, err)
+		assert.True(t, needsMath)
+		assert.Equal(t, "math."+goName+"(x)", code)
+
+		goCode, err := NewGenerator(WithComplex()).Generate(call, "main", "f")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, "return cmplx."+goName+"(x)")
+	}
+
+	// atan2(y, x) keeps its arguments in order
+	code, needsMath, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "atan2", Args: []ast.Expr{&ast.NumberLiteral{Value: 1}, x}})
+	require.NoError(t, err)
+	assert.True(t, needsMath)
+	assert.Equal(t, "math.Atan2(1, x)", code)
+}
+
+func TestGenerator_Gcd(t *testing.T) {
+	gcd := &ast.FuncCall{FuncName: "gcd", Args: []ast.Expr{&ast.Variable{Name: "a"}, &ast.NumberLiteral{Value: 6}}}
+
+	code, needsMath, err := NewGenerator().GenerateExpr(gcd)
+	require.NoError(t, err)
+	assert.True(t, needsMath)
+	assert.Contains(t, code, "func(values ...float64) float64 {")
+	assert.Contains(t, code, "a, b = b, math.Mod(a, b)")
+	assert.True(t, strings.HasSuffix(code, "}(a, 6)"))
+
+	_, _, err = NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "gcd", Args: []ast.Expr{&ast.Variable{Name: "a"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\gcd requires at least 2 arguments")
+}
+
+func TestGenerator_ClampAndLerp(t *testing.T) {
+	x, a, b := &ast.Variable{Name: "x"}, &ast.Variable{Name: "a"}, &ast.Variable{Name: "b"}
+	num := func(v float64) ast.Expr { return &ast.NumberLiteral{Value: v} }
+
+	code, needsMath, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "clamp", Args: []ast.Expr{x, num(0), num(1)}})
+	require.NoError(t, err)
+	assert.True(t, needsMath)
+	assert.Equal(t, "math.Max(0, math.Min(1, x))", code)
+
+	// The operands of b - a and of the product are parenthesized as needed
+	sum := &ast.BinaryExpr{Op: "+", Left: a, Right: num(1)}
+	code, needsMath, err = NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "lerp", Args: []ast.Expr{sum, b, x}})
+	require.NoError(t, err)
+	assert.False(t, needsMath)
+	assert.Equal(t, "a + 1 + (b - (a + 1)) * x", code)
+
+	for _, name := range []string{"clamp", "lerp"} {
+		_, _, err = NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: name, Args: []ast.Expr{x, a}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), name+" requires 3 arguments, got 2")
+	}
+}
+
+func TestGenerator_SmallIntegerPowers(t *testing.T) {
+	gen := NewGenerator()
+	x := &ast.Variable{Name: "x"}
+	pow := func(base ast.Expr, exp float64) *ast.BinaryExpr {
+		return &ast.BinaryExpr{Op: "^", Left: base, Right: &ast.NumberLiteral{Value: exp}}
+	}
+
+	tests := []struct {
+		name       string
+		expr       ast.Expr
+		expected   string
+		expectMath bool
+	}{
+		{"Cube", pow(x, 3), "x * x * x", false},
+		{"Zero Exponent", pow(x, 0), "1", false},
+		{"First Power", pow(x, 1), "x", false},
+		{"Largest Expanded", pow(x, 8), "x * x * x * x * x * x * x * x", false},
+		{"Large Exponent Uses Pow", pow(x, 9), "math.Pow(x, 9)", true},
+		{"Negative Exponent Uses Pow", pow(x, -2), "math.Pow(x, -2)", true},
+		{"Literal Base Uses Pow", pow(&ast.NumberLiteral{Value: 10}, 8), "math.Pow(10, 8)", true},
+		{"Sum Base Parenthesized", pow(&ast.BinaryExpr{Op: "+", Left: x, Right: &ast.NumberLiteral{Value: 1}}, 2), "(x + 1) * (x + 1)", false},
+		// A call would be evaluated once per factor
+		{"Function Base Uses Pow", pow(&ast.FuncCall{FuncName: "sin", Args: []ast.Expr{x}}, 2), "math.Pow(math.Sin(x), 2)", true},
+		{"Divisor Parenthesized", &ast.BinaryExpr{Op: "/", Left: &ast.Variable{Name: "y"}, Right: pow(x, 2)}, "y / (x * x)", false},
+		{"Negation Parenthesized", &ast.UnaryExpr{Op: "-", Operand: pow(x, 2)}, "-(x * x)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, needsMath, err := gen.GenerateExpr(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, code)
+			assert.Equal(t, tt.expectMath, needsMath)
+		})
+	}
+
+	// An integral or a sum as the base runs its loop once, in math.Pow
+	integral := &ast.IntegralExpr{Var: "t", IsDefinite: true, Lower: &ast.NumberLiteral{Value: 0}, Upper: &ast.NumberLiteral{Value: 1}, Body: &ast.Variable{Name: "t"}}
+	sum := &ast.SumExpr{Var: "i", Lower: &ast.NumberLiteral{Value: 1}, Upper: &ast.Variable{Name: "n"}, Body: &ast.Variable{Name: "i"}}
+	for _, base := range []ast.Expr{integral, sum} {
+		code, _, err := gen.GenerateExpr(pow(base, 2))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(code, "math.Pow("), code)
+		assert.Equal(t, 1, strings.Count(code, "func()"), code)
+	}
+}
+
+func TestGenerator_MaxParams(t *testing.T) {
+	// a + b + c has three free variables
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "c"}, Right: &ast.Variable{Name: "a"}},
+		Right: &ast.Variable{Name: "b"},
+	}
+
+	_, err := NewGenerator(WithMaxParams(2)).Generate(inputAST, "main", "tooMany")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "generated function would have 3 parameters, exceeding the limit of 2: a, b, c")
+
+	goCode, err := NewGenerator(WithMaxParams(3)).Generate(inputAST, "main", "withinLimit")
+	checkGeneratedCode(t, goCode, err, "main", "withinLimit", []string{"a", "b", "c"}, false)
+
+	goCode, err = NewGenerator(WithMaxParams(0)).Generate(inputAST, "main", "noLimit")
+	checkGeneratedCode(t, goCode, err, "main", "noLimit", []string{"a", "b", "c"}, false)
+}
+
+func TestGenerator_ComplexOperators(t *testing.T) {
+	gen := NewGenerator(WithComplex())
+	z := &ast.Variable{Name: "z"}
+	call := func(name string, arg ast.Expr) *ast.FuncCall {
+		return &ast.FuncCall{FuncName: name, Args: []ast.Expr{arg}}
+	}
+
+	tests := []struct {
+		name       string
+		expr       ast.Expr
+		expected   string
+		returnType string
+		imports    []string
+	}{
+		{"Real Part", call("Re", z), "return real(z)", "float64", nil},
+		{"Imaginary Part", call("Im", z), "return imag(z)", "float64", nil},
+		{"Modulus", call("abs", z), "return cmplx.Abs(z)", "float64", []string{`"math/cmplx"`}},
+		{"Phase", call("arg", z), "return cmplx.Phase(z)", "float64", []string{`"math/cmplx"`}},
+		{"Conjugate", call("overline", z), "return cmplx.Conj(z)", "complex128", []string{`"math/cmplx"`}},
+		{"Real Part Of Real Value", call("Re", call("abs", z)), "return real(complex(cmplx.Abs(z), 0))", "float64", []string{`"math/cmplx"`}},
+		{"Real Mixed Into Complex", &ast.BinaryExpr{Op: "+", Left: z, Right: call("Re", z)}, "return z + complex(real(z), 0)", "complex128", nil},
+		{"Complex Sqrt", call("sqrt", z), "return cmplx.Sqrt(z)", "complex128", []string{`"math/cmplx"`}},
+		{"Real Sqrt", call("sqrt", call("abs", z)), "return math.Sqrt(cmplx.Abs(z))", "float64", []string{`"math"`, `"math/cmplx"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := gen.Generate(tt.expr, "main", "f")
+			require.NoError(t, err)
+			assert.Contains(t, goCode, "func f(z complex128) "+tt.returnType)
+			assert.Contains(t, goCode, tt.expected)
+			for _, imp := range tt.imports {
+				assert.Contains(t, goCode, imp)
+			}
+			if len(tt.imports) == 0 {
+				assert.NotContains(t, goCode, "import")
+			}
+		})
+	}
+}
+
+func TestGenerator_ComplexOperatorsRequireComplexMode(t *testing.T) {
+	_, _, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "Re", Args: []ast.Expr{&ast.Variable{Name: "z"}}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\Re requires complex mode (--complex)")
+
+	code, needsMath, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "abs", Args: []ast.Expr{&ast.Variable{Name: "x"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "math.Abs(x)", code)
+	assert.True(t, needsMath)
+}
+
+func TestGenerator_RegisteredReducer(t *testing.T) {
+	gen := NewGenerator()
+	// \operatorname*{mean}_{i=1}^{n} i * x
+	inputAST := &ast.BigOpExpr{
+		Name:  "mean",
+		Var:   "i",
+		Lower: &ast.NumberLiteral{Value: 1},
+		Upper: &ast.Variable{Name: "n"},
+		Body:  &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "i"}, Right: &ast.Variable{Name: "x"}},
+	}
+
+	_, err := gen.Generate(inputAST, "main", "meanFunc")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown operator \operatorname*{mean}: no reducer registered for "mean"`)
+
+	gen.RegisterReducer("mean", func(op *ast.BigOpExpr, g *Generator) (string, bool, error) {
+		sum := &ast.SumExpr{Var: op.Var, Lower: op.Lower, Upper: op.Upper, Body: op.Body}
+		sumCode, needsMath, err := g.GenerateExpr(sum)
+		if err != nil {
+			return "", false, err
+		}
+		upperCode, _, err := g.GenerateExpr(op.Upper)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("%s / %s", sumCode, upperCode), needsMath, nil
+	})
+
+	goCode, err := gen.Generate(inputAST, "main", "meanFunc")
+	checkGeneratedCode(t, goCode, err, "main", "meanFunc", []string{"n", "x"}, true) // The loop rounds the upper bound with math.Floor
+	assert.NotContains(t, goCode, "i float64")
+	assert.Contains(t, goCode, "result = result + (i * x)")
+}
+
+func TestGenerator_VectorizeOption(t *testing.T) {
+	gen := NewGenerator(WithVectorize())
+	// x^2 + y
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 2}},
+		Right: &ast.Variable{Name: "y"},
+	}
+
+	goCode, err := gen.Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(x []float64, y []float64) []float64 {")
+	assert.Contains(t, goCode, `panic("f: y and x have different lengths")`)
+	assert.Contains(t, goCode, "x, y := x[i], y[i]")
+	assert.Contains(t, goCode, "out[i] = x*x + y")
+
+	// A parameter named i moves the loop index out of its way
+	goCode, err = gen.Generate(&ast.Variable{Name: "i"}, "main", "g")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "i := i[i_]")
+
+	_, err = gen.Generate(&ast.NumberLiteral{Value: 1}, "main", "h")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot vectorize an expression without parameters")
+}
+
+func TestGenerator_Assignments(t *testing.T) {
+	gen := NewGenerator()
+	// u = x^2; u + y
+	block := func(result ast.Expr) *ast.BlockExpr {
+		return &ast.BlockExpr{
+			Assignments: []*ast.AssignmentExpr{
+				{Name: "u", Value: &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 2}}},
+			},
+			Result: result,
+		}
+	}
+
+	goCode, err := gen.Generate(block(&ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "u"}, Right: &ast.Variable{Name: "y"}}), "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"x", "y"}, false)
+	assert.Contains(t, goCode, "\tu := float64(x * x)\n\treturn u + y\n")
+
+	_, err = gen.Generate(block(&ast.Variable{Name: "y"}), "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "u is assigned but never used")
+
+	selfReference := &ast.BlockExpr{
+		Assignments: []*ast.AssignmentExpr{
+			{Name: "u", Value: &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "u"}, Right: &ast.NumberLiteral{Value: 1}}},
+		},
+		Result: &ast.Variable{Name: "u"},
+	}
+	_, err = gen.Generate(selfReference, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "u is used before it is assigned")
+}
+
+func TestGenerator_Intermediates(t *testing.T) {
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	// u = x^2; v = u + 1; w = y; u v: every assignment is recorded, w although unused
+	inputAST := &ast.BlockExpr{
+		Assignments: []*ast.AssignmentExpr{
+			{Name: "u", Value: &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: 2}}},
+			{Name: "v", Value: &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "u"}, Right: &ast.NumberLiteral{Value: 1}}},
+			{Name: "w", Value: y},
+		},
+		Result: &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "u"}, Right: &ast.Variable{Name: "v"}},
+	}
+
+	goCode, err := NewGenerator(WithIntermediates()).Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "", goCode, 0)
+	require.NoError(t, err, "generated code does not parse:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(x float64, y float64) map[string]float64 {")
+	assert.Contains(t, goCode, "\tu := float64(x * x)\n\tv := float64(u + 1)\n\tw := float64(y)\n")
+	assert.Contains(t, goCode, "\treturn map[string]float64{\n\t\t\"u\":      u,\n\t\t\"v\":      v,\n\t\t\"w\":      w,\n\t\t\"result\": u * v,\n\t}\n")
+
+	// Without assignments, the map only holds the result
+	goCode, err = NewGenerator(WithIntermediates()).Generate(x, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return map[string]float64{\n\t\t\"result\": x,\n\t}")
+
+	errorTests := []struct {
+		name     string
+		opts     []Option
+		expr     ast.Expr
+		expected string
+	}{
+		{"Bool Result", nil, &ast.BinaryExpr{Op: ">", Left: x, Right: y}, "intermediate results require a float64 result"},
+		{"Overflow Check", []Option{WithOverflowCheck()}, x, "intermediate results require a float64 result"},
+		{"Named Result", nil, &ast.BlockExpr{
+			Assignments: []*ast.AssignmentExpr{{Name: "result", Value: x}},
+			Result:      &ast.Variable{Name: "result"},
+		}, "an assignment named result clashes with the key of the final value"},
+		{"Condition", nil, &ast.BlockExpr{
+			Assignments: []*ast.AssignmentExpr{{Name: "p", Value: &ast.BinaryExpr{Op: ">", Left: x, Right: y}}},
+			Result:      x,
+		}, "intermediate results must be numbers, but p is a condition"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGenerator(append(tt.opts, WithIntermediates())...).Generate(tt.expr, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestGenerator_ParamOrder(t *testing.T) {
+	// m * a + b
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "m"}, Right: &ast.Variable{Name: "a"}},
+		Right: &ast.Variable{Name: "b"},
+	}
+
+	goCode, err := NewGenerator().Generate(inputAST, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"a", "b", "m"}, false)
+
+	goCode, err = NewGenerator(WithParamOrder("alpha")).Generate(inputAST, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"a", "b", "m"}, false)
+
+	goCode, err = NewGenerator(WithParamOrder("appearance")).Generate(inputAST, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"m", "a", "b"}, false)
+
+	_, err = NewGenerator(WithParamOrder("random")).Generate(inputAST, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid parameter order "random"`)
+}
+
+func TestGenerator_NegationOfVariable(t *testing.T) {
+	gen := NewGenerator()
+	neg := func(e ast.Expr) ast.Expr {
+		return &ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: -1}, Right: e}
+	}
+	a, b := &ast.Variable{Name: "a"}, &ast.Variable{Name: "b"}
+
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		// \frac{-b}{2a}
+		{"frac numerator", &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{
+			neg(b),
+			&ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: 2}, Right: a},
+		}}, "return (-b) / (2 * a)"},
+		{"sum operand", &ast.BinaryExpr{Op: "-", Left: a, Right: neg(b)}, "return a - -b"},
+		{"negated sum", neg(&ast.BinaryExpr{Op: "+", Left: a, Right: b}), "return -(a + b)"},
+		{"double negation", neg(neg(b)), "return -(-b)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := gen.Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+}
+
+func TestGenerator_Indent(t *testing.T) {
+	// \sum_{i=1}^{n} i * x, generated as a loop nested two levels deep
+	inputAST := &ast.SumExpr{
+		Var:   "i",
+		Lower: &ast.NumberLiteral{Value: 1},
+		Upper: &ast.Variable{Name: "n"},
+		Body:  &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "i"}, Right: &ast.Variable{Name: "x"}},
+	}
+
+	tabbed, err := NewGenerator().Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	spaced, err := NewGenerator(WithIndent(4)).Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", spaced, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", spaced)
+	assert.NotContains(t, spaced, "\t")
+	assert.Contains(t, spaced, "\n        result = result + ")
+	assert.Equal(t, strings.ReplaceAll(tabbed, "\t", "    "), spaced)
+
+	_, err = NewGenerator(WithIndent(-1)).Generate(inputAST, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid indent -1")
+}
+
+func TestGenerator_WithoutMathImport(t *testing.T) {
+	gen := NewGenerator(WithoutMathImport())
+	abs := func(e ast.Expr) ast.Expr { return &ast.FuncCall{FuncName: "abs", Args: []ast.Expr{e}} }
+	// |x| + \sqrt{|x|}
+	inputAST := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  abs(&ast.Variable{Name: "x"}),
+		Right: &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{abs(&ast.Variable{Name: "x"})}},
+	}
+
+	goCode, err := gen.Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.NotContains(t, goCode, "import")
+	assert.NotContains(t, goCode, "math.Abs(")
+	assert.NotContains(t, goCode, "math.Sqrt(")
+	assert.Contains(t, goCode, "return x_abs(x) + x_sqrt(x_abs(x))")
+	assert.Equal(t, 1, strings.Count(goCode, "func x_abs(v float64) float64 {"))
+	assert.Equal(t, 1, strings.Count(goCode, "func x_sqrt(v float64) float64 {"))
+
+	// Only the helpers actually used are emitted
+	goCode, err = gen.Generate(abs(&ast.Variable{Name: "x"}), "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func x_abs(")
+	assert.NotContains(t, goCode, "func x_sqrt(")
+
+	// Functions without a pure-Go helper are an error
+	_, err = gen.Generate(&ast.FuncCall{FuncName: "sin", Args: []ast.Expr{&ast.Variable{Name: "x"}}}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "math.Sin has no pure-Go replacement without the math import (supported: Abs, Sqrt)")
+}
+
+func TestGenerator_UnitCheck(t *testing.T) {
+	unit := func(value float64, u string) ast.Expr {
+		return &ast.UnitExpr{Value: &ast.NumberLiteral{Value: value}, Unit: u}
+	}
+	sum := func(l, r ast.Expr) ast.Expr { return &ast.BinaryExpr{Op: "+", Left: l, Right: r} }
+
+	// Without the check units are stripped
+	goCode, err := NewGenerator().Generate(sum(unit(1, "m"), unit(1, "s")), "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return 1 + 1")
+
+	gen := NewGenerator(WithUnitCheck())
+	tests := []struct {
+		name        string
+		input       ast.Expr
+		expectedErr string
+	}{
+		{"meters plus seconds", sum(unit(1, "m"), unit(1, "s")), "inconsistent units: cannot add meters to seconds"},
+		{"lengths", sum(unit(1, "m"), unit(2, "m")), ""},
+		// Units of the same dimension but different scales are not converted
+		{"kilometers plus meters", sum(unit(1, "km"), unit(2, "m")), "inconsistent units: cannot add meters in units 1000 times apart: convert them to one unit first"},
+		{"speeds", &ast.BinaryExpr{Op: "<", Left: unit(1, "km/h"), Right: unit(2, "m/s")}, "cannot compare m/s in units 3.6 times apart"},
+		{"same scale", sum(&ast.BinaryExpr{Op: "*", Left: unit(1, "km"), Right: unit(1, "mm")}, unit(1, "m^2")), ""},
+		{"newtons", sum(&ast.BinaryExpr{Op: "*", Left: unit(2, "kg"), Right: unit(9.81, "m/s^2")}, unit(1, "N")), ""},
+		{"speed plus length", sum(&ast.FuncCall{FuncName: "frac", Args: []ast.Expr{unit(1, "m"), unit(1, "s")}}, unit(1, "m")),
+			"cannot add m/s to meters"},
+		{"unknown variable", sum(&ast.Variable{Name: "x"}, unit(1, "s")), ""},
+		{"dimensionless number", sum(unit(1, "s"), &ast.NumberLiteral{Value: 1}), "cannot add seconds to a dimensionless number"},
+		{"unknown unit", unit(1, "apples"), "unknown unit apples"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gen.Generate(tt.input, "main", "f")
+			if tt.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestGenerator_Trace(t *testing.T) {
+	gen := NewGenerator(WithTrace())
+	// u = x^2; u / y
+	inputAST := &ast.BlockExpr{
+		Assignments: []*ast.AssignmentExpr{{Name: "u", Value: &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 2}}}},
+		Result:      &ast.BinaryExpr{Op: "/", Left: &ast.Variable{Name: "u"}, Right: &ast.Variable{Name: "y"}},
+	}
+
+	goCode, err := gen.Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "\"fmt\"")
+	assert.Contains(t, goCode, "\"os\"")
+	assert.Contains(t, goCode, `fmt.Fprintf(os.Stderr, "f: u = %v\n", u)`)
+	assert.Contains(t, goCode, "left := u")
+	assert.Contains(t, goCode, "right := y")
+	assert.Contains(t, goCode, "result := left / right")
+	assert.Contains(t, goCode, `fmt.Fprintf(os.Stderr, "f: result = %v\n", result)`)
+
+	_, err = NewGenerator(WithTrace(), WithVectorize()).Generate(inputAST.Result, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tracing is not supported")
+}
+
+func TestGenerator_NumericGuards(t *testing.T) {
+	gen := NewGenerator(WithNumericGuards(), WithMaxTerms(100))
+	n := &ast.Variable{Name: "n"}
+	// \sum_{n=1}^{\infty} \sqrt{c - n}: terms turn NaN once n exceeds c
+	series := &ast.SumExpr{
+		Var:   "n",
+		Lower: &ast.NumberLiteral{Value: 1},
+		Upper: &ast.ConstantExpr{Name: "infty"},
+		Body:  &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.BinaryExpr{Op: "-", Left: &ast.Variable{Name: "c"}, Right: n}}},
+	}
+
+	goCode, err := gen.Generate(series, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "n <= math.Floor(math.Inf(1)) && n < 1.0+100;")
+	assert.Contains(t, goCode, "term := math.Sqrt(c - n)")
+	assert.Contains(t, goCode, "if math.IsNaN(term) || math.IsInf(term, 0) {")
+	assert.Contains(t, goCode, "result = result + term")
+
+	// Integrals check each sample of the integrand
+	integral := &ast.IntegralExpr{IsDefinite: true, Var: "x", Lower: &ast.Variable{Name: "a"}, Upper: &ast.Variable{Name: "b"}, Body: &ast.Variable{Name: "x"}}
+	goCode, err = gen.Generate(integral, "main", "g")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "if math.IsNaN(fx) || math.IsInf(fx, 0) {")
+
+	// In error mode a NaN result is an error
+	goCode, err = NewGenerator(WithNumericGuards(), WithOverflowCheck()).Generate(series.Body, "main", "h")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, `return 0, errors.New("h: result is NaN")`)
+
+	_, err = NewGenerator(WithMaxTerms(-1)).Generate(series, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid maximum of -1 terms")
+}
+
+func TestGenerator_PlusMinus(t *testing.T) {
+	gen := NewGenerator()
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	// 1 + x \pm y
+	inputAST := &ast.BinaryExpr{Op: "±", Left: &ast.BinaryExpr{Op: "+", Left: &ast.NumberLiteral{Value: 1}, Right: x}, Right: y}
+
+	goCode, err := gen.Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(x float64, y float64) (plus, minus float64) {")
+	assert.Contains(t, goCode, "return 1 + x + y, 1 + x - y")
+
+	// The signs are correlated: \mp takes the opposite of \pm in each result
+	correlated := &ast.BinaryExpr{Op: "∓", Left: &ast.BinaryExpr{Op: "±", Left: x, Right: y}, Right: &ast.Variable{Name: "z"}}
+	goCode, err = gen.Generate(correlated, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return x + y - z, x - y + z")
+
+	// A parenthesized \pm stays grouped in Go
+	grouped := &ast.BinaryExpr{Op: "*", Left: &ast.BinaryExpr{Op: "±", Left: x, Right: y}, Right: x}
+	goCode, err = gen.Generate(grouped, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return (x + y) * x, (x - y) * x")
+
+	// Results named like a parameter move out of its way
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "±", Left: &ast.Variable{Name: "plus"}, Right: y}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "(plus_, minus float64)")
+
+	errorTests := []struct {
+		name        string
+		gen         *Generator
+		input       ast.Expr
+		expectedErr string
+	}{
+		{"assignment", gen, &ast.BlockExpr{
+			Assignments: []*ast.AssignmentExpr{{Name: "u", Value: inputAST}},
+			Result:      &ast.Variable{Name: "u"},
+		}, "\\pm and \\mp are only supported in the final expression"},
+		{"vectorized", NewGenerator(WithVectorize()), inputAST, "\\pm is not supported"},
+		{"bool", gen, &ast.BinaryExpr{Op: "<", Left: x, Right: inputAST}, "\\pm requires a numeric result"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.gen.Generate(tt.input, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestGenerator_GenerateFiles(t *testing.T) {
+	// sqrt(x): the helper goes to its own file
+	sqrt := &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.Variable{Name: "x"}}}
+
+	files, err := NewGenerator(WithoutMathImport()).GenerateFiles(sqrt, "shapes", "root")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "root.go", files[0].Name)
+	assert.Contains(t, files[0].Code, "return x_sqrt(x)")
+	assert.NotContains(t, files[0].Code, "func x_sqrt(")
+	assert.Equal(t, HelpersFile, files[1].Name)
+	assert.True(t, strings.HasPrefix(files[1].Code, "package shapes\n"))
+	// Every helper is included, so that functions of the package can share the file
+	assert.Contains(t, files[1].Code, "func x_sqrt(v float64) float64 {")
+	assert.Contains(t, files[1].Code, "func x_abs(v float64) float64 {")
+	for _, file := range files {
+		_, parseErr := parser.ParseFile(token.NewFileSet(), file.Name, file.Code, parser.AllErrors)
+		require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", file.Code)
+	}
+
+	// Without helpers there is only the function's file
+	files, err = NewGenerator().GenerateFiles(sqrt, "shapes", "root")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Contains(t, files[0].Code, "return math.Sqrt(x)")
+}
+
+func TestGenerator_DomainNotes(t *testing.T) {
+	x, n := &ast.Variable{Name: "x"}, &ast.Variable{Name: "n"}
+	sqrt := func(e ast.Expr) ast.Expr { return &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{e}} }
+	xMinus1 := &ast.BinaryExpr{Op: "-", Left: x, Right: &ast.NumberLiteral{Value: 1}}
+
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		{"sqrt", sqrt(x), "// Note: x must be >= 0\nfunc f("},
+		{"sqrt of an expression", sqrt(xMinus1), "// Note: x - 1 must be >= 0\nfunc f("},
+		{"denominator", &ast.BinaryExpr{Op: "/", Left: &ast.NumberLiteral{Value: 1}, Right: xMinus1}, "// Note: x - 1 must be != 0\nfunc f("},
+		{"frac", &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{x, n}}, "// Note: n must be != 0\nfunc f("},
+		{"factorial", &ast.FactorialExpr{Value: n}, "// Note: n must be a non-negative integer\nfunc f("},
+		{"log", &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{xMinus1}}, "// Note: x - 1 must be > 0\nfunc f("},
+		{"in order of appearance, once each", &ast.BinaryExpr{Op: "+",
+			Left:  &ast.BinaryExpr{Op: "*", Left: sqrt(x), Right: &ast.FactorialExpr{Value: n}},
+			Right: sqrt(x),
+		}, "// Note: x must be >= 0\n// Note: n must be a non-negative integer\nfunc f("},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := NewGenerator(WithDomainNotes()).Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+
+	// Constraints on bound variables, locals or constants are not about the parameters
+	unnoted := []ast.Expr{
+		// \sum_{i=1}^{n} \sqrt{i}
+		&ast.SumExpr{Var: "i", Lower: &ast.NumberLiteral{Value: 1}, Upper: n, Body: sqrt(&ast.Variable{Name: "i"})},
+		// u = x^2; \sqrt{u}
+		&ast.BlockExpr{
+			Assignments: []*ast.AssignmentExpr{{Name: "u", Value: &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: 2}}}},
+			Result:      sqrt(&ast.Variable{Name: "u"}),
+		},
+		// \frac{x}{2}
+		&ast.FuncCall{FuncName: "frac", Args: []ast.Expr{x, &ast.NumberLiteral{Value: 2}}},
+	}
+	for _, input := range unnoted {
+		goCode, err := NewGenerator(WithDomainNotes()).Generate(input, "main", "f")
+		require.NoError(t, err)
+		assert.NotContains(t, goCode, "// Note:")
+	}
+
+	// Without the option, nothing is noted
+	goCode, err := NewGenerator().Generate(sqrt(x), "main", "f")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, "// Note:")
+}
+
+func TestGenerator_Simplify(t *testing.T) {
+	a, b, c, d, x := &ast.Variable{Name: "a"}, &ast.Variable{Name: "b"}, &ast.Variable{Name: "c"}, &ast.Variable{Name: "d"}, &ast.Variable{Name: "x"}
+	zero, one := &ast.NumberLiteral{Value: 0}, &ast.NumberLiteral{Value: 1}
+	frac := func(num, den ast.Expr) ast.Expr { return &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{num, den}} }
+	bin := func(op string, left, right ast.Expr) ast.Expr { return &ast.BinaryExpr{Op: op, Left: left, Right: right} }
+
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		{"fraction over fraction", frac(frac(a, b), frac(c, d)), "return (a * d) / (b * c)"},
+		{"fraction over a term", frac(frac(a, b), c), "return (a) / (b * c)"},
+		{"term over a fraction", frac(a, frac(c, d)), "return (a * d) / (c)"},
+		{"slash over fraction", bin("/", bin("/", a, b), frac(c, d)), "return (a * d) / (b * c)"},
+		{"three levels", frac(frac(frac(a, b), c), d), "return (a) / (b * c * d)"},
+		{"x/1", frac(x, one), "return x"},
+		{"0*x", bin("+", a, bin("*", zero, x)), "return a"},
+		{"1*x", bin("*", x, one), "return x"},
+		{"x+0", bin("+", zero, bin("-", x, zero)), "return x"},
+		{"rules in turn", frac(bin("*", one, bin("+", x, zero)), one), "return x"},
+		{"nothing to simplify", bin("*", a, frac(x, b)), "return a * (x) / (b)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := NewGenerator(WithSimplify()).Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+
+	// The equation itself is left as it was
+	input := frac(frac(a, b), frac(c, d))
+	_, err := NewGenerator(WithSimplify()).Generate(input, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, frac(frac(a, b), frac(c, d)), input)
+
+	// Without the option, fractions stay nested
+	goCode, err := NewGenerator().Generate(input, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return ((a) / (b)) / ((c) / (d))")
+}
+
+func TestGenerator_GenerateUnformatted(t *testing.T) {
+	gen := NewGenerator(WithIndent(2))
+	// sqrt(x) + y
+	root := &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.Variable{Name: "x"}}},
+		Right: &ast.Variable{Name: "y"},
+	}
+
+	src, err := gen.GenerateUnformatted(root, "main", "f")
+	require.NoError(t, err)
+	formatted, err := gen.Format(src)
+	require.NoError(t, err)
+
+	goCode, err := gen.Generate(root, "main", "f")
+	require.NoError(t, err)
+	assert.Equal(t, goCode, formatted)
+}
+
+func TestGenerator_TwoBranchCases(t *testing.T) {
+	gen := NewGenerator()
+	// x^2 if x > 0, otherwise -x
+	cases := &ast.PiecewiseExpr{Cases: []ast.PiecewiseCase{
+		{
+			Value:     &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 2}},
+			Condition: &ast.BinaryExpr{Op: ">", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 0}},
+		},
+		{Value: &ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: -1}, Right: &ast.Variable{Name: "x"}}},
+	}}
+
+	goCode, err := gen.Generate(cases, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"x"}, false)
+	assert.Contains(t, goCode, "func f(x float64) float64 {\n\tif x > 0 {\n\t\treturn x * x\n\t}\n\treturn -x\n}")
+
+	// Inside a larger expression the cases stay a closure
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: 2}, Right: cases}, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"x"}, false)
+	assert.Contains(t, goCode, "return 2 * func() float64 {")
+}
+
+func TestGenerator_DefaultCase(t *testing.T) {
+	gen := NewGenerator()
+	x := &ast.Variable{Name: "x"}
+	num := func(v float64) ast.Expr { return &ast.NumberLiteral{Value: v} }
+	positive := &ast.BinaryExpr{Op: ">", Left: x, Right: num(0)}
+	negative := &ast.BinaryExpr{Op: "<", Left: x, Right: num(0)}
+
+	// 1 if x > 0, -1 if x < 0, otherwise 0: the default is the final return
+	goCode, err := gen.Generate(&ast.PiecewiseExpr{Cases: []ast.PiecewiseCase{
+		{Value: num(1), Condition: positive},
+		{Value: num(-1), Condition: negative},
+		{Value: num(0)},
+	}}, "main", "sign")
+	checkGeneratedCode(t, goCode, err, "main", "sign", []string{"x"}, false)
+	assert.Contains(t, goCode, "\t\t// Default case\n\t\treturn 0\n\t}()")
+	assert.NotContains(t, goCode, "math.NaN()")
+
+	// A default case before another one would hide it
+	misplaced := []*ast.PiecewiseExpr{
+		{Cases: []ast.PiecewiseCase{{Value: num(0)}, {Value: num(1), Condition: positive}}},
+		{Cases: []ast.PiecewiseCase{{Value: num(1), Condition: positive}, {Value: num(0)}, {Value: num(-1)}}},
+		{Cases: []ast.PiecewiseCase{
+			{Value: num(0)},
+			{Value: num(1), Condition: &ast.BinaryExpr{Op: "==", Left: x, Right: num(1)}},
+			{Value: num(2), Condition: &ast.BinaryExpr{Op: "==", Left: x, Right: num(2)}},
+		}},
+	}
+	for _, cases := range misplaced {
+		for _, root := range []ast.Expr{cases, &ast.BinaryExpr{Op: "+", Left: cases, Right: num(1)}} {
+			_, err := gen.Generate(root, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "the default case of a cases environment must be the last one")
+		}
+	}
+	_, err = gen.Generate(misplaced[1], "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "case 2 of 3 has no condition")
+}
+
+func TestGenerator_IndexOutsideSum(t *testing.T) {
+	gen := NewGenerator()
+	i, j, n := &ast.Variable{Name: "i"}, &ast.Variable{Name: "j"}, &ast.Variable{Name: "n"}
+	one := &ast.NumberLiteral{Value: 1}
+	frac := func(num, den ast.Expr) ast.Expr { return &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{num, den}} }
+
+	// \sum_{i=1}^{n} \frac{1}{i} + \frac{1}{i^2} sums the first fraction only
+	_, err := gen.Generate(&ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.SumExpr{Var: "i", Lower: one, Upper: n, Body: frac(one, i)},
+		Right: frac(one, &ast.BinaryExpr{Op: "^", Left: i, Right: &ast.NumberLiteral{Value: 2}}),
+	}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "i is the index of \\sum_{i} but is also used outside it")
+
+	// The same index after a product, as an element index
+	_, err = gen.Generate(&ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.SumExpr{IsProduct: true, Var: "i", Lower: one, Upper: n, Body: i},
+		Right: &ast.IndexExpr{Sequence: "x", Index: i},
+	}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "i is the index of \\prod_{i}")
+
+	// The index of an enclosing sum stays bound in a nested one
+	goCode, err := gen.Generate(&ast.SumExpr{Var: "i", Lower: one, Upper: n, Body: &ast.SumExpr{
+		Var: "j", Lower: one, Upper: n, Body: &ast.BinaryExpr{Op: "*", Left: i, Right: j},
+	}}, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"n"}, true)
+}
+
+func TestGenerator_SwitchCases(t *testing.T) {
+	gen := NewGenerator()
+	n, m := &ast.Variable{Name: "n"}, &ast.Variable{Name: "m"}
+	num := func(v float64) ast.Expr { return &ast.NumberLiteral{Value: v} }
+	eq := func(left, right ast.Expr) ast.Expr { return &ast.BinaryExpr{Op: "==", Left: left, Right: right} }
+
+	// 1 if n = 0, 2 if n = 1, 5 if -2 = n, otherwise 0
+	keyed := &ast.PiecewiseExpr{Cases: []ast.PiecewiseCase{
+		{Value: num(1), Condition: eq(n, num(0))},
+		{Value: num(2), Condition: eq(n, num(1))},
+		{Value: num(5), Condition: eq(num(-2), n)},
+		{Value: num(0)},
+	}}
+	goCode, err := gen.Generate(keyed, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"n"}, false)
+	assert.Contains(t, goCode, "func f(n float64) float64 {\n\tswitch n {\n\tcase 0:\n\t\treturn 1\n\tcase 1:\n\t\treturn 2\n\tcase -2:\n\t\treturn 5\n\tdefault:\n\t\treturn 0\n\t}\n}")
+
+	// Inside a larger expression the switch is in a closure, and without a default
+	// case the default is NaN
+	noDefault := &ast.PiecewiseExpr{Cases: keyed.Cases[:2]}
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "+", Left: noDefault, Right: num(1)}, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"n"}, true)
+	assert.Contains(t, goCode, "return func() float64 {\n\t\tswitch n {")
+	assert.Contains(t, goCode, "\t\tdefault:\n\t\t\treturn math.NaN()")
+
+	// Anything else is an if chain
+	fallbacks := map[string]*ast.PiecewiseExpr{
+		"different variables": {Cases: []ast.PiecewiseCase{{Value: num(1), Condition: eq(n, num(0))}, {Value: num(2), Condition: eq(m, num(1))}}},
+		"non-integer value":   {Cases: []ast.PiecewiseCase{{Value: num(1), Condition: eq(n, num(0))}, {Value: num(2), Condition: eq(n, num(0.5))}}},
+		"repeated value":      {Cases: []ast.PiecewiseCase{{Value: num(1), Condition: eq(n, num(0))}, {Value: num(2), Condition: eq(n, num(0))}}},
+		"other relation": {Cases: []ast.PiecewiseCase{
+			{Value: num(1), Condition: eq(n, num(0))},
+			{Value: num(2), Condition: &ast.BinaryExpr{Op: ">", Left: n, Right: num(1)}},
+			{Value: num(3)},
+		}},
+	}
+	for name, pw := range fallbacks {
+		t.Run(name, func(t *testing.T) {
+			goCode, err := gen.Generate(pw, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.NotContains(t, goCode, "switch")
+			assert.Contains(t, goCode, "if n == 0 {")
+		})
+	}
+}
+
+func TestGenerator_OverflowCheck(t *testing.T) {
+	gen := NewGenerator(WithOverflowCheck())
+	// result * 2: a parameter named result moves the local out of its way
+	inputAST := &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "result"}, Right: &ast.NumberLiteral{Value: 2}}
+
+	goCode, err := gen.Generate(inputAST, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "\"errors\"")
+	assert.Contains(t, goCode, "func f(result float64) (float64, error) {")
+	assert.Contains(t, goCode, "result_ := result * 2")
+	assert.Contains(t, goCode, "if math.IsInf(result_, 0) {")
+	assert.Contains(t, goCode, `return 0, errors.New("f: result overflows float64")`)
+	assert.Contains(t, goCode, "return result_, nil")
+
+	// The argument of a logarithm is checked before computing the result
+	goCode, err = gen.Generate(&ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.FuncCall{FuncName: "log", Args: []ast.Expr{&ast.BinaryExpr{Op: "-", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 1}}}},
+		Right: &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{&ast.Variable{Name: "y"}}},
+	}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "\tif x-1 <= 0 {\n\t\treturn 0, errors.New(\"f: log of non-positive value\")\n\t}\n"+
+		"\tif y <= 0 {\n\t\treturn 0, errors.New(\"f: log of non-positive value\")\n\t}\n"+
+		"\tresult := math.Log(x-1) + math.Log(y)\n")
+	assert.NotContains(t, goCode, "// Note:") // Without WithDomainNotes
+
+	// The argument of one inside a sum depends on its index and cannot be checked ahead
+	goCode, err = gen.Generate(&ast.SumExpr{Var: "i", Lower: &ast.NumberLiteral{Value: 1}, Upper: &ast.Variable{Name: "n"},
+		Body: &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{&ast.Variable{Name: "i"}}}}, "main", "f")
+	require.NoError(t, err)
+	assert.NotContains(t, goCode, "log of non-positive value")
+
+	// Only float64 results can be checked
+	_, err = gen.Generate(&ast.BinaryExpr{Op: ">", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 0}}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "overflow checks require a float64 result")
+}
+
+func TestGenerator_Schema(t *testing.T) {
+	x, y, n := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}, &ast.Variable{Name: "n"}
+	// \frac{\sqrt{x}}{y} + \sqrt{x - y} + n! + \ln z
+	z := &ast.Variable{Name: "z"}
+	root := &ast.BinaryExpr{
+		Op: "+",
+		Left: &ast.BinaryExpr{
+			Op: "+",
+			Left: &ast.BinaryExpr{
+				Op:    "+",
+				Left:  &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{&ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{x}}, y}},
+				Right: &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.BinaryExpr{Op: "-", Left: x, Right: y}}},
+			},
+			Right: &ast.FactorialExpr{Value: n},
+		},
+		Right: &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{z}},
+	}
+
+	schema, err := NewGenerator().GenerateSchema(root, "f")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "f",
+		"description": "Domain: x - y must be >= 0",
+		"type": "object",
+		"properties": {
+			"n": {"type": "integer", "minimum": 0},
+			"x": {"type": "number", "minimum": 0},
+			"y": {"type": "number", "not": {"const": 0}},
+			"z": {"type": "number", "exclusiveMinimum": 0}
+		},
+		"required": ["n", "x", "y", "z"],
+		"additionalProperties": false,
+		"$defs": {"result": {"type": "number"}}
+	}`, schema)
+
+	// Propositions are booleans, vectors arrays, and the closure is left out
+	schema, err = NewGenerator(WithClosure()).GenerateSchema(&ast.BinaryExpr{Op: "&&", Left: &ast.Variable{Name: "p"}, Right: &ast.Variable{Name: "q"}}, "f")
+	require.NoError(t, err)
+	assert.Contains(t, schema, `"p": {
+      "type": "boolean"
+    }`)
+	assert.Contains(t, schema, `"result": {
+      "type": "boolean"
+    }`)
+	schema, err = NewGenerator().GenerateSchema(&ast.NormExpr{Vector: "v", Order: 2}, "f")
+	require.NoError(t, err)
+	assert.Contains(t, schema, `"v": {
+      "type": "array",
+      "items": {
+        "type": "number"
+      }
+    }`)
+
+	// The two results of \pm
+	schema, err = NewGenerator().GenerateSchema(&ast.BinaryExpr{Op: "±", Left: x, Right: y}, "f")
+	require.NoError(t, err)
+	assert.Contains(t, schema, `"required": [
+        "plus",
+        "minus"
+      ]`)
+
+	_, err = NewGenerator(WithComplex()).GenerateSchema(x, "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "complex128 values have no JSON Schema type")
+}
+
+func TestGenerator_Context(t *testing.T) {
+	gen := NewGenerator(WithContext())
+	// \sum_{i=1}^{n} i
+	sum := &ast.SumExpr{Var: "i", Lower: &ast.NumberLiteral{Value: 1}, Upper: &ast.Variable{Name: "n"}, Body: &ast.Variable{Name: "i"}}
+
+	goCode, err := gen.Generate(sum, "main", "f")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "\"context\"")
+	assert.Contains(t, goCode, "func f(ctx context.Context, n float64) (float64, error) {")
+	assert.Contains(t, goCode, "if steps%256 == 0 && ctx.Err() != nil {")
+	assert.Contains(t, goCode, "if err := ctx.Err(); err != nil {")
+	assert.Contains(t, goCode, "return result, nil")
+
+	// \int_{0}^{1} x dx checks on its own step counter
+	integral := &ast.IntegralExpr{IsDefinite: true, Var: "x", Lower: &ast.NumberLiteral{Value: 0}, Upper: &ast.NumberLiteral{Value: 1}, Body: &ast.Variable{Name: "x"}}
+	goCode, err = gen.Generate(integral, "main", "f")
+	require.NoError(t, err)
+	_, parseErr = parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(ctx context.Context) (float64, error) {")
+	assert.Contains(t, goCode, "if i%256 == 0 && ctx.Err() != nil {")
+
+	_, err = gen.Generate(&ast.Variable{Name: "ctx"}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a variable named ctx clashes with the context parameter")
+
+	_, err = NewGenerator(WithContext(), WithOverflowCheck()).Generate(sum, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a context is not supported with \\pm or in overflow-checked mode")
+}
+
+func TestGenerator_Einstein(t *testing.T) {
+	gen := NewGenerator(WithEinstein())
+	a := &ast.Variable{Name: "a"}
+	i := &ast.Variable{Name: "i"}
+	// a^i b_i
+	contracted := &ast.BinaryExpr{Op: "*", Left: &ast.BinaryExpr{Op: "^", Left: a, Right: i}, Right: &ast.IndexExpr{Sequence: "b", Index: i}}
+
+	goCode, err := gen.Generate(contracted, "main", "dot")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func dot(a []float64, b []float64) float64 {")
+	assert.Contains(t, goCode, "for i := range a {")
+	assert.Contains(t, goCode, "result = result + (a[i] * b[i])")
+
+	// Without the option, a^i is a power
+	goCode, err = NewGenerator().Generate(contracted, "main", "dot")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "math.Pow(a, i) * b[int(i)]")
+
+	// Tensor components with one index are vectors: a^{\mu} b_{\mu}
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "a_up_mu"}, Right: &ast.Variable{Name: "b_lo_mu"}}, "main", "dot")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "for mu := range a {")
+	assert.Contains(t, goCode, "result = result + (a[mu] * b[mu])")
+
+	// An index repeated in the same position is not summed over: a_i b_i
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "*", Left: &ast.IndexExpr{Sequence: "a", Index: i}, Right: &ast.IndexExpr{Sequence: "b", Index: i}}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return a[int(i)] * b[int(i)]")
+
+	errorTests := []struct {
+		name        string
+		input       ast.Expr
+		expectedErr string
+	}{
+		{"two repeated indices", &ast.BinaryExpr{Op: "*", Left: contracted, Right: &ast.BinaryExpr{
+			Op: "*", Left: &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "c"}, Right: &ast.Variable{Name: "j"}},
+			Right: &ast.IndexExpr{Sequence: "d", Index: &ast.Variable{Name: "j"}},
+		}}, "the indices i, j are each repeated in a product, but a product can only be summed over one index"},
+		{"tensor of rank two", &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "T_up_mu_lo_nu"}, Right: &ast.Variable{Name: "x_up_nu"}},
+			"cannot sum over the index nu of T_up_mu_lo_nu, which has 2 indices: only the indices of vectors can be summed over"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gen.Generate(tt.input, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestGenerator_VectorArithmetic(t *testing.T) {
+	gen := NewGenerator()
+	a, b := &ast.VectorExpr{Name: "a"}, &ast.VectorExpr{Name: "b"}
+	n := &ast.VectorExpr{Name: "n", Unit: true}
+	k := &ast.Variable{Name: "k"}
+
+	goCode, err := gen.Generate(&ast.BinaryExpr{Op: "+", Left: a, Right: b}, "main", "add")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func add(a []float64, b []float64) []float64 {")
+	assert.Contains(t, goCode, `panic("add: b and a have different lengths")`)
+	assert.Contains(t, goCode, "out[i] = a[i] + b[i]")
+
+	// Scalar multiples, and a unit vector divided by its norm computed once
+	goCode, err = gen.Generate(&ast.BinaryExpr{Op: "-", Left: &ast.BinaryExpr{Op: "*", Left: k, Right: a}, Right: n}, "main", "f")
+	require.NoError(t, err)
+	_, parseErr = parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func f(a []float64, k float64, n []float64) []float64 {")
+	assert.Contains(t, goCode, "nNorm := func() float64 {")
+	assert.Contains(t, goCode, "out[i] = k*a[i] - n[i]/nNorm")
+
+	// Outside vector arithmetic the decoration is transparent
+	goCode, err = gen.Generate(&ast.FuncCall{FuncName: "sin", Args: []ast.Expr{a}}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f(a float64) float64 {")
+	assert.Contains(t, goCode, "return math.Sin(a)")
+
+	errorTests := []struct {
+		name        string
+		input       ast.Expr
+		expectedErr string
+	}{
+		{"vector plus scalar", &ast.BinaryExpr{Op: "+", Left: a, Right: k}, "cannot add a vector and a scalar"},
+		{"product of vectors", &ast.BinaryExpr{Op: "*", Left: a, Right: b}, "the product of two vectors is ambiguous"},
+		{"division by a vector", &ast.BinaryExpr{Op: "/", Left: k, Right: a}, "cannot divide by a vector"},
+		{"vector used as a scalar", &ast.BinaryExpr{Op: "*", Left: &ast.Variable{Name: "a"}, Right: a}, "vector a is also used as a scalar"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gen.Generate(tt.input, "main", "f")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestGenerator_FloatLiterals(t *testing.T) {
+	num := func(v float64) ast.Expr { return &ast.NumberLiteral{Value: v} }
+	frac := func(numerator, denominator ast.Expr) ast.Expr {
+		return &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{numerator, denominator}}
+	}
+	x := &ast.Variable{Name: "x"}
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		// Go would truncate a quotient of untyped integer constants: 1 / 2 is 0
+		{"quotient of integers", &ast.BinaryExpr{Op: "/", Left: num(1), Right: num(2)}, "return 1.0 / 2"},
+		{"fraction of integers", frac(num(-1), num(2)), "return (-1.0) / (2)"},
+		{"integer constant dividend", frac(&ast.BinaryExpr{Op: "+", Left: num(1), Right: num(2)}, num(4)), "return (1.0 + 2.0) / (4)"},
+		{"expanded power", frac(&ast.BinaryExpr{Op: "^", Left: &ast.BinaryExpr{Op: "+", Left: num(1), Right: num(2)}, Right: num(2)}, num(4)),
+			"return ((1.0 + 2.0) * (1.0 + 2.0)) / (4)"},
+		// Other literals are left as written
+		{"float dividend", frac(num(1.5), num(2)), "return (1.5) / (2)"},
+		{"variable divisor", frac(num(1), x), "return (1) / (x)"},
+		{"variable dividend", &ast.BinaryExpr{Op: "/", Left: &ast.BinaryExpr{Op: "*", Left: num(2), Right: x}, Right: num(3)}, "return 2 * x / 3"},
+		{"exponent form", frac(num(1e21), num(7)), "return (1e+21) / (7)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := NewGenerator().Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+
+	// In complex mode too
+	goCode, err := NewGenerator(WithComplex()).Generate(&ast.BinaryExpr{Op: "/", Left: num(1), Right: num(2)}, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "return 1.0 / 2")
+}
+
+func TestGenerator_RoundingFunctions(t *testing.T) {
+	gen := NewGenerator()
+	x := &ast.Variable{Name: "x"}
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		{"round", &ast.FuncCall{FuncName: "round", Args: []ast.Expr{x}}, "return math.Round(x)"},
+		{"trunc", &ast.FuncCall{FuncName: "trunc", Args: []ast.Expr{x}}, "return math.Trunc(x)"},
+		{"fractional part", &ast.FuncCall{FuncName: "fracpart", Args: []ast.Expr{x}}, "return (x - math.Trunc(x))"},
+		// A compound argument is evaluated once
+		{"fractional part of a sum", &ast.FuncCall{FuncName: "fracpart", Args: []ast.Expr{
+			&ast.BinaryExpr{Op: "+", Left: x, Right: &ast.NumberLiteral{Value: 1}},
+		}}, "return func(v float64) float64 { return v - math.Trunc(v) }(x + 1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := gen.Generate(tt.input, "main", "f")
+			checkGeneratedCode(t, goCode, err, "main", "f", []string{"x"}, true)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+}
+
+func TestGenerator_ConditionalExpectation(t *testing.T) {
+	// \langle X \mid Y \rangle is parsed, but has no numerical value to generate
+	conditional := &ast.ConditionalExpectationExpr{Value: &ast.Variable{Name: "X"}, Given: &ast.Variable{Name: "Y"}}
+	for _, gen := range []*Generator{NewGenerator(), NewGenerator(WithComplex())} {
+		_, err := gen.Generate(conditional, "main", "f")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "the conditional expectation \\langle X \\mid Y \\rangle is not supported")
+	}
+}
+
+func TestGenerator_Optimize(t *testing.T) {
+	x := &ast.Variable{Name: "x"}
+	pow := func(n float64) ast.Expr { return &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: n}} }
+	times := func(l, r ast.Expr) ast.Expr { return &ast.BinaryExpr{Op: "*", Left: l, Right: r} }
+	// a x^3 + b x^2 + c x + d
+	cubic := &ast.BinaryExpr{Op: "+", Left: &ast.BinaryExpr{Op: "+", Left: &ast.BinaryExpr{Op: "+",
+		Left:  times(&ast.Variable{Name: "a"}, pow(3)),
+		Right: times(&ast.Variable{Name: "b"}, pow(2)),
+	}, Right: times(&ast.Variable{Name: "c"}, x)}, Right: &ast.Variable{Name: "d"}}
+	// 2 x^n - 3 x^4 + 5
+	sparse := func(n float64) ast.Expr {
+		return &ast.BinaryExpr{Op: "+", Left: &ast.BinaryExpr{Op: "-",
+			Left:  times(&ast.NumberLiteral{Value: 2}, pow(n)),
+			Right: times(&ast.NumberLiteral{Value: 3}, pow(4)),
+		}, Right: &ast.NumberLiteral{Value: 5}}
+	}
+
+	tests := []struct {
+		name     string
+		input    ast.Expr
+		expected string
+	}{
+		{"cubic", cubic, "return ((a*x+b)*x+c)*x + d"},
+		{"missing powers", sparse(8), "return (2*x*x*x*x-3)*x*x*x*x + 5"},
+		// A leading term subtracted is negated
+		{"negative leading term", &ast.BinaryExpr{Op: "-", Left: &ast.NumberLiteral{Value: 1}, Right: pow(2)}, "return -x*x + 1"},
+		// Polynomials are found inside calls
+		{"argument", &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{&ast.BinaryExpr{Op: "+", Left: pow(2), Right: x}}}, "return math.Sqrt((x + 1) * x)"},
+		// Degree 1 is already as cheap as it gets
+		{"linear", &ast.BinaryExpr{Op: "+", Left: times(&ast.Variable{Name: "a"}, x), Right: &ast.Variable{Name: "b"}}, "return a*x + b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := NewGenerator(WithOptimize()).Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, tt.expected)
+			assert.NotContains(t, goCode, "math.Pow")
+		})
+	}
+
+	// Above maxExpandedExponent, a polynomial keeps math.Pow as without the option
+	for _, degree := range []float64{maxExpandedExponent + 1, 1000000} {
+		goCode, err := NewGenerator(WithOptimize()).Generate(sparse(degree), "main", "f")
+		require.NoError(t, err)
+		assert.Contains(t, goCode, fmt.Sprintf("return 2*math.Pow(x, %g) - 3*(x*x*x*x) + 5", degree))
+	}
+}
+
+func TestGenerator_ImplicationAndEquivalence(t *testing.T) {
+	gen := NewGenerator()
+	p, q, x := &ast.Variable{Name: "p"}, &ast.Variable{Name: "q"}, &ast.Variable{Name: "x"}
+	positive := func(e ast.Expr) ast.Expr { return &ast.BinaryExpr{Op: ">", Left: e, Right: &ast.NumberLiteral{Value: 0}} }
+	square := &ast.BinaryExpr{Op: "^", Left: x, Right: &ast.NumberLiteral{Value: 2}}
+
+	tests := []struct {
+		name      string
+		input     ast.Expr
+		signature string
+		expected  string
+	}{
+		// Variables joined by connectives are propositions, passed as bool
+		{"implication", &ast.BinaryExpr{Op: "⟹", Left: p, Right: q}, "func f(p bool, q bool) bool {", "return !p || q"},
+		{"equivalence", &ast.BinaryExpr{Op: "⟺", Left: p, Right: q}, "func f(p bool, q bool) bool {", "return p == q"},
+		{"compound implication", &ast.BinaryExpr{Op: "⟹", Left: positive(x), Right: positive(square)},
+			"func f(x float64) bool {", "return !(x > 0) || x*x > 0"},
+		// Go comparisons do not chain
+		{"compared conditions", &ast.BinaryExpr{Op: "⟺", Left: positive(x), Right: p},
+			"func f(p bool, x float64) bool {", "return (x > 0) == p"},
+		{"conjunction", &ast.BinaryExpr{Op: "&&", Left: p, Right: &ast.UnaryExpr{Op: "!", Operand: q}},
+			"func f(p bool, q bool) bool {", "return p && !q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goCode, err := gen.Generate(tt.input, "main", "f")
+			require.NoError(t, err)
+			_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+			require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+			assert.Contains(t, goCode, tt.signature)
+			assert.Contains(t, goCode, tt.expected)
+		})
+	}
+
+	// p \implies p > 0
+	_, err := gen.Generate(&ast.BinaryExpr{Op: "⟹", Left: p, Right: positive(p)}, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "p is used both as a number and as a proposition")
+}
+
+func TestGenerator_Gradient(t *testing.T) {
+	gen := NewGenerator()
+	// \nabla (h^2 + x^2): a parameter named h moves the step out of its way
+	inputAST := &ast.GradientExpr{Body: &ast.BinaryExpr{
+		Op:    "+",
+		Left:  &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "h"}, Right: &ast.NumberLiteral{Value: 2}},
+		Right: &ast.BinaryExpr{Op: "^", Left: &ast.Variable{Name: "x"}, Right: &ast.NumberLiteral{Value: 2}},
+	}}
+
+	goCode, err := gen.Generate(inputAST, "main", "grad")
+	require.NoError(t, err)
+	_, parseErr := parser.ParseFile(token.NewFileSet(), "", goCode, parser.AllErrors)
+	require.NoError(t, parseErr, "Generated code is not valid Go:\n%s", goCode)
+	assert.Contains(t, goCode, "func grad(h float64, x float64) []float64 {")
+	assert.Contains(t, goCode, "h_ := 0.0001")
+	assert.Contains(t, goCode, "// ∂/∂h")
+	assert.Contains(t, goCode, "(func() float64 { x := x + h_; return h*h + x*x }() - func() float64 { x := x - h_; return h*h + x*x }()) / (2 * h_),")
+
+	_, err = gen.Generate(&ast.BinaryExpr{Op: "+", Left: inputAST, Right: &ast.NumberLiteral{Value: 1}}, "main", "grad")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\nabla is only supported as the whole equation")
+
+	_, err = gen.Generate(&ast.GradientExpr{Body: &ast.NumberLiteral{Value: 1}}, "main", "grad")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\nabla requires a scalar expression of one or more variables")
+}
+
+func TestGenerator_ModuloAndCongruence(t *testing.T) {
+	gen := NewGenerator()
+
+	// (a + b) mod n
+	modulo := &ast.BinaryExpr{
+		Op:    "mod",
+		Left:  &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "a"}, Right: &ast.Variable{Name: "b"}},
+		Right: &ast.Variable{Name: "n"},
+	}
+	goCode, err := gen.Generate(modulo, "main", "f")
+	checkGeneratedCode(t, goCode, err, "main", "f", []string{"a", "b", "n"}, true)
+	assert.Contains(t, goCode, "return math.Mod(a+b, n)")
+
+	// a ≡ b + 1 (mod n)
+	congruence := &ast.CongruenceExpr{
+		Left:    &ast.Variable{Name: "a"},
+		Right:   &ast.BinaryExpr{Op: "+", Left: &ast.Variable{Name: "b"}, Right: &ast.NumberLiteral{Value: 1}},
+		Modulus: &ast.Variable{Name: "n"},
+	}
+	goCode, err = gen.Generate(congruence, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func f(a float64, b float64, n float64) bool {")
+	assert.Contains(t, goCode, "return math.Mod(a-(b+1), n) == 0")
+}
+
+func TestGenerator_CompositeSpecialFunctions(t *testing.T) {
+	x := &ast.Variable{Name: "x"}
+	tests := map[string]string{
+		"sinc":     "func(v float64) float64 { if v == 0 { return 1 }; return math.Sin(v) / v }(x)",
+		"logistic": "func(v float64) float64 { return 1 / (1 + math.Exp(-v)) }(x)",
+		"softplus": "func(v float64) float64 { if v > 0 { return v + math.Log1p(math.Exp(-v)) }; return math.Log1p(math.Exp(v)) }(x)",
+		"relu":     "func(v float64) float64 { return math.Max(0, v) }(x)",
+	}
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, needsMath, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: name, Args: []ast.Expr{x}})
+			require.NoError(t, err)
+			assert.True(t, needsMath)
+			assert.Equal(t, expected, code)
+		})
+	}
+
+	_, _, err := NewGenerator().GenerateExpr(&ast.FuncCall{FuncName: "sinc", Args: []ast.Expr{x, x}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\operatorname{sinc} requires 1 argument, got 2")
+}
+
+func TestGenerator_Validator(t *testing.T) {
+	x, y := &ast.Variable{Name: "x"}, &ast.Variable{Name: "y"}
+	xMinus1 := &ast.BinaryExpr{Op: "-", Left: x, Right: &ast.NumberLiteral{Value: 1}}
+	input := &ast.BinaryExpr{Op: "+",
+		Left:  &ast.FuncCall{FuncName: "sqrt", Args: []ast.Expr{xMinus1}},
+		Right: &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{y}},
+	}
+
+	goCode, err := NewGenerator(WithValidator()).Generate(input, "main", "f")
+	require.NoError(t, err)
+	// The computation is unchanged
+	assert.Contains(t, goCode, "func f(x float64, y float64) float64 {\n\treturn math.Sqrt(x-1) + math.Log(y)\n}")
+	assert.Contains(t, goCode, `func fValid(x float64, y float64) error {
+	if x-1 < 0 {
+		return fmt.Errorf("f: x - 1 must be >= 0, got %v", x-1)
+	}
+	if y <= 0 {
+		return fmt.Errorf("f: y must be > 0, got %v", y)
+	}
+	return nil
+}`)
+	assert.Contains(t, goCode, "\"fmt\"")
+
+	// Without constraints the validator accepts everything, and fmt is not imported
+	goCode, err = NewGenerator(WithValidator()).Generate(x, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func fValid(x float64) error {\n\treturn nil\n}")
+	assert.NotContains(t, goCode, "\"fmt\"")
+
+	// A constraint met in a case holds only where the case applies, so it is not checked
+	piecewise := &ast.PiecewiseExpr{Cases: []ast.PiecewiseCase{
+		{Value: &ast.FuncCall{FuncName: "ln", Args: []ast.Expr{x}}, Condition: &ast.BinaryExpr{Op: ">", Left: x, Right: &ast.NumberLiteral{Value: 0}}},
+		{Value: &ast.NumberLiteral{Value: 0}},
+	}}
+	goCode, err = NewGenerator(WithValidator()).Generate(piecewise, "main", "f")
+	require.NoError(t, err)
+	assert.Contains(t, goCode, "func fValid(x float64) error {\n\treturn nil\n}")
+
+	_, err = NewGenerator(WithValidator(), WithVectorize()).Generate(input, "main", "f")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "validation functions are not supported in complex, vectorized or generic mode")
+}
+
+func TestGenerator_LimitAtInfinity(t *testing.T) {
+	n := &ast.Variable{Name: "n"}
+	infty := &ast.ConstantExpr{Name: "infty"}
+	limit := &ast.LimitExpr{Var: "n", Approaches: infty, Body: &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{&ast.NumberLiteral{Value: 1}, n}}}
+
+	code, needsMath, err := NewGenerator().GenerateExpr(limit)
+	require.NoError(t, err)
+	assert.True(t, needsMath)
+	assert.Contains(t, code, "previous := eval(1e3)")
+	assert.Contains(t, code, "next := eval(t)")
+	assert.NotContains(t, code, "math.Inf")
+
+	// -\infty is approached through negative values
+	limit.Approaches = &ast.BinaryExpr{Op: "*", Left: &ast.NumberLiteral{Value: -1}, Right: infty}
+	code, _, err = NewGenerator().GenerateExpr(limit)
+	require.NoError(t, err)
+	assert.Contains(t, code, "previous := eval(-1e3)")
+	assert.Contains(t, code, "next := eval(-t)")
+
+	limit.Body = &ast.IndexExpr{Sequence: "x", Index: n}
+	_, _, err = NewGenerator().GenerateExpr(limit)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the limit of a sequence as n \\to \\infty")
+}
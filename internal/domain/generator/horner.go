@@ -0,0 +1,192 @@
+package generator
+
+import (
+	"math"
+	"slices"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// monomial is a term c * x^power of a polynomial in x, with a coefficient free of x.
+type monomial struct {
+	coefficient ast.Expr // nil for 1
+	negative    bool
+	power       int
+}
+
+// optimize rewrites every polynomial of expr in Horner form with WithOptimize:
+// a x^3 + b x^2 + c x + d becomes ((a*x + b)*x + c)*x + d, which takes one
+// multiplication per degree and no math.Pow. Sums are tried whole before their
+// operands, as a partial sum of a polynomial is a polynomial too. Like simplify,
+// only arithmetic and the operands of calls and assignments are visited, and the
+// nodes rewritten are copies.
+func optimize(expr ast.Expr) ast.Expr {
+	switch node := expr.(type) {
+	case *ast.BinaryExpr:
+		if node.Op == "+" || node.Op == "-" {
+			if horner, ok := hornerForm(node); ok {
+				return horner
+			}
+		}
+		b := *node
+		b.Left, b.Right = optimize(node.Left), optimize(node.Right)
+		return &b
+	case *ast.UnaryExpr:
+		u := *node
+		u.Operand = optimize(node.Operand)
+		return &u
+	case *ast.FuncCall:
+		call := *node
+		call.Args = make([]ast.Expr, len(node.Args))
+		for i, arg := range node.Args {
+			call.Args[i] = optimize(arg)
+		}
+		return &call
+	case *ast.BlockExpr:
+		block := ast.BlockExpr{Result: optimize(node.Result)}
+		for _, assignment := range node.Assignments {
+			block.Assignments = append(block.Assignments, &ast.AssignmentExpr{Name: assignment.Name, Value: optimize(assignment.Value)})
+		}
+		return &block
+	}
+	return expr
+}
+
+// hornerForm returns the sum in Horner form if it is a polynomial of degree 2 or
+// more in one of its variables, with at least two terms. The variable is the
+// first, by name, raised to a power in the sum.
+func hornerForm(sum *ast.BinaryExpr) (ast.Expr, bool) {
+	terms := sumTerms(sum)
+	var candidates []string
+	for _, term := range terms {
+		for _, factor := range productFactors(term.expr) {
+			if power, ok := factor.(*ast.BinaryExpr); ok && power.Op == "^" {
+				if v, ok := power.Left.(*ast.Variable); ok {
+					candidates = append(candidates, v.Name)
+				}
+			}
+		}
+	}
+	slices.Sort(candidates)
+	for _, x := range slices.Compact(candidates) {
+		monomials, ok := polynomialIn(terms, x)
+		if !ok {
+			continue
+		}
+		degree, powers := 0, map[int]bool{}
+		for _, m := range monomials {
+			degree = max(degree, m.power)
+			powers[m.power] = true
+		}
+		if degree >= 2 && len(powers) >= 2 {
+			return horner(monomials, degree, &ast.Variable{Name: x}), true
+		}
+	}
+	return nil, false
+}
+
+// signedTerm is a term of a sum, subtracted if negative.
+type signedTerm struct {
+	expr     ast.Expr
+	negative bool
+}
+
+// sumTerms returns the terms of a chain of '+' and '-', left to right. A sum in
+// parentheses on the right of an operator is a single term.
+func sumTerms(expr ast.Expr) []signedTerm {
+	if b, ok := expr.(*ast.BinaryExpr); ok && (b.Op == "+" || b.Op == "-") {
+		return append(sumTerms(b.Left), signedTerm{b.Right, b.Op == "-"})
+	}
+	return []signedTerm{{expr, false}}
+}
+
+// polynomialIn splits each term into a monomial in x, or reports false if one of
+// them is not a monomial: x must only appear as a factor, or raised to a literal
+// non-negative integer. A degree above maxExpandedExponent is not rewritten
+// either, as its Horner form would take one multiplication per degree where
+// math.Pow takes one call.
+func polynomialIn(terms []signedTerm, x string) ([]monomial, bool) {
+	monomials := make([]monomial, len(terms))
+	for i, term := range terms {
+		m := monomial{negative: term.negative}
+		var coefficient []ast.Expr
+		for _, factor := range productFactors(term.expr) {
+			if v, ok := factor.(*ast.Variable); ok && v.Name == x {
+				m.power++
+				continue
+			}
+			if power, ok := factor.(*ast.BinaryExpr); ok && power.Op == "^" {
+				base, baseOK := power.Left.(*ast.Variable)
+				exponent, exponentOK := power.Right.(*ast.NumberLiteral)
+				if baseOK && base.Name == x {
+					if !exponentOK || exponent.Value < 0 || exponent.Value != math.Trunc(exponent.Value) || exponent.Value > maxExpandedExponent {
+						return nil, false
+					}
+					m.power += int(exponent.Value)
+					continue
+				}
+			}
+			if slices.Contains(variableNames(factor), x) {
+				return nil, false
+			}
+			// A literal coefficient carries its sign in negative, and 1 is left out
+			if lit, ok := factor.(*ast.NumberLiteral); ok && lit.Value < 0 {
+				m.negative = !m.negative
+				factor = &ast.NumberLiteral{Value: -lit.Value}
+			}
+			if !isNumber(factor, 1) {
+				coefficient = append(coefficient, factor)
+			}
+		}
+		if m.power > maxExpandedExponent {
+			return nil, false
+		}
+		if len(coefficient) > 0 {
+			m.coefficient = rebuildProduct(coefficient)
+		}
+		monomials[i] = m
+	}
+	return monomials, true
+}
+
+// horner builds the Horner form of a polynomial of the given degree in x: starting
+// from the leading coefficient, multiply by x and add the next coefficient, down to
+// the constant term.
+func horner(monomials []monomial, degree int, x ast.Expr) ast.Expr {
+	coefficients := make([][]monomial, degree+1) // The monomials of each power
+	for _, m := range monomials {
+		coefficients[m.power] = append(coefficients[m.power], m)
+	}
+	leading := coefficients[degree]
+	result := leading[0].coefficient // nil for 1
+	if leading[0].negative {
+		result = product(&ast.NumberLiteral{Value: -1}, result)
+	}
+	for _, m := range leading[1:] {
+		result = addMonomial(orOne(result), m)
+	}
+	for power := degree - 1; power >= 0; power-- {
+		result = product(result, x)
+		for _, m := range coefficients[power] {
+			result = addMonomial(result, m)
+		}
+	}
+	return result
+}
+
+// addMonomial adds or subtracts the coefficient of m to sum.
+func addMonomial(sum ast.Expr, m monomial) ast.Expr {
+	op := "+"
+	if m.negative {
+		op = "-"
+	}
+	return &ast.BinaryExpr{Op: op, Left: sum, Right: orOne(m.coefficient)}
+}
+
+// orOne returns expr, or the literal 1 for nil.
+func orOne(expr ast.Expr) ast.Expr {
+	if expr == nil {
+		return &ast.NumberLiteral{Value: 1}
+	}
+	return expr
+}
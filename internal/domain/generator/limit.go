@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// infiniteTarget reports whether a limit approaches \infty or -\infty, and the sign
+// of the infinity.
+func infiniteTarget(approaches ast.Expr) (sign float64, ok bool) {
+	switch node := approaches.(type) {
+	case *ast.ConstantExpr:
+		return 1, node.Name == "infty"
+	case *ast.UnaryExpr:
+		if node.Op == "-" {
+			sign, ok := infiniteTarget(node.Operand)
+			return -sign, ok
+		}
+	case *ast.BinaryExpr:
+		// -\infty parses as -1 * \infty
+		if lit, isLit := node.Left.(*ast.NumberLiteral); isLit && node.Op == "*" && lit.Value == -1 {
+			sign, ok := infiniteTarget(node.Right)
+			return -sign, ok
+		}
+	}
+	return 0, false
+}
+
+// indexesBy reports whether e takes an element of a sequence at a position
+// depending on the variable name, as x_n does on n.
+func indexesBy(e ast.Expr, name string) bool {
+	found := false
+	inspectNodes(e, func(node ast.Expr) {
+		if index, ok := node.(*ast.IndexExpr); ok && slices.Contains(variableNames(index.Index), name) {
+			found = true
+		}
+	})
+	return found
+}
+
+// limitAtInfinity renders the limit of bodyCode, an expression of variable, as
+// it grows without bound in the direction of sign. There is no point close to
+// an infinite target, so the body is evaluated at 10^3, 10^4, ... until two
+// successive values agree, up to 10^15, short of where float64 stops telling x
+// from x + 1.
+func (g *Generator) limitAtInfinity(variable string, sign float64, bodyCode string) string {
+	first, t := "1e3", "t"
+	if sign < 0 {
+		first, t = "-1e3", "-t"
+	}
+	lines := []string{
+		"func() float64 {",
+		"    // Approximating a limit at infinity by evaluating at growing values until they settle",
+		fmt.Sprintf("    eval := func(%s float64) float64 { return %s } // Expression under the limit", variable, bodyCode),
+		fmt.Sprintf("    previous := eval(%s)", first),
+		"    for t := 1e4; t <= 1e15; t *= 10 {",
+		fmt.Sprintf("        next := eval(%s)", t),
+	}
+	lines = append(lines, g.nonFiniteGuard("        ", "next")...)
+	return strings.Join(append(lines,
+		"        if math.Abs(next-previous) <= 1e-6*math.Max(1, math.Abs(next)) {",
+		"            return next",
+		"        }",
+		"        previous = next",
+		"    }",
+		"    return previous",
+		"}()",
+	), "\n")
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// mathCall matches a reference to a math package identifier, like math.Sqrt.
+var mathCall = regexp.MustCompile(`\bmath\.([A-Za-z0-9_]+)`)
+
+// pureGoHelpers are self-contained replacements for math package functions, keyed
+// by the function name, used when the math package cannot be imported.
+var pureGoHelpers = map[string]struct {
+	name string // Name of the helper replacing math.<key>
+	code string // Source of the helper
+}{
+	"Abs": {"x_abs", `// x_abs returns the absolute value of v, like math.Abs.
+func x_abs(v float64) float64 {
+	if v <= 0 {
+		return 0 - v // 0 - v turns -0 into 0
+	}
+	return v
+}`},
+	"Sqrt": {"x_sqrt", `// x_sqrt returns the square root of v by Newton's method, like math.Sqrt.
+func x_sqrt(v float64) float64 {
+	if v == 0 || v != v || v > 1.7976931348623157e308 {
+		return v // ±0, NaN and +Inf are their own square roots
+	}
+	if v < 0 {
+		var zero float64
+		return zero / zero // NaN
+	}
+	// Starting above the root, the iterates decrease until they reach it
+	r := v
+	if r < 1 {
+		r = 1
+	}
+	for {
+		next := (r + v/r) / 2
+		if next >= r {
+			return r
+		}
+		r = next
+	}
+}`},
+}
+
+// replaceMathCalls rewrites the math package calls in code to the pure-Go helpers
+// and returns the rewritten code together with the source of the helpers it uses,
+// in name order. Identifiers without a helper are an error.
+func replaceMathCalls(code string) (string, string, error) {
+	var used []string
+	for _, match := range mathCall.FindAllStringSubmatch(code, -1) {
+		if _, ok := pureGoHelpers[match[1]]; !ok {
+			supported := make([]string, 0, len(pureGoHelpers))
+			for name := range pureGoHelpers {
+				supported = append(supported, name)
+			}
+			slices.Sort(supported)
+			return "", "", fmt.Errorf("math.%s has no pure-Go replacement without the math import (supported: %s)",
+				match[1], strings.Join(supported, ", "))
+		}
+		if !slices.Contains(used, match[1]) {
+			used = append(used, match[1])
+		}
+	}
+	slices.Sort(used)
+
+	code = mathCall.ReplaceAllStringFunc(code, func(ref string) string {
+		return pureGoHelpers[strings.TrimPrefix(ref, "math.")].name
+	})
+	return code, helperSource(used), nil
+}
+
+// helperSource returns the source of the helpers replacing the named math
+// functions, each preceded by a blank line.
+func helperSource(names []string) string {
+	var helpers strings.Builder
+	for _, name := range names {
+		helpers.WriteString("\n\n" + pureGoHelpers[name].code)
+	}
+	return helpers.String()
+}
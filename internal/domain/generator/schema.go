@@ -0,0 +1,217 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// The JSON Schema of a generated function, from GenerateSchema, describes its
+// arguments as the properties of an object, all of them required, and its result
+// in $defs/result:
+//
+//	{
+//	  "$schema": "https://json-schema.org/draft/2020-12/schema",
+//	  "title": "calculate",
+//	  "type": "object",
+//	  "properties": {"x": {"type": "number", "minimum": 0}},
+//	  "required": ["x"],
+//	  "additionalProperties": false,
+//	  "$defs": {"result": {"type": "number"}}
+//	}
+//
+// A float64 is a number, a bool a boolean and a slice an array of its elements.
+// Two named results, as of \pm, are an object with a property for each, and the
+// error of a checked function is left out. The domain constraints noted with
+// WithDomainNotes restrict the parameter they are about: x >= 0 is a minimum of 0,
+// x != 0 is "not": {"const": 0} and the operand of a factorial is a non-negative
+// integer. Those on an expression of the parameters, such as x - y >= 0, are
+// listed in the description.
+
+// jsonSchemaDialect is the JSON Schema version of the schemas.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is the subset of JSON Schema used to describe generated functions.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum,omitempty"`
+	Not                  *jsonSchema            `json:"not,omitempty"`
+	Const                *float64               `json:"const,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// GenerateSchema returns a JSON Schema describing the parameters and the result
+// of the function that Generate would produce for root. The function is generated
+// without a closure or a context, which are not data.
+func (g *Generator) GenerateSchema(root ast.Expr, funcName string) (string, error) {
+	if g.complex {
+		return "", fmt.Errorf("complex128 values have no JSON Schema type")
+	}
+	plain := *g
+	plain.closure, plain.cancellable, plain.domainNotes = false, false, true
+	goCode, err := plain.Generate(root, "main", funcName)
+	if err != nil {
+		return "", err
+	}
+	file, err := goparser.ParseFile(token.NewFileSet(), "", goCode, goparser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the generated function: %w", err)
+	}
+	var decl *goast.FuncDecl
+	for _, d := range file.Decls {
+		if fn, ok := d.(*goast.FuncDecl); ok && fn.Name.Name == funcName {
+			decl = fn
+		}
+	}
+	if decl == nil {
+		return "", fmt.Errorf("the generated code has no function %s", funcName)
+	}
+
+	noAdditional := false
+	schema := &jsonSchema{
+		Schema:               jsonSchemaDialect,
+		Title:                funcName,
+		Type:                 "object",
+		Properties:           map[string]*jsonSchema{},
+		Required:             []string{},
+		AdditionalProperties: &noAdditional,
+	}
+	for _, field := range decl.Type.Params.List {
+		for _, name := range field.Names {
+			property, err := schemaOf(field.Type)
+			if err != nil {
+				return "", err
+			}
+			schema.Properties[name.Name] = property
+			schema.Required = append(schema.Required, name.Name)
+		}
+	}
+	result, err := resultSchema(decl.Type.Results)
+	if err != nil {
+		return "", err
+	}
+	schema.Defs = map[string]*jsonSchema{"result": result}
+
+	var unattached []string
+	if decl.Doc != nil {
+		for _, comment := range decl.Doc.List {
+			note, ok := strings.CutPrefix(comment.Text, "// Note: ")
+			if ok && !restrictParameter(schema.Properties, note) {
+				unattached = append(unattached, note)
+			}
+		}
+	}
+	if len(unattached) > 0 {
+		schema.Description = "Domain: " + strings.Join(unattached, "; ")
+	}
+
+	var out strings.Builder
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false) // Keep the >= of the constraints readable
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+// schemaOf returns the schema of the values of a Go type of a generated function.
+func schemaOf(typ goast.Expr) (*jsonSchema, error) {
+	switch t := typ.(type) {
+	case *goast.Ident:
+		switch t.Name {
+		case "float64":
+			return &jsonSchema{Type: "number"}, nil
+		case "bool":
+			return &jsonSchema{Type: "boolean"}, nil
+		}
+	case *goast.ArrayType:
+		items, err := schemaOf(t.Elt)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+	}
+	return nil, fmt.Errorf("%s values have no JSON Schema type", goTypeString(typ))
+}
+
+// resultSchema returns the schema of the results of a generated function: that of
+// its value, leaving out an error, or an object of its named results.
+func resultSchema(results *goast.FieldList) (*jsonSchema, error) {
+	var values []*goast.Field
+	for _, field := range results.List {
+		if ident, ok := field.Type.(*goast.Ident); ok && ident.Name == "error" {
+			continue
+		}
+		values = append(values, field)
+	}
+	if len(values) == 1 && len(values[0].Names) <= 1 {
+		return schemaOf(values[0].Type)
+	}
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	for _, field := range values {
+		value, err := schemaOf(field.Type)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range field.Names {
+			schema.Properties[name.Name] = value
+			schema.Required = append(schema.Required, name.Name)
+		}
+	}
+	return schema, nil
+}
+
+// restrictParameter adds the domain constraint of note, such as "x must be >= 0",
+// to the schema of its operand, and reports false if the operand is not one of
+// the parameters in properties.
+func restrictParameter(properties map[string]*jsonSchema, note string) bool {
+	for _, constraint := range []string{nonNegative, positive, nonZero, nonNegativeInteger} {
+		operand, ok := strings.CutSuffix(note, " "+constraint)
+		property, isParam := properties[operand]
+		if !ok || !isParam || (property.Type != "number" && property.Type != "integer") {
+			continue
+		}
+		zero := 0.0
+		switch constraint {
+		case nonNegative:
+			property.Minimum = &zero
+		case positive:
+			property.ExclusiveMinimum = &zero
+		case nonZero:
+			property.Not = &jsonSchema{Const: &zero}
+		case nonNegativeInteger:
+			property.Type, property.Minimum = "integer", &zero
+		}
+		return true
+	}
+	return false
+}
+
+// goTypeString renders a Go type of a generated function for error messages.
+func goTypeString(typ goast.Expr) string {
+	switch t := typ.(type) {
+	case *goast.Ident:
+		return t.Name
+	case *goast.ArrayType:
+		return "[]" + goTypeString(t.Elt)
+	case *goast.FuncType:
+		return "func"
+	case *goast.SelectorExpr:
+		return goTypeString(t.X) + "." + t.Sel.Name
+	}
+	return fmt.Sprintf("%T", typ)
+}
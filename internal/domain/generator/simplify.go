@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// rewriteRule is an algebraic identity applied by WithSimplify: apply returns the
+// rewritten expression and true when the rule matches expr.
+type rewriteRule struct {
+	name  string
+	apply func(expr ast.Expr) (ast.Expr, bool)
+}
+
+// simplificationRules are the identities applied by WithSimplify, tried in order on
+// every node once its operands are simplified. A quotient is either \frac{a}{b} or
+// a / b. 0 * x -> 0 assumes x is finite, as x may be NaN or ±Inf at runtime.
+var simplificationRules = []rewriteRule{
+	{"(a/b) / (c/d) -> (a*d) / (b*c)", flattenQuotient},
+	{"x / 1 -> x", func(expr ast.Expr) (ast.Expr, bool) {
+		if num, den, ok := quotientOperands(expr); ok && isNumber(den, 1) {
+			return num, true
+		}
+		return nil, false
+	}},
+	{"0 * x -> 0", func(expr ast.Expr) (ast.Expr, bool) {
+		if b, ok := expr.(*ast.BinaryExpr); ok && b.Op == "*" && (isNumber(b.Left, 0) || isNumber(b.Right, 0)) {
+			return &ast.NumberLiteral{Value: 0}, true
+		}
+		return nil, false
+	}},
+	{"1 * x -> x", func(expr ast.Expr) (ast.Expr, bool) {
+		if b, ok := expr.(*ast.BinaryExpr); ok && b.Op == "*" {
+			if isNumber(b.Left, 1) {
+				return b.Right, true
+			}
+			if isNumber(b.Right, 1) {
+				return b.Left, true
+			}
+		}
+		return nil, false
+	}},
+	{"x + 0 -> x", func(expr ast.Expr) (ast.Expr, bool) {
+		if b, ok := expr.(*ast.BinaryExpr); ok && (b.Op == "+" || b.Op == "-") {
+			if isNumber(b.Right, 0) {
+				return b.Left, true
+			}
+			if b.Op == "+" && isNumber(b.Left, 0) {
+				return b.Right, true
+			}
+		}
+		return nil, false
+	}},
+}
+
+// simplify rewrites expr with simplificationRules, bottom-up, until no rule
+// matches. The tree is not modified: rewritten nodes are copies. Only arithmetic
+// and the operands of calls, sums, integrals, derivatives and assignments are
+// visited; other nodes are kept as they are.
+func simplify(expr ast.Expr) ast.Expr {
+	switch node := expr.(type) {
+	case *ast.BinaryExpr:
+		b := *node
+		b.Left, b.Right = simplify(node.Left), simplify(node.Right)
+		expr = &b
+	case *ast.UnaryExpr:
+		u := *node
+		u.Operand = simplify(node.Operand)
+		expr = &u
+	case *ast.FuncCall:
+		call := *node
+		call.Args = simplifyAll(node.Args)
+		expr = &call
+	case *ast.SumExpr:
+		sum := *node
+		sum.Lower, sum.Upper = simplifyOptional(node.Lower), simplifyOptional(node.Upper)
+		sum.Values = simplifyAll(node.Values)
+		sum.Filter, sum.Body = simplifyOptional(node.Filter), simplify(node.Body)
+		expr = &sum
+	case *ast.IntegralExpr:
+		integral := *node
+		integral.Lower, integral.Upper = simplifyOptional(node.Lower), simplifyOptional(node.Upper)
+		integral.Body = simplify(node.Body)
+		expr = &integral
+	case *ast.DerivativeExpr:
+		derivative := *node
+		derivative.Body = simplify(node.Body)
+		expr = &derivative
+	case *ast.BlockExpr:
+		block := ast.BlockExpr{Result: simplify(node.Result)}
+		for _, assignment := range node.Assignments {
+			block.Assignments = append(block.Assignments, &ast.AssignmentExpr{Name: assignment.Name, Value: simplify(assignment.Value)})
+		}
+		return &block
+	default:
+		return expr
+	}
+	for _, rule := range simplificationRules {
+		if rewritten, ok := rule.apply(expr); ok {
+			// The result may combine operands that match a rule in turn
+			return simplify(rewritten)
+		}
+	}
+	return expr
+}
+
+// simplifyOptional simplifies expr, which may be nil.
+func simplifyOptional(expr ast.Expr) ast.Expr {
+	if expr == nil {
+		return nil
+	}
+	return simplify(expr)
+}
+
+// simplifyAll simplifies each of exprs into a new slice, nil for nil.
+func simplifyAll(exprs []ast.Expr) []ast.Expr {
+	if exprs == nil {
+		return nil
+	}
+	simplified := make([]ast.Expr, len(exprs))
+	for i, e := range exprs {
+		simplified[i] = simplify(e)
+	}
+	return simplified
+}
+
+// flattenQuotient turns a quotient with a quotient as numerator or denominator into
+// a single \frac: (a/b) / d -> a / (b*d), n / (c/d) -> (n*d) / c and
+// (a/b) / (c/d) -> (a*d) / (b*c).
+func flattenQuotient(expr ast.Expr) (ast.Expr, bool) {
+	num, den, ok := quotientOperands(expr)
+	if !ok {
+		return nil, false
+	}
+	numNum, numDen, numIsQuotient := quotientOperands(num)
+	denNum, denDen, denIsQuotient := quotientOperands(den)
+	if !numIsQuotient && !denIsQuotient {
+		return nil, false
+	}
+	if !numIsQuotient {
+		numNum, numDen = num, nil
+	}
+	if !denIsQuotient {
+		denNum, denDen = den, nil
+	}
+	return &ast.FuncCall{FuncName: "frac", Args: []ast.Expr{
+		product(numNum, denDen),
+		product(numDen, denNum),
+	}}, true
+}
+
+// quotientOperands returns the numerator and denominator of \frac{a}{b} or a / b.
+func quotientOperands(expr ast.Expr) (num, den ast.Expr, ok bool) {
+	switch node := expr.(type) {
+	case *ast.FuncCall:
+		if node.FuncName == "frac" && len(node.Args) == 2 {
+			return node.Args[0], node.Args[1], true
+		}
+	case *ast.BinaryExpr:
+		if node.Op == "/" {
+			return node.Left, node.Right, true
+		}
+	}
+	return nil, nil, false
+}
+
+// product returns a * b, or the other factor when one of them is nil.
+func product(a, b ast.Expr) ast.Expr {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return &ast.BinaryExpr{Op: "*", Left: a, Right: b}
+}
+
+// isNumber reports whether expr is the number literal value.
+func isNumber(expr ast.Expr, value float64) bool {
+	num, ok := expr.(*ast.NumberLiteral)
+	return ok && num.Value == value
+}
@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// compositeFuncs maps the special functions without a math package counterpart
+// to the body of a Go function of their argument v, called in place:
+// \operatorname{sinc}(x) is func(v float64) float64 { ... }(x), evaluating a
+// compound argument once.
+var compositeFuncs = map[string]string{
+	// The unnormalized sinc sin(v)/v, which is 1 at 0, its limit
+	"sinc": "if v == 0 { return 1 }; return math.Sin(v) / v",
+	// The logistic sigmoid 1/(1 + e^-v)
+	"logistic": "return 1 / (1 + math.Exp(-v))",
+	// ln(1 + e^v), rewritten for v > 0 so that e^v does not overflow
+	"softplus": "if v > 0 { return v + math.Log1p(math.Exp(-v)) }; return math.Log1p(math.Exp(v))",
+	// The rectifier max(0, v)
+	"relu": "return math.Max(0, v)",
+}
+
+// generateCompositeFunc generates a call to one of compositeFuncs.
+func (g *Generator) generateCompositeFunc(node *ast.FuncCall) (string, bool, error) {
+	if len(node.Args) != 1 {
+		return "", false, fmt.Errorf("\\operatorname{%s} requires 1 argument, got %d", node.FuncName, len(node.Args))
+	}
+	argCode, _, err := g.generateExpr(node.Args[0])
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("func(v float64) float64 { %s }(%s)", compositeFuncs[node.FuncName], argCode), true, nil
+}
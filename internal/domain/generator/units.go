@@ -0,0 +1,306 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// dimension holds the exponents of the SI base units, in the order of baseUnits,
+// and the scale of the unit relative to their product, such as 1000 for km.
+type dimension struct {
+	exps  [7]int
+	scale float64 // 0 stands for 1, so that the zero dimension is a plain number
+}
+
+// unit returns the dimension of a unit of the given scale and base unit exponents.
+func unit(scale float64, exps ...int) dimension {
+	d := dimension{scale: scale}
+	copy(d.exps[:], exps)
+	return d
+}
+
+// baseUnits are the symbols of the SI base units, with the names used in errors.
+var baseUnits = [7]struct{ symbol, name string }{
+	{"m", "meters"}, {"kg", "kilograms"}, {"s", "seconds"}, {"A", "amperes"},
+	{"K", "kelvins"}, {"mol", "moles"}, {"cd", "candelas"},
+}
+
+// unitDimensions maps the known unit symbols to their dimension. km and m are
+// both lengths, but of different scales.
+var unitDimensions = map[string]dimension{
+	"m": unit(1, 1), "km": unit(1e3, 1), "cm": unit(1e-2, 1), "mm": unit(1e-3, 1),
+	"kg": unit(1, 0, 1), "g": unit(1e-3, 0, 1),
+	"s": unit(1, 0, 0, 1), "ms": unit(1e-3, 0, 0, 1), "min": unit(60, 0, 0, 1), "h": unit(3600, 0, 0, 1),
+	"A": unit(1, 0, 0, 0, 1), "K": unit(1, 0, 0, 0, 0, 1), "mol": unit(1, 0, 0, 0, 0, 0, 1), "cd": unit(1, 0, 0, 0, 0, 0, 0, 1),
+	"Hz": unit(1, 0, 0, -1),
+	"N":  unit(1, 1, 1, -2),
+	"Pa": unit(1, -1, 1, -2),
+	"J":  unit(1, 2, 1, -2),
+	"W":  unit(1, 2, 1, -3),
+	"C":  unit(1, 0, 0, 1, 1),
+	"V":  unit(1, 2, 1, -3, -1),
+}
+
+func (d dimension) times(o dimension) dimension {
+	for i := range d.exps {
+		d.exps[i] += o.exps[i]
+	}
+	d.scale = d.factor() * o.factor()
+	return d
+}
+
+func (d dimension) pow(n int) dimension {
+	for i := range d.exps {
+		d.exps[i] *= n
+	}
+	d.scale = math.Pow(d.factor(), float64(n))
+	return d
+}
+
+// factor returns the scale of d, 1 for the zero value.
+func (d dimension) factor() float64 {
+	if d.scale == 0 {
+		return 1
+	}
+	return d.scale
+}
+
+// sameScale reports whether d and o are units of the same scale, up to rounding:
+// 1000 km/m and 1 are.
+func (d dimension) sameScale(o dimension) bool {
+	return math.Abs(d.factor()-o.factor()) <= 1e-9*math.Max(d.factor(), o.factor())
+}
+
+// String renders d as its unit, e.g. "kg m/s^2", naming a single base unit in full
+// ("meters") and a dimensionless quantity as "a dimensionless number".
+func (d dimension) String() string {
+	var num, den []string
+	for i, exp := range d.exps {
+		factor := baseUnits[i].symbol
+		if exp > 1 || exp < -1 {
+			factor += "^" + strconv.Itoa(max(exp, -exp))
+		}
+		if exp > 0 {
+			num = append(num, factor)
+		} else if exp < 0 {
+			den = append(den, factor)
+		}
+	}
+	switch {
+	case len(num) == 0 && len(den) == 0:
+		return "a dimensionless number"
+	case len(num) == 1 && len(den) == 0 && !strings.Contains(num[0], "^"):
+		for _, base := range baseUnits {
+			if base.symbol == num[0] {
+				return base.name
+			}
+		}
+	case len(num) == 0:
+		num = []string{"1"}
+	}
+	unit := strings.Join(num, " ")
+	if len(den) > 0 {
+		unit += "/" + strings.Join(den, " ")
+	}
+	return unit
+}
+
+// parseUnit returns the dimension of a unit as written in a unit annotation: unit
+// symbols with optional integer exponents (s^2, m^{-1}), separated by spaces or
+// '*' and divided by at most one '/'.
+func parseUnit(unit string) (dimension, error) {
+	var d dimension
+	num, den, divided := strings.Cut(unit, "/")
+	for side, part := range []string{num, den} {
+		if side == 1 && !divided {
+			break
+		}
+		for _, factor := range strings.Fields(strings.ReplaceAll(part, "*", " ")) {
+			symbol, expText, hasExp := strings.Cut(factor, "^")
+			exp := 1
+			if hasExp {
+				n, err := strconv.Atoi(strings.Trim(expText, "{}"))
+				if err != nil {
+					return d, fmt.Errorf("invalid exponent in unit %q", unit)
+				}
+				exp = n
+			}
+			dim, ok := unitDimensions[symbol]
+			if !ok {
+				return d, fmt.Errorf("unknown unit %s", symbol)
+			}
+			if side == 1 {
+				exp = -exp
+			}
+			d = d.times(dim.pow(exp))
+		}
+	}
+	return d, nil
+}
+
+// checkUnits checks that the units annotated in e are consistent: sums, differences,
+// comparisons and remainders combine quantities of the same dimension, while products,
+// quotients and integer powers combine their dimensions. Numbers are dimensionless and
+// variables have an unknown dimension, which is compatible with any other.
+func checkUnits(e ast.Expr) error {
+	_, _, err := unitsOf(e, map[string]dimension{})
+	return err
+}
+
+// unitsOf returns the dimension of e and whether it is known. Assignments record
+// the dimension of their value in env for the names they bind.
+func unitsOf(e ast.Expr, env map[string]dimension) (dimension, bool, error) {
+	switch n := e.(type) {
+	case *ast.NumberLiteral, *ast.ConstantExpr:
+		return dimension{}, true, nil
+	case *ast.Variable:
+		d, ok := env[n.Name]
+		return d, ok, nil
+	case *ast.UnitExpr:
+		unit, err := parseUnit(n.Unit)
+		if err != nil {
+			return dimension{}, false, err
+		}
+		value, known, err := unitsOf(n.Value, env)
+		if err != nil || !known {
+			return unit, true, err
+		}
+		return value.times(unit), true, nil
+	case *ast.BlockExpr:
+		for _, a := range n.Assignments {
+			d, known, err := unitsOf(a.Value, env)
+			if err != nil {
+				return dimension{}, false, err
+			}
+			if known {
+				env[a.Name] = d
+			}
+		}
+		return unitsOf(n.Result, env)
+	case *ast.UnaryExpr:
+		return unitsOf(n.Operand, env)
+	case *ast.RelationalChain:
+		for i := 1; i < len(n.Operands); i++ {
+			if _, _, err := sameUnits("compare", n.Operands[i-1], n.Operands[i], env); err != nil {
+				return dimension{}, false, err
+			}
+		}
+		return dimension{}, false, nil
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case "+", "-", "mod":
+			return sameUnits(n.Op, n.Left, n.Right, env)
+		case "±", "∓":
+			return sameUnits("+", n.Left, n.Right, env)
+		case "<", "<=", ">", ">=", "!=", "==":
+			_, _, err := sameUnits("compare", n.Left, n.Right, env)
+			return dimension{}, false, err
+		case "*", "/":
+			return combinedUnits(n.Op, n.Left, n.Right, env)
+		case "^":
+			base, known, err := unitsOf(n.Left, env)
+			if err != nil {
+				return dimension{}, false, err
+			}
+			if _, _, err := unitsOf(n.Right, env); err != nil {
+				return dimension{}, false, err
+			}
+			exp, ok := n.Right.(*ast.NumberLiteral)
+			if !known || !ok || exp.Value != math.Trunc(exp.Value) {
+				return dimension{}, base.exps == [7]int{} && known, nil
+			}
+			return base.pow(int(exp.Value)), true, nil
+		}
+		_, _, err := combinedUnits(n.Op, n.Left, n.Right, env)
+		return dimension{}, false, err
+	case *ast.FuncCall:
+		if n.FuncName == "frac" && len(n.Args) == 2 {
+			return combinedUnits("/", n.Args[0], n.Args[1], env)
+		}
+		var argDim dimension
+		var argKnown bool
+		for i, arg := range n.Args {
+			d, known, err := unitsOf(arg, env)
+			if err != nil {
+				return dimension{}, false, err
+			}
+			if i == 0 {
+				argDim, argKnown = d, known
+			}
+		}
+		switch {
+		case n.FuncName == "abs" && len(n.Args) == 1:
+			return argDim, argKnown, nil
+		case n.FuncName == "sqrt" && len(n.Args) == 1 && argKnown:
+			for i, exp := range argDim.exps {
+				if exp%2 != 0 {
+					return dimension{}, false, fmt.Errorf("cannot take the square root of %s", argDim)
+				}
+				argDim.exps[i] = exp / 2
+			}
+			argDim.scale = math.Sqrt(argDim.factor())
+			return argDim, true, nil
+		}
+	}
+	// Other constructs have an unknown dimension
+	return dimension{}, false, nil
+}
+
+// sameUnits checks that left and right, combined by op, have the same dimension
+// when both are known, and returns it.
+func sameUnits(op string, left, right ast.Expr, env map[string]dimension) (dimension, bool, error) {
+	l, lKnown, err := unitsOf(left, env)
+	if err != nil {
+		return dimension{}, false, err
+	}
+	r, rKnown, err := unitsOf(right, env)
+	if err != nil {
+		return dimension{}, false, err
+	}
+	if lKnown && rKnown && l.exps == r.exps && !l.sameScale(r) {
+		verbs := map[string]string{"+": "add", "-": "subtract", "mod": "reduce"}
+		verb, ok := verbs[op]
+		if !ok {
+			verb = "compare"
+		}
+		ratio := math.Max(l.factor(), r.factor()) / math.Min(l.factor(), r.factor())
+		return dimension{}, false, fmt.Errorf("cannot %s %s in units %.6g times apart: convert them to one unit first", verb, l, ratio)
+	}
+	if lKnown && rKnown && l.exps != r.exps {
+		switch op {
+		case "+":
+			return dimension{}, false, fmt.Errorf("cannot add %s to %s", l, r)
+		case "-":
+			return dimension{}, false, fmt.Errorf("cannot subtract %s from %s", r, l)
+		case "mod":
+			return dimension{}, false, fmt.Errorf("cannot reduce %s modulo %s", l, r)
+		default:
+			return dimension{}, false, fmt.Errorf("cannot compare %s with %s", l, r)
+		}
+	}
+	if lKnown {
+		return l, true, nil
+	}
+	return r, rKnown, nil
+}
+
+// combinedUnits returns the dimension of the product or quotient of left and right,
+// known only when both are.
+func combinedUnits(op string, left, right ast.Expr, env map[string]dimension) (dimension, bool, error) {
+	l, lKnown, err := unitsOf(left, env)
+	if err != nil {
+		return dimension{}, false, err
+	}
+	r, rKnown, err := unitsOf(right, env)
+	if err != nil {
+		return dimension{}, false, err
+	}
+	if op == "/" {
+		r = r.pow(-1)
+	}
+	return l.times(r), lKnown && rKnown, nil
+}
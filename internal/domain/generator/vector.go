@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// A decorated vector, \vec{v}, \overrightarrow{AB} or \hat{n}, is a vector when the
+// whole equation is vector arithmetic: sums and differences of vectors, their
+// negations, and their products with or quotients by scalars, as in
+// \vec{a} + k \cdot \hat{n}. The function then takes each vector as a []float64,
+// all of the same length, and returns the []float64 of the elementwise results;
+// \hat{n} is n divided by its Euclidean norm, computed once ahead of the loop.
+//
+// Anywhere else the decoration is transparent: in \sin(\vec{\theta}) or
+// \vec{v} > 0, \vec{v} is the scalar variable v, and \hat{x} is x, as when the hat
+// marks an estimate.
+
+// vectorArithmetic reports whether expr is vector arithmetic, whose result is a
+// vector. It fails on the operations that mix vectors and scalars without one,
+// such as the sum of a vector and a scalar.
+func vectorArithmetic(expr ast.Expr) (bool, error) {
+	switch node := expr.(type) {
+	case *ast.VectorExpr:
+		return true, nil
+	case *ast.BinaryExpr:
+		if !strings.Contains("+-*/", node.Op) {
+			return false, nil
+		}
+		left, err := vectorArithmetic(node.Left)
+		if err != nil {
+			return false, err
+		}
+		right, err := vectorArithmetic(node.Right)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case !left && !right:
+			return false, nil
+		case (node.Op == "+" || node.Op == "-") && left != right:
+			return false, fmt.Errorf("cannot add a vector and a scalar: every term of a sum of vectors must be a vector")
+		case node.Op == "*" && left && right:
+			return false, fmt.Errorf("the product of two vectors is ambiguous: write \\braket{a|b} for their inner product")
+		case node.Op == "/" && right:
+			return false, fmt.Errorf("cannot divide by a vector")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// vectorOperands returns the decorated vectors of the vector arithmetic expr that
+// are vectors, rather than transparent scalars, in order of appearance.
+func vectorOperands(expr ast.Expr) []*ast.VectorExpr {
+	var operands []*ast.VectorExpr
+	var walk func(e ast.Expr)
+	walk = func(e ast.Expr) {
+		switch node := e.(type) {
+		case *ast.VectorExpr:
+			operands = append(operands, node)
+		case *ast.BinaryExpr:
+			if isVector, _ := vectorArithmetic(node); isVector {
+				walk(node.Left)
+				walk(node.Right)
+			}
+		}
+	}
+	walk(expr)
+	return operands
+}
+
+// vectorNames returns the names of the vectors among operands, and those of the
+// ones normalized with \hat, in order of appearance and without duplicates.
+func vectorNames(operands []*ast.VectorExpr) (vectors, units []string) {
+	for _, operand := range operands {
+		name := sanitizeVariableName(operand.Name)
+		if !slices.Contains(vectors, name) {
+			vectors = append(vectors, name)
+		}
+		if operand.Unit && !slices.Contains(units, name) {
+			units = append(units, name)
+		}
+	}
+	return vectors, units
+}
+
+// generateVectorElement generates the element at index of the vector arithmetic
+// expr. Unit vectors are divided by the variables named in norms, and scalar
+// operands are generated as usual, with their decorated vectors transparent.
+func (g *Generator) generateVectorElement(expr ast.Expr, index string, norms map[string]string) (string, bool, error) {
+	if isVector, _ := vectorArithmetic(expr); !isVector {
+		return g.generateExpr(expr)
+	}
+	switch node := expr.(type) {
+	case *ast.VectorExpr:
+		name := sanitizeVariableName(node.Name)
+		elem := fmt.Sprintf("%s[%s]", name, index)
+		if node.Unit {
+			return elem + " / " + norms[name], false, nil
+		}
+		return elem, false, nil
+	case *ast.BinaryExpr:
+		if isNegation(node) {
+			operandCode, needsMath, err := g.generateVectorElement(node.Right, index, norms)
+			if err != nil {
+				return "", false, err
+			}
+			if _, ok := g.operandPrecedence(node.Right); ok {
+				operandCode = "(" + operandCode + ")"
+			}
+			return "-" + operandCode, needsMath, nil
+		}
+		leftCode, leftNeedsMath, err := g.generateVectorElement(node.Left, index, norms)
+		if err != nil {
+			return "", false, err
+		}
+		rightCode, rightNeedsMath, err := g.generateVectorElement(node.Right, index, norms)
+		if err != nil {
+			return "", false, err
+		}
+		if node.Op == "/" {
+			g.requireDomain(rightCode, nonZero)
+		}
+		prec := goPrecedence(node.Op)
+		if leftPrec, ok := g.operandPrecedence(node.Left); ok && leftPrec < prec {
+			leftCode = "(" + leftCode + ")"
+		}
+		if rightPrec, ok := g.operandPrecedence(node.Right); ok && rightPrec <= prec {
+			rightCode = "(" + rightCode + ")"
+		}
+		return fmt.Sprintf("%s %s %s", leftCode, node.Op, rightCode), leftNeedsMath || rightNeedsMath, nil
+	}
+	return "", false, fmt.Errorf("unsupported vector expression %T", expr)
+}
+
+// vectorBody renders the statements of a function returning the vector arithmetic
+// whose element at index is elemCode: the vectors must have the same length, and
+// the norms of the unit vectors are computed ahead of the loop.
+func vectorBody(funcName string, vectors, units []string, norms map[string]string, index, elemCode string, taken []string) string {
+	taken = append(slices.Clone(taken), index)
+	for _, name := range units {
+		taken = append(taken, norms[name])
+	}
+	out := unusedName("out", taken)
+	var lines []string
+	for _, name := range vectors[1:] {
+		lines = append(lines,
+			fmt.Sprintf("if len(%s) != len(%s) {", name, vectors[0]),
+			fmt.Sprintf("\tpanic(\"%s: %s and %s have different lengths\")", funcName, name, vectors[0]),
+			"}")
+	}
+	for _, name := range units {
+		lines = append(lines, fmt.Sprintf("%s := %s", norms[name], generateNorm(&ast.NormExpr{Vector: name, Order: 2})))
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s := make([]float64, len(%s))", out, vectors[0]),
+		fmt.Sprintf("for %s := range %s {", index, out),
+		fmt.Sprintf("\t%s[%s] = %s", out, index, elemCode),
+		"}",
+		"return "+out)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseBraceAnnotation handles \overbrace{X}^{label} and \underbrace{X}_{label},
+// which annotate X without changing its value: the result is X itself. The label
+// is the script following the brace group, '^' for \overbrace and '_' for
+// \underbrace, and is skipped rather than parsed, so it may hold any text. Without
+// the script the brace is just a group. The parser is expected to be positioned
+// on the command.
+func (p *Parser) parseBraceAnnotation(funcName string) (internalast.Expr, error) {
+	expr, err := p.parseAnnotatedGroup(funcName)
+	if err != nil {
+		return nil, err
+	}
+
+	script := CARET
+	if funcName == "underbrace" {
+		script = UNDERSCORE
+	}
+	if p.peekToken.Type != script {
+		return expr, nil
+	}
+	p.nextToken() // move to the script
+	if err := p.skipLabel(funcName); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// cancelCommands strike through their argument to show a cancellation, as in
+// \frac{\cancel{x} y}{\cancel{x}}. Unlike styling commands they may hold any
+// expression.
+var cancelCommands = map[string]bool{
+	"cancel":  true, // Diagonal stroke up
+	"bcancel": true, // Diagonal stroke down
+	"xcancel": true, // Both strokes
+}
+
+// parseCancel handles \cancel{X}, \bcancel{X} and \xcancel{X}, which only mark X
+// as cancelled: the result is X itself. The parser is expected to be positioned
+// on the command.
+func (p *Parser) parseCancel(funcName string) (internalast.Expr, error) {
+	return p.parseAnnotatedGroup(funcName)
+}
+
+// parseAnnotatedGroup parses the brace group following an annotating command and
+// returns the expression inside. It leaves the parser on the closing '}'.
+func (p *Parser) parseAnnotatedGroup(funcName string) (internalast.Expr, error) {
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\%s", funcName)
+	}
+	p.nextToken() // move to the annotated expression
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after the expression of \\%s", funcName)
+	}
+	return expr, nil
+}
+
+// skipLabel consumes the label of a brace annotation: a balanced brace group or a
+// single token. It is called positioned on the script token and leaves the parser
+// on the last token of the label.
+func (p *Parser) skipLabel(funcName string) error {
+	p.nextToken() // move to the label
+	if p.curToken.Type != LBRACE {
+		if p.curToken.Type == EOF {
+			p.addError("expected a label after the script of \\%s", funcName)
+			return fmt.Errorf("expected a label after the script of \\%s", funcName)
+		}
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		p.nextToken()
+		switch p.curToken.Type {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		case EOF:
+			p.addError("missing '}' after the label of \\%s", funcName)
+			return fmt.Errorf("missing '}' after the label of \\%s", funcName)
+		}
+	}
+	return nil
+}
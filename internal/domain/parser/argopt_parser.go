@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseArgOptExpression handles parsing of argmin/argmax expressions like:
+// \argmin_{x \in [a, b]} f(x), \arg\max_{x} f(x) or \argmin_x f(x)
+// The parser is expected to be positioned on the last token of the operator name.
+func (p *Parser) parseArgOptExpression(isMax bool) (internalast.Expr, error) {
+	opName := "argmin"
+	if isMax {
+		opName = "argmax"
+	}
+
+	// Expect subscript with the optimization variable: _x or _{x ...}
+	if p.peekToken.Type != UNDERSCORE {
+		p.addError("expected '_' with optimization variable after \\%s", opName)
+		return nil, fmt.Errorf("expected '_' with optimization variable after \\%s", opName)
+	}
+	p.nextToken() // consume '_'
+
+	braced := p.peekToken.Type == LBRACE
+	if braced {
+		p.nextToken() // consume '{'
+	}
+	p.nextToken() // move to variable
+	if p.curToken.Type != IDENT {
+		p.addError("expected identifier for optimization variable in \\%s", opName)
+		return nil, fmt.Errorf("expected identifier for optimization variable in \\%s", opName)
+	}
+	expr := &internalast.ArgOptExpr{
+		IsMax: isMax,
+		Var:   p.curToken.Literal,
+	}
+
+	if braced {
+		// Optional search domain: \in [lower, upper]
+		if p.peekToken.Type == COMMAND && p.peekToken.Literal == "in" {
+			p.nextToken() // consume '\in'
+			if !p.expectPeek(LBRACKET) {
+				return nil, fmt.Errorf("expected '[' to open the domain of \\%s", opName)
+			}
+			p.nextToken() // move to lower bound
+			lower, err := p.parseExpression(LOWEST)
+			if err != nil {
+				return nil, err
+			}
+			if !p.expectPeek(COMMA) {
+				return nil, fmt.Errorf("expected ',' between domain bounds of \\%s", opName)
+			}
+			p.nextToken() // move to upper bound
+			upper, err := p.parseExpression(LOWEST)
+			if err != nil {
+				return nil, err
+			}
+			if !p.expectPeek(RBRACKET) {
+				return nil, fmt.Errorf("expected ']' to close the domain of \\%s", opName)
+			}
+			expr.Lower, expr.Upper = lower, upper
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after subscript of \\%s", opName)
+		}
+	}
+
+	p.nextToken() // move to body
+	body, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	expr.Body = body
+	return expr, nil
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// An annotated arrow, (1 + 1/n)^n \xrightarrow{n \to \infty} e, states that its
+// source tends to its target as the variable of the annotation approaches a value.
+// It parses as the limit of the source, \lim_{n \to \infty} (1 + 1/n)^n, which the
+// generated function computes: the target only states its value, and is parsed
+// but not kept. \xleftarrow points the other way, with the source on its right.
+// The arrow binds the loosest, and must make the whole expression, since inside a
+// larger one it would be unclear whether it stands for the limit or its target. Any
+// other annotation, as in A \xrightarrow{f} B or \xrightarrow{}, labels a map
+// between its sides, which has no value, and is an error.
+
+// parseAnnotatedArrow parses the annotation and the other side of an annotated
+// arrow following left. The parser is expected to be positioned on the arrow and
+// is left on the last token of the other side.
+func (p *Parser) parseAnnotatedArrow(left internalast.Expr) (internalast.Expr, error) {
+	arrow := p.curToken.Literal
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\%s", arrow)
+	}
+
+	next := p.lookahead(1)
+	if p.peekToken.Type != IDENT || len(next) != 1 || !isStrayTo(next[0]) {
+		err := fmt.Errorf("\\%s is only supported with a limit annotation, as in a_n \\%s{n \\to \\infty} L: an arrow labeled otherwise has no value to compute",
+			arrow, arrow)
+		p.addError("%s", err.Error())
+		return nil, err
+	}
+	varName, approaches, direction, err := p.parseLimitSubscript()
+	if err != nil {
+		return nil, err
+	}
+
+	p.nextToken() // move to the other side
+	right, err := p.parseExpression(ANNOTATED_ARROW)
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type != EOF {
+		err := fmt.Errorf("\\%s{%s \\to ...} must be the whole expression: its value is the limit of one side, stated to be the other",
+			arrow, varName)
+		p.addError("%s", err.Error())
+		return nil, err
+	}
+	source := left
+	if arrow == "xleftarrow" {
+		source = right
+	}
+	return &internalast.LimitExpr{Var: varName, Approaches: approaches, Direction: direction, Body: source}, nil
+}
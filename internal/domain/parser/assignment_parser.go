@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseStatements parses the top level of an equation: zero or more assignments
+// "name = value", each terminated by ';', followed by the final expression, as in
+// u = x^2; v = u + 1; \sqrt{v}. A name may be assigned only once. Without
+// assignments the final expression is returned as is; otherwise the result is a
+// BlockExpr. It is called positioned on the first token and leaves the parser on
+// the last token of the final expression.
+func (p *Parser) parseStatements() (internalast.Expr, error) {
+	// The whole equation may be wrapped in \begin{equation} or \begin{align}
+	if p.curToken.Type == BEGIN {
+		if envName, ok := p.peekDisplayEnvironment(); ok {
+			return p.parseDisplayEnvironment(envName)
+		}
+	}
+
+	var assignments []*internalast.AssignmentExpr
+	assigned := make(map[string]bool)
+	for p.curToken.Type == IDENT && p.peekToken.Type == EQUALS {
+		name := p.curToken.Literal
+		if assigned[name] {
+			p.addError("%s is assigned more than once", name)
+			return nil, fmt.Errorf("%s is assigned more than once", name)
+		}
+		p.nextToken() // move to '='
+		p.nextToken() // move to the value
+		value, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(SEMICOLON) {
+			return nil, fmt.Errorf("expected ';' after the assignment to %s", name)
+		}
+		p.nextToken() // move to the next statement
+		assignments = append(assignments, &internalast.AssignmentExpr{Name: name, Value: value})
+		assigned[name] = true
+	}
+
+	if len(assignments) > 0 && p.curToken.Type == EOF {
+		p.addError("expected an expression after the assignments")
+		return nil, fmt.Errorf("expected an expression after the assignments")
+	}
+	result, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if len(assignments) == 0 {
+		return result, nil
+	}
+	return &internalast.BlockExpr{Assignments: assignments, Result: result}, nil
+}
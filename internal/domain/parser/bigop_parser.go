@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseOperatorWithLimits handles \operatorname*{name}_{i=1}^{n} body, a named operator
+// taking its index in a subscript. \operatorname*{argmin} and \operatorname*{argmax} are
+// the usual argmin/argmax; any other name becomes a BigOpExpr reduced by the generator.
+// The parser is expected to be positioned on \operatorname, with '*' as the next token.
+func (p *Parser) parseOperatorWithLimits() (internalast.Expr, error) {
+	p.nextToken() // consume '*'
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\operatorname*")
+	}
+	var name strings.Builder
+	for p.peekToken.Type == IDENT {
+		p.nextToken()
+		name.WriteString(p.curToken.Literal)
+	}
+	if name.Len() == 0 {
+		p.addError("expected operator name after \\operatorname*")
+		return nil, fmt.Errorf("expected operator name after \\operatorname*")
+	}
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after operator name in \\operatorname*")
+	}
+	opName := name.String()
+	if opName == "argmin" || opName == "argmax" {
+		return p.parseArgOptExpression(opName == "argmax")
+	}
+
+	// Subscript with the index: _i, _{i} or _{i=1}
+	if p.peekToken.Type != UNDERSCORE {
+		p.addError("expected '_' with index variable after \\operatorname*{%s}", opName)
+		return nil, fmt.Errorf("expected '_' with index variable after \\operatorname*{%s}", opName)
+	}
+	p.nextToken() // consume '_'
+	braced := p.peekToken.Type == LBRACE
+	if braced {
+		p.nextToken() // consume '{'
+	}
+	p.nextToken() // move to variable
+	if p.curToken.Type != IDENT {
+		p.addError("expected identifier for index variable in \\operatorname*{%s}", opName)
+		return nil, fmt.Errorf("expected identifier for index variable in \\operatorname*{%s}", opName)
+	}
+	expr := &internalast.BigOpExpr{Name: opName, Var: p.curToken.Literal}
+	if braced {
+		if p.peekToken.Type == EQUALS {
+			p.nextToken() // consume '='
+			p.nextToken() // move to lower bound
+			lower, err := p.parseExpression(LOWEST)
+			if err != nil {
+				return nil, err
+			}
+			expr.Lower = lower
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after subscript of \\operatorname*{%s}", opName)
+		}
+	}
+
+	// Optional superscript with the upper bound: ^{n}
+	if p.peekToken.Type == CARET {
+		p.nextToken() // consume '^'
+		if !p.expectPeek(LBRACE) {
+			return nil, fmt.Errorf("expected '{' after '^' in \\operatorname*{%s}", opName)
+		}
+		p.nextToken() // move to upper bound
+		upper, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after upper bound in \\operatorname*{%s}", opName)
+		}
+		expr.Upper = upper
+	}
+
+	// Like \sum, the body is the immediate term
+	p.nextToken() // move to body
+	body, err := p.parseExpression(SUM)
+	if err != nil {
+		return nil, err
+	}
+	expr.Body = body
+	return expr, nil
+}
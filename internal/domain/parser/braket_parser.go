@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseBraket handles the inner product \braket{a|b}. The parser is expected to be
+// positioned on \braket.
+func (p *Parser) parseBraket() (internalast.Expr, error) {
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\braket")
+	}
+	bra, err := p.parseKetVector("braket")
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(PIPE) {
+		return nil, fmt.Errorf("expected '|' between the vectors of \\braket")
+	}
+	ket, err := p.parseKetVector("braket")
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after the vectors of \\braket")
+	}
+	return &internalast.InnerProductExpr{Bra: bra, Ket: ket}, nil
+}
+
+// parseBraOrKet handles \bra{a} and \ket{a}. A bra directly followed by a ket is
+// their inner product, \bra{a}\ket{b} = \braket{a|b}. The parser is expected to be
+// positioned on \bra or \ket.
+func (p *Parser) parseBraOrKet(funcName string) (internalast.Expr, error) {
+	vector, err := p.parseKetArgument(funcName)
+	if err != nil {
+		return nil, err
+	}
+	if funcName == "bra" && p.peekToken.Type == COMMAND && p.peekToken.Literal == "ket" {
+		p.nextToken() // move to \ket
+		ket, err := p.parseKetArgument("ket")
+		if err != nil {
+			return nil, err
+		}
+		return &internalast.InnerProductExpr{Bra: vector, Ket: ket}, nil
+	}
+	return &internalast.KetExpr{Vector: vector, IsBra: funcName == "bra"}, nil
+}
+
+// parseKetArgument parses the braced vector name of \bra or \ket and leaves the
+// parser on the closing '}'.
+func (p *Parser) parseKetArgument(funcName string) (string, error) {
+	if !p.expectPeek(LBRACE) {
+		return "", fmt.Errorf("expected '{' after \\%s", funcName)
+	}
+	vector, err := p.parseKetVector(funcName)
+	if err != nil {
+		return "", err
+	}
+	if !p.expectPeek(RBRACE) {
+		return "", fmt.Errorf("expected '}' after the vector of \\%s", funcName)
+	}
+	return vector, nil
+}
+
+// parseKetVector parses the name of a vector in bra-ket notation: an identifier or,
+// as is usual for states, a Greek letter such as \psi, named without its
+// backslash.
+func (p *Parser) parseKetVector(funcName string) (string, error) {
+	if p.peekToken.Type != IDENT && p.peekToken.Type != COMMAND {
+		p.addError("expected a vector name in \\%s, got %s", funcName, p.peekToken.Type)
+		return "", fmt.Errorf("expected a vector name in \\%s, got %s", funcName, p.peekToken.Type)
+	}
+	p.nextToken() // move to the name
+	return p.curToken.Literal, nil
+}
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// leibnizDerivative recognizes a Leibniz fraction \frac{dy}{dx} or \frac{\partial y}{\partial x},
+// whose numerator and denominator are differentials of single-letter variables, as
+// the derivative of y with respect to x. Its order is the power of the differentials,
+// 1 unless written as in \frac{d^2 y}{dx^2}. The numerator names the dependent
+// variable only; how y depends on x is unknown here, so the body is the bare
+// Variable y, which the generator resolves if y is assigned earlier. For the
+// operator \frac{d}{dx} or \frac{d^2}{dx^2}, applied to the expression that
+// follows, the body is nil. Differentials of different orders, as in
+// \frac{d^2 y}{dx}, are an error.
+func leibnizDerivative(num, den internalast.Expr) (*internalast.DerivativeExpr, bool, error) {
+	dependent, partial, order, ok := leibnizNumerator(num)
+	if !ok {
+		return nil, false, nil
+	}
+	denBase, denOrder := differentialPower(den)
+	diffVar, denPartial, ok := differentialOf(denBase)
+	if !ok || denPartial != partial {
+		return nil, false, nil
+	}
+	if order != denOrder {
+		return nil, false, fmt.Errorf("the numerator of a derivative is of order %d but its denominator of order %d: write them with the same power, as in \\frac{d^2 y}{dx^2}",
+			order, denOrder)
+	}
+	deriv := &internalast.DerivativeExpr{IsPartial: partial, Var: diffVar, Order: order}
+	if dependent != "" {
+		deriv.Body = &internalast.Variable{Name: dependent}
+	}
+	return deriv, true, nil
+}
+
+// leibnizNumerator matches the numerator of a Leibniz fraction: a differential dy or
+// \partial y, the operator d or \partial, which has no dependent variable, or a power
+// of the operator, as in d^2 or d^2 y.
+func leibnizNumerator(num internalast.Expr) (dependent string, partial bool, order int, ok bool) {
+	if dependent, partial, ok := differentialOf(num); ok {
+		return dependent, partial, 1, true
+	}
+	if product, isProduct := num.(*internalast.BinaryExpr); isProduct && product.Op == "*" {
+		// d^2 y: a power of the operator, then the dependent variable; d y is a
+		// plain product, the differential being dy
+		y, isVar := product.Right.(*internalast.Variable)
+		inner, partial, order, ok := leibnizNumerator(product.Left)
+		if !isVar || !ok || inner != "" || order == 1 {
+			return "", false, 0, false
+		}
+		return y.Name, partial, order, true
+	}
+	base, order := differentialPower(num)
+	operator, isVar := base.(*internalast.Variable)
+	if !isVar || (operator.Name != "d" && operator.Name != "\\partial") {
+		return "", false, 0, false
+	}
+	return "", operator.Name == "\\partial", order, true
+}
+
+// differentialPower splits a power with a positive integer literal exponent, as in
+// d^2 or dx^2, into its base and exponent. Any other expression is its own base,
+// with exponent 1.
+func differentialPower(e internalast.Expr) (internalast.Expr, int) {
+	power, ok := e.(*internalast.BinaryExpr)
+	if !ok || power.Op != "^" {
+		return e, 1
+	}
+	n, ok := power.Right.(*internalast.NumberLiteral)
+	if !ok || n.Value < 1 || n.Value != math.Trunc(n.Value) {
+		return e, 1
+	}
+	return power.Left, int(n.Value)
+}
+
+// differentialOf returns x for a differential written as the identifier "dx", or
+// as \partial x (see parsePartial), in which case partial is true.
+func differentialOf(e internalast.Expr) (name string, partial bool, ok bool) {
+	v, ok := e.(*internalast.Variable)
+	if !ok {
+		return "", false, false
+	}
+	if name, ok := strings.CutPrefix(v.Name, "\\partial "); ok {
+		return name, true, true
+	}
+	if len(v.Name) != 2 || v.Name[0] != 'd' {
+		return "", false, false
+	}
+	return v.Name[1:], false, true
+}
+
+// parsePartial handles \partial, which only has a meaning in a derivative fraction.
+// Followed by a variable x, as in \partial x, it is the Variable "\partial x";
+// alone, as in the operator \frac{\partial}{\partial x}, it is the Variable "\partial".
+// The generator rejects both outside a derivative. The parser is expected to be
+// positioned on \partial and is left on its last token.
+func (p *Parser) parsePartial() internalast.Expr {
+	if p.peekToken.Type != IDENT {
+		return &internalast.Variable{Name: "\\partial"}
+	}
+	p.nextToken() // move to the variable
+	return &internalast.Variable{Name: "\\partial " + p.curToken.Literal}
+}
+
+// parseDerivativeProduct multiplies a derivative fraction by a directly following
+// fraction, as in the chain rule \frac{dy}{du}\frac{du}{dx}. The parser is expected
+// to be positioned on the '}' closing the first fraction.
+func (p *Parser) parseDerivativeProduct(left internalast.Expr) (internalast.Expr, error) {
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != "frac" {
+		return left, nil
+	}
+	p.nextToken() // move to the next \frac
+	right, err := p.parseExpression(PRODUCT)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.BinaryExpr{Op: "*", Left: left, Right: right}, nil
+}
+
+// parseDifferentialMacro handles the physics package differential \dd{x} (or
+// \dd x) and returns the variable x. The parser is expected to be positioned on
+// the \dd command and is left on the last token of the differential.
+func (p *Parser) parseDifferentialMacro() (string, error) {
+	braced := p.peekToken.Type == LBRACE
+	if braced {
+		p.nextToken() // consume '{'
+	}
+	if !p.expectPeek(IDENT) {
+		return "", fmt.Errorf("expected a variable in \\dd")
+	}
+	variable := p.curToken.Literal
+	if braced && !p.expectPeek(RBRACE) {
+		return "", fmt.Errorf("expected '}' after variable in \\dd")
+	}
+	return variable, nil
+}
+
+// parsePhysicsDerivative handles the physics package derivatives \dv{f}{x} and
+// \pdv{f}{x}, with an optional order as in \dv[2]{f}{x}. The parser is expected
+// to be positioned on the \dv or \pdv command.
+func (p *Parser) parsePhysicsDerivative(funcName string) (internalast.Expr, error) {
+	order := 1
+	if p.peekToken.Type == LBRACKET {
+		p.nextToken() // consume '['
+		if !p.expectPeek(NUMBER) {
+			return nil, fmt.Errorf("expected derivative order in \\%s[...]", funcName)
+		}
+		n, err := strconv.Atoi(p.curToken.Literal)
+		if err != nil || n < 1 {
+			p.addError("invalid derivative order '%s' in \\%s", p.curToken.Literal, funcName)
+			return nil, fmt.Errorf("invalid derivative order '%s' in \\%s", p.curToken.Literal, funcName)
+		}
+		order = n
+		if !p.expectPeek(RBRACKET) {
+			return nil, fmt.Errorf("expected ']' after derivative order in \\%s", funcName)
+		}
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' for the function in \\%s", funcName)
+	}
+	p.nextToken() // move to the function
+	body, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after the function in \\%s", funcName)
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' for the variable in \\%s", funcName)
+	}
+	if !p.expectPeek(IDENT) {
+		return nil, fmt.Errorf("expected a variable to differentiate by in \\%s", funcName)
+	}
+	diffVar := p.curToken.Literal
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after the variable in \\%s", funcName)
+	}
+
+	return &internalast.DerivativeExpr{
+		IsPartial: funcName == "pdv",
+		Var:       diffVar,
+		Order:     order,
+		Body:      body,
+	}, nil
+}
+
+// parseGradient handles the gradient \nabla f. Like the body of \sum, the
+// differentiated expression is the immediate term: \nabla x^2 y is the gradient
+// of x^2 y, while a sum needs parentheses, as in \nabla (x^2 + y^2). The parser
+// is expected to be positioned on \nabla.
+func (p *Parser) parseGradient() (internalast.Expr, error) {
+	if !isPrimaryStart(p.peekToken.Type) {
+		p.addError("expected an expression after \\nabla")
+		return nil, fmt.Errorf("expected an expression after \\nabla")
+	}
+	p.nextToken() // move to the body
+	body, err := p.parseExpression(SUM)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.GradientExpr{Body: body}, nil
+}
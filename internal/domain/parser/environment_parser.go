@@ -0,0 +1,371 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// matrixEnvironments lists the environments parsed as a grid of cells.
+var matrixEnvironments = map[string]bool{
+	"matrix":  true,
+	"pmatrix": true,
+	"bmatrix": true,
+	"Bmatrix": true,
+	"vmatrix": true,
+	"Vmatrix": true,
+	"array":   true,
+}
+
+// environmentsWithArgument lists the environments that take a mandatory argument
+// group after \begin{name}, such as the column spec in \begin{array}{cc}.
+var environmentsWithArgument = map[string]bool{
+	"array":    true,
+	"tabular":  true,
+	"alignat":  true,
+	"alignat*": true,
+}
+
+// displayEnvironments lists the environments wrapping a whole equation as typeset in
+// a document: equation holds a single one and align a system of them.
+var displayEnvironments = map[string]bool{
+	"equation":  true,
+	"equation*": true,
+	"align":     true,
+	"align*":    true,
+}
+
+// parseEnvironment parses a \begin{...} ... \end{...} block and dispatches on the environment name.
+func (p *Parser) parseEnvironment() (internalast.Expr, error) {
+	envName, err := p.parseEnvironmentBegin()
+	if err != nil {
+		return nil, err
+	}
+	p.nextToken() // move to the first token of the environment body
+
+	switch {
+	case envName == "cases":
+		return p.parsePiecewiseExpression(envName)
+	case matrixEnvironments[envName]:
+		return p.parseMatrixExpression(envName)
+	case displayEnvironments[envName]:
+		p.addError("the %s environment must wrap the whole equation", envName)
+		return nil, fmt.Errorf("the %s environment must wrap the whole equation", envName)
+	default:
+		p.addError("unsupported environment '%s'", envName)
+		return nil, fmt.Errorf("unsupported environment '%s'", envName)
+	}
+}
+
+// peekDisplayEnvironment returns the name of the environment begun by the \begin on
+// which the parser is positioned, if it is one of displayEnvironments.
+func (p *Parser) peekDisplayEnvironment() (string, bool) {
+	next := p.lookahead(2)
+	if p.peekToken.Type != LBRACE || len(next) < 2 || next[0].Type != IDENT {
+		return "", false
+	}
+	name := next[0].Literal
+	if next[1].Type == ASTERISK {
+		name += "*"
+	}
+	return name, displayEnvironments[name]
+}
+
+// parseDisplayEnvironment parses an equation wrapped in one of displayEnvironments.
+// The body of equation is parsed like a bare equation, assignments included, and
+// that of align by parseAlignRows. It is called positioned on the \begin and leaves
+// the parser on the closing '}' of the \end.
+func (p *Parser) parseDisplayEnvironment(envName string) (internalast.Expr, error) {
+	if _, err := p.parseEnvironmentBegin(); err != nil {
+		return nil, err
+	}
+	p.nextToken() // move to the first token of the environment body
+
+	var expr internalast.Expr
+	var err error
+	if strings.HasPrefix(envName, "equation") {
+		expr, err = p.parseStatements()
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(END) {
+			return nil, fmt.Errorf("expected \\end{%s} after the equation", envName)
+		}
+	} else {
+		expr, err = p.parseAlignRows(envName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.parseEnvironmentEnd(envName); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// parseAlignRows parses the rows of an align environment, separated by '\\', as a
+// system of equations "name = value" whose '&' alignment marks are ignored. The rows
+// before the last are assignments, as if terminated by ';', and the value of the
+// last one is the result, so that
+//
+//	\begin{align} u &= x^2 \\ f &= u + 1 \end{align}
+//
+// is u = x^2; u + 1. The last row may also be a bare expression. It is called
+// positioned on the first token of the body and leaves the parser on the END token.
+func (p *Parser) parseAlignRows(envName string) (internalast.Expr, error) {
+	var rows []*internalast.AssignmentExpr
+	for p.curToken.Type != END {
+		p.skipAlignmentMarks()
+		if p.curToken.Type == EOF {
+			p.addError("missing \\end{%s}", envName)
+			return nil, fmt.Errorf("missing \\end{%s}", envName)
+		}
+		row := &internalast.AssignmentExpr{}
+		if p.curToken.Type == IDENT && (p.peekToken.Type == EQUALS || p.peekToken.Type == AMPERSAND) {
+			row.Name = p.curToken.Literal
+			p.nextToken() // move past the name
+			p.skipAlignmentMarks()
+			if p.curToken.Type != EQUALS {
+				p.addError("expected '=' after %s in %s row", row.Name, envName)
+				return nil, fmt.Errorf("expected '=' after %s in %s row", row.Name, envName)
+			}
+			p.nextToken() // move past '='
+			p.skipAlignmentMarks()
+		}
+		value, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		row.Value = value
+		rows = append(rows, row)
+
+		switch {
+		case isRowSeparator(p.peekToken):
+			p.nextToken() // consume the row separator
+			p.nextToken() // move to the next row (or \end)
+		case p.peekToken.Type == END:
+			p.nextToken() // move to \end
+		case p.peekToken.Type == EOF:
+			p.addError("missing \\end{%s}", envName)
+			return nil, fmt.Errorf("missing \\end{%s}", envName)
+		default:
+			p.peekError(END)
+			return nil, fmt.Errorf("unexpected token '%s' in %s environment", p.peekToken.Literal, envName)
+		}
+	}
+	if len(rows) == 0 {
+		p.addError("%s environment must contain at least one equation", envName)
+		return nil, fmt.Errorf("%s environment must contain at least one equation", envName)
+	}
+
+	assignments, result := rows[:len(rows)-1], rows[len(rows)-1].Value
+	assigned := make(map[string]bool)
+	for i, a := range assignments {
+		if a.Name == "" {
+			p.addError("expected 'name = value' in row %d of %s, as only the last row may be an expression", i+1, envName)
+			return nil, fmt.Errorf("expected 'name = value' in row %d of %s, as only the last row may be an expression", i+1, envName)
+		}
+		if assigned[a.Name] {
+			p.addError("%s is assigned more than once", a.Name)
+			return nil, fmt.Errorf("%s is assigned more than once", a.Name)
+		}
+		assigned[a.Name] = true
+	}
+	if len(assignments) == 0 {
+		return result, nil
+	}
+	return &internalast.BlockExpr{Assignments: assignments, Result: result}, nil
+}
+
+// skipAlignmentMarks moves the parser past the '&' on which it is positioned, if any.
+func (p *Parser) skipAlignmentMarks() {
+	for p.curToken.Type == AMPERSAND {
+		p.nextToken()
+	}
+}
+
+// parseBracedCases parses a piecewise definition written as an escaped opening brace
+// before an array, \left\{ \begin{array}{ll} value & condition \\ ... \end{array} \right.,
+// whose rows are read like those of a cases environment. The \left and \right. are
+// dropped by the lexer. It is called positioned on the \{ command.
+func (p *Parser) parseBracedCases() (internalast.Expr, error) {
+	p.nextToken() // move to \begin
+	envName, err := p.parseEnvironmentBegin()
+	if err != nil {
+		return nil, err
+	}
+	if envName != "array" && envName != "cases" {
+		p.addError("expected an array after '\\{', got environment '%s'", envName)
+		return nil, fmt.Errorf("expected an array after '\\{', got environment '%s'", envName)
+	}
+	p.nextToken() // move to the first token of the environment body
+	return p.parsePiecewiseExpression(envName)
+}
+
+// parseEnvironmentBegin parses "\begin{name}" followed by an optional "[...]" options
+// group and, for environments that take one, a "{...}" argument group (e.g. the column
+// spec of \begin{array}{cc}). Both groups are skipped. It is called positioned on the
+// BEGIN token and leaves the parser on the last token of the header.
+func (p *Parser) parseEnvironmentBegin() (string, error) {
+	if p.curToken.Type != BEGIN {
+		p.addError("expected \\begin for environment")
+		return "", fmt.Errorf("expected \\begin for environment")
+	}
+	envName, err := p.parseEnvironmentName("begin")
+	if err != nil {
+		return "", err
+	}
+
+	if p.peekToken.Type == LBRACKET {
+		p.nextToken() // move to '['
+		if err := p.skipGroup(LBRACKET, RBRACKET); err != nil {
+			return "", fmt.Errorf("unterminated options for environment '%s': %w", envName, err)
+		}
+	}
+	if environmentsWithArgument[envName] && p.peekToken.Type == LBRACE {
+		p.nextToken() // move to '{'
+		if err := p.skipGroup(LBRACE, RBRACE); err != nil {
+			return "", fmt.Errorf("unterminated argument for environment '%s': %w", envName, err)
+		}
+	}
+	return envName, nil
+}
+
+// parseEnvironmentEnd parses "\end{name}" and checks that it closes envName.
+// It is called positioned on the END token and leaves the parser on the closing '}'.
+func (p *Parser) parseEnvironmentEnd(envName string) error {
+	if p.curToken.Type != END {
+		p.addError("expected \\end for %s environment", envName)
+		return fmt.Errorf("expected \\end for %s environment", envName)
+	}
+	closing, err := p.parseEnvironmentName("end")
+	if err != nil {
+		return err
+	}
+	if closing != envName {
+		p.addError("expected '%s' in \\end{}, got '%s'", envName, closing)
+		return fmt.Errorf("expected '%s' in \\end{}, got '%s'", envName, closing)
+	}
+	return nil
+}
+
+// parseEnvironmentName reads the braced environment name following \begin or \end,
+// leaving the parser on the closing '}'. Names may contain a trailing '*' (e.g. align*).
+func (p *Parser) parseEnvironmentName(keyword string) (string, error) {
+	if p.peekToken.Type != LBRACE {
+		p.addError("expected '{' after \\%s", keyword)
+		return "", fmt.Errorf("expected '{' after \\%s", keyword)
+	}
+	p.nextToken() // consume '{'
+
+	var name strings.Builder
+	for p.peekToken.Type == IDENT || p.peekToken.Type == ASTERISK {
+		p.nextToken()
+		name.WriteString(p.curToken.Literal)
+	}
+	if name.Len() == 0 {
+		p.addError("expected environment name after \\%s", keyword)
+		return "", fmt.Errorf("expected environment name after \\%s", keyword)
+	}
+	if !p.expectPeek(RBRACE) {
+		return "", fmt.Errorf("expected '}' after environment name in \\%s", keyword)
+	}
+	return name.String(), nil
+}
+
+// skipGroup skips a balanced group of tokens. It is called positioned on the
+// opening token and leaves the parser on the matching closing token.
+func (p *Parser) skipGroup(open, close TokenType) error {
+	depth := 1
+	for depth > 0 {
+		p.nextToken()
+		switch p.curToken.Type {
+		case open:
+			depth++
+		case close:
+			depth--
+		case EOF:
+			p.addError("missing closing %s", close)
+			return fmt.Errorf("missing closing %s", close)
+		}
+	}
+	return nil
+}
+
+// parseEnvironmentRows parses the body of a tabular environment: cells separated by '&'
+// and rows separated by '\\', up to the \end token. It is called positioned on the first
+// token of the body and leaves the parser on the END token. A trailing '\\' is allowed.
+// With conditions, a cell after the first may be an equality, as in n = 0, like the
+// conditions of a piecewise definition.
+func (p *Parser) parseEnvironmentRows(envName string, conditions bool) ([][]internalast.Expr, error) {
+	rows := [][]internalast.Expr{}
+	row := []internalast.Expr{}
+	for p.curToken.Type != END {
+		if p.curToken.Type == EOF {
+			p.addError("missing \\end{%s}", envName)
+			return nil, fmt.Errorf("missing \\end{%s}", envName)
+		}
+		// \text{otherwise} stands in for a condition and is recorded as a nil cell
+		var cell internalast.Expr
+		if p.curToken.Type != OTHERWISE {
+			var err error
+			p.equality = conditions && len(row) > 0
+			cell, err = p.parseExpression(LOWEST)
+			p.equality = false
+			if err != nil {
+				return nil, err
+			}
+		}
+		row = append(row, cell)
+
+		switch {
+		case p.peekToken.Type == AMPERSAND:
+			p.nextToken() // consume '&'
+			p.nextToken() // move to the next cell
+		case isRowSeparator(p.peekToken):
+			p.nextToken() // consume the row separator
+			p.nextToken() // move to the next row (or \end)
+			rows = append(rows, row)
+			row = []internalast.Expr{}
+		case p.peekToken.Type == END:
+			p.nextToken() // move to \end
+			rows = append(rows, row)
+			row = []internalast.Expr{}
+		case p.peekToken.Type == EOF:
+			p.addError("missing \\end{%s}", envName)
+			return nil, fmt.Errorf("missing \\end{%s}", envName)
+		default:
+			p.peekError(END)
+			return nil, fmt.Errorf("unexpected token '%s' in %s environment", p.peekToken.Literal, envName)
+		}
+	}
+	return rows, nil
+}
+
+// parseMatrixExpression parses the rows of a matrix-like environment (matrix, pmatrix,
+// bmatrix, array, ...) whose header has already been consumed.
+func (p *Parser) parseMatrixExpression(envName string) (internalast.Expr, error) {
+	rows, err := p.parseEnvironmentRows(envName, false)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		for _, cell := range row {
+			if cell == nil {
+				p.addError("'otherwise' is only allowed in a cases environment, not %s", envName)
+				return nil, fmt.Errorf("'otherwise' is only allowed in a cases environment, not %s", envName)
+			}
+		}
+		if len(row) != len(rows[0]) {
+			p.addError("row %d of %s has %d columns, expected %d", i+1, envName, len(row), len(rows[0]))
+			return nil, fmt.Errorf("row %d of %s has %d columns, expected %d", i+1, envName, len(row), len(rows[0]))
+		}
+	}
+	if err := p.parseEnvironmentEnd(envName); err != nil {
+		return nil, err
+	}
+	return &internalast.MatrixExpr{
+		Rows: rows,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// A right bar \right| closes either an absolute value or an evaluation bar, told
+// apart by the left delimiter:
+//
+//	|x| or \left| x \right|        absolute value; a subscript after it is an error
+//	\left. F \right|_a^b           F at b minus F at a
+//	\left. F \right|_{x=a}^{b}     the same, naming the variable set to the bounds
+//	\left. F \right|_a             F at a, an evaluation at a point
+//
+// Only the empty delimiter \left. opens an evaluation bar, and its closing bar must
+// be followed by the bounds.
+
+// errBarWithBounds is reported for bounds after an absolute value, as in |x|_0^1.
+const errBarWithBounds = "bounds after |...| need an evaluation bar: write \\left. F \\right|_a^b to evaluate F"
+
+// parseEvaluationBar parses \left. F \right|_a^b. The parser is expected to be
+// positioned on \left. and is left on the last token of the bounds.
+func (p *Parser) parseEvaluationBar() (internalast.Expr, error) {
+	p.nextToken() // move to the body
+	body, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type != PIPE {
+		p.addError("expected \\right| to close \\left., got %s", p.peekToken.Type)
+		return nil, fmt.Errorf("expected \\right| to close \\left., got %s", p.peekToken.Type)
+	}
+	p.nextToken() // move to '|'
+	if p.peekToken.Type != UNDERSCORE {
+		p.addError("expected the bounds _a^b or the point _a after \\right|")
+		return nil, fmt.Errorf("expected the bounds _a^b or the point _a after \\right|")
+	}
+	p.nextToken() // move to '_'
+
+	eval := &internalast.EvaluationExpr{Body: body}
+	next := p.lookahead(3)
+	if p.peekToken.Type == LBRACE && len(next) == 3 && next[0].Type == IDENT && next[1].Type == EQUALS {
+		// _{x=a} names the variable
+		p.nextToken() // move to '{'
+		p.nextToken() // move to the variable
+		eval.Var = p.curToken.Literal
+		p.nextToken() // move to '='
+		p.nextToken() // move to the bound
+		eval.Lower, err = p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after the lower bound of an evaluation bar")
+		}
+	} else if eval.Lower, err = p.parseScriptArgument("an evaluation bar"); err != nil {
+		return nil, err
+	}
+
+	if p.peekToken.Type == CARET {
+		p.nextToken() // move to '^'
+		eval.Upper, err = p.parseScriptArgument("an evaluation bar")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return eval, nil
+}
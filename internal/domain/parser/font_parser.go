@@ -0,0 +1,50 @@
+package parser
+
+import internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+
+// fontAlphabets are the font commands naming a distinct symbol, mapped to the
+// suffix of its variable name: \mathbb{R} is the variable R_bb, \mathcal{L} is
+// L_cal and \mathfrak{g} is g_frak, so none of them is confused with a plain R, L
+// or g.
+var fontAlphabets = map[string]string{
+	"mathbb":   "bb",
+	"mathcal":  "cal",
+	"mathfrak": "frak",
+}
+
+// isFontSymbol reports whether the font command funcName, on which the parser is
+// positioned, wraps a symbol: an identifier or a Greek letter, braced or not. The
+// \mathbb{1}[condition] of an indicator and the \mathbb{E}[X] of an expectation
+// are left to the generic command handling.
+func (p *Parser) isFontSymbol(funcName string) bool {
+	if _, ok := fontAlphabets[funcName]; !ok {
+		return false
+	}
+	if isSymbolToken(p.peekToken) {
+		return true
+	}
+	next := p.lookahead(3)
+	return p.peekToken.Type == LBRACE && len(next) == 3 && isSymbolToken(next[0]) && next[1].Type == RBRACE &&
+		(funcName != "mathbb" || next[2].Type != LBRACKET)
+}
+
+// isSymbolToken reports whether tok names a symbol: an identifier or a Greek letter.
+func isSymbolToken(tok Token) bool {
+	return tok.Type == IDENT || (tok.Type == COMMAND && greekLetters[tok.Literal])
+}
+
+// parseFontSymbol handles \mathbb{R}, \mathcal{L} and \mathfrak{g}, returning the
+// variable named after the symbol and its font. The parser is expected to be
+// positioned on the command, with isFontSymbol true.
+func (p *Parser) parseFontSymbol(funcName string) internalast.Expr {
+	braced := p.peekToken.Type == LBRACE
+	if braced {
+		p.nextToken() // move to '{'
+	}
+	p.nextToken() // move to the symbol
+	name := p.curToken.Literal
+	if braced {
+		p.nextToken() // consume '}'
+	}
+	return &internalast.Variable{Name: name + "_" + fontAlphabets[funcName]}
+}
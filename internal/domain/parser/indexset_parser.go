@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// A sum or product may enumerate the values of its index instead of bounding them,
+// in either of two forms:
+//
+//	\sum_{i=1,3,5} f(i)            comma-separated values after '='
+//	\sum_{i \in \{1,3,5\}} f(i)    a set literal after \in
+//
+// The values are arbitrary expressions, taken in the order written, and the sum has
+// no upper bound.
+//
+// A closed interval after \in bounds the index instead, which runs over its
+// integers: \sum_{k \in [1, n]} f(k) is \sum_{k=1}^{n} f(k). After \in a bracket
+// always opens an interval, never a group.
+
+// parseIndexSet parses "i \in \{v1, v2, ...\}" in the subscript of \sum or \prod.
+// It is called positioned on the index variable and leaves the parser on the
+// closing \}.
+func (p *Parser) parseIndexSet(funcName string) (string, []internalast.Expr, error) {
+	varName := p.curToken.Literal
+	p.nextToken() // move to \in
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != "{" {
+		p.addError("expected a set \\{...\\} after \\in in \\%s", funcName)
+		return "", nil, fmt.Errorf("expected a set \\{...\\} after \\in in \\%s", funcName)
+	}
+	p.nextToken() // move to \{
+	p.nextToken() // move to the first value
+	first, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return "", nil, err
+	}
+	values, err := p.parseIndexValues(funcName, first)
+	if err != nil {
+		return "", nil, err
+	}
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != "}" {
+		p.addError("expected '\\}' after the index set in \\%s", funcName)
+		return "", nil, fmt.Errorf("expected '\\}' after the index set in \\%s", funcName)
+	}
+	p.nextToken() // move to \}
+	return varName, values, nil
+}
+
+// parseIndexInterval parses "k \in [a, b]" in the subscript of \sum or \prod and
+// returns the index with its bounds. It is called positioned on the index
+// variable and leaves the parser on the closing ']'.
+func (p *Parser) parseIndexInterval(funcName string) (string, internalast.Expr, internalast.Expr, error) {
+	varName := p.curToken.Literal
+	p.nextToken() // move to \in
+	p.nextToken() // move to '['
+	p.nextToken() // move to the lower bound
+	lower, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !p.expectPeek(COMMA) {
+		return "", nil, nil, fmt.Errorf("expected ',' between the bounds of the interval of %s in \\%s", varName, funcName)
+	}
+	p.nextToken() // move to the upper bound
+	upper, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if p.peekToken.Type != RBRACKET {
+		p.addError("expected ']' after the interval of %s in \\%s: only closed intervals [a, b] are supported", varName, funcName)
+		return "", nil, nil, fmt.Errorf("expected ']' after the interval of %s in \\%s: only closed intervals [a, b] are supported", varName, funcName)
+	}
+	p.nextToken() // move to ']'
+	return varName, lower, upper, nil
+}
+
+// parseIndexValues parses the values following the first one of an enumerated
+// index, each preceded by a comma. It is called positioned on the last token of
+// the first value and leaves the parser on the last token of the last one.
+func (p *Parser) parseIndexValues(funcName string, first internalast.Expr) ([]internalast.Expr, error) {
+	values := []internalast.Expr{first}
+	for p.peekToken.Type == COMMA {
+		p.nextToken() // consume ','
+		p.nextToken() // move to the value
+		value, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// parseIndexSetSum parses the body of a sum or product over enumerated index values.
+// The parser is expected to be positioned on the '}' closing the subscript.
+func (p *Parser) parseIndexSetSum(funcName, varName string, values []internalast.Expr) (internalast.Expr, error) {
+	if p.peekToken.Type == CARET {
+		p.addError("\\%s over listed values of %s takes no upper bound", funcName, varName)
+		return nil, fmt.Errorf("\\%s over listed values of %s takes no upper bound", funcName, varName)
+	}
+	p.nextToken() // advance to body token
+	// As with bounds, the body is the immediate term
+	body, err := p.parseExpression(SUM)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.SumExpr{
+		IsProduct: funcName == "prod",
+		Var:       varName,
+		Values:    values,
+		Body:      body,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseKroneckerDelta handles parsing of Kronecker delta expressions like:
+// \delta_{ij}, \delta_{i,j} or \delta_{i j}
+// The parser is expected to be positioned on the \delta command.
+func (p *Parser) parseKroneckerDelta() (internalast.Expr, error) {
+	p.nextToken() // consume '_'
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after '_' in \\delta")
+	}
+	p.nextToken() // move to the first index
+
+	// A two-letter identifier such as "ij" names both indices
+	if p.curToken.Type == IDENT && len(p.curToken.Literal) == 2 && p.peekToken.Type == RBRACE {
+		name := p.curToken.Literal
+		p.nextToken() // consume '}'
+		return &internalast.KroneckerDeltaExpr{
+			I: &internalast.Variable{Name: name[:1]},
+			J: &internalast.Variable{Name: name[1:]},
+		}, nil
+	}
+
+	// Indices are separated by ',' or simply juxtaposed: \delta_{i j}, so a following
+	// identifier starts the second index instead of multiplying the first
+	p.stopBefore = func() bool { return p.peekToken.Type == IDENT }
+	i, err := p.parseExpression(LOWEST)
+	p.stopBefore = nil
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type == COMMA {
+		p.nextToken() // consume ','
+	}
+	p.nextToken() // move to the second index
+	j, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after indices of \\delta")
+	}
+	return &internalast.KroneckerDeltaExpr{I: i, J: j}, nil
+}
+
+// parseIndicator handles the bracketed condition of an indicator function
+// \mathbb{1}[condition]. The parser is expected to be positioned on the '}'
+// closing \mathbb{1}, with '[' as the next token.
+func (p *Parser) parseIndicator() (internalast.Expr, error) {
+	p.nextToken() // consume '['
+	p.nextToken() // move to the condition
+	condition, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACKET) {
+		return nil, fmt.Errorf("expected ']' after indicator condition")
+	}
+	return &internalast.IndicatorExpr{Condition: condition}, nil
+}
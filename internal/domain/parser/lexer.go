@@ -2,6 +2,8 @@ package parser
 
 import (
 	"fmt"
+	"iter"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -33,12 +35,43 @@ const (
 	CARET      // ^
 	EQUALS     // =
 	EXCLAMATION// ! (factorial)
+	PM         // \pm (plus-minus)
+	MP         // \mp (minus-plus)
+
+	// Relational and logical operators
+	LT  // <
+	GT  // >
+	LE  // \le, \leq
+	GE  // \ge, \geq
+	NEQ // \ne, \neq
+	AND // \land, \wedge, \text{and}
+	OR  // \lor, \vee, \text{or}
+	NOT // \lnot, \neg, \text{not}
+
+	IMPLIES // \implies, \Rightarrow, \text{implies}
+	IFF     // \iff, \Leftrightarrow, \text{iff}
+
+	XARROW // \xrightarrow{...}, \xleftarrow{...} (annotated arrow)
+
+	EQUIV // \equiv (congruence)
+	PMOD  // \pmod (modulus of a congruence or modulo value)
+	BMOD  // \bmod (binary modulo operator, a \bmod n)
+
+	OTHERWISE // \text{otherwise}, \text{else} (default row of a cases environment)
 
 	// Delimiters
 	LPAREN     // (
 	RPAREN     // )
 	LBRACE     // {
 	RBRACE     // }
+	LBRACKET   // [
+	RBRACKET   // ]
+	COMMA      // ,
+	SEMICOLON  // ; (separates assignments from the final expression)
+	AMPERSAND  // & (alignment/column separator in environments)
+	PIPE       // | (absolute value / modulus delimiter)
+	MID        // \mid (bar of a condition, as in \langle X \mid Y \rangle)
+	NULLDELIM  // \left. (empty left delimiter, opening an evaluation bar \left. F \right|_a^b)
 	UNDERSCORE // _
 
 	// LaTeX Commands (treated specially)
@@ -47,6 +80,104 @@ const (
 	END        // \end{...}
 )
 
+// commandTokens maps LaTeX commands that act as operators to their token types.
+var commandTokens = map[string]TokenType{
+	"le":    LE,
+	"leq":   LE,
+	"ge":    GE,
+	"geq":   GE,
+	"ne":    NEQ,
+	"neq":   NEQ,
+	"land":  AND,
+	"wedge": AND,
+	"lor":   OR,
+	"vee":   OR,
+	"lnot":  NOT,
+	"neg":   NOT,
+	"equiv": EQUIV,
+	"pmod":  PMOD,
+	"bmod":  BMOD,
+	"pm":    PM,
+	"mp":    MP,
+	"mid":   MID,
+
+	"implies":        IMPLIES,
+	"Rightarrow":     IMPLIES,
+	"iff":            IFF,
+	"Leftrightarrow": IFF,
+
+	"xrightarrow": XARROW,
+	"xleftarrow":  XARROW,
+}
+
+// arithmeticCommands maps the LaTeX spellings of the arithmetic operators to the
+// token of the operator symbol (3 \cdot 10^8 lexes like 3 * 10^8).
+var arithmeticCommands = map[string]Token{
+	"cdot":  {Type: ASTERISK, Literal: "*"},
+	"times": {Type: ASTERISK, Literal: "*"},
+	"div":   {Type: SLASH, Literal: "/"},
+}
+
+// spacingCommands are the spacing commands, which produce no token.
+var spacingCommands = map[string]bool{
+	",":     true, // Thin space
+	":":     true, // Medium space
+	">":     true, // Medium space, plain TeX spelling
+	";":     true, // Thick space
+	"!":     true, // Negative thin space
+	" ":     true, // Control space
+	"quad":  true,
+	"qquad": true,
+}
+
+// textKeywords maps words spelled out with \text{...} to tokens: the logical
+// connectives and the "otherwise" of a cases environment.
+var textKeywords = map[string]TokenType{
+	"and":       AND,
+	"or":        OR,
+	"not":       NOT,
+	"implies":   IMPLIES,
+	"iff":       IFF,
+	"otherwise": OTHERWISE,
+	"else":      OTHERWISE,
+}
+
+// textFillers are words spelled out with \text{...} that only make a formula read
+// as prose, like the "if" of a cases condition; the lexer skips them.
+var textFillers = map[string]bool{
+	"if":    true,
+	"for":   true,
+	"when":  true,
+	"where": true,
+}
+
+// textStylingCommands are the styling commands unwrapped inside \text{...} before
+// looking for a keyword, so \text{\textbf{otherwise}} reads as \text{otherwise}.
+var textStylingCommands = []string{"textbf", "textit", "textrm", "textsf", "texttt", "textup", "emph", "mathrm", "mathbf", "mathit"}
+
+// unicodeTokens maps Unicode math symbols, common in pasted content, to the
+// token their ASCII or LaTeX spelling lexes to (≤ behaves like \le, π like \pi).
+var unicodeTokens = map[rune]Token{
+	'×': {Type: ASTERISK, Literal: "*"},
+	'⋅': {Type: ASTERISK, Literal: "*"},
+	'·': {Type: ASTERISK, Literal: "*"},
+	'÷': {Type: SLASH, Literal: "/"},
+	'−': {Type: MINUS, Literal: "-"},
+	'≤': {Type: LE, Literal: "le"},
+	'≥': {Type: GE, Literal: "ge"},
+	'≠': {Type: NEQ, Literal: "ne"},
+	'∧': {Type: AND, Literal: "land"},
+	'∨': {Type: OR, Literal: "lor"},
+	'¬': {Type: NOT, Literal: "lnot"},
+	'⇒': {Type: IMPLIES, Literal: "implies"},
+	'⟹': {Type: IMPLIES, Literal: "implies"},
+	'⇔': {Type: IFF, Literal: "iff"},
+	'⟺': {Type: IFF, Literal: "iff"},
+	'≈': {Type: COMMAND, Literal: "approx"},
+	'π': {Type: COMMAND, Literal: "pi"},
+	'∞': {Type: COMMAND, Literal: "infty"},
+}
+
 // Lexer holds the state of the scanner.
 type Lexer struct {
 	input        string // Input string being scanned
@@ -62,6 +193,30 @@ func NewLexer(input string) *Lexer {
 	return l
 }
 
+// Reset reinitializes the Lexer to scan input from the start, so a Lexer can be
+// reused across inputs without allocating a new one.
+func (l *Lexer) Reset(input string) {
+	l.input = input
+	l.position = 0
+	l.readPosition = 0
+	l.ch = 0
+	l.readChar()
+}
+
+// Tokens returns an iterator over the remaining tokens, as scanned by NextToken.
+// The iteration ends at the end of the input: the EOF token itself is not yielded.
+// ILLEGAL tokens are yielded like any other, leaving error handling to the consumer.
+// Breaking out of the loop leaves the Lexer positioned after the last yielded token.
+func (l *Lexer) Tokens() iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+			if !yield(tok) {
+				return
+			}
+		}
+	}
+}
+
 // readChar gives us the next character and advances our position in the input string.
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
@@ -107,6 +262,10 @@ func (l *Lexer) NextToken() Token {
 		tok = newToken(CARET, l.ch)
 	case '=':
 		tok = newToken(EQUALS, l.ch)
+	case '<':
+		tok = newToken(LT, l.ch)
+	case '>':
+		tok = newToken(GT, l.ch)
 	case '!':
 		tok = newToken(EXCLAMATION, l.ch)
 	case '_':
@@ -119,6 +278,18 @@ func (l *Lexer) NextToken() Token {
 		tok = newToken(LBRACE, l.ch)
 	case '}':
 		tok = newToken(RBRACE, l.ch)
+	case '[':
+		tok = newToken(LBRACKET, l.ch)
+	case ']':
+		tok = newToken(RBRACKET, l.ch)
+	case ',':
+		tok = newToken(COMMA, l.ch)
+	case ';':
+		tok = newToken(SEMICOLON, l.ch)
+	case '&':
+		tok = newToken(AMPERSAND, l.ch)
+	case '|':
+		tok = newToken(PIPE, l.ch)
 	case '\\':
 		tok.Type = COMMAND
 		cmdStr := l.readCommand()
@@ -130,6 +301,37 @@ func (l *Lexer) NextToken() Token {
 			tok.Type = BEGIN
 		} else if cmdStr == "end" {
 			tok.Type = END
+		} else if tokType, ok := commandTokens[cmdStr]; ok {
+			tok.Type = tokType
+		} else if cmdStr == "left" || cmdStr == "right" {
+			// \left and \right only size the delimiter that follows, which lexes as
+			// usual (\left( as '('); the null delimiter of \right. is dropped, while
+			// that of \left. opens an evaluation bar
+			l.skipWhitespace()
+			if l.ch == '.' {
+				l.readChar()
+				if cmdStr == "left" {
+					tok.Type, tok.Literal = NULLDELIM, "left."
+					return tok
+				}
+			}
+			return l.NextToken()
+		} else if spacingCommands[cmdStr] {
+			// Spacing like the thin space of 1\,\text{m} or the \quad before a
+			// condition only affects layout
+			return l.NextToken()
+		} else if op, ok := arithmeticCommands[cmdStr]; ok {
+			tok.Type, tok.Literal = op.Type, op.Literal
+		} else if cmdStr == "text" {
+			// \text{and}, \text{or}, \text{not} and \text{otherwise} are keywords,
+			// while fillers like \text{if} are skipped
+			if word, ok := l.readTextKeyword(); ok {
+				if textFillers[word] {
+					return l.NextToken()
+				}
+				tok.Type = textKeywords[word]
+				tok.Literal = word
+			}
 		}
 		return tok
 	case 0:
@@ -144,6 +346,9 @@ func (l *Lexer) NextToken() Token {
 			tok.Type = NUMBER
 			tok.Literal = l.readNumber()
 			return tok
+		} else if ut, ok := unicodeTokens[l.ch]; ok {
+			tok.Type = ut.Type
+			tok.Literal = ut.Literal
 		} else {
 			tok = newToken(ILLEGAL, l.ch)
 		}
@@ -168,15 +373,83 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// readCommand reads a command name after a backslash. Like TeX, a command is either
+// a run of letters (\frac) or a single non-letter character (\\, \,, \{).
 func (l *Lexer) readCommand() string {
 	position := l.position + 1
 	l.readChar()
+	if !isLetter(l.ch) && l.ch != 0 {
+		l.readChar()
+		return l.input[position:l.position]
+	}
 	for isLetter(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
+// readTextKeyword checks whether the braced argument following \text is a keyword
+// or filler word (e.g. "\text{ and }", "\text{\textbf{otherwise}}"). If it is, the
+// argument is consumed and the word is returned; otherwise the lexer is left untouched.
+func (l *Lexer) readTextKeyword() (string, bool) {
+	i := l.position
+	for i < len(l.input) && unicode.IsSpace(rune(l.input[i])) {
+		i++
+	}
+	if i >= len(l.input) || l.input[i] != '{' {
+		return "", false
+	}
+	closing := matchingBrace(l.input, i)
+	if closing < 0 {
+		return "", false
+	}
+	word := stripTextStyling(l.input[i+1 : closing])
+	if _, ok := textKeywords[word]; !ok && !textFillers[word] {
+		return "", false
+	}
+	// Resume scanning right after the closing brace
+	l.readPosition = closing + 1
+	l.readChar()
+	return word, true
+}
+
+// stripTextStyling trims s and repeatedly unwraps a styling command spanning all
+// of it, so " \textbf{\emph{if}} " becomes "if".
+func stripTextStyling(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		unwrapped := false
+		for _, cmd := range textStylingCommands {
+			prefix := "\\" + cmd + "{"
+			if strings.HasPrefix(s, prefix) && matchingBrace(s, len(prefix)-1) == len(s)-1 {
+				s = s[len(prefix) : len(s)-1]
+				unwrapped = true
+				break
+			}
+		}
+		if !unwrapped {
+			return s
+		}
+	}
+}
+
+// matchingBrace returns the index of the '}' closing the '{' at s[open], or -1.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 func (l *Lexer) readNumber() string {
 	position := l.position
 	hasDecimal := false
@@ -228,6 +501,40 @@ func (t TokenType) String() string {
 		return "EQUALS"
 	case EXCLAMATION:
 		return "EXCLAMATION"
+	case PM:
+		return "PM"
+	case MP:
+		return "MP"
+	case LT:
+		return "LT"
+	case GT:
+		return "GT"
+	case LE:
+		return "LE"
+	case GE:
+		return "GE"
+	case NEQ:
+		return "NEQ"
+	case AND:
+		return "AND"
+	case OR:
+		return "OR"
+	case NOT:
+		return "NOT"
+	case IMPLIES:
+		return "IMPLIES"
+	case IFF:
+		return "IFF"
+	case XARROW:
+		return "XARROW"
+	case EQUIV:
+		return "EQUIV"
+	case PMOD:
+		return "PMOD"
+	case BMOD:
+		return "BMOD"
+	case OTHERWISE:
+		return "OTHERWISE"
 	case UNDERSCORE:
 		return "UNDERSCORE"
 	case LPAREN:
@@ -238,6 +545,22 @@ func (t TokenType) String() string {
 		return "LBRACE"
 	case RBRACE:
 		return "RBRACE"
+	case LBRACKET:
+		return "LBRACKET"
+	case RBRACKET:
+		return "RBRACKET"
+	case COMMA:
+		return "COMMA"
+	case SEMICOLON:
+		return "SEMICOLON"
+	case AMPERSAND:
+		return "AMPERSAND"
+	case PIPE:
+		return "PIPE"
+	case MID:
+		return "MID"
+	case NULLDELIM:
+		return "NULLDELIM"
 	case COMMAND:
 		return "COMMAND"
 	case BEGIN:
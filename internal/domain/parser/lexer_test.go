@@ -47,6 +47,90 @@ func TestLexer(t *testing.T) {
 				{Type: EOF, Literal: "", Pos: 10},
 			},
 		},
+		{
+			input: `x \le 1 \land y > 0`,
+			expected: []Token{
+				{Type: IDENT, Literal: "x", Pos: 0},
+				{Type: LE, Literal: "le", Pos: 5},
+				{Type: NUMBER, Literal: "1", Pos: 6},
+				{Type: AND, Literal: "land", Pos: 13},
+				{Type: IDENT, Literal: "y", Pos: 14},
+				{Type: GT, Literal: ">", Pos: 16},
+				{Type: NUMBER, Literal: "0", Pos: 18},
+				{Type: EOF, Literal: "", Pos: 19},
+			},
+		},
+		{
+			input: `\text{ and } \text{or} \text{not} \text{x}`,
+			expected: []Token{
+				{Type: AND, Literal: "and", Pos: 13},
+				{Type: OR, Literal: "or", Pos: 23},
+				{Type: NOT, Literal: "not", Pos: 34},
+				{Type: COMMAND, Literal: "text", Pos: 40},
+				{Type: LBRACE, Literal: "{", Pos: 40},
+				{Type: IDENT, Literal: "x", Pos: 41},
+				{Type: RBRACE, Literal: "}", Pos: 42},
+				{Type: EOF, Literal: "", Pos: 43},
+			},
+		},
+		{
+			input: `1 & x \\ 2`,
+			expected: []Token{
+				{Type: NUMBER, Literal: "1", Pos: 0},
+				{Type: AMPERSAND, Literal: "&", Pos: 2},
+				{Type: IDENT, Literal: "x", Pos: 4},
+				{Type: COMMAND, Literal: "\\", Pos: 8},
+				{Type: NUMBER, Literal: "2", Pos: 9},
+				{Type: EOF, Literal: "", Pos: 10},
+			},
+		},
+		{
+			input: "a × b ÷ c ≤ π ≥ ∞ ≠ x ≈ y",
+			expected: []Token{
+				{Type: IDENT, Literal: "a", Pos: 0},
+				{Type: ASTERISK, Literal: "*", Pos: 2},
+				{Type: IDENT, Literal: "b", Pos: 5},
+				{Type: SLASH, Literal: "/", Pos: 7},
+				{Type: IDENT, Literal: "c", Pos: 10},
+				{Type: LE, Literal: "le", Pos: 12},
+				{Type: COMMAND, Literal: "pi", Pos: 16},
+				{Type: GE, Literal: "ge", Pos: 19},
+				{Type: COMMAND, Literal: "infty", Pos: 23},
+				{Type: NEQ, Literal: "ne", Pos: 27},
+				{Type: IDENT, Literal: "x", Pos: 31},
+				{Type: COMMAND, Literal: "approx", Pos: 33},
+				{Type: IDENT, Literal: "y", Pos: 37},
+				{Type: EOF, Literal: "", Pos: 38},
+			},
+		},
+		{
+			input: "x−1 ∧ ¬y",
+			expected: []Token{
+				{Type: IDENT, Literal: "x", Pos: 0},
+				{Type: MINUS, Literal: "-", Pos: 1},
+				{Type: NUMBER, Literal: "1", Pos: 4},
+				{Type: AND, Literal: "land", Pos: 6},
+				{Type: NOT, Literal: "lnot", Pos: 10},
+				{Type: IDENT, Literal: "y", Pos: 12},
+				{Type: EOF, Literal: "", Pos: 13},
+			},
+		},
+		{
+			input: `\text{if } x \text{\textbf{otherwise}} \text{ \emph{\textit{else}} } \text{\textbf{y}}`,
+			expected: []Token{
+				{Type: IDENT, Literal: "x", Pos: 12},
+				{Type: OTHERWISE, Literal: "otherwise", Pos: 14},
+				{Type: OTHERWISE, Literal: "else", Pos: 42},
+				{Type: COMMAND, Literal: "text", Pos: 72},
+				{Type: LBRACE, Literal: "{", Pos: 72},
+				{Type: COMMAND, Literal: "textbf", Pos: 73},
+				{Type: LBRACE, Literal: "{", Pos: 80},
+				{Type: IDENT, Literal: "y", Pos: 81},
+				{Type: RBRACE, Literal: "}", Pos: 82},
+				{Type: RBRACE, Literal: "}", Pos: 83},
+				{Type: EOF, Literal: "", Pos: 84},
+			},
+		},
 		// Add more test cases as needed
 	}
 
@@ -73,3 +157,129 @@ func TestLexer(t *testing.T) {
 		})
 	}
 }
+
+func TestLexer_Reset(t *testing.T) {
+	l := NewLexer(`\frac{a}{b}`)
+	for l.NextToken().Type != EOF {
+	}
+
+	l.Reset("x ≤ 1")
+	expected := []Token{
+		{Type: IDENT, Literal: "x", Pos: 0},
+		{Type: LE, Literal: "le", Pos: 2},
+		{Type: NUMBER, Literal: "1", Pos: 6},
+		{Type: EOF, Literal: "", Pos: 7},
+	}
+	for i, want := range expected {
+		assert.Equal(t, want, l.NextToken(), "token %d", i)
+	}
+}
+
+func TestLexer_Tokens(t *testing.T) {
+	var got []Token
+	for tok := range NewLexer(`\frac{a}{2}`).Tokens() {
+		got = append(got, Token{Type: tok.Type, Literal: tok.Literal})
+	}
+	// The iteration stops at the end of the input without yielding EOF
+	expected := []Token{
+		{Type: COMMAND, Literal: "frac"},
+		{Type: LBRACE, Literal: "{"},
+		{Type: IDENT, Literal: "a"},
+		{Type: RBRACE, Literal: "}"},
+		{Type: LBRACE, Literal: "{"},
+		{Type: NUMBER, Literal: "2"},
+		{Type: RBRACE, Literal: "}"},
+	}
+	assert.Equal(t, expected, got)
+
+	// Breaking out early leaves the remaining tokens to NextToken
+	l := NewLexer("x + y")
+	for tok := range l.Tokens() {
+		if tok.Type == PLUS {
+			break
+		}
+	}
+	assert.Equal(t, Token{Type: IDENT, Literal: "y", Pos: 4}, l.NextToken())
+	assert.Equal(t, EOF, l.NextToken().Type)
+}
+
+func TestLexer_ThinSpace(t *testing.T) {
+	l := NewLexer(`1\,\text{m}`)
+	expected := []Token{
+		{Type: NUMBER, Literal: "1"},
+		{Type: COMMAND, Literal: "text"},
+		{Type: LBRACE, Literal: "{"},
+		{Type: IDENT, Literal: "m"},
+		{Type: RBRACE, Literal: "}"},
+	}
+	for i, want := range expected {
+		tok := l.NextToken()
+		assert.Equal(t, want.Type, tok.Type, "token %d type", i)
+		assert.Equal(t, want.Literal, tok.Literal, "token %d literal", i)
+	}
+	assert.Equal(t, EOF, l.NextToken().Type)
+}
+
+func TestLexer_SpacingCommands(t *testing.T) {
+	l := NewLexer(`a\!b \: c\;d \> e\quad f \qquad g\ h`)
+	for i, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		tok := l.NextToken()
+		assert.Equal(t, IDENT, tok.Type, "token %d type", i)
+		assert.Equal(t, name, tok.Literal, "token %d literal", i)
+	}
+	assert.Equal(t, EOF, l.NextToken().Type)
+}
+
+func TestLexer_CdotAndCdots(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Token
+	}{
+		{`3 \cdot 10`, []Token{{Type: NUMBER, Literal: "3"}, {Type: ASTERISK, Literal: "*"}, {Type: NUMBER, Literal: "10"}}},
+		{`a \times b`, []Token{{Type: IDENT, Literal: "a"}, {Type: ASTERISK, Literal: "*"}, {Type: IDENT, Literal: "b"}}},
+		{`a \div b`, []Token{{Type: IDENT, Literal: "a"}, {Type: SLASH, Literal: "/"}, {Type: IDENT, Literal: "b"}}},
+		// readCommand reads the full name, so the ellipsis is not a \cdot followed by s
+		{`1 + \cdots + n`, []Token{{Type: NUMBER, Literal: "1"}, {Type: PLUS, Literal: "+"}, {Type: COMMAND, Literal: "cdots"}, {Type: PLUS, Literal: "+"}, {Type: IDENT, Literal: "n"}}},
+		{`a\cdot b`, []Token{{Type: IDENT, Literal: "a"}, {Type: ASTERISK, Literal: "*"}, {Type: IDENT, Literal: "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			for i, want := range tt.expected {
+				tok := l.NextToken()
+				assert.Equal(t, want.Type, tok.Type, "token %d type", i)
+				assert.Equal(t, want.Literal, tok.Literal, "token %d literal", i)
+			}
+			assert.Equal(t, EOF, l.NextToken().Type)
+		})
+	}
+}
+
+func TestLexer_LeftRightDelimiters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Token
+	}{
+		{`\left( x \right)`, []Token{{Type: LPAREN, Literal: "("}, {Type: IDENT, Literal: "x"}, {Type: RPAREN, Literal: ")"}}},
+		{`\left| x \right|`, []Token{{Type: PIPE, Literal: "|"}, {Type: IDENT, Literal: "x"}, {Type: PIPE, Literal: "|"}}},
+		// The null delimiter '.' produces no token after \right, and opens an evaluation bar after \left
+		{`\left\{ x \right.`, []Token{{Type: COMMAND, Literal: "{"}, {Type: IDENT, Literal: "x"}}},
+		{`\left. x \right|`, []Token{{Type: NULLDELIM, Literal: "left."}, {Type: IDENT, Literal: "x"}, {Type: PIPE, Literal: "|"}}},
+		// The bar of a condition is not a delimiter
+		{`\left\langle X \mid Y \right\rangle`, []Token{
+			{Type: COMMAND, Literal: "langle"}, {Type: IDENT, Literal: "X"}, {Type: MID, Literal: "mid"},
+			{Type: IDENT, Literal: "Y"}, {Type: COMMAND, Literal: "rangle"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			for i, want := range tt.expected {
+				tok := l.NextToken()
+				assert.Equal(t, want.Type, tok.Type, "token %d type", i)
+				assert.Equal(t, want.Literal, tok.Literal, "token %d literal", i)
+			}
+			assert.Equal(t, EOF, l.NextToken().Type)
+		})
+	}
+}
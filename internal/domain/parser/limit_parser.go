@@ -10,8 +10,6 @@ import (
 // parseLimitExpression handles parsing of limit expressions like:
 // \lim_{x \to 0} or \lim{x \to 0}
 func (p *Parser) parseLimitExpression(braceStyle bool) (internalast.Expr, error) {
-	var varName string
-
 	// If we're not in brace style, then we expect underscore followed by a brace
 	if !braceStyle {
 		// Check for opening brace after underscore
@@ -22,11 +20,22 @@ func (p *Parser) parseLimitExpression(braceStyle bool) (internalast.Expr, error)
 		p.nextToken() // consume '{'
 	}
 
+	varName, approaches, direction, err := p.parseLimitSubscript()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseLimitBody(varName, approaches, direction)
+}
+
+// parseLimitSubscript parses the "x \to a" of a limit, with an optional one-sided
+// direction suffix as in x \to 0^+. It is called positioned on the '{' opening the
+// subscript and leaves the parser on the closing '}'.
+func (p *Parser) parseLimitSubscript() (varName string, approaches internalast.Expr, direction string, err error) {
 	// Next token should be the variable
 	p.nextToken()
 	if p.curToken.Type != IDENT {
 		p.addError("expected identifier for limit variable")
-		return nil, fmt.Errorf("expected identifier for limit variable in \\lim")
+		return "", nil, "", fmt.Errorf("expected identifier for limit variable in \\lim")
 	}
 
 	varName = p.curToken.Literal
@@ -72,27 +81,48 @@ func (p *Parser) parseLimitExpression(braceStyle bool) (internalast.Expr, error)
 		p.addError("warning: couldn't find 'to' in limit expression, assuming implied")
 	}
 
-	// Skip any additional whitespace or non-significant tokens
+	// Skip any additional whitespace or non-significant tokens, keeping the sign of
+	// a negative value such as -\infty
 	for p.curToken.Type != IDENT && p.curToken.Type != NUMBER &&
-		p.curToken.Type != COMMAND && p.curToken.Type != RBRACE {
+		p.curToken.Type != COMMAND && p.curToken.Type != RBRACE && p.curToken.Type != MINUS {
 		p.nextToken()
 	}
 
-	// Now parse the approach value
-	approaches, err := p.parseExpression(LOWEST)
+	// Now parse the approach value, stopping before a one-sided direction suffix (0^+ or 0^{-})
+	p.stopBefore = p.peekIsLimitDirection
+	approaches, err = p.parseExpression(LOWEST)
+	p.stopBefore = nil
 	if err != nil {
-		return nil, err
+		return "", nil, "", err
+	}
+
+	if p.peekIsLimitDirection() {
+		p.nextToken() // consume '^'
+		braced := p.peekToken.Type == LBRACE
+		if braced {
+			p.nextToken() // consume '{'
+		}
+		p.nextToken() // move to '+' or '-'
+		direction = p.curToken.Literal
+		if braced {
+			p.nextToken() // consume '}'
+		}
 	}
 
 	// Check for closing brace
 	if p.peekToken.Type != RBRACE {
 		p.addError("expected '}' after approach value in \\lim")
-		return nil, fmt.Errorf("expected '}' after approach value in \\lim")
+		return "", nil, "", fmt.Errorf("expected '}' after approach value in \\lim")
 	}
 	// Consume closing brace
 	p.nextToken() // Consume the closing brace '}'
+	return varName, approaches, direction, nil
+}
 
-	// Move past the closing brace to get ready for the body expression
+// parseLimitBody parses the expression a limit applies to. It is called positioned
+// on the last token before the body.
+func (p *Parser) parseLimitBody(varName string, approaches internalast.Expr, direction string) (internalast.Expr, error) {
+	// Move past the closing brace (or \\lim) to get ready for the body expression
 	// This is the key fix - ensuring we're positioned correctly for parsing the body
 	p.nextToken()
 
@@ -106,6 +136,68 @@ func (p *Parser) parseLimitExpression(braceStyle bool) (internalast.Expr, error)
 	return &internalast.LimitExpr{
 		Var:        varName,
 		Approaches: approaches,
+		Direction:  direction,
 		Body:       body,
 	}, nil
 }
+
+// peekIsLimitDirection reports whether the upcoming tokens are a one-sided limit
+// direction suffix closing the subscript: ^+}, ^-}, ^{+}} or ^{-}}.
+func (p *Parser) peekIsLimitDirection() bool {
+	if p.peekToken.Type != CARET {
+		return false
+	}
+	next := p.lookahead(4)
+	isSign := func(t Token) bool { return t.Type == PLUS || t.Type == MINUS }
+	if len(next) >= 2 && isSign(next[0]) && next[1].Type == RBRACE {
+		return true
+	}
+	return len(next) >= 4 && next[0].Type == LBRACE && isSign(next[1]) &&
+		next[2].Type == RBRACE && next[3].Type == RBRACE
+}
+
+// parseUnderset handles \underset{x \to a}{\lim} f(x), the limit \lim_{x \to a} f(x)
+// with its subscript set under the operator. The first argument is read as the
+// subscript of \lim, so any form accepted there works, and the limit applies to
+// the expression following the second. Only \lim is supported as the second
+// argument. The parser is expected to be positioned on \underset.
+func (p *Parser) parseUnderset() (internalast.Expr, error) {
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\underset")
+	}
+	if !p.undersetIsLimit() {
+		p.addError("\\underset is only supported for limits, as in \\underset{x \\to 0}{\\lim}")
+		return nil, fmt.Errorf("\\underset is only supported for limits, as in \\underset{x \\to 0}{\\lim}")
+	}
+	varName, approaches, direction, err := p.parseLimitSubscript()
+	if err != nil {
+		return nil, err
+	}
+	p.nextToken() // move to '{'
+	p.nextToken() // move to \lim
+	p.nextToken() // move to '}'
+	return p.parseLimitBody(varName, approaches, direction)
+}
+
+// undersetIsLimit reports whether the second argument of the \underset whose first
+// '{' is the current token is {\lim}.
+func (p *Parser) undersetIsLimit() bool {
+	depth := 1 // The current '{'
+	tokens := append([]Token{p.peekToken}, p.lookahead(64)...)
+	for i, tok := range tokens {
+		switch tok.Type {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		case EOF:
+			return false
+		}
+		if depth == 0 {
+			base := tokens[i+1:]
+			return len(base) >= 3 && base[0].Type == LBRACE &&
+				base[1].Type == COMMAND && base[1].Literal == "lim" && base[2].Type == RBRACE
+		}
+	}
+	return false
+}
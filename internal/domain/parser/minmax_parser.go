@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// listArgCommands are the commands taking parenthesized, comma-separated arguments.
+var listArgCommands = map[string]bool{"min": true, "max": true, "gcd": true}
+
+// operatorListCommands are the functions taking parenthesized, comma-separated
+// arguments that are only named with \operatorname, mapped to their number of
+// arguments: \operatorname{clamp}(x, lo, hi) and \operatorname{lerp}(a, b, t).
+// \operatorname{mod}(a, n), also written \mod(a, n), is the function form of a \bmod n,
+// and \operatorname{atan2}(y, x), also written \arctan2(y, x), the angle of (x, y).
+var operatorListCommands = map[string]int{"clamp": 3, "lerp": 3, "mod": 2, "atan2": 2}
+
+// readOperatorListName reads the name of the \operatorname{clamp}(...) on which
+// the parser is positioned, if it names a function of operatorListCommands or
+// listArgCommands followed by '('. The parser is then left on the '}'.
+func (p *Parser) readOperatorListName() (string, bool) {
+	next := p.lookahead(4)
+	if p.peekToken.Type != LBRACE || len(next) < 3 || next[0].Type != IDENT {
+		return "", false
+	}
+	// A name ending in digits, like atan2, is lexed as an identifier and a number
+	name, rest := next[0].Literal, next[1:]
+	if rest[0].Type == NUMBER && len(rest) == 3 {
+		name, rest = name+rest[0].Literal, rest[1:]
+	}
+	if len(rest) < 2 || rest[0].Type != RBRACE || rest[1].Type != LPAREN {
+		return "", false
+	}
+	if _, ok := operatorListCommands[name]; !ok && !listArgCommands[name] {
+		return "", false
+	}
+	for range len(next) - len(rest) + 2 {
+		p.nextToken() // move to '{', through the name, to '}'
+	}
+	return name, true
+}
+
+// parseArgumentList handles the parenthesized, comma-separated arguments of
+// \min(a, b, ...), \max(a, b, ...) and \gcd(a, b, ...), and of the functions of
+// operatorListCommands. The parser is expected to be positioned on the command,
+// or the '}' of its \operatorname, with '(' as the next token.
+func (p *Parser) parseArgumentList(funcName string) (internalast.Expr, error) {
+	p.nextToken() // consume '('
+	args := []internalast.Expr{}
+	for {
+		p.nextToken() // move to the argument
+		arg, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil, fmt.Errorf("expected ')' after arguments of \\%s", funcName)
+	}
+	if arity, ok := operatorListCommands[funcName]; ok {
+		if len(args) != arity {
+			p.addError("%s requires %d arguments, got %d", funcName, arity, len(args))
+			return nil, fmt.Errorf("%s requires %d arguments, got %d", funcName, arity, len(args))
+		}
+	} else if len(args) < 2 {
+		p.addError("\\%s requires at least 2 arguments, got %d", funcName, len(args))
+		return nil, fmt.Errorf("\\%s requires at least 2 arguments, got %d", funcName, len(args))
+	}
+	if funcName == "mod" {
+		return &internalast.BinaryExpr{Op: "mod", Left: args[0], Right: args[1]}, nil
+	}
+	return &internalast.FuncCall{FuncName: funcName, Args: args}, nil
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// \pmod means a modulo value or the modulus of a congruence depending on context:
+//
+//	a \pmod{n}             -> BinaryExpr{Op: "mod"}, the value a mod n
+//	a \equiv b \pmod{n}    -> CongruenceExpr, true when a - b is a multiple of n
+//
+// Both \equiv and \pmod bind like the relational operators, so a + b \pmod n is
+// (a + b) mod n and the right side of \equiv ends before \pmod. The modulus is a
+// single primary (\pmod 3, \pmod n) or a braced expression (\pmod{n + 1}).
+
+// parseModuloValue handles a \pmod trailing an expression without \equiv.
+// The parser is expected to be positioned on \pmod.
+func (p *Parser) parseModuloValue(left internalast.Expr) (internalast.Expr, error) {
+	modulus, err := p.parseModulus()
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.BinaryExpr{Op: "mod", Left: left, Right: modulus}, nil
+}
+
+// parseCongruence handles a \equiv b \pmod{n}. The parser is expected to be
+// positioned on \equiv; a congruence without \pmod is an error.
+func (p *Parser) parseCongruence(left internalast.Expr) (internalast.Expr, error) {
+	p.nextToken() // move to the right side
+	right, err := p.parseExpression(RELATIONAL)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(PMOD) {
+		return nil, fmt.Errorf("expected \\pmod after congruence")
+	}
+	modulus, err := p.parseModulus()
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.CongruenceExpr{Left: left, Right: right, Modulus: modulus}, nil
+}
+
+// parseModulus parses the argument of \pmod. It is called positioned on \pmod
+// and leaves the parser on the last token of the modulus.
+func (p *Parser) parseModulus() (internalast.Expr, error) {
+	if p.peekToken.Type == LBRACE {
+		p.nextToken() // consume '{'
+		p.nextToken() // move to the modulus
+		modulus, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after modulus of \\pmod")
+		}
+		return modulus, nil
+	}
+	if !isPrimaryStart(p.peekToken.Type) {
+		p.addError("expected a modulus after \\pmod")
+		return nil, fmt.Errorf("expected a modulus after \\pmod")
+	}
+	p.nextToken() // move to the primary
+	return p.parseExpression(CALL)
+}
@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseNorm handles the p-norm \lVert v \rVert_p of a vector named v. The order
+// p is a number of at least 1 or \infty, written as a single primary (_1,
+// _\infty) or braced (_{2}); without a subscript the norm is the Euclidean (L2)
+// one. The parser is expected to be positioned on \lVert.
+func (p *Parser) parseNorm() (internalast.Expr, error) {
+	if !p.expectPeek(IDENT) {
+		return nil, fmt.Errorf("expected a vector name after \\lVert")
+	}
+	vector := p.curToken.Literal
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != "rVert" {
+		p.addError("expected \\rVert after the vector %s of a norm", vector)
+		return nil, fmt.Errorf("expected \\rVert after the vector %s of a norm", vector)
+	}
+	p.nextToken() // move to \rVert
+
+	order := 2.0
+	if p.peekToken.Type == UNDERSCORE {
+		p.nextToken() // move to '_'
+		arg, err := p.parseScriptArgument("norm")
+		if err != nil {
+			return nil, err
+		}
+		order = 0 // Invalid unless the subscript is a number or \infty
+		switch a := arg.(type) {
+		case *internalast.NumberLiteral:
+			order = a.Value
+		case *internalast.ConstantExpr:
+			if a.Name == "infty" {
+				order = math.Inf(1)
+			}
+		}
+		if order < 1 {
+			p.addError("the order of a norm must be a number of at least 1 or \\infty")
+			return nil, fmt.Errorf("the order of a norm must be a number of at least 1 or \\infty")
+		}
+	}
+	return &internalast.NormExpr{Vector: vector, Order: order}, nil
+}
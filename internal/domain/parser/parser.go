@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 
 	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
 )
@@ -12,8 +14,15 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ANNOTATED_ARROW // \xrightarrow{n \to \infty}
+	LOGICAL_IFF     // \iff
+	LOGICAL_IMPLIES // \implies
+	LOGICAL_OR  // \lor
+	LOGICAL_AND // \land
+	RELATIONAL  // <, >, \le, \ge, \ne
 	SUM      // +, -
 	PRODUCT  // *, /
+	UNIT     // 1\,\text{m} (unit annotation)
 	EXPONENT // ^
 	PREFIX   // -X (unary minus)
 	POSTFIX  // X! (factorial)
@@ -21,16 +30,143 @@ const (
 )
 
 var precedences = map[TokenType]int{
+	XARROW:     ANNOTATED_ARROW,
+	IFF:        LOGICAL_IFF,
+	IMPLIES:    LOGICAL_IMPLIES,
+	OR:         LOGICAL_OR,
+	AND:        LOGICAL_AND,
+	LT:         RELATIONAL,
+	GT:         RELATIONAL,
+	LE:         RELATIONAL,
+	GE:         RELATIONAL,
+	NEQ:        RELATIONAL,
+	EQUALS:     RELATIONAL, // Only in conditions, see Parser.equality
+	EQUIV:      RELATIONAL,
+	PMOD:       RELATIONAL, // Applies to the whole arithmetic expression before it
 	PLUS:       SUM,
 	MINUS:      SUM,
+	PM:         SUM,
+	MP:         SUM,
 	ASTERISK:   PRODUCT,
 	SLASH:      PRODUCT,
+	BMOD:       PRODUCT, // a \bmod n binds like a quotient
+	IDENT:      PRODUCT, // Implicit multiplication: 2x, n x
 	CARET:      EXPONENT,
 	EXCLAMATION: POSTFIX, // Factorial has higher precedence
 	LPAREN:     CALL,
 	COMMAND:    CALL,
 }
 
+// infixOperators maps operator tokens whose literal is a LaTeX command name
+// to the operator symbol stored in the AST.
+var infixOperators = map[TokenType]string{
+	LE:  "<=",
+	GE:  ">=",
+	NEQ: "!=",
+	EQUALS: "==",
+	AND: "&&",
+	OR:  "||",
+	IMPLIES: "⟹", // Generated as !p || q
+	IFF:     "⟺", // Generated as p == q
+	PM:  "±", // Both signs: the upper one for the first result, the lower one for the second
+	MP:  "∓",
+	BMOD: "mod",
+}
+
+// singleArgCommands are the commands taking exactly one argument, which may
+// also be written without braces as a single primary: \sqrt x, \sin 2.
+var singleArgCommands = map[string]bool{
+	"sqrt": true,
+	"sin":  true,
+	"cos":  true,
+	"tan":  true,
+	"sinh": true,
+	"cosh": true,
+	"tanh": true,
+	"ln":   true, // Natural logarithm, \ln x
+	"log":  true, // Natural logarithm too, \log x
+	"Re":   true, // Real part, \Re(z)
+	"Im":   true, // Imaginary part, \Im(z)
+	"arg":  true, // Argument (phase), \arg(z)
+	"sgn":  true, // Sign function, \sgn(x)
+
+	"round":    true, // Rounding half away from zero, \operatorname{round}(x)
+	"trunc":    true, // Rounding toward zero, \operatorname{trunc}(x)
+	"fracpart": true, // Fractional part, \operatorname{frac}(x)
+	"sinc":     true, // Unnormalized sinc, \operatorname{sinc}(x) = \sin x / x
+	"logistic": true, // Logistic sigmoid, \operatorname{logistic}(x) = 1 / (1 + e^{-x})
+	"softplus": true, // \operatorname{softplus}(x) = \ln(1 + e^x)
+	"relu":     true, // Rectifier, \operatorname{relu}(x) = \max(0, x)
+	"arctan":   true, // Inverse tangent, \arctan x; \arctan2(y, x) is the two-argument atan2
+	"arcsinh":  true, // Inverse hyperbolic sine, \operatorname{arcsinh}(x) or \sinh^{-1} x
+	"arccosh":  true, // Inverse hyperbolic cosine, \operatorname{arccosh}(x) or \cosh^{-1} x
+	"arctanh":  true, // Inverse hyperbolic tangent, \operatorname{arctanh}(x) or \tanh^{-1} x
+
+	"overline": true, // Complex conjugate, \overline{z}
+	"bar":      true, // Complex conjugate, \bar{z}
+}
+
+// operatorNames are the single-argument functions named in \operatorname{...} by
+// another name than their own: \operatorname{frac} is the fractional part, not the
+// fraction \frac.
+var operatorNames = map[string]string{
+	"frac":   "fracpart",
+	"arsinh": "arcsinh", // The ISO spelling of the inverse hyperbolic functions
+	"arcosh": "arccosh",
+	"artanh": "arctanh",
+}
+
+// inverseFunctions maps the functions whose inverse is written with a -1
+// superscript, as in \sinh^{-1} x, to the name of the inverse.
+var inverseFunctions = map[string]string{
+	"sinh": "arcsinh",
+	"cosh": "arccosh",
+	"tanh": "arctanh",
+}
+
+// peekInverseSuperscript reports whether the upcoming tokens are the ^{-1} or ^-1
+// marking the inverse of a function, and consumes them if so.
+func (p *Parser) peekInverseSuperscript() bool {
+	if p.peekToken.Type != CARET {
+		return false
+	}
+	isMinusOne := func(minus, one Token) bool {
+		return minus.Type == MINUS && one.Type == NUMBER && one.Literal == "1"
+	}
+	next := p.lookahead(4)
+	n := 0
+	switch {
+	case len(next) >= 4 && next[0].Type == LBRACE && isMinusOne(next[1], next[2]) && next[3].Type == RBRACE:
+		n = 5
+	case len(next) >= 2 && isMinusOne(next[0], next[1]):
+		n = 3
+	default:
+		return false
+	}
+	for i := 0; i < n; i++ {
+		p.nextToken()
+	}
+	return true
+}
+
+// spellsCommand reports whether \command{name} is another spelling of the
+// single-argument command \name, or of the one operatorNames maps name to.
+func spellsCommand(command, name string) bool {
+	switch command {
+	case "operatorname":
+		return singleArgCommands[name] || operatorNames[name] != ""
+	case "text", "mathrm":
+		return name == "Re" || name == "Im"
+	}
+	return false
+}
+
+// constantCommands are the commands naming mathematical constants, which take no arguments.
+var constantCommands = map[string]bool{
+	"pi":    true,
+	"infty": true,
+}
+
 // --- Parser Implementation ---
 
 type (
@@ -38,6 +174,20 @@ type (
 	infixParseFn  func(internalast.Expr) (internalast.Expr, error)
 )
 
+// CommandParseFunc parses a custom LaTeX command. It is called with the parser
+// positioned on the COMMAND token and must leave the parser on the last token it consumed.
+type CommandParseFunc func(p *Parser) (internalast.Expr, error)
+
+// InfixParseFunc parses a custom infix operator. It is called with the parser
+// positioned on the operator token and the already-parsed left operand.
+type InfixParseFunc func(p *Parser, left internalast.Expr) (internalast.Expr, error)
+
+// customInfix pairs a custom infix parse function with its binding precedence.
+type customInfix struct {
+	precedence int
+	fn         InfixParseFunc
+}
+
 type Parser struct {
 	l      *Lexer
 	errors []string
@@ -47,10 +197,79 @@ type Parser struct {
 
 	prefixParseFns map[TokenType]prefixParseFn
 	infixParseFns  map[TokenType]infixParseFn
+
+	// stopBefore, when set, ends the infix loop of parseExpression before the peek
+	// token (used to stop an expression before a suffix such as the limit direction in 0^+)
+	stopBefore func() bool
+
+	// equality, when set, makes '=' the equality operator rather than ending the
+	// expression (used in the conditions of a piecewise definition, as in n = 0)
+	equality bool
+
+	// integrand, when set, makes a differential such as dx end the expression
+	// rather than multiply it (used in the body of an integral, as in \int x dx)
+	integrand bool
+
+	// Extension points registered on the public parser and installed on each stateful parser
+	customCommands map[string]CommandParseFunc
+	customInfixes  map[TokenType]customInfix
+}
+
+// lexerPool recycles lexers across Parse calls, which matters for servers
+// parsing many short expressions.
+var lexerPool = sync.Pool{
+	New: func() any { return new(Lexer) },
 }
 
 func NewParser() *Parser {
-	return &Parser{}
+	return &Parser{
+		customCommands: make(map[string]CommandParseFunc),
+		customInfixes:  make(map[TokenType]customInfix),
+	}
+}
+
+// RegisterCustomCommand makes the parser delegate \name to fn instead of the built-in command handling.
+func (p *Parser) RegisterCustomCommand(name string, fn CommandParseFunc) {
+	p.customCommands[name] = fn
+}
+
+// RegisterCustomInfix makes the parser treat tokens of type t as an infix operator
+// binding with the given precedence (e.g. SUM, PRODUCT), parsed by fn.
+func (p *Parser) RegisterCustomInfix(t TokenType, precedence int, fn InfixParseFunc) {
+	p.customInfixes[t] = customInfix{precedence: precedence, fn: fn}
+}
+
+// installExtensions copies the custom commands and infix operators registered on from.
+func (p *Parser) installExtensions(from *Parser) {
+	p.customCommands = from.customCommands
+	p.customInfixes = from.customInfixes
+	for t, ci := range from.customInfixes {
+		fn := ci.fn
+		p.registerInfix(t, func(left internalast.Expr) (internalast.Expr, error) {
+			return fn(p, left)
+		})
+	}
+}
+
+// CurToken returns the token the parser is positioned on.
+func (p *Parser) CurToken() Token {
+	return p.curToken
+}
+
+// PeekToken returns the token following the current one.
+func (p *Parser) PeekToken() Token {
+	return p.peekToken
+}
+
+// Advance moves the parser to the next token.
+func (p *Parser) Advance() {
+	p.nextToken()
+}
+
+// ParseSubExpression parses an expression starting at the current token,
+// stopping before operators that bind no tighter than precedence.
+func (p *Parser) ParseSubExpression(precedence int) (internalast.Expr, error) {
+	return p.parseExpression(precedence)
 }
 
 func newStatefulParser(l *Lexer) *Parser {
@@ -66,7 +285,10 @@ func newStatefulParser(l *Lexer) *Parser {
 	p.registerPrefix(LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(MINUS, p.parsePrefixExpression)
 	p.registerPrefix(COMMAND, p.parseCommandExpression)
-	p.registerPrefix(BEGIN, p.parsePiecewiseExpression) // Add parsing for \begin{cases}
+	p.registerPrefix(BEGIN, p.parseEnvironment) // \begin{cases}, \begin{matrix}, \begin{array}, ...
+	p.registerPrefix(NOT, p.parseNotExpression)
+	p.registerPrefix(PIPE, p.parseAbsoluteValue) // |x|
+	p.registerPrefix(NULLDELIM, p.parseEvaluationBar) // \left. x^2 \right|_0^1
 
 	p.registerInfix(PLUS, p.parseInfixExpression)
 	p.registerInfix(MINUS, p.parseInfixExpression)
@@ -74,6 +296,14 @@ func newStatefulParser(l *Lexer) *Parser {
 	p.registerInfix(SLASH, p.parseInfixExpression)
 	p.registerInfix(CARET, p.parseInfixExpression)
 	p.registerInfix(EXCLAMATION, p.parseFactorialExpression) // Add factorial parsing
+	p.registerInfix(IDENT, p.parseImplicitProduct)
+	p.registerInfix(EQUIV, p.parseCongruence)
+	p.registerInfix(PMOD, p.parseModuloValue)
+	p.registerInfix(XARROW, p.parseAnnotatedArrow)
+	p.registerInfix(COMMAND, p.parseUnitAnnotation)
+	for _, tokType := range []TokenType{LT, GT, LE, GE, NEQ, EQUALS, AND, OR, IMPLIES, IFF, PM, MP, BMOD} {
+		p.registerInfix(tokType, p.parseInfixExpression)
+	}
 
 	p.nextToken()
 	p.nextToken()
@@ -96,7 +326,7 @@ func (p *Parser) nextToken() {
 }
 
 func (p *Parser) ParseExpression() (internalast.Expr, error) {
-	expr, err := p.parseExpression(LOWEST)
+	expr, err := p.parseStatements()
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +354,7 @@ func (p *Parser) parseExpression(precedence int) (internalast.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
-	for p.peekToken.Type != EOF && precedence < p.peekPrecedence() {
+	for p.peekToken.Type != EOF && precedence < p.peekPrecedence() && (p.stopBefore == nil || !p.stopBefore()) {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp, nil
@@ -138,7 +368,40 @@ func (p *Parser) parseExpression(precedence int) (internalast.Expr, error) {
 	return leftExp, nil
 }
 
+// lookahead returns up to n tokens following the peek token without consuming them.
+// The lexer only holds plain values, so scanning a copy leaves the parser untouched.
+func (p *Parser) lookahead(n int) []Token {
+	l := *p.l
+	tokens := make([]Token, 0, n)
+	for i := 0; i < n; i++ {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.Type == EOF {
+			break
+		}
+	}
+	return tokens
+}
+
 func (p *Parser) peekPrecedence() int {
+	if ci, ok := p.customInfixes[p.peekToken.Type]; ok {
+		return ci.precedence
+	}
+	// Only a unit annotation (1\,\text{m}) continues an expression at a command,
+	// binding the factor before it: x^2 \text{m} is (x^2) m, and a \text{kg} \cdot b \text{m} is (a kg)(b m)
+	if p.peekToken.Type == COMMAND {
+		if _, _, ok := p.peekUnit(); ok {
+			return UNIT
+		}
+		return LOWEST
+	}
+	if p.peekToken.Type == EQUALS && !p.equality {
+		return LOWEST
+	}
+	// A differential (dx) ends an integrand rather than multiplying it
+	if p.integrand && p.peekToken.Type == IDENT && isDifferential(p.peekToken.Literal) {
+		return LOWEST
+	}
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
 	}
@@ -146,6 +409,9 @@ func (p *Parser) peekPrecedence() int {
 }
 
 func (p *Parser) curPrecedence() int {
+	if ci, ok := p.customInfixes[p.curToken.Type]; ok {
+		return ci.precedence
+	}
 	if p, ok := precedences[p.curToken.Type]; ok {
 		return p
 	}
@@ -163,9 +429,32 @@ func (p *Parser) registerInfix(tokenType TokenType, fn infixParseFn) {
 // --- Parsing Functions ---
 
 func (p *Parser) parseIdentifier() (internalast.Expr, error) {
+	if p.peekTensorScript(false) {
+		return p.parseTensor()
+	}
+	// A subscripted identifier is an element of a sequence, as in a_n
+	if p.peekToken.Type == UNDERSCORE {
+		return p.parseIndexed()
+	}
 	return &internalast.Variable{Name: p.curToken.Literal}, nil
 }
 
+// parseImplicitProduct multiplies an expression by a directly following identifier,
+// as in 2x or \sin(n x). The identifier binds like '*', so 2x^2 is 2 * x^2.
+func (p *Parser) parseImplicitProduct(left internalast.Expr) (internalast.Expr, error) {
+	right, err := p.parseExpression(PRODUCT)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.BinaryExpr{Op: "*", Left: left, Right: right}, nil
+}
+
+// isDifferential reports whether an identifier is an integration differential: d
+// followed by a single-letter variable, as in dx. Longer names such as dist are not.
+func isDifferential(literal string) bool {
+	return len(literal) == 2 && literal[0] == 'd' && unicode.IsLetter(rune(literal[1]))
+}
+
 func (p *Parser) parseNumberLiteral() (internalast.Expr, error) {
 	val, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
@@ -187,6 +476,10 @@ func (p *Parser) parsePrefixExpression() (internalast.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Fold negation of a literal directly into the literal (e.g. -5 -> NumberLiteral{-5})
+	if num, ok := rightExpr.(*internalast.NumberLiteral); ok {
+		return &internalast.NumberLiteral{Value: -num.Value}, nil
+	}
 	return &internalast.BinaryExpr{
 		Op:    "*",
 		Left:  &internalast.NumberLiteral{Value: -1.0},
@@ -194,17 +487,61 @@ func (p *Parser) parsePrefixExpression() (internalast.Expr, error) {
 	}, nil
 }
 
+// parseNotExpression parses logical negation (\lnot, \neg, \text{not}).
+// The operand extends over relational operators but not over \land/\lor,
+// so \lnot x > 0 \land y reads as (\lnot (x > 0)) \land y.
+func (p *Parser) parseNotExpression() (internalast.Expr, error) {
+	p.nextToken()
+	operand, err := p.parseExpression(LOGICAL_AND)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.UnaryExpr{
+		Op:      "!",
+		Operand: operand,
+	}, nil
+}
+
 func (p *Parser) parseInfixExpression(left internalast.Expr) (internalast.Expr, error) {
+	op := p.curToken.Literal
+	if symbol, ok := infixOperators[p.curToken.Type]; ok {
+		op = symbol
+	}
 	expr := &internalast.BinaryExpr{
-		Op:   p.curToken.Literal,
+		Op:   op,
 		Left: left,
 	}
 	precedence := p.curPrecedence()
 	p.nextToken()
 	var err error
 	
-	// Special handling for ^ operator to make it right-associative
+	// Factorial powers x^{\overline{n}} and x^{\underline{n}}
+	if expr.Op == "^" && p.isFactorialPowerStart() {
+		return p.parseFactorialPower(left)
+	}
+
+	// The exponent is a braced group x^{a} or a single term x^a, parsed at the
+	// precedence of ^ so that a superscript after it is left for this check: a
+	// double superscript, x^{a}^{b} or x^a^b, is rejected by LaTeX itself, so it is
+	// reported instead of guessing between (x^a)^b and x^(a^b)
 	if expr.Op == "^" {
+		if p.curToken.Type == LBRACE {
+			expr.Right, err = p.parseBracedExponent()
+		} else {
+			expr.Right, err = p.parseExpression(precedence)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.peekToken.Type == CARET {
+			p.addError("%s", errDoubleSuperscript)
+			return nil, fmt.Errorf("%s", errDoubleSuperscript)
+		}
+		return expr, nil
+	}
+
+	// \implies is right-associative: p \implies q \implies r is p \implies (q \implies r)
+	if expr.Op == "⟹" {
 		// Pass precedence-1 to give right-side expressions higher precedence
 		expr.Right, err = p.parseExpression(precedence - 1)
 	} else {
@@ -214,9 +551,50 @@ func (p *Parser) parseInfixExpression(left internalast.Expr) (internalast.Expr,
 	if err != nil {
 		return nil, err
 	}
+
+	// Successive ordering relations form a chain: 0 \le x \le 10
+	if isOrderingOp(expr.Op) {
+		switch l := left.(type) {
+		case *internalast.BinaryExpr:
+			if isOrderingOp(l.Op) {
+				return &internalast.RelationalChain{
+					Operands: []internalast.Expr{l.Left, l.Right, expr.Right},
+					Ops:      []string{l.Op, expr.Op},
+				}, nil
+			}
+		case *internalast.RelationalChain:
+			l.Operands = append(l.Operands, expr.Right)
+			l.Ops = append(l.Ops, expr.Op)
+			return l, nil
+		}
+	}
 	return expr, nil
 }
 
+// parseBracedExponent parses the braced exponent of x^{...}, starting at its '{'.
+func (p *Parser) parseBracedExponent() (internalast.Expr, error) {
+	p.nextToken() // move past '{' to the exponent
+	exponent, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type != RBRACE {
+		p.addError("expected '}' after exponent")
+		return nil, fmt.Errorf("expected '}' after exponent")
+	}
+	p.nextToken() // consume RBRACE
+	return exponent, nil
+}
+
+// errDoubleSuperscript is reported for a superscript on a superscripted term, as in
+// x^{a}^{b} or x^a^b.
+const errDoubleSuperscript = "double superscript: write (x^{a})^{b} or x^{a^{b}} instead of x^{a}^{b}"
+
+// isOrderingOp reports whether op is an ordering relation that may be chained.
+func isOrderingOp(op string) bool {
+	return op == "<" || op == "<=" || op == ">" || op == ">="
+}
+
 func (p *Parser) parseGroupedExpression() (internalast.Expr, error) {
 	p.nextToken()
 	expr, err := p.parseExpression(LOWEST)
@@ -226,13 +604,97 @@ func (p *Parser) parseGroupedExpression() (internalast.Expr, error) {
 	if !p.expectPeek(RPAREN) {
 		return nil, fmt.Errorf("missing closing parenthesis")
 	}
+	// A subscripted group is the Pochhammer symbol (x)_n
+	if p.peekToken.Type == UNDERSCORE {
+		return p.parsePochhammer(expr)
+	}
 	return expr, nil
 }
 
+// parseAbsoluteValue parses |x| into FuncCall{"abs"}: the absolute value of a
+// real number or the modulus of a complex one.
+func (p *Parser) parseAbsoluteValue() (internalast.Expr, error) {
+	p.nextToken() // move past the opening '|'
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(PIPE) {
+		return nil, fmt.Errorf("missing closing '|'")
+	}
+	if p.peekToken.Type == UNDERSCORE {
+		p.addError("%s", errBarWithBounds)
+		return nil, fmt.Errorf("%s", errBarWithBounds)
+	}
+	return &internalast.FuncCall{FuncName: "abs", Args: []internalast.Expr{expr}}, nil
+}
+
+// roundingBrackets maps the opening floor and ceiling brackets to their closing
+// command and the function they apply.
+var roundingBrackets = map[string]struct{ closing, funcName string }{
+	"lfloor": {"rfloor", "floor"},
+	"lceil":  {"rceil", "ceil"},
+}
+
+// isClosingRoundingBracket reports whether tok is \rfloor or \rceil.
+func isClosingRoundingBracket(tok Token) bool {
+	return tok.Type == COMMAND && (tok.Literal == "rfloor" || tok.Literal == "rceil")
+}
+
+// parseRoundingBrackets parses \lfloor x \rfloor into FuncCall{"floor"} and
+// \lceil x \rceil into FuncCall{"ceil"}, as in the floor division \lfloor a/b \rfloor.
+// The parser is expected to be positioned on the opening bracket.
+func (p *Parser) parseRoundingBrackets() (internalast.Expr, error) {
+	opening := p.curToken.Literal
+	bracket := roundingBrackets[opening]
+	p.nextToken() // move past the opening bracket
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != bracket.closing {
+		p.addError("missing \\%s after \\%s", bracket.closing, opening)
+		return nil, fmt.Errorf("missing \\%s after \\%s", bracket.closing, opening)
+	}
+	p.nextToken() // move to the closing bracket
+	return &internalast.FuncCall{FuncName: bracket.funcName, Args: []internalast.Expr{expr}}, nil
+}
+
 // --- Enhanced parseCommandExpression for \sum and \prod ---
 func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 	funcName := p.curToken.Literal
 
+	// Custom commands take priority over the built-in handling
+	if fn, ok := p.customCommands[funcName]; ok {
+		return fn(p)
+	}
+
+	// \to only makes sense in the subscript of \lim, which reads it directly
+	if funcName == "to" {
+		p.addError("%s", errStrayTo)
+		return nil, fmt.Errorf("%s", errStrayTo)
+	}
+	if isApprox(p.curToken) {
+		p.addError("%s", errApprox)
+		return nil, fmt.Errorf("%s", errApprox)
+	}
+
+	// A brace opening an array, as in \left\{ \begin{array}{ll} ... \end{array} \right.,
+	// is a piecewise definition
+	if funcName == "{" && p.peekToken.Type == BEGIN {
+		return p.parseBracedCases()
+	}
+
+	// Mathematical constants: \pi, \infty
+	if constantCommands[funcName] {
+		return &internalast.ConstantExpr{Name: funcName}, nil
+	}
+
+	// A limit with its subscript set under \lim: \underset{x \to 0}{\lim} f(x)
+	if funcName == "underset" {
+		return p.parseUnderset()
+	}
+
 	// Special handling for limit expressions with underscore notation
 	if funcName == "lim" {
 		if p.peekToken.Type == UNDERSCORE {
@@ -246,6 +708,102 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		}
 	}
 
+	// Special handling for the Kronecker delta \delta_{ij}
+	if funcName == "delta" && p.peekToken.Type == UNDERSCORE {
+		return p.parseKroneckerDelta()
+	}
+
+	// Special handling for \argmin/\argmax and the \arg\min/\arg\max spelling
+	if funcName == "argmin" || funcName == "argmax" {
+		return p.parseArgOptExpression(funcName == "argmax")
+	}
+	if funcName == "arg" && p.peekToken.Type == COMMAND &&
+		(p.peekToken.Literal == "min" || p.peekToken.Literal == "max") {
+		p.nextToken() // move to \min or \max
+		return p.parseArgOptExpression(p.curToken.Literal == "max")
+	}
+
+	// Named operators with limits: \operatorname*{argmax}_{x}, \operatorname*{name}_{i=1}^{n}
+	if funcName == "operatorname" && p.peekToken.Type == ASTERISK {
+		return p.parseOperatorWithLimits()
+	}
+
+	// physics package derivatives: \dv{f}{x}, \pdv{f}{x}
+	if funcName == "dv" || funcName == "pdv" {
+		return p.parsePhysicsDerivative(funcName)
+	}
+
+	// Annotated subexpressions: \overbrace{a + b}^{\text{sum}} is a + b
+	if funcName == "overbrace" || funcName == "underbrace" {
+		return p.parseBraceAnnotation(funcName)
+	}
+
+	// Cancellation marks: \cancel{x} is x
+	if cancelCommands[funcName] {
+		return p.parseCancel(funcName)
+	}
+
+	// Norm of a vector: \lVert v \rVert_p
+	if funcName == "lVert" {
+		return p.parseNorm()
+	}
+
+	// Decorated vectors: \vec{v}, \overrightarrow{AB}, \hat{n}
+	if _, ok := vectorDecorators[funcName]; ok {
+		return p.parseVector(funcName)
+	}
+
+	// Symbols in another alphabet: \mathbb{R}, \mathcal{L}, \mathfrak{g}
+	if p.isFontSymbol(funcName) {
+		return p.parseFontSymbol(funcName), nil
+	}
+
+	// Bra-ket notation: \braket{a|b}, \bra{a}\ket{b}, \ket{\psi}
+	if funcName == "braket" {
+		return p.parseBraket()
+	}
+	if funcName == "bra" || funcName == "ket" {
+		return p.parseBraOrKet(funcName)
+	}
+
+	// Angle brackets: \langle X \rangle, \langle X \mid Y \rangle, \langle a | b \rangle
+	if funcName == "langle" {
+		return p.parseAngleBrackets()
+	}
+
+	// Floor and ceiling brackets: \lfloor a/b \rfloor, \lceil x \rceil
+	if _, ok := roundingBrackets[funcName]; ok {
+		return p.parseRoundingBrackets()
+	}
+
+	// Gradient of the following term: \nabla (x^2 + y^2)
+	if funcName == "nabla" {
+		return p.parseGradient()
+	}
+
+	// Partial differential in a derivative fraction: \frac{\partial f}{\partial x}
+	if funcName == "partial" {
+		return p.parsePartial(), nil
+	}
+
+	// \min(a, b), \max(a, b) and \gcd(a, b) take parenthesized, comma-separated arguments,
+	// like \operatorname{clamp}(x, lo, hi), \operatorname{lerp}(a, b, t) and \mod(a, n)
+	if (listArgCommands[funcName] || funcName == "mod") && p.peekToken.Type == LPAREN {
+		return p.parseArgumentList(funcName)
+	}
+	// \arctan2(y, x) is \operatorname{atan2}(y, x), unlike \arctan 2
+	if funcName == "arctan" && p.peekToken.Type == NUMBER && p.peekToken.Literal == "2" {
+		if next := p.lookahead(1); next[0].Type == LPAREN {
+			p.nextToken() // move to the 2
+			return p.parseArgumentList("atan2")
+		}
+	}
+	if funcName == "operatorname" {
+		if name, ok := p.readOperatorListName(); ok {
+			return p.parseArgumentList(name)
+		}
+	}
+
 	// Special handling for \sum and \prod
 	if (funcName == "sum" || funcName == "prod") {
 		isProduct := funcName == "prod"
@@ -257,27 +815,40 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		}
 		p.nextToken() // consume '_'
 
+		// \sum_n a_n runs over the indices of the sequences it indexes
+		if index, ok := p.parseRangeIndex(); ok {
+			return p.parseRangeSum(funcName, index)
+		}
+
 		if p.peekToken.Type != LBRACE {
 			p.addError("expected '{' after '_' in \\%s", funcName)
 			return nil, fmt.Errorf("expected '{' after '_' in \\%s", funcName)
 		}
 		p.nextToken() // consume '{'
 
-		p.nextToken() // move to variable
-		varName := ""
-		if p.curToken.Type == IDENT {
-			varName = p.curToken.Literal
+		p.nextToken() // move to variable (or \substack)
+		var varName string
+		var lower, upper, filter internalast.Expr
+		var err error
+		var values []internalast.Expr
+		if p.curToken.Type == COMMAND && p.curToken.Literal == "substack" {
+			// \sum_{\substack{i=1 \\ i \ne j}}: index on the first line, conditions below
+			varName, lower, filter, err = p.parseSubstack(funcName)
+		} else if p.curToken.Type == IDENT && p.peekToken.Type == COMMAND && p.peekToken.Literal == "in" {
+			if next := p.lookahead(1); len(next) == 1 && next[0].Type == LBRACKET {
+				// \sum_{k \in [1, n]}: the index runs over the integers of the interval
+				varName, lower, upper, err = p.parseIndexInterval(funcName)
+			} else {
+				// \sum_{i \in \{1,3,5\}}: the index takes each listed value
+				varName, values, err = p.parseIndexSet(funcName)
+			}
 		} else {
-			p.addError("expected identifier for summation variable in \\%s", funcName)
-			return nil, fmt.Errorf("expected identifier for summation variable in \\%s", funcName)
-		}
-		p.nextToken() // move to '='
-		if p.curToken.Type != EQUALS {
-			p.addError("expected '=' after variable in \\%s lower bound", funcName)
-			return nil, fmt.Errorf("expected '=' after variable in \\%s lower bound", funcName)
+			varName, lower, err = p.parseSumIndex(funcName)
+			if err == nil && p.peekToken.Type == COMMA {
+				// \sum_{i=1,3,5}: the lower bound was the first listed value
+				values, err = p.parseIndexValues(funcName, lower)
+			}
 		}
-		p.nextToken() // move to lower bound expr
-		lower, err := p.parseExpression(LOWEST)
 		if err != nil {
 			return nil, err
 		}
@@ -287,32 +858,33 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 			return nil, fmt.Errorf("expected '}' after lower bound in \\%s", funcName)
 		}
 		p.nextToken() // consume RBRACE
-
-		// Expect superscript (upper bound): ^{n}
-		if p.peekToken.Type != CARET {
-			p.addError("expected '^' for upper bound after lower bound in \\%s", funcName)
-			return nil, fmt.Errorf("expected '^' for upper bound after lower bound in \\%s", funcName)
-		}
-		p.nextToken() // consume '}'
-		p.nextToken() // consume '^'
-		if p.curToken.Type != LBRACE {
-			p.addError("expected '{' after '^' in \\%s", funcName)
-			return nil, fmt.Errorf("expected '{' after '^' in \\%s", funcName)
+		if values != nil {
+			return p.parseIndexSetSum(funcName, varName, values)
 		}
-		p.nextToken() // move to upper bound expr
-		upper, err := p.parseExpression(LOWEST)
-		if err != nil {
-			return nil, err
-		}
-		// After parsing the upper bound, expect to see RBRACE as the next token
-		if p.peekToken.Type != RBRACE {
-			p.addError("expected '}' after upper bound in \\%s", funcName)
-			return nil, fmt.Errorf("expected '}' after upper bound in \\%s", funcName)
+
+		if upper != nil {
+			// The interval holds both bounds
+			if p.peekToken.Type == CARET {
+				p.addError("\\%s over the interval of %s takes no upper bound", funcName, varName)
+				return nil, fmt.Errorf("\\%s over the interval of %s takes no upper bound", funcName, varName)
+			}
+		} else {
+			// Expect superscript (upper bound): ^{n}
+			if p.peekToken.Type != CARET {
+				p.addError("expected '^' for upper bound after lower bound in \\%s", funcName)
+				return nil, fmt.Errorf("expected '^' for upper bound after lower bound in \\%s", funcName)
+			}
+			p.nextToken() // move to '^'
+			upper, err = p.parseBound(funcName, "upper")
+			if err != nil {
+				return nil, err
+			}
 		}
-		p.nextToken() // consume RBRACE
 		p.nextToken() // advance to body token
 
-		body, err := p.parseExpression(LOWEST)
+		// The body is the immediate term: products and powers belong to it, while
+		// \sum_{i=1}^{n} i + c means (\sum_{i=1}^{n} i) + c
+		body, err := p.parseExpression(SUM)
 		if err != nil {
 			return nil, err
 		}
@@ -322,6 +894,7 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 			Var:       varName,
 			Lower:     lower,
 			Upper:     upper,
+			Filter:    filter,
 			Body:      body,
 		}, nil
 	}
@@ -335,57 +908,32 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		if p.peekToken.Type == UNDERSCORE {
 			isDefinite = true
 			
-			// Parse lower bound: _{a}
+			// Parse lower bound: _{a} or _0
 			p.nextToken() // consume '_'
-			if p.peekToken.Type != LBRACE {
-				p.addError("expected '{' after '_' in \\%s", funcName)
-				return nil, fmt.Errorf("expected '{' after '_' in \\%s", funcName)
-			}
-			p.nextToken() // consume '{'
-			p.nextToken() // move to lower bound expression
 			var err error
-			lower, err = p.parseExpression(LOWEST)
+			lower, err = p.parseBound(funcName, "lower")
 			if err != nil {
 				return nil, err
 			}
 			
-			// After parsing the lower bound, expect to see RBRACE
-			if p.peekToken.Type != RBRACE {
-				p.addError("expected '}' after lower bound in \\%s", funcName)
-				return nil, fmt.Errorf("expected '}' after lower bound in \\%s", funcName)
-			}
-			p.nextToken() // consume RBRACE
-			
-			// Parse upper bound: ^{b}
+			// Parse upper bound: ^{b} or ^1
 			if p.peekToken.Type != CARET {
 				p.addError("expected '^' for upper bound after lower bound in \\%s", funcName)
 				return nil, fmt.Errorf("expected '^' for upper bound after lower bound in \\%s", funcName)
 			}
 			p.nextToken() // consume '^'
-			
-			if p.peekToken.Type != LBRACE {
-				p.addError("expected '{' after '^' in \\%s", funcName)
-				return nil, fmt.Errorf("expected '{' after '^' in \\%s", funcName)
-			}
-			p.nextToken() // consume '{'
-			p.nextToken() // move to upper bound expression
-			
-			upper, err = p.parseExpression(LOWEST)
+			upper, err = p.parseBound(funcName, "upper")
 			if err != nil {
 				return nil, err
 			}
-			
-			// After parsing the upper bound, expect to see RBRACE
-			if p.peekToken.Type != RBRACE {
-				p.addError("expected '}' after upper bound in \\%s", funcName)
-				return nil, fmt.Errorf("expected '}' after upper bound in \\%s", funcName)
-			}
-			p.nextToken() // consume RBRACE
 		}
 		
 		// Parse the body of the integral
 		p.nextToken() // Move to the body expression
+		outerIntegrand := p.integrand
+		p.integrand = true
 		body, err := p.parseExpression(LOWEST)
+		p.integrand = outerIntegrand
 		if err != nil {
 			return nil, err
 		}
@@ -393,10 +941,18 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		// Find the differential variable (e.g., "dx" in \int f(x) dx)
 		// Look for a command or identifier that should represent the differential
 		var integrationVar string
-		if p.peekToken.Type == IDENT && strings.HasPrefix(p.peekToken.Literal, "d") {
+		if p.peekToken.Type == IDENT && isDifferential(p.peekToken.Literal) {
 			// Extract the variable name from "dx", "dy", etc.
 			integrationVar = strings.TrimPrefix(p.peekToken.Literal, "d")
 			p.nextToken() // consume the differential
+		} else if p.peekToken.Type == COMMAND && p.peekToken.Literal == "dd" {
+			// physics package differential: \dd{x}
+			p.nextToken() // move to \dd
+			var err error
+			integrationVar, err = p.parseDifferentialMacro()
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			// If no differential is specified, default to "x"
 			integrationVar = "x"
@@ -457,6 +1013,26 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		// This is just a partial implementation - a real one would need to rewind properly
 	}
 	
+	// \sinh^{-1} x is the inverse hyperbolic sine \operatorname{arcsinh}(x)
+	if inverse, ok := inverseFunctions[funcName]; ok && p.peekInverseSuperscript() {
+		funcName = inverse
+	}
+
+	// \operatorname{sgn} spells a single-argument command like \sgn, and \text{Re}
+	// or \mathrm{Im} the real or imaginary part
+	if p.peekToken.Type == LBRACE {
+		next := p.lookahead(2)
+		if len(next) == 2 && next[0].Type == IDENT && spellsCommand(funcName, next[0].Literal) && next[1].Type == RBRACE {
+			p.nextToken() // consume '{'
+			p.nextToken() // move to the name
+			funcName = p.curToken.Literal
+			if renamed, ok := operatorNames[funcName]; ok {
+				funcName = renamed
+			}
+			p.nextToken() // consume '}'
+		}
+	}
+
 	// Standard argument parsing
 	for p.peekToken.Type == LBRACE {
 		p.nextToken() // consume LBRACE
@@ -486,6 +1062,32 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		p.nextToken() // consume RBRACE
 	}
 
+	// Indicator function: \mathbb{1}[condition]
+	if funcName == "mathbb" && len(args) == 1 && p.peekToken.Type == LBRACKET {
+		if one, ok := args[0].(*internalast.NumberLiteral); ok && one.Value == 1 {
+			return p.parseIndicator()
+		}
+	}
+
+	// Expectation and variance operators: \mathbb{E}[X], \text{Var}[X]
+	if len(args) == 1 && p.peekToken.Type == LBRACKET {
+		if op, ok := statisticOperator(funcName, args[0]); ok {
+			return p.parseStatisticOperator(op)
+		}
+	}
+
+	// Unbraced argument for single-argument commands: \sqrt x parses like \sqrt{x}.
+	// The command applies to the immediately following primary only, so
+	// \sin 2x is (\sin 2) * x and \sin x^2 is (\sin x)^2
+	if len(args) == 0 && singleArgCommands[funcName] && isPrimaryStart(p.peekToken.Type) {
+		p.nextToken() // move to the primary
+		argExpr, err := p.parseExpression(CALL) // CALL binds tighter than any infix, so only the primary is consumed
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argExpr)
+	}
+
 	if len(args) == 0 && funcName != "sum" && funcName != "prod" { // Allow sum/prod to have no {} args initially
 		err := fmt.Errorf("expected '{' arguments after command '\\%s', got %s", funcName, p.peekToken.Type)
 		p.addError("%s", err.Error())
@@ -498,43 +1100,25 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 	case "frac":
 		// Special case for derivatives: \frac{d}{dx} or \frac{\partial}{\partial x}
 		if len(args) == 2 {
-			// Check if this might be a derivative
-			if numExpr, ok := args[0].(*internalast.Variable); ok {
-				if numExpr.Name == "d" || numExpr.Name == "\\partial" {
-					// Check denominator for dx or \partial x pattern
-					if denExpr, ok := args[1].(*internalast.Variable); ok {
-						if strings.HasPrefix(denExpr.Name, "d") || strings.HasPrefix(denExpr.Name, "\\partial ") {
-							// This appears to be a derivative setup
-							// Extract the variable of differentiation
-							var diffVar string
-							var isPartial bool
-							
-							if strings.HasPrefix(denExpr.Name, "d") {
-								diffVar = strings.TrimPrefix(denExpr.Name, "d")
-								isPartial = false
-							} else {
-								diffVar = strings.TrimPrefix(denExpr.Name, "\\partial ")
-								isPartial = true
-							}
-							
-							// Look ahead to capture the expression being differentiated
-							if p.peekToken.Type == IDENT || p.peekToken.Type == COMMAND || 
-							   p.peekToken.Type == LPAREN || p.peekToken.Type == NUMBER {
-								body, err := p.parseExpression(LOWEST)
-								if err != nil {
-									return nil, err
-								}
-								
-								return &internalast.DerivativeExpr{
-									IsPartial: isPartial,
-									Var:       diffVar,
-									Order:     1, // First-order derivative
-									Body:      body,
-								}, nil
-							}
-						}
-					}
+			// Leibniz notation \frac{dy}{dx}, possibly multiplied by further fractions,
+			// or the operator \frac{d}{dx} applied to the expression that follows
+			deriv, ok, err := leibnizDerivative(args[0], args[1])
+			if err != nil {
+				p.addError("%s", err.Error())
+				return nil, err
+			}
+			if ok && deriv.Body != nil {
+				return p.parseDerivativeProduct(deriv)
+			}
+			if ok && (p.peekToken.Type == IDENT || p.peekToken.Type == COMMAND ||
+				p.peekToken.Type == LPAREN || p.peekToken.Type == NUMBER) {
+				p.nextToken() // move to the start of the body
+				body, err := p.parseExpression(LOWEST)
+				if err != nil {
+					return nil, err
 				}
+				deriv.Body = body
+				return deriv, nil
 			}
 		}
 		requiredArgs = 2	
@@ -602,26 +1186,31 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 		
 		// If we didn't find a limit pattern, fall back to regular function parsing
 		requiredArgs = 1
-	case "sqrt", "sin", "cos", "tan":
-		requiredArgs = 1
+	default:
+		if singleArgCommands[funcName] {
+			requiredArgs = 1
+		}
 	}
 
 	if requiredArgs != -1 && len(args) != requiredArgs {
 		err := fmt.Errorf("\\%s requires %d argument(s), got %d", funcName, requiredArgs, len(args))
+		if funcName == "frac" && len(args) == 1 {
+			err = fmt.Errorf("%w: write \\operatorname{frac}(x) for the fractional part", err)
+		}
 		p.addError("%s", err.Error())
 		return nil, err
 	}
 
 	// Check for unexpected tokens after the function and its arguments
-	// Valid tokens after a function expression can be:
-	// - EOF (end of input)
-	// - RPAREN (closing parenthesis for grouped expressions)
-	// - RBRACE (closing brace for nested LaTeX commands)
-	// - Operators (PLUS, MINUS, ASTERISK, SLASH, CARET)
-	if p.peekToken.Type != EOF && p.peekToken.Type != RPAREN && p.peekToken.Type != RBRACE && 
-	   !(p.peekToken.Type == PLUS || p.peekToken.Type == MINUS || 
-	     p.peekToken.Type == ASTERISK || p.peekToken.Type == SLASH || 
-	     p.peekToken.Type == CARET) {
+	if isStrayTo(p.peekToken) {
+		p.addError("%s", errStrayTo)
+		return nil, fmt.Errorf("%s", errStrayTo)
+	}
+	if isApprox(p.peekToken) {
+		p.addError("%s", errApprox)
+		return nil, fmt.Errorf("%s", errApprox)
+	}
+	if !canFollowExpression(p.peekToken) {
 		err := fmt.Errorf("unexpected token '%s' after expression", p.peekToken.Type)
 		p.addError("%s", err.Error())
 		return nil, err
@@ -633,6 +1222,93 @@ func (p *Parser) parseCommandExpression() (internalast.Expr, error) {
 	}, nil
 }
 
+// parseBound parses a bound of \sum, \prod or \int: a braced expression, as in
+// ^{n + 1}, or a single primary, as in \int_0^1 or ^n. It is called positioned on
+// the '_' of a lower bound or the '^' of an upper one and leaves the parser on the
+// last token of the bound.
+func (p *Parser) parseBound(funcName, which string) (internalast.Expr, error) {
+	if p.peekToken.Type != LBRACE {
+		if !isPrimaryStart(p.peekToken.Type) {
+			p.addError("expected '{' after '%s' in \\%s", p.curToken.Literal, funcName)
+			return nil, fmt.Errorf("expected '{' after '%s' in \\%s", p.curToken.Literal, funcName)
+		}
+		p.nextToken() // move to the primary
+		return p.parseExpression(CALL)
+	}
+	p.nextToken() // consume '{'
+	p.nextToken() // move to the bound
+	bound, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if p.peekToken.Type != RBRACE {
+		p.addError("expected '}' after %s bound in \\%s", which, funcName)
+		return nil, fmt.Errorf("expected '}' after %s bound in \\%s", which, funcName)
+	}
+	p.nextToken() // consume RBRACE
+	return bound, nil
+}
+
+// errStrayTo is reported for a \to outside a limit, e.g. in f: \mathbb{R} \to \mathbb{R}.
+const errStrayTo = "'\\to' is only valid inside \\lim"
+
+// errApprox is reported for \approx, or ≈, which states that two values are close
+// rather than computing anything.
+const errApprox = "'\\approx' is not supported: an approximation has no value to compute, use = for an equation"
+
+// isStrayTo reports whether tok is a \to arrow met outside the subscript of \lim.
+func isStrayTo(tok Token) bool {
+	return tok.Type == COMMAND && tok.Literal == "to"
+}
+
+// isApprox reports whether tok is \approx, which the lexer also makes of ≈.
+func isApprox(tok Token) bool {
+	return tok.Type == COMMAND && tok.Literal == "approx"
+}
+
+// isPrimaryStart reports whether t can begin an unbraced command argument.
+func isPrimaryStart(t TokenType) bool {
+	switch t {
+	case IDENT, NUMBER, COMMAND, LPAREN:
+		return true
+	}
+	return false
+}
+
+// canFollowExpression reports whether tok may follow a complete expression, such
+// as a command with its arguments. The rule is the same for every expression:
+//   - EOF (end of input)
+//   - RPAREN (closing parenthesis for grouped expressions)
+//   - RBRACE (closing brace for nested LaTeX commands)
+//   - Binary operators (PLUS, MINUS, ASTERISK, SLASH, CARET, relational and logical operators)
+//   - EXCLAMATION (factorial, as in \binom{n}{k}!)
+//   - IDENT (implicit multiplication, as in \sin 2x)
+//   - SEMICOLON (end of an assignment, as in u = \sqrt{x}; u + 1)
+//   - AMPERSAND, '\\' and END (end of a cell, row or environment, as in
+//     \begin{cases} \sqrt{x} & x > 0 \\ ... \end{cases})
+//   - A closing floor or ceiling bracket, as in \lfloor \frac{a}{b} \rfloor
+func canFollowExpression(tok Token) bool {
+	switch tok.Type {
+	case EOF, RPAREN, RBRACE, PIPE, EXCLAMATION, IDENT, SEMICOLON, AMPERSAND, END:
+		return true
+	}
+	return isOperatorToken(tok.Type) || isClosingRoundingBracket(tok) || isRowSeparator(tok)
+}
+
+// isRowSeparator reports whether tok is the '\\' ending a row of an environment.
+func isRowSeparator(tok Token) bool {
+	return tok.Type == COMMAND && tok.Literal == "\\"
+}
+
+// isOperatorToken reports whether t is a binary operator that may follow a complete expression.
+func isOperatorToken(t TokenType) bool {
+	switch t {
+	case PLUS, MINUS, PM, MP, ASTERISK, SLASH, CARET, LT, GT, LE, GE, NEQ, AND, OR, IMPLIES, IFF, XARROW, EQUIV, PMOD, BMOD:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) expectPeek(t TokenType) bool {
 	if p.peekToken.Type == t {
 		p.nextToken()
@@ -643,119 +1319,72 @@ func (p *Parser) expectPeek(t TokenType) bool {
 }
 
 func (p *Parser) peekError(t TokenType) {
+	if isStrayTo(p.peekToken) {
+		p.addError("%s", errStrayTo)
+		return
+	}
+	if isApprox(p.peekToken) {
+		p.addError("%s", errApprox)
+		return
+	}
 	p.addError("expected next token to be %s, got %s ('%s') instead", t, p.peekToken.Type, p.peekToken.Literal)
 }
 
-func (p *Parser) parsePiecewiseExpression() (internalast.Expr, error) {
-	// Check if this is a \begin{cases} environment
-	if p.curToken.Type != BEGIN {
-		return nil, fmt.Errorf("expected \\begin for piecewise expression")
-	}
-	
-	// Check for the opening brace and "cases" environment
-	if p.peekToken.Type != LBRACE {
-		p.addError("expected '{' after \\begin for cases environment")
-		return nil, fmt.Errorf("expected '{' after \\begin for cases environment")
-	}
-	p.nextToken() // consume '{'
-	
-	// Read the environment type (should be "cases")
-	p.nextToken() // move to environment identifier
-	if p.curToken.Type != IDENT || p.curToken.Literal != "cases" {
-		p.addError("expected 'cases' for piecewise environment")
-		return nil, fmt.Errorf("expected 'cases' for piecewise environment")
-	}
-	
-	// Check for closing brace
-	if p.peekToken.Type != RBRACE {
-		p.addError("expected '}' after 'cases' in \\begin")
-		return nil, fmt.Errorf("expected '}' after 'cases' in \\begin")
+// parsePiecewiseExpression parses the rows of a \begin{cases} environment whose
+// header has already been consumed. Each row is "value & condition"; a row without
+// a condition is the "otherwise" case.
+func (p *Parser) parsePiecewiseExpression(envName string) (internalast.Expr, error) {
+	rows, err := p.parseEnvironmentRows(envName, true)
+	if err != nil {
+		return nil, err
 	}
-	p.nextToken() // consume '}'
-	p.nextToken() // move past '}'
-	
-	// Now parse the cases until we reach \end{cases}
+
 	cases := []internalast.PiecewiseCase{}
-	
-	for p.curToken.Type != END {
-		// Parse the case value (expression)
-		value, err := p.parseExpression(LOWEST)
-		if err != nil {
-			return nil, err
+	for _, row := range rows {
+		if len(row) > 2 {
+			p.addError("expected 'value & condition' in %s row, got %d columns", envName, len(row))
+			return nil, fmt.Errorf("expected 'value & condition' in %s row, got %d columns", envName, len(row))
 		}
-		
-		// Check for the condition separator (usually &)
-		// Note: This is a simplification, as LaTeX typically uses & for alignment
-		var condition internalast.Expr
-		if p.peekToken.Type == IDENT && p.peekToken.Literal == "&" {
-			p.nextToken() // consume the alignment marker
-			
-			// Parse the condition expression
-			condition, err = p.parseExpression(LOWEST)
-			if err != nil {
-				return nil, err
-			}
+		if row[0] == nil {
+			p.addError("expected a value before the condition in %s row", envName)
+			return nil, fmt.Errorf("expected a value before the condition in %s row", envName)
 		}
-		
-		// Add the case
-		cases = append(cases, internalast.PiecewiseCase{
-			Value:     value,
-			Condition: condition,
-		})
-		
-		// Look for case separator (usually \\)
-		// Again, this is a simplification
-		if p.peekToken.Type == COMMAND && p.peekToken.Literal == "\\" {
-			p.nextToken() // consume the line break
+		piecewiseCase := internalast.PiecewiseCase{Value: row[0]}
+		if len(row) == 2 {
+			piecewiseCase.Condition = row[1]
 		}
-		
-		// Move to the next token to continue parsing
-		p.nextToken()
-	}
-	
-	// Now we should be at \end{cases}
-	if p.curToken.Type != END {
-		p.addError("expected \\end for cases environment")
-		return nil, fmt.Errorf("expected \\end for cases environment")
-	}
-	
-	// Check for the closing environment tag
-	if p.peekToken.Type != LBRACE {
-		p.addError("expected '{' after \\end")
-		return nil, fmt.Errorf("expected '{' after \\end")
+		cases = append(cases, piecewiseCase)
 	}
-	p.nextToken() // consume '{'
-	
-	// Check that we're closing the "cases" environment
-	p.nextToken() // move to environment identifier
-	if p.curToken.Type != IDENT || p.curToken.Literal != "cases" {
-		p.addError("expected 'cases' in \\end{}")
-		return nil, fmt.Errorf("expected 'cases' in \\end{}")
+	if len(cases) == 0 {
+		p.addError("%s environment must contain at least one case", envName)
+		return nil, fmt.Errorf("%s environment must contain at least one case", envName)
 	}
-	
-	// Check for closing brace
-	if p.peekToken.Type != RBRACE {
-		p.addError("expected '}' after 'cases' in \\end")
-		return nil, fmt.Errorf("expected '}' after 'cases' in \\end")
+
+	if err := p.parseEnvironmentEnd(envName); err != nil {
+		return nil, err
 	}
-	p.nextToken() // consume '}'
-	
 	return &internalast.PiecewiseExpr{
 		Cases: cases,
 	}, nil
 }
 
+// parseFactorialExpression handles the postfix '!'. The parser is positioned on
+// the '!', which is the last token of the factorial, so that operators may follow
+// it as after any operand: n!^2, (a+b)! + 1.
 func (p *Parser) parseFactorialExpression(left internalast.Expr) (internalast.Expr, error) {
-	expr := &internalast.FactorialExpr{
+	return &internalast.FactorialExpr{
 		Value: left,
-	}
-	p.nextToken() // Consume the '!' token
-	return expr, nil
+	}, nil
 }
 
+// Parse parses a LaTeX string into an AST. Each call uses its own lexer and parser
+// state, so Parse is safe for concurrent use once custom extensions are registered.
 func (p *Parser) Parse(latexString string) (internalast.Expr, error) {
-	l := NewLexer(latexString)
+	l := lexerPool.Get().(*Lexer)
+	l.Reset(latexString)
+	defer lexerPool.Put(l)
 	statefulParser := newStatefulParser(l)
+	statefulParser.installExtensions(p)
 	expr, err := statefulParser.ParseExpression()
 	if err != nil {
 		if len(statefulParser.errors) > 0 {
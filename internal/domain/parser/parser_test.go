@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"testing"
 
@@ -162,7 +163,6 @@ func TestParser_Exponentiation(t *testing.T) {
 		{"a ^ b", "a", "^", "b"},
 		{"x ^ 2", "x", "^", 2.0},
 		{"3 ^ y", 3.0, "^", "y"},
-		{"a ^ b ^ c", "a", "^", nil}, // Right-associative (a ^ (b^c))
 		{"(a ^ b) ^ c", nil, "^", "c"}, // Grouping overrides associativity
 		{"a * b ^ c", "a", "*", nil}, // Precedence: ^ higher than *
 		{"a ^ b * c", nil, "*", "c"}, // Precedence: ^ higher than *
@@ -191,15 +191,6 @@ func TestParser_Exponentiation(t *testing.T) {
 				if tt.expectedLeft != nil {
 					testLiteralExpression(t, binExpr.Left, tt.expectedLeft)
 					}
-				
-				// For the case a ^ b ^ c, test that the right side is actually (b ^ c)
-				if tt.input == "a ^ b ^ c" {
-					rightBin, ok := binExpr.Right.(*internalast.BinaryExpr)
-					require.True(t, ok, "Expected Right of 'a ^ b ^ c' to be BinaryExpr due to right-associativity")
-					assert.Equal(t, "^", rightBin.Op, "Expected '^' operator in nested right expression")
-					testLiteralExpression(t, rightBin.Left, "b")
-					testLiteralExpression(t, rightBin.Right, "c")
-				}
 			} else {
 				testBinaryExpr(t, expr, tt.expectedLeft, tt.expectedOp, tt.expectedRight)
 			}
@@ -213,7 +204,6 @@ func TestParser_UnaryMinus(t *testing.T) {
 		expectedValue interface{}
 	}{
 		{"-a", "a"},
-		{"-5", 5.0},
 		{"- (a + b)", nil}, // Check negation of a group
 	}
 
@@ -244,6 +234,39 @@ func TestParser_UnaryMinus(t *testing.T) {
 	}
 }
 
+func TestParser_NegativeNumberLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"-5", -5.0},
+		{"-3.14", -3.14},
+		{"-(2)", -2.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			// Unary minus on a literal is folded into a negative NumberLiteral
+			testNumberLiteral(t, expr, tt.expected)
+		})
+	}
+
+	t.Run("subtraction is not folded", func(t *testing.T) {
+		l := NewLexer("a - 5")
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+		testBinaryExpr(t, expr, "a", "-", 5.0)
+	})
+}
+
 func TestParser_FunctionCalls(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -258,6 +281,9 @@ func TestParser_FunctionCalls(t *testing.T) {
 		{`\frac{a}{b}`, "frac", []interface{}{"a", "b"}, ""},
 		{`\frac{1}{x+y}`, "frac", []interface{}{1.0, nil}, ""},
 		{`\sqrt{x+y}`, "sqrt", []interface{}{nil}, ""},
+		{`\sqrt x`, "sqrt", []interface{}{"x"}, ""}, // Unbraced single primary
+		{`\sqrt 2`, "sqrt", []interface{}{2.0}, ""},
+		{`\cos (a+b)`, "cos", []interface{}{nil}, ""},
 		// Error cases
 		{`\sqrt`, "sqrt", nil, "expected '{' arguments after command"},
 		{`\sqrt{}`, "sqrt", nil, "argument expression cannot be empty"}, 
@@ -317,7 +343,7 @@ func TestParser_Errors(t *testing.T) {
 		{`* 2`, "no prefix parse function found for token ASTERISK"},
 		{`( a + b`, "missing closing parenthesis"},
 		{`a + b )`, "expected next token to be EOF, got RPAREN"},
-		{`\sqrt{x} y`, "unexpected token 'IDENT' after expression"}, // Update to match actual error
+		{`\sqrt{x} ]`, "unexpected token 'RBRACKET' after expression"}, // Update to match actual error
 		{`1.2.3`, "expected next token to be EOF, got ILLEGAL"},
 		{`{`, "no prefix parse function found for token LBRACE"},
 	}
@@ -365,8 +391,8 @@ func TestParser_AdvancedExpressions(t *testing.T) {
 		// {"\\int_{0}^{1} x dx", "IntegralExpr", false},
 		
 		// Derivative expression - using specialized frac detection
-		// Skip for now - requires more complex parsing patterns
-		// {"\\frac{d}{dx} x^2", "DerivativeExpr", false},
+		{"\\frac{d}{dx} x^2", "DerivativeExpr", false},
+		{"\\frac{dy}{dx}", "DerivativeExpr", false},
 	}
 
 	for _, tt := range tests {
@@ -390,3 +416,2250 @@ func TestParser_AdvancedExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestParser_LeibnizDerivatives(t *testing.T) {
+	t.Run(`\frac{dy}{dx}`, func(t *testing.T) {
+		l := NewLexer(`\frac{dy}{dx}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		deriv, ok := expr.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr, got %T", expr)
+		assert.Equal(t, "x", deriv.Var)
+		assert.Equal(t, 1, deriv.Order)
+		testVariable(t, deriv.Body, "y")
+	})
+
+	t.Run("chain rule product", func(t *testing.T) {
+		l := NewLexer(`\frac{dy}{du}\frac{du}{dx}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		assert.Equal(t, "*", binExpr.Op)
+		outer, ok := binExpr.Left.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr on the left, got %T", binExpr.Left)
+		assert.Equal(t, "u", outer.Var)
+		testVariable(t, outer.Body, "y")
+		inner, ok := binExpr.Right.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr on the right, got %T", binExpr.Right)
+		assert.Equal(t, "x", inner.Var)
+		testVariable(t, inner.Body, "u")
+	})
+
+	t.Run(`\frac{\partial f}{\partial x}`, func(t *testing.T) {
+		l := NewLexer(`\frac{\partial f}{\partial x}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		deriv, ok := expr.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr, got %T", expr)
+		assert.True(t, deriv.IsPartial)
+		assert.Equal(t, "x", deriv.Var)
+		assert.Equal(t, 1, deriv.Order)
+		testVariable(t, deriv.Body, "f")
+	})
+
+	t.Run("partial operator", func(t *testing.T) {
+		l := NewLexer(`\frac{\partial}{\partial x} x y`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		deriv, ok := expr.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr, got %T", expr)
+		assert.True(t, deriv.IsPartial)
+		assert.Equal(t, "x", deriv.Var)
+		assert.Equal(t, &internalast.BinaryExpr{Op: "*", Left: &internalast.Variable{Name: "x"}, Right: &internalast.Variable{Name: "y"}}, deriv.Body)
+	})
+
+	t.Run("second order", func(t *testing.T) {
+		for _, in := range []string{`\frac{d^2}{dx^2} x^4`, `\frac{\partial^2}{\partial x^2} x^4`} {
+			p := newStatefulParser(NewLexer(in))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err, in)
+			checkParserErrors(t, p)
+
+			deriv, ok := expr.(*internalast.DerivativeExpr)
+			require.True(t, ok, "Expected DerivativeExpr for %s, got %T", in, expr)
+			assert.Equal(t, "x", deriv.Var)
+			assert.Equal(t, 2, deriv.Order, in)
+			assert.Equal(t, &internalast.BinaryExpr{Op: "^", Left: &internalast.Variable{Name: "x"}, Right: &internalast.NumberLiteral{Value: 4}}, deriv.Body)
+		}
+
+		p := newStatefulParser(NewLexer(`\frac{d^2 y}{dx^2}`))
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+		deriv, ok := expr.(*internalast.DerivativeExpr)
+		require.True(t, ok, "Expected DerivativeExpr, got %T", expr)
+		assert.Equal(t, 2, deriv.Order)
+		testVariable(t, deriv.Body, "y")
+	})
+
+	t.Run("differentials of different orders", func(t *testing.T) {
+		for _, in := range []string{`\frac{d^2}{dx} x`, `\frac{d^2 y}{dx^3}`} {
+			_, err := newStatefulParser(NewLexer(in)).ParseExpression()
+			require.Error(t, err, in)
+			assert.Contains(t, err.Error(), "the numerator of a derivative is of order")
+		}
+	})
+
+	t.Run("mixed differentials are a fraction", func(t *testing.T) {
+		l := NewLexer(`\frac{\partial f}{dx}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		_, ok := expr.(*internalast.DerivativeExpr)
+		assert.False(t, ok, "Expected a plain fraction, got %T", expr)
+	})
+
+	t.Run("product binds tighter than sum", func(t *testing.T) {
+		l := NewLexer(`\frac{dy}{du}\frac{du}{dx} + 1`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		assert.Equal(t, "+", binExpr.Op)
+		product, ok := binExpr.Left.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected product on the left, got %T", binExpr.Left)
+		assert.Equal(t, "*", product.Op)
+	})
+}
+
+func TestParser_SumBodyBinding(t *testing.T) {
+	parse := func(t *testing.T, input string) internalast.Expr {
+		t.Helper()
+		p := newStatefulParser(NewLexer(input))
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+		return expr
+	}
+
+	t.Run("trailing sum stays outside", func(t *testing.T) {
+		expr := parse(t, `\sum_{i=1}^{n} i + c`)
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		assert.Equal(t, "+", binExpr.Op)
+		sum, ok := binExpr.Left.(*internalast.SumExpr)
+		require.True(t, ok, "Expected SumExpr on the left, got %T", binExpr.Left)
+		testVariable(t, sum.Body, "i")
+		testVariable(t, binExpr.Right, "c")
+	})
+
+	t.Run("products belong to the body", func(t *testing.T) {
+		expr := parse(t, `\prod_{k=1}^{n} 2 * k^2 - 1`)
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		assert.Equal(t, "-", binExpr.Op)
+		prod, ok := binExpr.Left.(*internalast.SumExpr)
+		require.True(t, ok, "Expected SumExpr on the left, got %T", binExpr.Left)
+		assert.True(t, prod.IsProduct)
+		body, ok := prod.Body.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected product body, got %T", prod.Body)
+		assert.Equal(t, "*", body.Op)
+	})
+
+	t.Run("fraction body", func(t *testing.T) {
+		expr := parse(t, `\sum_{i=1}^{n} \frac{1}{i}`)
+		sum, ok := expr.(*internalast.SumExpr)
+		require.True(t, ok, "Expected SumExpr, got %T", expr)
+		frac, ok := sum.Body.(*internalast.FuncCall)
+		require.True(t, ok, "Expected FuncCall body, got %T", sum.Body)
+		assert.Equal(t, "frac", frac.FuncName)
+	})
+}
+
+func TestParser_SpacingCommands(t *testing.T) {
+	x, y := &internalast.Variable{Name: "x"}, &internalast.Variable{Name: "y"}
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`x \, + \quad y`, &internalast.BinaryExpr{Op: "+", Left: x, Right: y}},
+		{`\frac{x\,}{\quad y} \qquad`, &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{x, y}}},
+		{`\int_{0}^{1} x \, dx`, &internalast.IntegralExpr{
+			IsDefinite: true, Var: "x", Lower: &internalast.NumberLiteral{Value: 0}, Upper: &internalast.NumberLiteral{Value: 1}, Body: x,
+		}},
+		{`\int y \quad dy`, &internalast.IntegralExpr{Var: "y", Body: y}},
+		{`\int x\!dx`, &internalast.IntegralExpr{Var: "x", Body: x}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_AnnotatedArrows(t *testing.T) {
+	n, x := &internalast.Variable{Name: "n"}, &internalast.Variable{Name: "x"}
+	one := &internalast.NumberLiteral{Value: 1}
+	reciprocal := &internalast.BinaryExpr{Op: "/", Left: one, Right: n}
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		// The annotation of a limit makes the arrow the limit of its source
+		{`\frac{1}{n} \xrightarrow{n \to \infty} 0`, &internalast.LimitExpr{
+			Var: "n", Approaches: &internalast.ConstantExpr{Name: "infty"},
+			Body: &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{one, n}},
+		}},
+		{`0 \xleftarrow{n \to 0^+} 1 / n`, &internalast.LimitExpr{
+			Var: "n", Approaches: &internalast.NumberLiteral{Value: 0}, Direction: "+", Body: reciprocal,
+		}},
+		// The arrow binds the loosest
+		{`x + 1 \xrightarrow{x \to 1} 2 \cdot y`, &internalast.LimitExpr{
+			Var: "x", Approaches: one, Body: &internalast.BinaryExpr{Op: "+", Left: x, Right: one},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`x \xrightarrow y`, "expected '{' after \\xrightarrow"},
+		// Other annotations only label the arrow, which has no value then
+		{`x \xrightarrow{} y`, "\\xrightarrow is only supported with a limit annotation"},
+		{`\sqrt{x} \xrightarrow{f} y`, "\\xrightarrow is only supported with a limit annotation"},
+		{`x \xleftarrow{n + 1} y`, "\\xleftarrow is only supported with a limit annotation"},
+		// Inside a larger expression, the arrow could stand for the limit or the target
+		{`1 + (x \xrightarrow{x \to 1} y)`, "\\xrightarrow{x \\to ...} must be the whole expression"},
+		{`\sqrt{\frac{1}{n} \xrightarrow{n \to \infty} 0}`, "\\xrightarrow{n \\to ...} must be the whole expression"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_PhysicsMacros(t *testing.T) {
+	integralTests := []struct {
+		input       string
+		expectedVar string
+	}{
+		{`\int x \dd{x}`, "x"},
+		{`\int_{0}^{1} t^2 \dd t`, "t"},
+	}
+	for _, tt := range integralTests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			integral, ok := expr.(*internalast.IntegralExpr)
+			require.True(t, ok, "Expected IntegralExpr, got %T", expr)
+			assert.Equal(t, tt.expectedVar, integral.Var)
+		})
+	}
+
+	derivativeTests := []struct {
+		input           string
+		expectedVar     string
+		expectedOrder   int
+		expectedPartial bool
+	}{
+		{`\dv{x^2}{x}`, "x", 1, false},
+		{`\dv[2]{x^3}{x}`, "x", 2, false},
+		{`\pdv{x * y}{y}`, "y", 1, true},
+	}
+	for _, tt := range derivativeTests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			deriv, ok := expr.(*internalast.DerivativeExpr)
+			require.True(t, ok, "Expected DerivativeExpr, got %T", expr)
+			assert.Equal(t, tt.expectedVar, deriv.Var)
+			assert.Equal(t, tt.expectedOrder, deriv.Order)
+			assert.Equal(t, tt.expectedPartial, deriv.IsPartial)
+		})
+	}
+
+	t.Run(`\dv{x^2}{x} body`, func(t *testing.T) {
+		p := newStatefulParser(NewLexer(`\dv{x^2}{x}`))
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		testBinaryExpr(t, expr.(*internalast.DerivativeExpr).Body, "x", "^", 2.0)
+	})
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`\dv{f}`, "expected '{' for the variable in \\dv"},
+		{`\dv[0]{f}{x}`, "invalid derivative order '0' in \\dv"},
+		{`\int x \dd{}`, "expected a variable in \\dd"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_PowerOfCommandExpression(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedFunc string
+	}{
+		{`\frac{a}{b}^2`, "frac"},
+		{`\sqrt{x}^2`, "sqrt"},
+		{`\sqrt x^2`, "sqrt"}, // Unbraced argument is only the primary x
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			// The caret must apply to the whole command expression, not its last argument
+			binExpr, ok := expr.(*internalast.BinaryExpr)
+			require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+			assert.Equal(t, "^", binExpr.Op)
+			callExpr, ok := binExpr.Left.(*internalast.FuncCall)
+			require.True(t, ok, "Expected FuncCall as base of the power, got %T", binExpr.Left)
+			assert.Equal(t, tt.expectedFunc, callExpr.FuncName)
+			testNumberLiteral(t, binExpr.Right, 2)
+		})
+	}
+}
+
+func TestParser_LogicalConnectives(t *testing.T) {
+	tests := []struct {
+		input      string
+		expectedOp string
+	}{
+		{`x > 0 \land x < 1`, "&&"},
+		{`x > 0 \text{ and } x < 1`, "&&"},
+		{`x \le 0 \lor x \ge 1`, "||"},
+		{`x \le 0 \text{or} x \ge 1`, "||"},
+		{`x > 0 \implies x^2 > 0`, "⟹"},
+		{`x > 0 \Rightarrow x^2 > 0`, "⟹"},
+		{`x > 0 \iff y > 0`, "⟺"},
+		{`x > 0 \Leftrightarrow y > 0`, "⟺"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			// Connectives bind more loosely than the comparisons they join
+			binExpr, ok := expr.(*internalast.BinaryExpr)
+			require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+			assert.Equal(t, tt.expectedOp, binExpr.Op)
+			_, leftIsBinary := binExpr.Left.(*internalast.BinaryExpr)
+			_, rightIsBinary := binExpr.Right.(*internalast.BinaryExpr)
+			assert.True(t, leftIsBinary && rightIsBinary, "Expected comparisons on both sides of %s", tt.expectedOp)
+		})
+	}
+
+	for _, input := range []string{`\lnot x > 0`, `\text{not} x > 0`} {
+		t.Run(input, func(t *testing.T) {
+			l := NewLexer(input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			unary, ok := expr.(*internalast.UnaryExpr)
+			require.True(t, ok, "Expected UnaryExpr, got %T", expr)
+			assert.Equal(t, "!", unary.Op)
+			testBinaryExpr(t, unary.Operand, "x", ">", 0.0)
+		})
+	}
+}
+
+func TestParser_ImplicationAndEquivalence(t *testing.T) {
+	p, q, r := &internalast.Variable{Name: "p"}, &internalast.Variable{Name: "q"}, &internalast.Variable{Name: "r"}
+	bin := func(op string, l, r internalast.Expr) internalast.Expr {
+		return &internalast.BinaryExpr{Op: op, Left: l, Right: r}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`p \implies q`, bin("⟹", p, q)},
+		{`p \text{ iff } q`, bin("⟺", p, q)},
+		{`p ⟹ q`, bin("⟹", p, q)},
+		// Implication associates to the right
+		{`p \implies q \implies r`, bin("⟹", p, bin("⟹", q, r))},
+		// and binds more loosely than \land and \lor, but more tightly than \iff
+		{`p \land q \implies r`, bin("⟹", bin("&&", p, q), r)},
+		{`p \implies q \lor r`, bin("⟹", p, bin("||", q, r))},
+		{`p \iff q \implies r`, bin("⟺", p, bin("⟹", q, r))},
+		{`p \implies q \iff r`, bin("⟺", bin("⟹", p, q), r)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			parser := newStatefulParser(NewLexer(tt.input))
+			expr, err := parser.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, parser)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+// doubleExpr is a toy custom node used to exercise the parser extension points.
+type doubleExpr struct {
+	internalast.ExtensionNode
+	Value internalast.Expr
+}
+
+func (doubleExpr) Kind() string                    { return "double" }
+func (d *doubleExpr) Children() []internalast.Expr { return []internalast.Expr{d.Value} }
+
+func TestParser_CustomExtensions(t *testing.T) {
+	p := NewParser()
+	p.RegisterCustomCommand("double", func(p *Parser) (internalast.Expr, error) {
+		if p.PeekToken().Type != LBRACE {
+			return nil, fmt.Errorf("expected '{' after \\double")
+		}
+		p.Advance() // move to '{'
+		p.Advance() // move to argument
+		arg, err := p.ParseSubExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if p.PeekToken().Type != RBRACE {
+			return nil, fmt.Errorf("expected '}' after \\double argument")
+		}
+		p.Advance() // consume '}'
+		return &doubleExpr{Value: arg}, nil
+	})
+	p.RegisterCustomInfix(EQUALS, RELATIONAL, func(p *Parser, left internalast.Expr) (internalast.Expr, error) {
+		p.Advance() // move past '='
+		right, err := p.ParseSubExpression(RELATIONAL)
+		if err != nil {
+			return nil, err
+		}
+		return &internalast.BinaryExpr{Op: "==", Left: left, Right: right}, nil
+	})
+
+	expr, err := p.Parse(`\double{x + 1} = y`)
+	require.NoError(t, err)
+
+	binExpr, ok := expr.(*internalast.BinaryExpr)
+	require.True(t, ok, "Expected BinaryExpr from custom infix, got %T", expr)
+	assert.Equal(t, "==", binExpr.Op)
+	testVariable(t, binExpr.Right, "y")
+
+	custom, ok := binExpr.Left.(*doubleExpr)
+	require.True(t, ok, "Expected custom doubleExpr node, got %T", binExpr.Left)
+	testBinaryExpr(t, custom.Value, "x", "+", 1.0)
+
+	// Registrations on one parser must not leak into another
+	_, err = NewParser().Parse(`\double{x}`)
+	require.NoError(t, err, "Unknown commands still parse as generic function calls")
+}
+
+func TestParser_ArgOptExpressions(t *testing.T) {
+	tests := []struct {
+		input     string
+		isMax     bool
+		hasDomain bool
+	}{
+		{`\argmin_{x \in [0, 10]} (x-3)^2`, false, true},
+		{`\arg\max_{x \in [a, b]} x`, true, true},
+		{`\argmin_x (x-3)^2`, false, false},
+		{`\arg\min_{x} x^2`, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			argOpt, ok := expr.(*internalast.ArgOptExpr)
+			require.True(t, ok, "Expected ArgOptExpr, got %T", expr)
+			assert.Equal(t, tt.isMax, argOpt.IsMax)
+			assert.Equal(t, "x", argOpt.Var)
+			assert.Equal(t, tt.hasDomain, argOpt.Lower != nil && argOpt.Upper != nil)
+			assert.NotNil(t, argOpt.Body)
+		})
+	}
+}
+
+func TestParser_Environments(t *testing.T) {
+	t.Run("cases", func(t *testing.T) {
+		l := NewLexer(`\begin{cases} x^2 & x > 0 \\ 0 & x \le 0 \end{cases}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		piecewise, ok := expr.(*internalast.PiecewiseExpr)
+		require.True(t, ok, "Expected PiecewiseExpr, got %T", expr)
+		require.Len(t, piecewise.Cases, 2)
+		testBinaryExpr(t, piecewise.Cases[0].Condition, "x", ">", 0.0)
+		testBinaryExpr(t, piecewise.Cases[1].Condition, "x", "<=", 0.0)
+	})
+
+	t.Run("cases with options and default row", func(t *testing.T) {
+		l := NewLexer(`\begin{cases}[l] 1 & x > 0 \\ 0 \\ \end{cases}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		piecewise, ok := expr.(*internalast.PiecewiseExpr)
+		require.True(t, ok, "Expected PiecewiseExpr, got %T", expr)
+		require.Len(t, piecewise.Cases, 2)
+		assert.Nil(t, piecewise.Cases[1].Condition, "Row without '&' should be the default case")
+	})
+
+	t.Run("cases with equalities", func(t *testing.T) {
+		l := NewLexer(`\begin{cases} 1 & n = 0 \\ 2 & n = 1 \land m = 2 \\ 0 & \text{otherwise} \end{cases}`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		piecewise, ok := expr.(*internalast.PiecewiseExpr)
+		require.True(t, ok, "Expected PiecewiseExpr, got %T", expr)
+		require.Len(t, piecewise.Cases, 3)
+		testBinaryExpr(t, piecewise.Cases[0].Condition, "n", "==", 0.0)
+		both, ok := piecewise.Cases[1].Condition.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", piecewise.Cases[1].Condition)
+		assert.Equal(t, "&&", both.Op)
+		testBinaryExpr(t, both.Left, "n", "==", 1.0)
+		testBinaryExpr(t, both.Right, "m", "==", 2.0)
+	})
+
+	matrixTests := []struct {
+		input        string
+		expectedRows int
+		expectedCols int
+	}{
+		{`\begin{array}{cc} a & b \\ c & d \end{array}`, 2, 2},
+		{`\begin{pmatrix} 1 & 2 & 3 \end{pmatrix}`, 1, 3},
+		{`\begin{bmatrix} x \\ y \\ \end{bmatrix}`, 2, 1},
+	}
+	for _, tt := range matrixTests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			matrix, ok := expr.(*internalast.MatrixExpr)
+			require.True(t, ok, "Expected MatrixExpr, got %T", expr)
+			require.Len(t, matrix.Rows, tt.expectedRows)
+			for _, row := range matrix.Rows {
+				assert.Len(t, row, tt.expectedCols)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`\begin{cases} 1 & x > 0 \end{pmatrix}`, "expected 'cases' in \\end{}, got 'pmatrix'"},
+		{`\begin{pmatrix} a & b \\ c \end{pmatrix}`, "row 2 of pmatrix has 1 columns, expected 2"},
+		{`\begin{array}{cc a & b \end{array}`, "unterminated argument for environment 'array'"},
+		{`\begin{foo} a \end{foo}`, "unsupported environment 'foo'"},
+		{`\begin{cases} 1 & x > 0`, "missing \\end{cases}"},
+		// '=' is only an equality in conditions
+		{`\begin{cases} n = 0 & x > 0 \end{cases}`, "unexpected token '=' in cases environment"},
+		{`\begin{pmatrix} a & b = c \end{pmatrix}`, "unexpected token '=' in pmatrix environment"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			_, err := p.ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_KroneckerDeltaAndIndicator(t *testing.T) {
+	deltaTests := []struct {
+		input     string
+		expectedI interface{}
+		expectedJ interface{}
+	}{
+		{`\delta_{ij}`, "i", "j"},
+		{`\delta_{i,j}`, "i", "j"},
+		{`\delta_{m n}`, "m", "n"},
+		{`\delta_{k, 2}`, "k", 2.0},
+	}
+	for _, tt := range deltaTests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			delta, ok := expr.(*internalast.KroneckerDeltaExpr)
+			require.True(t, ok, "Expected KroneckerDeltaExpr, got %T", expr)
+			testLiteralExpression(t, delta.I, tt.expectedI)
+			testLiteralExpression(t, delta.J, tt.expectedJ)
+		})
+	}
+
+	t.Run(`\mathbb{1}[x > 0] + 1`, func(t *testing.T) {
+		l := NewLexer(`\mathbb{1}[x > 0] + 1`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		indicator, ok := binExpr.Left.(*internalast.IndicatorExpr)
+		require.True(t, ok, "Expected IndicatorExpr, got %T", binExpr.Left)
+		testBinaryExpr(t, indicator.Condition, "x", ">", 0.0)
+	})
+}
+
+func TestParser_UnicodeOperatorsMatchLatex(t *testing.T) {
+	tests := []struct {
+		unicode string
+		latex   string
+	}{
+		{"a × b", `a * b`},
+		{"a ÷ b", `a / b`},
+		{"0 ≤ x ≤ 10", `0 \le x \le 10`},
+		{"x ≠ 1", `x \neq 1`},
+		{"2 × π", `2 * \pi`},
+		{"x < ∞", `x < \infty`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unicode, func(t *testing.T) {
+			unicodeExpr, err := newStatefulParser(NewLexer(tt.unicode)).ParseExpression()
+			require.NoError(t, err)
+			latexExpr, err := newStatefulParser(NewLexer(tt.latex)).ParseExpression()
+			require.NoError(t, err)
+			assert.Equal(t, latexExpr, unicodeExpr)
+		})
+	}
+
+	t.Run("constants", func(t *testing.T) {
+		expr, err := newStatefulParser(NewLexer(`\pi`)).ParseExpression()
+		require.NoError(t, err)
+		assert.Equal(t, &internalast.ConstantExpr{Name: "pi"}, expr)
+	})
+}
+
+func TestParser_RelationalChains(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperands []interface{}
+		expectedOps      []string
+	}{
+		{`0 \le x \le 10`, []interface{}{0.0, "x", 10.0}, []string{"<=", "<="}},
+		{`a < b < c < d`, []interface{}{"a", "b", "c", "d"}, []string{"<", "<", "<"}},
+		{`10 \geq x > 0`, []interface{}{10.0, "x", 0.0}, []string{">=", ">"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			chain, ok := expr.(*internalast.RelationalChain)
+			require.True(t, ok, "Expected RelationalChain, got %T", expr)
+			assert.Equal(t, tt.expectedOps, chain.Ops)
+			require.Len(t, chain.Operands, len(tt.expectedOperands))
+			for i, expected := range tt.expectedOperands {
+				testLiteralExpression(t, chain.Operands[i], expected)
+			}
+		})
+	}
+
+	t.Run("single comparison stays binary", func(t *testing.T) {
+		l := NewLexer(`x \le 10 \land y > 0`)
+		p := newStatefulParser(l)
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+
+		binExpr, ok := expr.(*internalast.BinaryExpr)
+		require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+		assert.Equal(t, "&&", binExpr.Op)
+		testBinaryExpr(t, binExpr.Left, "x", "<=", 10.0)
+	})
+}
+
+func TestParser_MinMax(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedFunc   string
+		expectedArgs   []interface{}
+		expectErrorMsg string
+	}{
+		{`\min(a, b)`, "min", []interface{}{"a", "b"}, ""},
+		{`\max(x, 0, y)`, "max", []interface{}{"x", 0.0, "y"}, ""},
+		{`\max(x)`, "max", nil, "\\max requires at least 2 arguments, got 1"},
+		{`\min(a, b`, "min", nil, "expected ')' after arguments of \\min"},
+		{`\operatorname{clamp}(x, 0, 1)`, "clamp", []interface{}{"x", 0.0, 1.0}, ""},
+		{`\operatorname{lerp}(a, b, t)`, "lerp", []interface{}{"a", "b", "t"}, ""},
+		{`\operatorname{gcd}(a, 6)`, "gcd", []interface{}{"a", 6.0}, ""},
+		{`\operatorname{clamp}(x, 1)`, "clamp", nil, "clamp requires 3 arguments, got 2"},
+		{`\operatorname{lerp}(a, b, t, u)`, "lerp", nil, "lerp requires 3 arguments, got 4"},
+		// atan2 keeps its arguments in order, y first, while \arctan takes one
+		{`\operatorname{atan2}(y, x)`, "atan2", []interface{}{"y", "x"}, ""},
+		{`\arctan2(1, x)`, "atan2", []interface{}{1.0, "x"}, ""},
+		{`\arctan 2`, "arctan", []interface{}{2.0}, ""},
+		{`\operatorname{atan2}(y)`, "atan2", nil, "atan2 requires 2 arguments, got 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			if tt.expectErrorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrorMsg)
+				return
+			}
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			callExpr, ok := expr.(*internalast.FuncCall)
+			require.True(t, ok, "Expected FuncCall, got %T", expr)
+			assert.Equal(t, tt.expectedFunc, callExpr.FuncName)
+			require.Len(t, callExpr.Args, len(tt.expectedArgs))
+			for i, expected := range tt.expectedArgs {
+				testLiteralExpression(t, callExpr.Args[i], expected)
+			}
+		})
+	}
+}
+
+func TestParser_CasesTextKeywords(t *testing.T) {
+	tests := []string{
+		`\begin{cases} x & \text{if } x > 0 \\ 0 & \text{otherwise} \end{cases}`,
+		`\begin{cases} x & \text{\textbf{if} } x > 0 \\ 0 & \text{\textbf{otherwise}} \end{cases}`,
+		`\begin{cases} x & \text{\emph{when}} x > 0 \\ 0 & \text{\emph{\textit{else}}} \end{cases}`,
+		// A value without a condition is a default too
+		`\begin{cases} x & x > 0 \\ 0 \end{cases}`,
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			piecewise, ok := expr.(*internalast.PiecewiseExpr)
+			require.True(t, ok, "Expected PiecewiseExpr, got %T", expr)
+			require.Len(t, piecewise.Cases, 2)
+			testVariable(t, piecewise.Cases[0].Value, "x")
+			testBinaryExpr(t, piecewise.Cases[0].Condition, "x", ">", 0.0)
+			testNumberLiteral(t, piecewise.Cases[1].Value, 0)
+			assert.Nil(t, piecewise.Cases[1].Condition, "otherwise should produce the default case")
+		})
+	}
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`\begin{pmatrix} 1 & \text{otherwise} \end{pmatrix}`, "'otherwise' is only allowed in a cases environment, not pmatrix"},
+		{`\begin{cases} \text{otherwise} & 1 \end{cases}`, "expected a value before the condition in cases row"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_StatisticOperators(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedFunc string
+	}{
+		{`\mathbb{E}[X]`, "E"},
+		{`\text{Var}[X]`, "Var"},
+		{`\mathrm{Var}[X]`, "Var"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			callExpr, ok := expr.(*internalast.FuncCall)
+			require.True(t, ok, "Expected FuncCall, got %T", expr)
+			assert.Equal(t, tt.expectedFunc, callExpr.FuncName)
+			require.Len(t, callExpr.Args, 1)
+			testLiteralExpression(t, callExpr.Args[0], "X")
+		})
+	}
+
+	t.Run("missing closing bracket", func(t *testing.T) {
+		l := NewLexer(`\mathbb{E}[X`)
+		p := newStatefulParser(l)
+		_, err := p.ParseExpression()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected ']' after argument of E")
+	})
+}
+
+func TestParser_OneSidedLimits(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedDirection string
+		expectedApproach  interface{}
+	}{
+		{`\lim_{x \to 0^+} x`, "+", 0.0},
+		{`\lim_{x \to 0^-} x`, "-", 0.0},
+		{`\lim_{x \to a^{+}} x`, "+", "a"},
+		{`\lim_{x \to 0} x`, "", 0.0},
+		{`\lim_{x \to -1^+} x`, "+", -1.0}, // The sign of the target is kept
+		{`\lim_{x \to a^2} x`, "", nil}, // A genuine power is not a direction
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			p := newStatefulParser(l)
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			limit, ok := expr.(*internalast.LimitExpr)
+			require.True(t, ok, "Expected LimitExpr, got %T", expr)
+			assert.Equal(t, "x", limit.Var)
+			assert.Equal(t, tt.expectedDirection, limit.Direction)
+			if tt.expectedApproach != nil {
+				testLiteralExpression(t, limit.Approaches, tt.expectedApproach)
+			} else {
+				testBinaryExpr(t, limit.Approaches, "a", "^", 2.0)
+			}
+		})
+	}
+}
+
+func TestParser_UndersetLimits(t *testing.T) {
+	parse := func(t *testing.T, input string) internalast.Expr {
+		t.Helper()
+		p := newStatefulParser(NewLexer(input))
+		expr, err := p.ParseExpression()
+		require.NoError(t, err)
+		checkParserErrors(t, p)
+		return expr
+	}
+
+	// The subscript under \lim reads like the one after \lim_
+	tests := []struct {
+		underset string
+		standard string
+	}{
+		{`\underset{x \to 0}{\lim} \frac{\sin x}{x}`, `\lim_{x \to 0} \frac{\sin x}{x}`},
+		{`\underset{h \to 0^+}{\lim} \frac{f + h}{h}`, `\lim_{h \to 0^+} \frac{f + h}{h}`},
+		{`\underset{n \to \infty}{\lim} \frac{1}{n} + 1`, `\lim_{n \to \infty} \frac{1}{n} + 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.underset, func(t *testing.T) {
+			expr := parse(t, tt.underset)
+			_, ok := expr.(*internalast.LimitExpr)
+			require.True(t, ok, "Expected LimitExpr, got %T", expr)
+			assert.Equal(t, parse(t, tt.standard), expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\underset{x}{\max} x`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\underset is only supported for limits")
+}
+
+func TestParser_ComplexOperators(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedFunc string
+	}{
+		{`|z|`, "abs"},
+		{`\Re(z)`, "Re"},
+		{`\Im{z}`, "Im"},
+		{`\operatorname{Re}(z)`, "Re"},
+		{`\text{Re}(z)`, "Re"},
+		{`\mathrm{Im}(z)`, "Im"},
+		{`\text{Im} z`, "Im"},
+		{`\arg z`, "arg"},
+		{`\overline{z}`, "overline"},
+		{`\bar{z}`, "bar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+
+			callExpr, ok := expr.(*internalast.FuncCall)
+			require.True(t, ok, "Expected FuncCall, got %T", expr)
+			assert.Equal(t, tt.expectedFunc, callExpr.FuncName)
+			require.Len(t, callExpr.Args, 1)
+			testLiteralExpression(t, callExpr.Args[0], "z")
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`|z + 1`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing closing '|'")
+}
+
+func TestParser_SubstackSum(t *testing.T) {
+	p := newStatefulParser(NewLexer(`\sum_{\substack{i=1 \\ i \ne j \\ i \ne k}}^{n} i`))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	sum, ok := expr.(*internalast.SumExpr)
+	require.True(t, ok, "Expected SumExpr, got %T", expr)
+	assert.Equal(t, "i", sum.Var)
+	testLiteralExpression(t, sum.Lower, 1.0)
+	testVariable(t, sum.Upper, "n")
+	filter, ok := sum.Filter.(*internalast.BinaryExpr)
+	require.True(t, ok, "Expected BinaryExpr filter, got %T", sum.Filter)
+	assert.Equal(t, "&&", filter.Op)
+	last, ok := filter.Right.(*internalast.BinaryExpr)
+	require.True(t, ok, "Expected BinaryExpr condition, got %T", filter.Right)
+	assert.Equal(t, "!=", last.Op)
+	testVariable(t, last.Left, "i")
+	testVariable(t, last.Right, "k")
+
+	// A single-line \substack is a plain index without a filter
+	p = newStatefulParser(NewLexer(`\prod_{\substack{k=1}}^{n} k`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	prod, ok := expr.(*internalast.SumExpr)
+	require.True(t, ok, "Expected SumExpr, got %T", expr)
+	assert.Nil(t, prod.Filter)
+
+	_, err = newStatefulParser(NewLexer(`\sum_{\substack{i=1 \\ i \ne j}^{n} i`)).ParseExpression()
+	require.Error(t, err)
+}
+
+func TestParser_NegativeAndDecimalBounds(t *testing.T) {
+	p := newStatefulParser(NewLexer(`\int_{-1}^{1} x^2\,dx`))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	integral, ok := expr.(*internalast.IntegralExpr)
+	require.True(t, ok, "Expected IntegralExpr, got %T", expr)
+	assert.Equal(t, "x", integral.Var)
+	testLiteralExpression(t, integral.Lower, -1.0)
+	testLiteralExpression(t, integral.Upper, 1.0)
+
+	p = newStatefulParser(NewLexer(`\sum_{i=-3}^{3} i`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	sum, ok := expr.(*internalast.SumExpr)
+	require.True(t, ok, "Expected SumExpr, got %T", expr)
+	assert.Equal(t, "i", sum.Var)
+	testLiteralExpression(t, sum.Lower, -3.0)
+	testLiteralExpression(t, sum.Upper, 3.0)
+	testVariable(t, sum.Body, "i")
+
+	p = newStatefulParser(NewLexer(`\int_{-0.5}^{2.5} x\,dx`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	integral, ok = expr.(*internalast.IntegralExpr)
+	require.True(t, ok, "Expected IntegralExpr, got %T", expr)
+	testLiteralExpression(t, integral.Lower, -0.5)
+	testLiteralExpression(t, integral.Upper, 2.5)
+}
+
+func BenchmarkParser_Parse(b *testing.B) {
+	const input = `\frac{x^2 + 1}{\sqrt{y}} - \sum_{i=1}^{n} i`
+
+	b.Run("fresh lexer", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			p := newStatefulParser(NewLexer(input))
+			if _, err := p.ParseExpression(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("pooled lexer", func(b *testing.B) {
+		parser := NewParser()
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			if _, err := parser.Parse(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestParser_OperatorWithLimits(t *testing.T) {
+	p := newStatefulParser(NewLexer(`\operatorname*{mean}_{i=1}^{n} i^2 + c`))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	binExpr, ok := expr.(*internalast.BinaryExpr)
+	require.True(t, ok, "Expected BinaryExpr, got %T", expr)
+	bigOp, ok := binExpr.Left.(*internalast.BigOpExpr)
+	require.True(t, ok, "Expected BigOpExpr on the left, got %T", binExpr.Left)
+	assert.Equal(t, "mean", bigOp.Name)
+	assert.Equal(t, "i", bigOp.Var)
+	testLiteralExpression(t, bigOp.Lower, 1.0)
+	testVariable(t, bigOp.Upper, "n")
+	testVariable(t, binExpr.Right, "c")
+
+	p = newStatefulParser(NewLexer(`\operatorname*{argmax}_{x \in [0, 10]} x`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	argOpt, ok := expr.(*internalast.ArgOptExpr)
+	require.True(t, ok, "Expected ArgOptExpr, got %T", expr)
+	assert.True(t, argOpt.IsMax)
+	assert.Equal(t, "x", argOpt.Var)
+
+	p = newStatefulParser(NewLexer(`\operatorname*{softmax}_k z`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	bigOp, ok = expr.(*internalast.BigOpExpr)
+	require.True(t, ok, "Expected BigOpExpr, got %T", expr)
+	assert.Equal(t, "k", bigOp.Var)
+	assert.Nil(t, bigOp.Lower)
+	assert.Nil(t, bigOp.Upper)
+
+	_, err = newStatefulParser(NewLexer(`\operatorname*{mean} x`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected '_' with index variable after \\operatorname*{mean}")
+}
+
+func TestParser_ImplicitProduct(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	bin := func(op string, left, right internalast.Expr) internalast.Expr {
+		return &internalast.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`2x`, bin("*", n(2), v("x"))},
+		{`n x`, bin("*", v("n"), v("x"))},
+		{`2x^2`, bin("*", n(2), bin("^", v("x"), n(2)))},
+		{`a + b c`, bin("+", v("a"), bin("*", v("b"), v("c")))},
+		{`\sin(n x)`, &internalast.FuncCall{FuncName: "sin", Args: []internalast.Expr{bin("*", v("n"), v("x"))}}},
+		// Only d and one letter, inside an integral, is a differential
+		{`k dist`, bin("*", v("k"), v("dist"))},
+		{`x dy`, bin("*", v("x"), v("dy"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	// A differential ends the integrand instead of multiplying it
+	p := newStatefulParser(NewLexer(`\int_{0}^{1} t x dt`))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	integral, ok := expr.(*internalast.IntegralExpr)
+	require.True(t, ok, "Expected IntegralExpr, got %T", expr)
+	assert.Equal(t, "t", integral.Var)
+	assert.Equal(t, bin("*", v("t"), v("x")), integral.Body)
+
+	p = newStatefulParser(NewLexer(`\int k dist dx`))
+	expr, err = p.ParseExpression()
+	require.NoError(t, err)
+	integral, ok = expr.(*internalast.IntegralExpr)
+	require.True(t, ok, "Expected IntegralExpr, got %T", expr)
+	assert.Equal(t, "x", integral.Var)
+	assert.Equal(t, bin("*", v("k"), v("dist")), integral.Body)
+}
+
+func TestParser_UnbracedFunctionArgument(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	call := func(name string, arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: name, Args: []internalast.Expr{arg}}
+	}
+	bin := func(op string, left, right internalast.Expr) internalast.Expr {
+		return &internalast.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+
+	// The function applies to the immediately following primary
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\sin 2`, call("sin", n(2))},
+		{`\sin 2x`, bin("*", call("sin", n(2)), v("x"))},
+		{`\sin x^2`, bin("^", call("sin", v("x")), n(2))},
+		{`\sin x y`, bin("*", call("sin", v("x")), v("y"))},
+		{`\sin(x) y`, bin("*", call("sin", v("x")), v("y"))},
+		{`\cos 2x + 1`, bin("+", bin("*", call("cos", n(2)), v("x")), n(1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_PochhammerAndFactorialPowers(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`(x)_3`, &internalast.PochhammerExpr{Base: v("x"), Count: n(3), Rising: true}},
+		{`(a+1)_n`, &internalast.PochhammerExpr{
+			Base:   &internalast.BinaryExpr{Op: "+", Left: v("a"), Right: n(1)},
+			Count:  v("n"),
+			Rising: true,
+		}},
+		{`(x)_{n-1}`, &internalast.PochhammerExpr{
+			Base:   v("x"),
+			Count:  &internalast.BinaryExpr{Op: "-", Left: v("n"), Right: n(1)},
+			Rising: true,
+		}},
+		{`x^{\overline{3}}`, &internalast.PochhammerExpr{Base: v("x"), Count: n(3), Rising: true}},
+		{`x^{\underline{k}}`, &internalast.PochhammerExpr{Base: v("x"), Count: v("k"), Rising: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`(x)_`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected subscript of Pochhammer symbol")
+}
+
+func TestParser_PostfixAfterCalls(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	gcd := &internalast.FuncCall{FuncName: "gcd", Args: []internalast.Expr{v("a"), v("b")}}
+	sgn := &internalast.FuncCall{FuncName: "sgn", Args: []internalast.Expr{v("x")}}
+
+	// Powers and factorials follow any operand, calls and operator results included
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\gcd(a,b)^2`, &internalast.BinaryExpr{Op: "^", Left: gcd, Right: n(2)}},
+		{`\gcd(a,b)!`, &internalast.FactorialExpr{Value: gcd}},
+		{`\gcd(a, b, 6)`, &internalast.FuncCall{FuncName: "gcd", Args: []internalast.Expr{v("a"), v("b"), n(6)}}},
+		{`\operatorname{sgn}(x)^2`, &internalast.BinaryExpr{Op: "^", Left: sgn, Right: n(2)}},
+		{`\operatorname{sgn}(x)!`, &internalast.FactorialExpr{Value: sgn}},
+		{`\binom{n}{k}!`, &internalast.FactorialExpr{Value: &internalast.FuncCall{FuncName: "binom", Args: []internalast.Expr{v("n"), v("k")}}}},
+		{`n!^2`, &internalast.BinaryExpr{Op: "^", Left: &internalast.FactorialExpr{Value: v("n")}, Right: n(2)}},
+		{`n! + 1`, &internalast.BinaryExpr{Op: "+", Left: &internalast.FactorialExpr{Value: v("n")}, Right: n(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\gcd(a)`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\gcd requires at least 2 arguments")
+}
+
+func TestParser_StrayTo(t *testing.T) {
+	for _, input := range []string{`\to`, `x \to 0`, `\mathbb{R} \to \mathbb{R}`, `(x \to 0)`, `\sin x \to 1`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := NewParser().Parse(input)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `'\to' is only valid inside \lim`)
+		})
+	}
+
+	// \to keeps working inside limits
+	_, err := NewParser().Parse(`\lim_{x \to 0} x`)
+	require.NoError(t, err)
+}
+
+func TestParser_Approx(t *testing.T) {
+	for _, input := range []string{`\approx`, `x \approx 2`, `x ≈ 2`, `\sin x \approx x`, `(x \approx 2)`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := NewParser().Parse(input)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `'\approx' is not supported`)
+		})
+	}
+}
+
+func TestParser_Assignments(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+
+	p := newStatefulParser(NewLexer(`u = x^2; v = \sqrt{u}; v + 1`))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+	assert.Equal(t, &internalast.BlockExpr{
+		Assignments: []*internalast.AssignmentExpr{
+			{Name: "u", Value: &internalast.BinaryExpr{Op: "^", Left: v("x"), Right: n(2)}},
+			{Name: "v", Value: &internalast.FuncCall{FuncName: "sqrt", Args: []internalast.Expr{v("u")}}},
+		},
+		Result: &internalast.BinaryExpr{Op: "+", Left: v("v"), Right: n(1)},
+	}, expr)
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`u = x^2`, "expected ';' after the assignment to u"},
+		{`u = x^2;`, "expected an expression after the assignments"},
+		{`u = x; u = 2; u`, "u is assigned more than once"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_DisplayEnvironments(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	square := &internalast.BinaryExpr{Op: "^", Left: v("x"), Right: n(2)}
+	block := &internalast.BlockExpr{
+		Assignments: []*internalast.AssignmentExpr{{Name: "u", Value: square}},
+		Result:      &internalast.BinaryExpr{Op: "+", Left: v("u"), Right: n(1)},
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		// equation is stripped, assignments included
+		{`\begin{equation} x^2 \end{equation}`, square},
+		{`\begin{equation*} u = x^2; u + 1 \end{equation*}`, block},
+		// Each row of align is an equation, the last one giving the result
+		{`\begin{align} u &= x^2 \\ f &= u + 1 \end{align}`, block},
+		{`\begin{align*} u &= x^2 \\ & u + 1 \\ \end{align*}`, block},
+		{`\begin{align} f &= x^2 \end{align}`, square},
+		// A command may end a row
+		{`\begin{align} u &= \sqrt{x} \\ f &= u \end{align}`, &internalast.BlockExpr{
+			Assignments: []*internalast.AssignmentExpr{{Name: "u", Value: &internalast.FuncCall{FuncName: "sqrt", Args: []internalast.Expr{v("x")}}}},
+			Result:      v("u"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`1 + \begin{equation} x \end{equation}`, "the equation environment must wrap the whole equation"},
+		{`\begin{equation} x \end{align}`, "expected 'equation' in \\end{}, got 'align'"},
+		{`\begin{align} u + 1 \\ v &= u \end{align}`, "expected 'name = value' in row 1 of align"},
+		{`\begin{align} u &= 1 \\ u &= 2 \\ u \end{align}`, "u is assigned more than once"},
+		{`\begin{align} u &= 1 \\`, "missing \\end{align}"},
+		{`\begin{align} \end{align}`, "align environment must contain at least one equation"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_BracedArrayCases(t *testing.T) {
+	input := `\left\{ \begin{array}{ll} x & \text{if } x > 0 \\ 0 & \text{otherwise} \end{array} \right.`
+	p := newStatefulParser(NewLexer(input))
+	expr, err := p.ParseExpression()
+	require.NoError(t, err)
+	checkParserErrors(t, p)
+
+	piecewise, ok := expr.(*internalast.PiecewiseExpr)
+	require.True(t, ok, "Expected PiecewiseExpr, got %T", expr)
+	require.Len(t, piecewise.Cases, 2)
+	testVariable(t, piecewise.Cases[0].Value, "x")
+	testBinaryExpr(t, piecewise.Cases[0].Condition, "x", ">", 0.0)
+	testNumberLiteral(t, piecewise.Cases[1].Value, 0)
+	assert.Nil(t, piecewise.Cases[1].Condition)
+
+	_, err = newStatefulParser(NewLexer(`\left\{ \begin{pmatrix} 1 \end{pmatrix} \right.`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected an array after '\\{', got environment 'pmatrix'")
+}
+
+func TestParser_Gradient(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	square := func(name string) internalast.Expr {
+		return &internalast.BinaryExpr{Op: "^", Left: v(name), Right: n(2)}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\nabla (x^2 + y^2)`, &internalast.GradientExpr{Body: &internalast.BinaryExpr{Op: "+", Left: square("x"), Right: square("y")}}},
+		{`\nabla x^2 y`, &internalast.GradientExpr{Body: &internalast.BinaryExpr{Op: "*", Left: square("x"), Right: v("y")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\nabla`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected an expression after \\nabla")
+}
+
+func TestParser_ModuloAndCongruence(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`7 \pmod 3`, &internalast.BinaryExpr{Op: "mod", Left: n(7), Right: n(3)}},
+		// \pmod applies to the whole arithmetic expression before it
+		{`a + b \pmod{n}`, &internalast.BinaryExpr{
+			Op:    "mod",
+			Left:  &internalast.BinaryExpr{Op: "+", Left: v("a"), Right: v("b")},
+			Right: v("n"),
+		}},
+		{`7 \equiv 1 \pmod 3`, &internalast.CongruenceExpr{Left: n(7), Right: n(1), Modulus: n(3)}},
+		// \bmod binds like a quotient, and \operatorname{mod}(a, n) and \mod(a, n) are its function form
+		{`7 \bmod 3`, &internalast.BinaryExpr{Op: "mod", Left: n(7), Right: n(3)}},
+		{`a + b \bmod n`, &internalast.BinaryExpr{
+			Op:    "+",
+			Left:  v("a"),
+			Right: &internalast.BinaryExpr{Op: "mod", Left: v("b"), Right: v("n")},
+		}},
+		{`\operatorname{mod}(7, 3)`, &internalast.BinaryExpr{Op: "mod", Left: n(7), Right: n(3)}},
+		{`\mod(a + b, n)`, &internalast.BinaryExpr{
+			Op:    "mod",
+			Left:  &internalast.BinaryExpr{Op: "+", Left: v("a"), Right: v("b")},
+			Right: v("n"),
+		}},
+		{`a \equiv b + 1 \pmod{n - 1}`, &internalast.CongruenceExpr{
+			Left:    v("a"),
+			Right:   &internalast.BinaryExpr{Op: "+", Left: v("b"), Right: n(1)},
+			Modulus: &internalast.BinaryExpr{Op: "-", Left: v("n"), Right: n(1)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input          string
+		expectErrorMsg string
+	}{
+		{`a \equiv b`, "expected \\pmod after congruence"},
+		{`a \pmod`, "expected a modulus after \\pmod"},
+		{`\operatorname{mod}(7, 3, 2)`, "mod requires 2 arguments, got 3"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectErrorMsg)
+		})
+	}
+}
+
+func TestParser_SignFunction(t *testing.T) {
+	sgn := func(arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: "sgn", Args: []internalast.Expr{arg}}
+	}
+	x := &internalast.Variable{Name: "x"}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\sgn(x)`, sgn(x)},
+		{`\sgn{x}`, sgn(x)},
+		{`\sgn x`, sgn(x)},
+		{`\operatorname{sgn}(x)`, sgn(x)},
+		{`\operatorname{sgn}{x - 1}`, sgn(&internalast.BinaryExpr{Op: "-", Left: x, Right: &internalast.NumberLiteral{Value: 1}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_RoundingFunctions(t *testing.T) {
+	x := &internalast.Variable{Name: "x"}
+	call := func(name string, arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: name, Args: []internalast.Expr{arg}}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\operatorname{round}(x)`, call("round", x)},
+		{`\operatorname{trunc}{x}`, call("trunc", x)},
+		// \operatorname{frac} is the fractional part, not a fraction
+		{`\operatorname{frac}(x)`, call("fracpart", x)},
+		{`\operatorname{frac} x + 1`, &internalast.BinaryExpr{Op: "+", Left: call("fracpart", x), Right: &internalast.NumberLiteral{Value: 1}}},
+		{`\frac{x}{2}`, &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{x, &internalast.NumberLiteral{Value: 2}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\frac{x}`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\frac requires 2 argument(s), got 1: write \\operatorname{frac}(x) for the fractional part")
+}
+
+func TestParser_CompositeSpecialFunctions(t *testing.T) {
+	x := &internalast.Variable{Name: "x"}
+	call := func(name string, arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: name, Args: []internalast.Expr{arg}}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\operatorname{sinc}(x)`, call("sinc", x)},
+		{`\operatorname{sinc}(\pi x)`, call("sinc", &internalast.BinaryExpr{Op: "*", Left: &internalast.ConstantExpr{Name: "pi"}, Right: x})},
+		{`\operatorname{logistic}{x}`, call("logistic", x)},
+		{`\operatorname{softplus}(x)`, call("softplus", x)},
+		{`\operatorname{relu}(x - 1)`, call("relu", &internalast.BinaryExpr{Op: "-", Left: x, Right: &internalast.NumberLiteral{Value: 1}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_InverseHyperbolicFunctions(t *testing.T) {
+	x := &internalast.Variable{Name: "x"}
+	call := func(name string, arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: name, Args: []internalast.Expr{arg}}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\operatorname{arcsinh}(x)`, call("arcsinh", x)},
+		{`\operatorname{arccosh}{x}`, call("arccosh", x)},
+		{`\operatorname{arctanh} x`, call("arctanh", x)},
+		// The ISO spellings
+		{`\operatorname{arsinh}(x)`, call("arcsinh", x)},
+		{`\operatorname{arcosh}(x)`, call("arccosh", x)},
+		{`\operatorname{artanh}(x)`, call("arctanh", x)},
+		// A -1 superscript, braced or not
+		{`\sinh^{-1} x`, call("arcsinh", x)},
+		{`\cosh^{-1}(x)`, call("arccosh", x)},
+		{`\tanh^-1{x}`, call("arctanh", x)},
+		{`\sinh x`, call("sinh", x)},
+		// The logarithms are single-argument commands too
+		{`\ln x`, call("ln", x)},
+		{`\log(x)`, call("log", x)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_AngleBrackets(t *testing.T) {
+	X, Y := &internalast.Variable{Name: "X"}, &internalast.Variable{Name: "Y"}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\langle X \rangle`, &internalast.FuncCall{FuncName: "E", Args: []internalast.Expr{X}}},
+		{`\langle X \mid Y \rangle`, &internalast.ConditionalExpectationExpr{Value: X, Given: Y}},
+		{`\left\langle X \mid Y > 0 \right\rangle`, &internalast.ConditionalExpectationExpr{
+			Value: X, Given: &internalast.BinaryExpr{Op: ">", Left: Y, Right: &internalast.NumberLiteral{Value: 0}},
+		}},
+		{`\mathbb{E}[X \mid Y]`, &internalast.ConditionalExpectationExpr{Value: X, Given: Y}},
+		// A plain bar between two vectors is bra-ket notation
+		{`\langle a | b \rangle`, &internalast.InnerProductExpr{Bra: "a", Ket: "b"}},
+		{`\langle \psi | \phi \rangle`, &internalast.InnerProductExpr{Bra: "psi", Ket: "phi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\langle X`, "missing \\rangle after \\langle, got EOF"},
+		{`\langle X \mid Y )`, "missing \\rangle after \\langle, got RPAREN"},
+		{`\mathbb{E}[X \mid Y`, "expected ']' after the condition of E"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_Norm(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\lVert v \rVert_1`, &internalast.NormExpr{Vector: "v", Order: 1}},
+		{`\lVert v \rVert_{2}`, &internalast.NormExpr{Vector: "v", Order: 2}},
+		{`\lVert v \rVert`, &internalast.NormExpr{Vector: "v", Order: 2}},
+		{`\lVert v \rVert_\infty`, &internalast.NormExpr{Vector: "v", Order: math.Inf(1)}},
+		{`\lVert w \rVert_2^2`, &internalast.BinaryExpr{
+			Op:    "^",
+			Left:  &internalast.NormExpr{Vector: "w", Order: 2},
+			Right: &internalast.NumberLiteral{Value: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\lVert 2 \rVert`, "expected a vector name after \\lVert"},
+		{`\lVert v`, "expected \\rVert after the vector v of a norm"},
+		{`\lVert v \rVert_{1/2}`, "the order of a norm must be a number of at least 1 or \\infty"},
+		{`\lVert v \rVert_p`, "the order of a norm must be a number of at least 1 or \\infty"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_BraKet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\braket{a|b}`, &internalast.InnerProductExpr{Bra: "a", Ket: "b"}},
+		{`\braket{\psi|\phi}`, &internalast.InnerProductExpr{Bra: "psi", Ket: "phi"}},
+		{`\bra{a}\ket{b}`, &internalast.InnerProductExpr{Bra: "a", Ket: "b"}},
+		{`\ket{\psi}`, &internalast.KetExpr{Vector: "psi"}},
+		{`\bra{\psi}`, &internalast.KetExpr{Vector: "psi", IsBra: true}},
+		{`\braket{a|a} + 1`, &internalast.BinaryExpr{
+			Op:    "+",
+			Left:  &internalast.InnerProductExpr{Bra: "a", Ket: "a"},
+			Right: &internalast.NumberLiteral{Value: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\braket{a,b}`, "expected '|' between the vectors of \\braket"},
+		{`\braket{2|b}`, "expected a vector name in \\braket"},
+		{`\ket{a`, "expected '}' after the vector of \\ket"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_EvaluationBar(t *testing.T) {
+	x := &internalast.Variable{Name: "x"}
+	square := &internalast.BinaryExpr{Op: "^", Left: x, Right: &internalast.NumberLiteral{Value: 2}}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		// The right bar closes an absolute value after a bar or \left|
+		{`|x|`, &internalast.FuncCall{FuncName: "abs", Args: []internalast.Expr{x}}},
+		{`\left| x \right|`, &internalast.FuncCall{FuncName: "abs", Args: []internalast.Expr{x}}},
+		{`|\sqrt{x}|`, &internalast.FuncCall{FuncName: "abs", Args: []internalast.Expr{
+			&internalast.FuncCall{FuncName: "sqrt", Args: []internalast.Expr{x}},
+		}}},
+		// and an evaluation bar after \left.
+		{`\left. x^2 \right|_0^1`, &internalast.EvaluationExpr{
+			Body: square, Lower: &internalast.NumberLiteral{Value: 0}, Upper: &internalast.NumberLiteral{Value: 1},
+		}},
+		{`\left. x^2 \right|_{x=a}^{b}`, &internalast.EvaluationExpr{
+			Body: square, Var: "x", Lower: &internalast.Variable{Name: "a"}, Upper: &internalast.Variable{Name: "b"},
+		}},
+		{`\left. x^2 \right|_{x=2}`, &internalast.EvaluationExpr{Body: square, Var: "x", Lower: &internalast.NumberLiteral{Value: 2}}},
+		{`\left. |x| \right|_{-1}^{1}`, &internalast.EvaluationExpr{
+			Body:  &internalast.FuncCall{FuncName: "abs", Args: []internalast.Expr{x}},
+			Lower: &internalast.NumberLiteral{Value: -1},
+			Upper: &internalast.NumberLiteral{Value: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`|x|_0^1`, errBarWithBounds},
+		{`\left| x \right|_0^1`, errBarWithBounds},
+		{`\left. x^2 \right|`, "expected the bounds _a^b or the point _a after \\right|"},
+		{`\left. x^2 \right)`, "expected \\right| to close \\left., got RPAREN"},
+		{`\left. x^2 \right|_{x=0`, "expected '}' after the lower bound of an evaluation bar"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_PlusMinus(t *testing.T) {
+	x, y := &internalast.Variable{Name: "x"}, &internalast.Variable{Name: "y"}
+	one := &internalast.NumberLiteral{Value: 1}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		// \pm binds like + and -, from the left
+		{`1 + x \pm y`, &internalast.BinaryExpr{Op: "±", Left: &internalast.BinaryExpr{Op: "+", Left: one, Right: x}, Right: y}},
+		{`x \pm y z`, &internalast.BinaryExpr{Op: "±", Left: x, Right: &internalast.BinaryExpr{Op: "*", Left: y, Right: &internalast.Variable{Name: "z"}}}},
+		{`x \mp 1`, &internalast.BinaryExpr{Op: "∓", Left: x, Right: one}},
+		{`\sqrt{x} \pm 1`, &internalast.BinaryExpr{Op: "±", Left: &internalast.FuncCall{FuncName: "sqrt", Args: []internalast.Expr{x}}, Right: one}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+}
+
+func TestParser_FloorAndCeiling(t *testing.T) {
+	a, b := &internalast.Variable{Name: "a"}, &internalast.Variable{Name: "b"}
+	call := func(name string, arg internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: name, Args: []internalast.Expr{arg}}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\lfloor a/b \rfloor`, call("floor", &internalast.BinaryExpr{Op: "/", Left: a, Right: b})},
+		{`\lfloor \frac{a}{b} \rfloor`, call("floor", &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{a, b}})},
+		{`\left\lceil a \right\rceil`, call("ceil", a)},
+		{`\lceil a \rceil + b`, &internalast.BinaryExpr{Op: "+", Left: call("ceil", a), Right: b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\lfloor a`, "missing \\rfloor after \\lfloor"},
+		{`\lceil a \rfloor`, "missing \\rceil after \\lceil"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_FracOfSumsAndIntegrals(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	frac := func(num, den internalast.Expr) internalast.Expr {
+		return &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{num, den}}
+	}
+	sum := &internalast.SumExpr{Var: "i", Lower: n(1), Upper: v("n"), Body: v("i")}
+	integral := &internalast.IntegralExpr{IsDefinite: true, Var: "x", Lower: n(0), Upper: n(1), Body: v("f")}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\frac{\sum_{i=1}^{n} i}{n}`, frac(sum, v("n"))},
+		{`\frac{n}{\sum_{i=1}^{n} i}`, frac(v("n"), sum)},
+		{`\frac{\int_{0}^{1} f \, dx}{2}`, frac(integral, n(2))},
+		// A bound may be a single primary rather than a braced expression
+		{`\frac{\sum_{i=1}^n i}{n}`, frac(sum, v("n"))},
+		{`\frac{\int_0^1 f\,dx}{2}`, frac(integral, n(2))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\int_0^+ x \, dx`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected '{' after '^' in \\int")
+}
+
+func TestParser_IndexSets(t *testing.T) {
+	v := func(name string) internalast.Expr { return &internalast.Variable{Name: name} }
+	n := func(value float64) internalast.Expr { return &internalast.NumberLiteral{Value: value} }
+	square := &internalast.BinaryExpr{Op: "^", Left: v("i"), Right: n(2)}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\sum_{i=1,3,5} i^2`, &internalast.SumExpr{Var: "i", Values: []internalast.Expr{n(1), n(3), n(5)}, Body: square}},
+		{`\sum_{i \in \{1,3,5\}} i^2`, &internalast.SumExpr{Var: "i", Values: []internalast.Expr{n(1), n(3), n(5)}, Body: square}},
+		{`\prod_{k \in \{a, b + 1\}} k`, &internalast.SumExpr{
+			IsProduct: true,
+			Var:       "k",
+			Values:    []internalast.Expr{v("a"), &internalast.BinaryExpr{Op: "+", Left: v("b"), Right: n(1)}},
+			Body:      v("k"),
+		}},
+		// An interval bounds the index
+		{`\sum_{k \in [1,3]} k`, &internalast.SumExpr{Var: "k", Lower: n(1), Upper: n(3), Body: v("k")}},
+		{`\prod_{k \in [a, b + 1]} k`, &internalast.SumExpr{
+			IsProduct: true,
+			Var:       "k",
+			Lower:     v("a"),
+			Upper:     &internalast.BinaryExpr{Op: "+", Left: v("b"), Right: n(1)},
+			Body:      v("k"),
+		}},
+		// As with bounds, the body is the immediate term
+		{`\sum_{i=1,2} i + c`, &internalast.BinaryExpr{
+			Op:    "+",
+			Left:  &internalast.SumExpr{Var: "i", Values: []internalast.Expr{n(1), n(2)}, Body: v("i")},
+			Right: v("c"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\sum_{i=1,3}^{5} i`, "\\sum over listed values of i takes no upper bound"},
+		{`\sum_{i \in n} i`, "expected a set \\{...\\} after \\in in \\sum"},
+		{`\sum_{i \in \{1, 2} i`, "expected '\\}' after the index set in \\sum"},
+		{`\sum_{k \in [1,3]}^{5} k`, "\\sum over the interval of k takes no upper bound"},
+		{`\sum_{k \in [1, n) k`, "expected ']' after the interval of k in \\sum: only closed intervals [a, b] are supported"},
+		{`\sum_{k \in [1] k`, "expected ',' between the bounds of the interval of k in \\sum"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_Sequences(t *testing.T) {
+	n := &internalast.Variable{Name: "n"}
+	an := &internalast.IndexExpr{Sequence: "a", Index: n}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`a_n`, an},
+		{`a_{n+1}`, &internalast.IndexExpr{Sequence: "a", Index: &internalast.BinaryExpr{Op: "+", Left: n, Right: &internalast.NumberLiteral{Value: 1}}}},
+		{`a_n^2`, &internalast.BinaryExpr{Op: "^", Left: an, Right: &internalast.NumberLiteral{Value: 2}}},
+		{`\sum_n a_n`, &internalast.SumExpr{Var: "n", Body: an}},
+		{`\sum_{n} a_n + 1`, &internalast.BinaryExpr{
+			Op:    "+",
+			Left:  &internalast.SumExpr{Var: "n", Body: an},
+			Right: &internalast.NumberLiteral{Value: 1},
+		}},
+		{`\prod_k x_k`, &internalast.SumExpr{IsProduct: true, Var: "k", Body: &internalast.IndexExpr{Sequence: "x", Index: &internalast.Variable{Name: "k"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\sum_n^{3} a_n`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\\sum_n takes no upper bound")
+}
+
+func TestParser_BraceAnnotations(t *testing.T) {
+	a, b := &internalast.Variable{Name: "a"}, &internalast.Variable{Name: "b"}
+	sum := &internalast.BinaryExpr{Op: "+", Left: a, Right: b}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\overbrace{a+b}^{s}`, sum},
+		{`\underbrace{a+b}_{s}`, sum},
+		{`\overbrace{a+b}^{\text{total cost}}`, sum},
+		{`\overbrace{a+b}^{\frac{x}{\text{n}}}`, sum},
+		{`\overbrace{a+b}`, sum},
+		// The label is not an exponent: only the annotated group takes part in the product
+		{`2 \cdot \overbrace{a+b}^{2} \cdot b`, &internalast.BinaryExpr{
+			Op:    "*",
+			Left:  &internalast.BinaryExpr{Op: "*", Left: &internalast.NumberLiteral{Value: 2}, Right: sum},
+			Right: b,
+		}},
+		// An \underbrace takes its label below; a '^' after it is a power of the group
+		{`\underbrace{a+b}^2`, &internalast.BinaryExpr{Op: "^", Left: sum, Right: &internalast.NumberLiteral{Value: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\overbrace a`, "expected '{' after \\overbrace"},
+		{`\overbrace{a+b}^{s`, "missing '}' after the label of \\overbrace"},
+		{`\underbrace{a+b}_`, "expected a label after the script of \\underbrace"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_Cancel(t *testing.T) {
+	x, y := &internalast.Variable{Name: "x"}, &internalast.Variable{Name: "y"}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\cancel{x} + y`, &internalast.BinaryExpr{Op: "+", Left: x, Right: y}},
+		{`\bcancel{x} y`, &internalast.BinaryExpr{Op: "*", Left: x, Right: y}},
+		{`\frac{\xcancel{x} y}{\xcancel{x}}`, &internalast.FuncCall{FuncName: "frac", Args: []internalast.Expr{
+			&internalast.BinaryExpr{Op: "*", Left: x, Right: y},
+			x,
+		}}},
+		{`\cancel{x + y}^2`, &internalast.BinaryExpr{
+			Op:    "^",
+			Left:  &internalast.BinaryExpr{Op: "+", Left: x, Right: y},
+			Right: &internalast.NumberLiteral{Value: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`\cancel x`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected '{' after \\cancel")
+}
+
+func TestParser_UnitAnnotations(t *testing.T) {
+	unit := func(value internalast.Expr, u string) internalast.Expr {
+		return &internalast.UnitExpr{Value: value, Unit: u}
+	}
+	one, x := &internalast.NumberLiteral{Value: 1}, &internalast.Variable{Name: "x"}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`1\,\text{m} + 1\,\text{s}`, &internalast.BinaryExpr{Op: "+", Left: unit(one, "m"), Right: unit(one, "s")}},
+		{`x \mathrm{kg}`, unit(x, "kg")},
+		{`1\,\text{m/s^2}`, unit(one, "m/s^2")},
+		{`1\,\text{kg m^{-1} * s}`, unit(one, "kg m^{-1}*s")},
+		// The unit applies to the whole power before it
+		{`x^2 \text{m}`, unit(&internalast.BinaryExpr{Op: "^", Left: x, Right: &internalast.NumberLiteral{Value: 2}}, "m")},
+		// ... but only to the last factor of a product
+		{`2 x \text{m}`, &internalast.BinaryExpr{Op: "*", Left: &internalast.NumberLiteral{Value: 2}, Right: unit(x, "m")}},
+		// An exponent after a single unit belongs to the unit
+		{`3\,\text{m}^2`, unit(&internalast.NumberLiteral{Value: 3}, "m^2")},
+		{`3\,\text{s}^{-1}`, unit(&internalast.NumberLiteral{Value: 3}, "s^-1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	// Arguments that are not units leave the command alone
+	// Only known unit symbols are units: \mathrm{d}x is not meters, nor 2\mathrm{e} a unit e
+	for _, input := range []string{`1\,\text{m^x}`, `1\,\text{m/s/s}`, `1\,\text{2 m}`, `1\,\sin{x}`, `2\mathrm{e}`, `\int_0^1 x\,\mathrm{d}x`, `1\,\text{apples}`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "expected next token to be EOF")
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`3\,\text{m/s}^2`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "an exponent after \\text{m/s} is ambiguous: write it inside the braces")
+}
+
+func TestParser_TensorIndices(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`T^{\mu}_{\nu}`, &internalast.Variable{Name: "T_up_mu_lo_nu"}},
+		// Upper indices come first whatever the order of the scripts
+		{`T_{\nu}^{\mu}`, &internalast.Variable{Name: "T_up_mu_lo_nu"}},
+		{`g_{\mu\nu}`, &internalast.Variable{Name: "g_lo_mu_nu"}},
+		{`R^\rho_{\sigma\mu\nu}`, &internalast.Variable{Name: "R_up_rho_lo_sigma_mu_nu"}},
+		{`T^{\mu}_i`, &internalast.Variable{Name: "T_up_mu_lo_i"}},
+		// A superscript after the indices is a power
+		{`T_{\mu}^2`, &internalast.BinaryExpr{
+			Op: "^", Left: &internalast.Variable{Name: "T_lo_mu"}, Right: &internalast.NumberLiteral{Value: 2},
+		}},
+		// Scripts without Greek letters keep their meaning
+		{`x_i`, &internalast.IndexExpr{Sequence: "x", Index: &internalast.Variable{Name: "i"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	_, err := newStatefulParser(NewLexer(`T^{\mu}_{1}`)).ParseExpression()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected Greek letters or identifiers as the indices of the tensor T")
+}
+
+func TestParser_FontSymbols(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\mathbb{R}`, &internalast.Variable{Name: "R_bb"}},
+		{`\mathbb R`, &internalast.Variable{Name: "R_bb"}},
+		{`\mathcal{L}`, &internalast.Variable{Name: "L_cal"}},
+		{`\mathfrak{g}`, &internalast.Variable{Name: "g_frak"}},
+		{`\mathcal{\lambda}`, &internalast.Variable{Name: "lambda_cal"}},
+		{`\mathcal{L} - L`, &internalast.BinaryExpr{
+			Op: "-", Left: &internalast.Variable{Name: "L_cal"}, Right: &internalast.Variable{Name: "L"},
+		}},
+		{`\mathfrak{g}^2`, &internalast.BinaryExpr{
+			Op: "^", Left: &internalast.Variable{Name: "g_frak"}, Right: &internalast.NumberLiteral{Value: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+}
+
+func TestParser_VectorDecorators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`\vec{v}`, &internalast.VectorExpr{Name: "v"}},
+		{`\vec v`, &internalast.VectorExpr{Name: "v"}},
+		{`\overrightarrow{AB}`, &internalast.VectorExpr{Name: "AB"}},
+		{`\hat{n}`, &internalast.VectorExpr{Name: "n", Unit: true}},
+		{`\vec{\omega}`, &internalast.VectorExpr{Name: "omega"}},
+		{`\vec{a} + \vec{b}`, &internalast.BinaryExpr{
+			Op: "+", Left: &internalast.VectorExpr{Name: "a"}, Right: &internalast.VectorExpr{Name: "b"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	errorTests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`\vec{2}`, "expected a vector name after \\vec, got NUMBER"},
+		{`\hat{n m}`, "expected '}' after the vector n of \\hat"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := newStatefulParser(NewLexer(tt.input)).ParseExpression()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestParser_BracedExponents(t *testing.T) {
+	x, a, b := &internalast.Variable{Name: "x"}, &internalast.Variable{Name: "a"}, &internalast.Variable{Name: "b"}
+	pow := func(base, exp internalast.Expr) internalast.Expr {
+		return &internalast.BinaryExpr{Op: "^", Left: base, Right: exp}
+	}
+
+	tests := []struct {
+		input    string
+		expected internalast.Expr
+	}{
+		{`x^{a}`, pow(x, a)},
+		{`x^{a + b}`, pow(x, &internalast.BinaryExpr{Op: "+", Left: a, Right: b})},
+		{`x^{a^{b}}`, pow(x, pow(a, b))},
+		{`(x^{a})^{b}`, pow(pow(x, a), b)},
+		{`x^{a} b`, &internalast.BinaryExpr{Op: "*", Left: pow(x, a), Right: b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := newStatefulParser(NewLexer(tt.input))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expected, expr)
+		})
+	}
+
+	// A superscript stacked on a superscripted term is rejected, as LaTeX does
+	for _, input := range []string{`x^{a}^{b}`, `x^{a}^b`, `x^2^3`, `a ^ b ^ c`} {
+		_, err := newStatefulParser(NewLexer(input)).ParseExpression()
+		require.Error(t, err, input)
+		assert.Contains(t, err.Error(), "double superscript: write (x^{a})^{b} or x^{a^{b}} instead of x^{a}^{b}")
+	}
+}
+
+func TestParser_LatexRoundTrip(t *testing.T) {
+	a, b, c := &internalast.Variable{Name: "a"}, &internalast.Variable{Name: "b"}, &internalast.Variable{Name: "c"}
+	minus3 := &internalast.NumberLiteral{Value: -3}
+
+	tests := []struct {
+		name string
+		expr internalast.Expr
+	}{
+		{"juxtaposed negative factor", &internalast.BinaryExpr{Op: "*", Left: a, Right: minus3}},
+		{"negative base", &internalast.BinaryExpr{Op: "^", Left: minus3, Right: &internalast.NumberLiteral{Value: 2}}},
+		{"negative subtrahend", &internalast.BinaryExpr{Op: "-", Left: a, Right: minus3}},
+		{"juxtaposed sum", &internalast.BinaryExpr{Op: "*", Left: a, Right: &internalast.BinaryExpr{Op: "+", Left: b, Right: c}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latex, err := internalast.ToLatex(tt.expr, internalast.WithMultiplication(internalast.MultiplyJuxtaposition))
+			require.NoError(t, err)
+			p := newStatefulParser(NewLexer(latex))
+			expr, err := p.ParseExpression()
+			require.NoError(t, err, latex)
+			checkParserErrors(t, p)
+			assert.Equal(t, tt.expr, expr, latex)
+		})
+	}
+}
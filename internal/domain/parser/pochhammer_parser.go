@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parsePochhammer handles the subscript of the Pochhammer symbol (x)_n, the rising
+// factorial. The count is a single primary (_n, _3) or a braced expression (_{n-1}).
+// The parser is expected to be positioned on the ')' closing the base, with '_' next.
+func (p *Parser) parsePochhammer(base internalast.Expr) (internalast.Expr, error) {
+	p.nextToken() // consume '_'
+	count, err := p.parseScriptArgument("Pochhammer symbol")
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.PochhammerExpr{Base: base, Count: count, Rising: true}, nil
+}
+
+// parseFactorialPower handles Knuth's factorial powers x^{\overline{n}} (rising) and
+// x^{\underline{n}} (falling). The parser is expected to be positioned on the '{'
+// following '^', with \overline or \underline next.
+func (p *Parser) parseFactorialPower(base internalast.Expr) (internalast.Expr, error) {
+	p.nextToken() // move to \overline or \underline
+	rising := p.curToken.Literal == "overline"
+	if !p.expectPeek(LBRACE) {
+		return nil, fmt.Errorf("expected '{' after \\%s in factorial power", p.curToken.Literal)
+	}
+	p.nextToken() // move to the count
+	count, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RBRACE) || !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after count of factorial power")
+	}
+	return &internalast.PochhammerExpr{Base: base, Count: count, Rising: rising}, nil
+}
+
+// isFactorialPowerStart reports whether the parser, positioned after '^', is at the
+// start of a factorial power exponent {\overline{n}} or {\underline{n}}.
+func (p *Parser) isFactorialPowerStart() bool {
+	return p.curToken.Type == LBRACE && p.peekToken.Type == COMMAND &&
+		(p.peekToken.Literal == "overline" || p.peekToken.Literal == "underline")
+}
+
+// parseScriptArgument parses the argument of a '_' or '^' script: a single primary
+// or a braced expression. The parser is expected to be positioned on the script
+// token and is left on the last token of the argument.
+func (p *Parser) parseScriptArgument(context string) (internalast.Expr, error) {
+	if p.peekToken.Type == LBRACE {
+		p.nextToken() // consume '{'
+		p.nextToken() // move to the argument
+		arg, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RBRACE) {
+			return nil, fmt.Errorf("expected '}' after subscript of %s", context)
+		}
+		return arg, nil
+	}
+	if !isPrimaryStart(p.peekToken.Type) {
+		p.addError("expected subscript of %s", context)
+		return nil, fmt.Errorf("expected subscript of %s", context)
+	}
+	p.nextToken() // move to the primary
+	return p.parseExpression(CALL)
+}
@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseIndexed handles an element of a sequence, a_n or a_{i+1}. The index is
+// written as a single primary or braced, like the order of a norm. The parser is
+// expected to be positioned on the sequence name, followed by '_'.
+func (p *Parser) parseIndexed() (internalast.Expr, error) {
+	name := p.curToken.Literal
+	p.nextToken() // move to '_'
+	index, err := p.parseScriptArgument(name)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.IndexExpr{Sequence: name, Index: index}, nil
+}
+
+// parseRangeIndex recognizes the index of a sum without bounds, \sum_n or \sum_{n}.
+// The parser is expected to be positioned on the '_'; when the subscript is not a
+// lone identifier (e.g. i=1) ok is false and the parser is left untouched.
+func (p *Parser) parseRangeIndex() (index string, ok bool) {
+	if p.peekToken.Type == IDENT {
+		p.nextToken() // move to the index
+		return p.curToken.Literal, true
+	}
+	next := p.lookahead(2)
+	if p.peekToken.Type != LBRACE || len(next) < 2 || next[0].Type != IDENT || next[1].Type != RBRACE {
+		return "", false
+	}
+	p.nextToken() // move to '{'
+	p.nextToken() // move to the index
+	index = p.curToken.Literal
+	p.nextToken() // move to '}'
+	return index, true
+}
+
+// parseRangeSum parses the body of \sum_n or \prod_n, which runs over the indices
+// of the sequences indexed by n in the body. The parser is expected to be
+// positioned on the last token of the index.
+func (p *Parser) parseRangeSum(funcName, index string) (internalast.Expr, error) {
+	if p.peekToken.Type == CARET {
+		p.addError("\\%s_%s takes no upper bound: write the bounds as \\%s_{%s=...}^{...}", funcName, index, funcName, index)
+		return nil, fmt.Errorf("\\%s_%s takes no upper bound: write the bounds as \\%s_{%s=...}^{...}", funcName, index, funcName, index)
+	}
+	p.nextToken() // advance to body token
+	// As with bounds, the body is the immediate term
+	body, err := p.parseExpression(SUM)
+	if err != nil {
+		return nil, err
+	}
+	return &internalast.SumExpr{
+		IsProduct: funcName == "prod",
+		Var:       index,
+		Body:      body,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// statisticOperator reports which statistics operator, if any, the command
+// \funcName{arg} names: \mathbb{E} is the expectation "E", while \text{Var},
+// \mathrm{Var} and \operatorname{Var} are the variance "Var".
+func statisticOperator(funcName string, arg internalast.Expr) (string, bool) {
+	v, ok := arg.(*internalast.Variable)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case funcName == "mathbb" && v.Name == "E":
+		return "E", true
+	case (funcName == "text" || funcName == "mathrm" || funcName == "operatorname") && v.Name == "Var":
+		return "Var", true
+	}
+	return "", false
+}
+
+// parseStatisticOperator handles the bracketed random variable of an
+// expectation or variance like \mathbb{E}[X] or \text{Var}[X]. The parser is
+// expected to be positioned on the '}' closing the operator name, with '[' as
+// the next token. The result is a FuncCall named op with the random variable
+// as its single argument, or a ConditionalExpectationExpr for \mathbb{E}[X \mid Y].
+func (p *Parser) parseStatisticOperator(op string) (internalast.Expr, error) {
+	p.nextToken() // consume '['
+	p.nextToken() // move to the random variable
+	arg, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	if op == "E" && p.peekToken.Type == MID {
+		given, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RBRACKET) {
+			return nil, fmt.Errorf("expected ']' after the condition of E")
+		}
+		return &internalast.ConditionalExpectationExpr{Value: arg, Given: given}, nil
+	}
+	if !p.expectPeek(RBRACKET) {
+		return nil, fmt.Errorf("expected ']' after argument of %s", op)
+	}
+	return &internalast.FuncCall{FuncName: op, Args: []internalast.Expr{arg}}, nil
+}
+
+// parseAngleBrackets parses the angle brackets \langle ... \rangle: the expectation
+// \langle X \rangle, like \mathbb{E}[X], the conditional expectation
+// \langle X \mid Y \rangle, or the inner product \langle a | b \rangle of bra-ket
+// notation, like \braket{a|b}. The parser is expected to be positioned on \langle.
+func (p *Parser) parseAngleBrackets() (internalast.Expr, error) {
+	if next := p.lookahead(1); (p.peekToken.Type == IDENT || p.peekToken.Type == COMMAND) && len(next) == 1 && next[0].Type == PIPE {
+		bra, err := p.parseKetVector("langle")
+		if err != nil {
+			return nil, err
+		}
+		p.nextToken() // move to '|'
+		ket, err := p.parseKetVector("langle")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectRangle(); err != nil {
+			return nil, err
+		}
+		return &internalast.InnerProductExpr{Bra: bra, Ket: ket}, nil
+	}
+
+	p.nextToken() // move to the random variable
+	value, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	var given internalast.Expr
+	if p.peekToken.Type == MID {
+		if given, err = p.parseCondition(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectRangle(); err != nil {
+		return nil, err
+	}
+	if given != nil {
+		return &internalast.ConditionalExpectationExpr{Value: value, Given: given}, nil
+	}
+	return &internalast.FuncCall{FuncName: "E", Args: []internalast.Expr{value}}, nil
+}
+
+// parseCondition parses the condition after the bar \mid, which is the peek token,
+// and leaves the parser on its last token.
+func (p *Parser) parseCondition() (internalast.Expr, error) {
+	p.nextToken() // move to \mid
+	p.nextToken() // move to the condition
+	return p.parseExpression(LOWEST)
+}
+
+// expectRangle advances to the \rangle closing \langle, which must be the peek token.
+func (p *Parser) expectRangle() error {
+	if p.peekToken.Type != COMMAND || p.peekToken.Literal != "rangle" {
+		p.addError("missing \\rangle after \\langle, got %s", p.peekToken.Type)
+		return fmt.Errorf("missing \\rangle after \\langle, got %s", p.peekToken.Type)
+	}
+	p.nextToken() // move to \rangle
+	return nil
+}
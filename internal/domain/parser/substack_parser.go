@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// parseSumIndex parses the "i=1" index assignment of a \sum or \prod subscript.
+// It is called positioned on the index variable and leaves the parser on the
+// last token of the lower bound.
+func (p *Parser) parseSumIndex(funcName string) (string, internalast.Expr, error) {
+	if p.curToken.Type != IDENT {
+		p.addError("expected identifier for summation variable in \\%s", funcName)
+		return "", nil, fmt.Errorf("expected identifier for summation variable in \\%s", funcName)
+	}
+	varName := p.curToken.Literal
+	p.nextToken() // move to '='
+	if p.curToken.Type != EQUALS {
+		p.addError("expected '=' after variable in \\%s lower bound", funcName)
+		return "", nil, fmt.Errorf("expected '=' after variable in \\%s lower bound", funcName)
+	}
+	p.nextToken() // move to lower bound expr
+	lower, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return "", nil, err
+	}
+	return varName, lower, nil
+}
+
+// parseSubstack parses a \substack{i=1 \\ i \ne j} subscript of \sum or \prod. The
+// first line holds the index assignment and every further line a condition the index
+// must satisfy; the conditions are joined with && into the returned filter, which is
+// nil for a single line. It is called positioned on the \substack command and leaves
+// the parser on its closing '}'.
+func (p *Parser) parseSubstack(funcName string) (string, internalast.Expr, internalast.Expr, error) {
+	if !p.expectPeek(LBRACE) {
+		return "", nil, nil, fmt.Errorf("expected '{' after \\substack in \\%s", funcName)
+	}
+	p.nextToken() // move to the index variable
+	varName, lower, err := p.parseSumIndex(funcName)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var filter internalast.Expr
+	for p.peekToken.Type == COMMAND && p.peekToken.Literal == "\\" {
+		p.nextToken() // consume the line separator
+		if p.peekToken.Type == RBRACE {
+			break // A trailing '\\' is allowed
+		}
+		p.nextToken() // move to the condition
+		cond, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if filter == nil {
+			filter = cond
+		} else {
+			filter = &internalast.BinaryExpr{Op: "&&", Left: filter, Right: cond}
+		}
+	}
+	if !p.expectPeek(RBRACE) {
+		return "", nil, nil, fmt.Errorf("expected '}' after \\substack in \\%s", funcName)
+	}
+	return varName, lower, filter, nil
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// A tensor component such as T^{\mu}_{\nu} is read as a single variable whose name
+// spells out its indices: the name of the tensor, then "_up_" and the upper indices,
+// then "_lo_" and the lower ones, each joined by '_':
+//
+//	T^{\mu}_{\nu}             T_up_mu_lo_nu
+//	g_{\mu\nu}                g_lo_mu_nu
+//	R^{\rho}_{\sigma\mu\nu}    R_up_rho_lo_sigma_mu_nu
+//
+// Upper indices always come first, so T_{\nu}^{\mu} is the same variable as
+// T^{\mu}_{\nu}. The names cannot clash with other variables, whose identifiers
+// have no '_'. Repeated indices are not summed over.
+//
+// A script opens a tensor when it holds only Greek letters, so x_{i} stays an
+// element of a sequence and x^{2} a power; once opened, later scripts may also use
+// Latin indices, as in T^{\mu}_{i}.
+
+// greekLetters are the commands accepted as tensor indices. \pi is left out, as it
+// is read as the constant.
+var greekLetters = map[string]bool{
+	"alpha": true, "beta": true, "gamma": true, "delta": true, "epsilon": true,
+	"varepsilon": true, "zeta": true, "eta": true, "theta": true, "vartheta": true,
+	"iota": true, "kappa": true, "lambda": true, "mu": true, "nu": true, "xi": true,
+	"rho": true, "sigma": true, "tau": true, "upsilon": true, "phi": true,
+	"varphi": true, "chi": true, "psi": true, "omega": true,
+}
+
+// peekTensorScript reports whether the peek token starts a script of tensor indices,
+// _\mu, ^{\mu\nu} and the like. With latin set, identifiers are accepted as indices
+// besides Greek letters; without it, the script must hold only Greek letters.
+func (p *Parser) peekTensorScript(latin bool) bool {
+	if p.peekToken.Type != UNDERSCORE && p.peekToken.Type != CARET {
+		return false
+	}
+	next := p.lookahead(1)
+	if len(next) == 1 && next[0].Type == COMMAND {
+		return greekLetters[next[0].Literal]
+	}
+	if len(next) == 1 && next[0].Type == IDENT {
+		return latin
+	}
+	if len(next) == 0 || next[0].Type != LBRACE {
+		return false
+	}
+	// A braced script: scan up to the closing brace
+	l := *p.l
+	l.NextToken() // '{'
+	count := 0
+	for tok := l.NextToken(); tok.Type != RBRACE; tok = l.NextToken() {
+		switch {
+		case tok.Type == COMMAND && greekLetters[tok.Literal]:
+		case tok.Type == IDENT && latin:
+		default:
+			return false
+		}
+		count++
+	}
+	return count > 0
+}
+
+// parseTensor parses the indices of a tensor component into a variable named after
+// them. The parser is expected to be positioned on the tensor's name, followed by a
+// script of Greek letters, and is left on the last token of its last script.
+func (p *Parser) parseTensor() (internalast.Expr, error) {
+	name := p.curToken.Literal
+	var upper, lower []string
+	for p.peekTensorScript(true) {
+		p.nextToken() // move to '_' or '^'
+		indices := &lower
+		if p.curToken.Type == CARET {
+			indices = &upper
+		}
+		if p.peekToken.Type != LBRACE {
+			p.nextToken() // move to the index
+			*indices = append(*indices, p.curToken.Literal)
+			continue
+		}
+		p.nextToken() // move to '{'
+		for p.peekToken.Type != RBRACE {
+			p.nextToken() // move to the index
+			*indices = append(*indices, p.curToken.Literal)
+		}
+		p.nextToken() // move to '}'
+	}
+	// A superscript left over is a power, as in T_{\mu}^2
+	if p.peekToken.Type == UNDERSCORE {
+		p.addError("expected Greek letters or identifiers as the indices of the tensor %s", name)
+		return nil, fmt.Errorf("expected Greek letters or identifiers as the indices of the tensor %s", name)
+	}
+
+	mangled := name
+	if len(upper) > 0 {
+		mangled += "_up_" + strings.Join(upper, "_")
+	}
+	if len(lower) > 0 {
+		mangled += "_lo_" + strings.Join(lower, "_")
+	}
+	return &internalast.Variable{Name: mangled}, nil
+}
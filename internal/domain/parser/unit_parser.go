@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// A unit annotation is a \text{...} or \mathrm{...} following an operand, as in
+// 9.81\,\text{m/s^2}. Its argument is a product of unitSymbols, each with an optional
+// integer exponent (s^2, m^{-1}), separated by spaces or '*' and divided by at most
+// one '/'. Anything else in the argument, such as the d of \mathrm{d}x or the e of
+// 2\mathrm{e}, or a following '[' or '(' as in \mathrm{Var}[X] and \text{Re}(z),
+// makes the command an ordinary one. An exponent directly after the annotation,
+// \text{m}^2, belongs to its unit.
+
+// unitCommands are the commands whose argument may be a unit.
+var unitCommands = map[string]bool{
+	"text":   true,
+	"mathrm": true,
+}
+
+// unitSymbols are the units a unit annotation may name: those whose dimensions
+// the generator's unit check knows.
+var unitSymbols = map[string]bool{
+	"m": true, "km": true, "cm": true, "mm": true,
+	"kg": true, "g": true,
+	"s": true, "ms": true, "min": true, "h": true,
+	"A": true, "K": true, "mol": true, "cd": true,
+	"Hz": true, "N": true, "Pa": true, "J": true, "W": true, "C": true, "V": true,
+}
+
+// maxUnitTokens bounds the tokens scanned when looking for a unit annotation.
+const maxUnitTokens = 24
+
+// peekUnit reports whether the peek token starts a unit annotation, returning the
+// unit as written.
+func (p *Parser) peekUnit() (string, int, bool) {
+	return scanUnit(p.peekToken, p.lookahead(maxUnitTokens+1))
+}
+
+// scanUnit reports whether command followed by the tokens next is a unit
+// annotation. It returns the unit as written, e.g. "kg m/s^2", and the number of
+// tokens of next it spans, up to and including the closing '}'.
+func scanUnit(command Token, next []Token) (string, int, bool) {
+	if command.Type != COMMAND || !unitCommands[command.Literal] || len(next) < 3 || next[0].Type != LBRACE {
+		return "", 0, false
+	}
+	var unit strings.Builder
+	slashes := 0
+	inExponent := false // Inside the braces of an exponent like m^{-1}
+	prev := LBRACE
+	for i, tok := range next[1:] {
+		valid := false
+		switch tok.Type {
+		case IDENT:
+			valid = !inExponent && prev != CARET && prev != MINUS && unitSymbols[tok.Literal]
+			if prev == IDENT || prev == NUMBER || prev == RBRACE {
+				unit.WriteString(" ")
+			}
+		case CARET:
+			valid = prev == IDENT
+		case MINUS:
+			valid = prev == CARET || (inExponent && prev == LBRACE)
+		case NUMBER:
+			valid = prev == CARET || prev == MINUS || (inExponent && prev == LBRACE)
+		case LBRACE:
+			valid = prev == CARET
+			inExponent = true
+		case SLASH, ASTERISK:
+			valid = !inExponent && (prev == IDENT || prev == NUMBER || prev == RBRACE)
+			if tok.Type == SLASH {
+				slashes++
+				valid = valid && slashes == 1
+			}
+		case RBRACE:
+			if inExponent {
+				valid = prev == NUMBER
+				inExponent = false
+				break
+			}
+			// The annotation ends here; a '[' or '(' after it is an operator argument
+			if (prev == IDENT || prev == NUMBER || prev == RBRACE) && (i+2 >= len(next) || (next[i+2].Type != LBRACKET && next[i+2].Type != LPAREN)) {
+				return unit.String(), i + 2, true
+			}
+		}
+		if !valid {
+			return "", 0, false
+		}
+		unit.WriteString(tok.Literal)
+		prev = tok.Type
+	}
+	return "", 0, false
+}
+
+// parseUnitAnnotation wraps left in a UnitExpr. The parser is expected to be
+// positioned on the \text or \mathrm of a unit annotation and is left on its
+// closing '}'.
+func (p *Parser) parseUnitAnnotation(left internalast.Expr) (internalast.Expr, error) {
+	command := p.curToken
+	unit, n, ok := scanUnit(command, append([]Token{p.peekToken}, p.lookahead(maxUnitTokens)...))
+	if !ok {
+		p.addError("unexpected \\%s after an expression", command.Literal)
+		return nil, fmt.Errorf("unexpected \\%s after an expression", command.Literal)
+	}
+	for i := 0; i < n; i++ {
+		p.nextToken()
+	}
+	if p.peekToken.Type == CARET {
+		exponent, err := p.parseUnitExponent(unit)
+		if err != nil {
+			return nil, err
+		}
+		unit += "^" + exponent
+	}
+	return &internalast.UnitExpr{Value: left, Unit: unit}, nil
+}
+
+// parseUnitExponent parses the integer exponent n of \text{m}^n or \text{m}^{-n}
+// following the unit annotation of a single unit. The parser is expected to be
+// positioned on the closing '}' of the annotation and is left on the last token of
+// the exponent.
+func (p *Parser) parseUnitExponent(unit string) (string, error) {
+	if strings.ContainsAny(unit, " */^") {
+		p.addError("an exponent after \\text{%s} is ambiguous: write it inside the braces", unit)
+		return "", fmt.Errorf("an exponent after \\text{%s} is ambiguous: write it inside the braces", unit)
+	}
+	p.nextToken() // move to '^'
+	next := p.lookahead(3)
+	switch {
+	case p.peekToken.Type == NUMBER:
+		p.nextToken()
+		return p.curToken.Literal, nil
+	case p.peekToken.Type == LBRACE && len(next) >= 2 && next[0].Type == NUMBER && next[1].Type == RBRACE:
+		p.nextToken() // move to '{'
+		p.nextToken() // move to the exponent
+		exponent := p.curToken.Literal
+		p.nextToken() // move to '}'
+		return exponent, nil
+	case p.peekToken.Type == LBRACE && len(next) >= 3 && next[0].Type == MINUS && next[1].Type == NUMBER && next[2].Type == RBRACE:
+		p.nextToken() // move to '{'
+		p.nextToken() // move to '-'
+		p.nextToken() // move to the exponent
+		exponent := "-" + p.curToken.Literal
+		p.nextToken() // move to '}'
+		return exponent, nil
+	}
+	p.addError("expected an integer exponent after \\text{%s}^", unit)
+	return "", fmt.Errorf("expected an integer exponent after \\text{%s}^", unit)
+}
@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"fmt"
+
+	internalast "github.com/ZanzyTHEbar/latex2go/internal/domain/ast"
+)
+
+// vectorDecorators are the commands marking a vector quantity, true for those
+// that also normalize it to a unit vector.
+var vectorDecorators = map[string]bool{
+	"vec":            false, // \vec{v}
+	"overrightarrow": false, // \overrightarrow{AB}
+	"hat":            true,  // \hat{n}, the unit vector along n
+}
+
+// parseVector handles \vec{v}, \overrightarrow{AB} and \hat{n}. The vector is an
+// identifier or a Greek letter, named without its backslash, braced or not, as in
+// \vec v. The parser is expected to be positioned on the command.
+func (p *Parser) parseVector(funcName string) (internalast.Expr, error) {
+	braced := p.peekToken.Type == LBRACE
+	if braced {
+		p.nextToken() // move to '{'
+	}
+	if p.peekToken.Type != IDENT && (p.peekToken.Type != COMMAND || !greekLetters[p.peekToken.Literal]) {
+		p.addError("expected a vector name after \\%s, got %s", funcName, p.peekToken.Type)
+		return nil, fmt.Errorf("expected a vector name after \\%s, got %s", funcName, p.peekToken.Type)
+	}
+	p.nextToken() // move to the name
+	name := p.curToken.Literal
+	if braced && !p.expectPeek(RBRACE) {
+		return nil, fmt.Errorf("expected '}' after the vector %s of \\%s", name, funcName)
+	}
+	return &internalast.VectorExpr{Name: name, Unit: vectorDecorators[funcName]}, nil
+}